@@ -0,0 +1,61 @@
+// Command fakeforge is a stand-in SD-Forge server for frontend and end-to-end work. It
+// implements the API subset forgeclient uses and answers with deterministic procedural
+// images (the same seed and prompt always give the same image), with configurable
+// latency and failure injection.
+//
+//	go run ./cmd/fakeforge -addr 127.0.0.1:7860 -latency 2s -fail-rate 0.1
+//
+// Point the backend at it with FORGE_SERVER_URL=http://127.0.0.1:7860. Behaviour can be
+// changed while running with PUT /fakeforge/config, e.g.
+// {"latency": "500ms", "fail_rate": 1, "fail_mode": "hang", "fail_paths": ["/sdapi/v1/txt2img"]}.
+package main
+
+import (
+   "flag"
+   "log"
+   "net/http"
+   "strings"
+   "time"
+)
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+   var out []string
+   for _, v := range strings.Split(s, ",") {
+       if v = strings.TrimSpace(v); v != "" {
+           out = append(out, v)
+       }
+   }
+   return out
+}
+
+func main() {
+   addr := flag.String("addr", "127.0.0.1:7860", "listen address")
+   latency := flag.Duration("latency", time.Second, "time spent generating each image")
+   switchLatency := flag.Duration("switch-latency", 2*time.Second, "time a checkpoint switch takes")
+   failRate := flag.Float64("fail-rate", 0, "probability (0-1) that a request fails")
+   failMode := flag.String("fail-mode", failError, "how injected failures look: error (500), hang or drop (close connection)")
+   failPaths := flag.String("fail-paths", "", "comma-separated path prefixes failures are limited to (default all)")
+   models := flag.String("models", "sdxl_base,flux1-dev,realistic_vision_v6", "comma-separated checkpoint names; the first is loaded at start")
+   loras := flag.String("loras", "ink_style,watercolor,pixel_art", "comma-separated LoRA names")
+   seed := flag.Int64("seed", 1, "seed for random generation seeds and failure injection")
+   flag.Parse()
+
+   cfg := config{
+       Latency:       *latency,
+       SwitchLatency: *switchLatency,
+       FailRate:      *failRate,
+       FailMode:      *failMode,
+       FailPaths:     splitList(*failPaths),
+   }
+   if err := cfg.validate(); err != nil {
+       log.Fatalf("fakeforge: %v", err)
+   }
+   modelList := splitList(*models)
+   if len(modelList) == 0 {
+       log.Fatalf("fakeforge: at least one model is required")
+   }
+   s := newServer(cfg, modelList, splitList(*loras), *seed)
+   log.Printf("fakeforge: listening on http://%s (models %v, %s per image)", *addr, modelList, cfg.Latency)
+   log.Fatal(http.ListenAndServe(*addr, s.handler()))
+}
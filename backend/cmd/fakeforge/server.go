@@ -0,0 +1,672 @@
+package main
+
+import (
+   "crypto/sha256"
+   "encoding/base64"
+   "encoding/hex"
+   "encoding/json"
+   "fmt"
+   "image"
+   "image/color"
+   "log"
+   "math/rand"
+   "net/http"
+   "strings"
+   "sync"
+   "time"
+
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/imaging"
+)
+
+// Failure modes for injected failures.
+const (
+   failError = "error" // respond 500
+   failHang  = "hang"  // never respond until the client gives up
+   failDrop  = "drop"  // close the connection without a response
+)
+
+// config is the behaviour of the fake server; it can be changed at runtime through
+// /fakeforge/config.
+type config struct {
+   // Latency is the time spent generating each image, spread over its sampling steps.
+   Latency time.Duration
+   // SwitchLatency is the time a checkpoint switch takes.
+   SwitchLatency time.Duration
+   // FailRate is the probability in [0, 1] that a request fails.
+   FailRate float64
+   // FailMode is how injected failures look: error, hang or drop.
+   FailMode string
+   // FailPaths restricts injected failures to paths with these prefixes (all when empty).
+   FailPaths []string
+}
+
+// validate checks the failure settings.
+func (c config) validate() error {
+   if c.FailRate < 0 || c.FailRate > 1 {
+       return fmt.Errorf("fail_rate must be between 0 and 1")
+   }
+   switch c.FailMode {
+   case failError, failHang, failDrop:
+   default:
+       return fmt.Errorf("fail_mode must be %s, %s or %s", failError, failHang, failDrop)
+   }
+   if c.Latency < 0 || c.SwitchLatency < 0 {
+       return fmt.Errorf("latencies must not be negative")
+   }
+   return nil
+}
+
+// job is the generation in progress, reported by /sdapi/v1/progress.
+type job struct {
+   id            string
+   seed          int64
+   prompt        string
+   width, height int
+   count, no     int
+   step, steps   int
+   start         time.Time
+   perImage      time.Duration
+   interrupted   bool
+   skipped       bool
+}
+
+// server is a fake SD-Forge implementing the API subset forgeclient uses.
+type server struct {
+   mu      sync.Mutex
+   cfg     config
+   rng     *rand.Rand
+   models  []string
+   loras   []string
+   options map[string]interface{}
+   job     *job
+   waiting int
+   jobs    int
+   // queued holds the task IDs of generations waiting for the running one
+   queued map[string]bool
+
+   // gen serializes generations and checkpoint switches like SD-Forge's queue lock.
+   gen sync.Mutex
+}
+
+// newServer returns a fake with the given models and LoRAs, the first model loaded.
+// seed makes random seeds (seed -1 requests) and injected failures reproducible.
+func newServer(cfg config, models, loras []string, seed int64) *server {
+   s := &server{cfg: cfg, rng: rand.New(rand.NewSource(seed)), models: models, loras: loras, queued: map[string]bool{}}
+   s.options = map[string]interface{}{
+       "sd_model_checkpoint":      modelTitle(models[0]),
+       "sd_vae":                   "Automatic",
+       "CLIP_stop_at_last_layers": 1,
+       "samples_format":           "png",
+   }
+   return s
+}
+
+// modelTitle returns the SD-Forge title of a model: file name plus short hash.
+func modelTitle(name string) string {
+   return fmt.Sprintf("%s.safetensors [%s]", name, modelHash(name)[:10])
+}
+
+// modelHash returns a stable fake sha256 for a model.
+func modelHash(name string) string {
+   sum := sha256.Sum256([]byte(name))
+   return hex.EncodeToString(sum[:])
+}
+
+// handler returns the HTTP routes of the fake.
+func (s *server) handler() http.Handler {
+   mux := http.NewServeMux()
+   mux.HandleFunc("POST /sdapi/v1/txt2img", s.handleTxt2Img)
+   mux.HandleFunc("POST /sdapi/v1/img2img", s.handleImg2Img)
+   mux.HandleFunc("GET /sdapi/v1/progress", s.handleProgress)
+   mux.HandleFunc("GET /sdapi/v1/sd-models", s.handleModels)
+   mux.HandleFunc("GET /sdapi/v1/options", s.handleGetOptions)
+   mux.HandleFunc("POST /sdapi/v1/options", s.handleSetOptions)
+   mux.HandleFunc("GET /sdapi/v1/loras", s.handleLoras)
+   mux.HandleFunc("POST /sdapi/v1/refresh-loras", s.handleEmpty)
+   mux.HandleFunc("POST /sdapi/v1/interrupt", s.handleInterrupt)
+   mux.HandleFunc("POST /sdapi/v1/skip", s.handleSkip)
+   mux.HandleFunc("GET /sdapi/v1/samplers", s.handleStatic(samplers))
+   mux.HandleFunc("GET /sdapi/v1/schedulers", s.handleStatic(schedulers))
+   mux.HandleFunc("GET /sdapi/v1/upscalers", s.handleStatic(upscalers))
+   mux.HandleFunc("GET /sdapi/v1/sd-vae", s.handleStatic([]forgeclient.VAEInfo{{ModelName: "sdxl_vae", Filename: "/models/VAE/sdxl_vae.safetensors"}}))
+   mux.HandleFunc("GET /sdapi/v1/embeddings", s.handleStatic(forgeclient.EmbeddingsResponse{Loaded: map[string]forgeclient.EmbeddingInfo{}, Skipped: map[string]forgeclient.EmbeddingInfo{}}))
+   mux.HandleFunc("GET /sdapi/v1/prompt-styles", s.handleStatic([]forgeclient.PromptStyle{}))
+   mux.HandleFunc("POST /internal/progress", s.handleTaskProgress)
+   mux.HandleFunc("GET /internal/ping", s.handleEmpty)
+   mux.HandleFunc("GET /fakeforge/config", s.handleGetConfig)
+   mux.HandleFunc("PUT /fakeforge/config", s.handleSetConfig)
+   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       if !strings.HasPrefix(r.URL.Path, "/fakeforge/") && s.injectFailure(w, r) {
+           return
+       }
+       mux.ServeHTTP(w, r)
+   })
+}
+
+// injectFailure fails the request according to the failure settings and reports whether
+// it did.
+func (s *server) injectFailure(w http.ResponseWriter, r *http.Request) bool {
+   s.mu.Lock()
+   cfg := s.cfg
+   fail := cfg.FailRate > 0 && s.rng.Float64() < cfg.FailRate
+   s.mu.Unlock()
+   if !fail || !matchesPrefix(r.URL.Path, cfg.FailPaths) {
+       return false
+   }
+   log.Printf("fakeforge: injecting %s failure into %s %s", cfg.FailMode, r.Method, r.URL.Path)
+   switch cfg.FailMode {
+   case failHang:
+       <-r.Context().Done()
+   case failDrop:
+       if hj, ok := w.(http.Hijacker); ok {
+           if conn, _, err := hj.Hijack(); err == nil {
+               conn.Close()
+               return true
+           }
+       }
+       fallthrough
+   default:
+       writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "InjectedFailure", "detail": "fakeforge: injected failure"})
+   }
+   return true
+}
+
+// matchesPrefix reports whether path starts with one of prefixes, or prefixes is empty.
+func matchesPrefix(path string, prefixes []string) bool {
+   if len(prefixes) == 0 {
+       return true
+   }
+   for _, p := range prefixes {
+       if strings.HasPrefix(path, p) {
+           return true
+       }
+   }
+   return false
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+   w.Header().Set("Content-Type", "application/json")
+   w.WriteHeader(status)
+   json.NewEncoder(w).Encode(v)
+}
+
+// generation holds the parameters shared by txt2img and img2img.
+type generation struct {
+   task             string
+   prompt, negative string
+   seed             *int
+   steps            int
+   cfg              float32
+   sampler          string
+   width, height    int
+   batch, iter      int
+   init             *image.NRGBA
+   mask             *image.Gray
+   strength         float64
+}
+
+// handleTxt2Img generates procedural images for a txt2img request.
+func (s *server) handleTxt2Img(w http.ResponseWriter, r *http.Request) {
+   var req forgeclient.Txt2ImgRequest
+   if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+       writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"detail": err.Error()})
+       return
+   }
+   s.generate(w, r, req, &generation{
+       task: req.ForceTaskID, prompt: req.Prompt, negative: req.NegativePrompt, seed: req.Seed, steps: req.Steps, cfg: req.CFGScale,
+       sampler: req.SamplerName, width: req.Width, height: req.Height, batch: req.BatchSize, iter: req.NIter,
+   })
+}
+
+// handleImg2Img generates procedural images blended into the init image (inside the mask).
+func (s *server) handleImg2Img(w http.ResponseWriter, r *http.Request) {
+   var req forgeclient.Img2ImgRequest
+   if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+       writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"detail": err.Error()})
+       return
+   }
+   if len(req.InitImages) == 0 {
+       writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"detail": "init_images is required"})
+       return
+   }
+   g := &generation{
+       task: req.ForceTaskID, prompt: req.Prompt, negative: req.NegativePrompt, seed: req.Seed, steps: req.Steps, cfg: req.CFGScale,
+       sampler: req.SamplerName, width: req.Width, height: req.Height, batch: req.BatchSize, iter: req.NIter,
+       strength: float64(req.DenoisingStrength),
+   }
+   if g.strength == 0 {
+       g.strength = 0.75
+   }
+   init, err := decodeBase64Image(req.InitImages[0])
+   if err != nil {
+       writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "ValueError", "detail": "init image: " + err.Error()})
+       return
+   }
+   if g.width == 0 || g.height == 0 {
+       g.width, g.height = init.Bounds().Dx(), init.Bounds().Dy()
+   }
+   g.init = imaging.Resize(init, g.width, g.height)
+   if req.Mask != "" {
+       mask, err := decodeBase64Image(req.Mask)
+       if err != nil {
+           writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "ValueError", "detail": "mask: " + err.Error()})
+           return
+       }
+       g.mask = image.NewGray(g.init.Bounds())
+       resized := imaging.Resize(mask, g.width, g.height)
+       for y := 0; y < g.height; y++ {
+           for x := 0; x < g.width; x++ {
+               g.mask.SetGray(x, y, color.GrayModel.Convert(resized.NRGBAAt(x, y)).(color.Gray))
+           }
+       }
+       if req.InpaintingMaskInvert == 1 {
+           g.mask = imaging.Invert(g.mask)
+       }
+   }
+   s.generate(w, r, req, g)
+}
+
+// decodeBase64Image decodes a base64 image, with or without a data URL prefix.
+func decodeBase64Image(b64 string) (*image.NRGBA, error) {
+   if i := strings.Index(b64, ","); i >= 0 && strings.HasPrefix(b64, "data:") {
+       b64 = b64[i+1:]
+   }
+   data, err := base64.StdEncoding.DecodeString(b64)
+   if err != nil {
+       return nil, err
+   }
+   return imaging.Decode(data)
+}
+
+// generate runs a generation one image at a time, taking the configured latency per image
+// and honouring interrupt and skip, then writes the SD-Forge response. params is echoed as
+// the response parameters.
+func (s *server) generate(w http.ResponseWriter, r *http.Request, params interface{}, g *generation) {
+   g.width, g.height = valueOr(g.width, 512), valueOr(g.height, 512)
+   g.steps, g.batch, g.iter = valueOr(g.steps, 20), valueOr(g.batch, 1), valueOr(g.iter, 1)
+   if g.sampler == "" {
+       g.sampler = "Euler a"
+   }
+   if g.cfg == 0 {
+       g.cfg = 7
+   }
+
+   s.mu.Lock()
+   s.waiting++
+   if g.task != "" {
+       s.queued[g.task] = true
+   }
+   s.mu.Unlock()
+   s.gen.Lock()
+   defer s.gen.Unlock()
+
+   s.mu.Lock()
+   s.waiting--
+   delete(s.queued, g.task)
+   seed := int64(-1)
+   if g.seed != nil {
+       seed = int64(*g.seed)
+   }
+   if seed < 0 {
+       seed = s.rng.Int63n(1 << 32)
+   }
+   s.jobs++
+   if g.task == "" {
+       g.task = fmt.Sprintf("task(fake%d)", s.jobs)
+   }
+   j := &job{
+       id: g.task, seed: seed, prompt: g.prompt, width: g.width, height: g.height,
+       count: g.batch * g.iter, steps: g.steps, start: time.Now(), perImage: s.cfg.Latency,
+   }
+   s.job = j
+   model, _ := s.options["sd_model_checkpoint"].(string)
+   s.mu.Unlock()
+   defer func() {
+       s.mu.Lock()
+       s.job = nil
+       s.mu.Unlock()
+   }()
+
+   images := []string{}
+   var seeds []int64
+   var infotexts []string
+   for i := 0; i < j.count; i++ {
+       s.mu.Lock()
+       j.no, j.step, j.skipped = i, 0, false
+       s.mu.Unlock()
+       if !s.sample(r, j) {
+           break
+       }
+       imgSeed := seed + int64(i)
+       img := render(imgSeed, g.prompt, g.width, g.height, 1)
+       if g.init != nil {
+           img = blend(img, g.init, g.mask, g.strength)
+       }
+       data, err := imaging.EncodePNG(img)
+       if err != nil {
+           writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "EncodeError", "detail": err.Error()})
+           return
+       }
+       images = append(images, base64.StdEncoding.EncodeToString(data))
+       seeds = append(seeds, imgSeed)
+       infotexts = append(infotexts, infotext(g, imgSeed, model))
+   }
+   // the job is over before the response reaches the client
+   s.mu.Lock()
+   s.job = nil
+   s.mu.Unlock()
+   if r.Context().Err() != nil {
+       return
+   }
+   info, _ := json.Marshal(map[string]interface{}{
+       "prompt": g.prompt, "negative_prompt": g.negative, "seed": seed, "all_seeds": seeds,
+       "width": g.width, "height": g.height, "sampler_name": g.sampler, "cfg_scale": g.cfg, "steps": g.steps,
+       "sd_model_name": strings.TrimSuffix(strings.Split(model, " [")[0], ".safetensors"),
+       "sd_model_hash": modelHash(strings.TrimSuffix(strings.Split(model, " [")[0], ".safetensors"))[:10],
+       "infotexts": infotexts, "index_of_first_image": 0, "batch_size": g.batch,
+   })
+   var echoed map[string]interface{}
+   raw, _ := json.Marshal(params)
+   json.Unmarshal(raw, &echoed)
+   writeJSON(w, http.StatusOK, forgeclient.ImageResponse{Images: images, Parameters: echoed, Info: string(info)})
+}
+
+// sample waits out the sampling steps of one image. It returns false when the generation
+// was interrupted or the client went away; a skip ends the image early but keeps it.
+func (s *server) sample(r *http.Request, j *job) bool {
+   tick := j.perImage / time.Duration(j.steps)
+   for step := 1; step <= j.steps; step++ {
+       if tick > 0 {
+           select {
+           case <-r.Context().Done():
+               return false
+           case <-time.After(tick):
+           }
+       }
+       s.mu.Lock()
+       j.step = step
+       interrupted, skipped := j.interrupted, j.skipped
+       s.mu.Unlock()
+       if interrupted {
+           return false
+       }
+       if skipped {
+           return true
+       }
+   }
+   return r.Context().Err() == nil
+}
+
+// infotext formats the parameters of one image the way SD-Forge embeds them.
+func infotext(g *generation, seed int64, model string) string {
+   var b strings.Builder
+   b.WriteString(g.prompt)
+   if g.negative != "" {
+       b.WriteString("\nNegative prompt: " + g.negative)
+   }
+   name := strings.Split(model, " [")[0]
+   fmt.Fprintf(&b, "\nSteps: %d, Sampler: %s, CFG scale: %g, Seed: %d, Size: %dx%d, Model: %s",
+       g.steps, g.sampler, g.cfg, seed, g.width, g.height, strings.TrimSuffix(name, ".safetensors"))
+   if g.init != nil {
+       fmt.Fprintf(&b, ", Denoising strength: %g", g.strength)
+   }
+   return b.String()
+}
+
+// valueOr returns v, or def when v is zero.
+func valueOr(v, def int) int {
+   if v == 0 {
+       return def
+   }
+   return v
+}
+
+// handleProgress reports the running generation with a preview of the current image.
+func (s *server) handleProgress(w http.ResponseWriter, r *http.Request) {
+   s.mu.Lock()
+   j := s.job
+   out := forgeclient.ProgressResponse{State: &forgeclient.ProgressState{JobCount: s.waiting}}
+   if j == nil {
+       s.mu.Unlock()
+       writeJSON(w, http.StatusOK, out)
+       return
+   }
+   done := (float64(j.no) + float64(j.step)/float64(j.steps)) / float64(j.count)
+   out.Progress = float32(done)
+   if done > 0 {
+       elapsed := time.Since(j.start).Seconds()
+       out.ETA = float32(elapsed/done - elapsed)
+   }
+   out.State = &forgeclient.ProgressState{
+       Job: j.id, JobCount: s.waiting + 1, JobNo: j.no, Interrupted: j.interrupted, Skipped: j.skipped,
+       SamplingStep: j.step, SamplingSteps: j.steps,
+   }
+   seed, prompt, step, steps := j.seed+int64(j.no), j.prompt, j.step, j.steps
+   width, height := j.width, j.height
+   s.mu.Unlock()
+   if r.URL.Query().Get("skip_current_image") != "true" && step > 0 {
+       // previews are small, like SD-Forge's approximate decoder output
+       scale := max(width, height) / 128
+       preview := render(seed, prompt, max(width/max(scale, 1), 1), max(height/max(scale, 1), 1), float64(step)/float64(steps))
+       if data, err := imaging.EncodePNG(preview); err == nil {
+           out.CurrentImage = base64.StdEncoding.EncodeToString(data)
+       }
+   }
+   writeJSON(w, http.StatusOK, out)
+}
+
+// handleModels lists the configured checkpoints.
+func (s *server) handleModels(w http.ResponseWriter, r *http.Request) {
+   out := make([]map[string]interface{}, len(s.models))
+   for i, m := range s.models {
+       hash := modelHash(m)
+       out[i] = map[string]interface{}{
+           "title": modelTitle(m), "model_name": m, "hash": hash[:10], "sha256": hash,
+           "filename": "/models/Stable-diffusion/" + m + ".safetensors", "config": nil,
+       }
+   }
+   writeJSON(w, http.StatusOK, out)
+}
+
+// handleGetOptions returns the current options.
+func (s *server) handleGetOptions(w http.ResponseWriter, r *http.Request) {
+   s.mu.Lock()
+   defer s.mu.Unlock()
+   writeJSON(w, http.StatusOK, s.options)
+}
+
+// handleSetOptions updates options; a checkpoint change waits for the running generation
+// and takes the configured switch latency.
+func (s *server) handleSetOptions(w http.ResponseWriter, r *http.Request) {
+   var opts map[string]interface{}
+   if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+       writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"detail": err.Error()})
+       return
+   }
+   if ckpt, ok := opts["sd_model_checkpoint"].(string); ok {
+       title := s.findModel(ckpt)
+       if title == "" {
+           writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "RuntimeError", "detail": "model not found: " + ckpt})
+           return
+       }
+       opts["sd_model_checkpoint"] = title
+       s.gen.Lock()
+       s.mu.Lock()
+       latency := s.cfg.SwitchLatency
+       s.mu.Unlock()
+       select {
+       case <-r.Context().Done():
+       case <-time.After(latency):
+       }
+       s.gen.Unlock()
+       if r.Context().Err() != nil {
+           return
+       }
+   }
+   s.mu.Lock()
+   for k, v := range opts {
+       s.options[k] = v
+   }
+   s.mu.Unlock()
+   writeJSON(w, http.StatusOK, nil)
+}
+
+// findModel resolves a checkpoint by title, name or file name to its title.
+func (s *server) findModel(ckpt string) string {
+   name := strings.TrimSuffix(strings.Split(ckpt, " [")[0], ".safetensors")
+   for _, m := range s.models {
+       if m == name {
+           return modelTitle(m)
+       }
+   }
+   return ""
+}
+
+// handleLoras lists the configured LoRAs with training tags for trigger words.
+func (s *server) handleLoras(w http.ResponseWriter, r *http.Request) {
+   out := make([]forgeclient.LoraInfo, len(s.loras))
+   for i, l := range s.loras {
+       out[i] = forgeclient.LoraInfo{
+           Name: l, Alias: l, Path: "/models/Lora/" + l + ".safetensors",
+           Metadata: map[string]interface{}{
+               "ss_tag_frequency": map[string]map[string]int{"1_" + l: {l: 40, l + " style": 25}},
+           },
+       }
+   }
+   writeJSON(w, http.StatusOK, out)
+}
+
+// handleTaskProgress reports whether a task is running or waiting, like SD-Forge's web UI
+// progress endpoint.
+func (s *server) handleTaskProgress(w http.ResponseWriter, r *http.Request) {
+   var req struct {
+       IDTask string `json:"id_task"`
+   }
+   if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+       writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"detail": err.Error()})
+       return
+   }
+   s.mu.Lock()
+   out := forgeclient.TaskProgress{Active: s.job != nil && s.job.id == req.IDTask, Queued: s.queued[req.IDTask]}
+   s.mu.Unlock()
+   writeJSON(w, http.StatusOK, out)
+}
+
+// handleInterrupt stops the running generation; the images finished so far are returned.
+func (s *server) handleInterrupt(w http.ResponseWriter, r *http.Request) {
+   s.mu.Lock()
+   if s.job != nil {
+       s.job.interrupted = true
+   }
+   s.mu.Unlock()
+   writeJSON(w, http.StatusOK, nil)
+}
+
+// handleSkip ends the current image of the running generation early.
+func (s *server) handleSkip(w http.ResponseWriter, r *http.Request) {
+   s.mu.Lock()
+   if s.job != nil {
+       s.job.skipped = true
+   }
+   s.mu.Unlock()
+   writeJSON(w, http.StatusOK, nil)
+}
+
+// handleEmpty answers with an empty object.
+func (s *server) handleEmpty(w http.ResponseWriter, r *http.Request) {
+   writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// handleStatic returns a handler always answering with v.
+func (s *server) handleStatic(v interface{}) http.HandlerFunc {
+   return func(w http.ResponseWriter, r *http.Request) {
+       writeJSON(w, http.StatusOK, v)
+   }
+}
+
+// handleGetConfig returns the current behaviour settings.
+func (s *server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+   s.mu.Lock()
+   defer s.mu.Unlock()
+   writeJSON(w, http.StatusOK, s.cfg.response())
+}
+
+// handleSetConfig changes behaviour settings; omitted fields keep their values. Latencies
+// are Go durations ("250ms").
+func (s *server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
+   var req struct {
+       Latency       *string   `json:"latency"`
+       SwitchLatency *string   `json:"switch_latency"`
+       FailRate      *float64  `json:"fail_rate"`
+       FailMode      *string   `json:"fail_mode"`
+       FailPaths     *[]string `json:"fail_paths"`
+   }
+   if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+       writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+       return
+   }
+   s.mu.Lock()
+   defer s.mu.Unlock()
+   cfg := s.cfg
+   for _, d := range []struct {
+       v   *string
+       dst *time.Duration
+   }{{req.Latency, &cfg.Latency}, {req.SwitchLatency, &cfg.SwitchLatency}} {
+       if d.v == nil {
+           continue
+       }
+       v, err := time.ParseDuration(*d.v)
+       if err != nil {
+           writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+           return
+       }
+       *d.dst = v
+   }
+   if req.FailRate != nil {
+       cfg.FailRate = *req.FailRate
+   }
+   if req.FailMode != nil {
+       cfg.FailMode = *req.FailMode
+   }
+   if req.FailPaths != nil {
+       cfg.FailPaths = *req.FailPaths
+   }
+   if err := cfg.validate(); err != nil {
+       writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+       return
+   }
+   s.cfg = cfg
+   writeJSON(w, http.StatusOK, cfg.response())
+}
+
+// response formats the settings with readable durations.
+func (c config) response() map[string]interface{} {
+   return map[string]interface{}{
+       "latency": c.Latency.String(), "switch_latency": c.SwitchLatency.String(),
+       "fail_rate": c.FailRate, "fail_mode": c.FailMode, "fail_paths": c.FailPaths,
+   }
+}
+
+// Static discovery lists.
+var (
+   samplers = []forgeclient.SamplerInfo{
+       {Name: "Euler a", Aliases: []string{"k_euler_a"}, Options: map[string]string{}},
+       {Name: "Euler", Aliases: []string{"k_euler"}, Options: map[string]string{}},
+       {Name: "DPM++ 2M", Aliases: []string{"k_dpmpp_2m"}, Options: map[string]string{}},
+       {Name: "DPM++ SDE", Aliases: []string{"k_dpmpp_sde"}, Options: map[string]string{}},
+       {Name: "UniPC", Aliases: []string{"UniPC"}, Options: map[string]string{}},
+   }
+   schedulers = []forgeclient.SchedulerInfo{
+       {Name: "automatic", Label: "Automatic", Aliases: []string{}},
+       {Name: "karras", Label: "Karras", Aliases: []string{}, DefaultRho: 7},
+       {Name: "exponential", Label: "Exponential", Aliases: []string{}},
+       {Name: "sgm_uniform", Label: "SGM Uniform", Aliases: []string{}, NeedInnerModel: true},
+   }
+   upscalers = []forgeclient.UpscalerInfo{
+       {Name: "None"},
+       {Name: "Lanczos"},
+       {Name: "Nearest"},
+   }
+)
@@ -0,0 +1,137 @@
+package main
+
+import (
+   "bytes"
+   "context"
+   "encoding/base64"
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "testing"
+   "time"
+
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/imaging"
+)
+
+func newTestServer(t *testing.T, cfg config) (*server, forgeclient.Client, string) {
+   if cfg.FailMode == "" {
+       cfg.FailMode = failError
+   }
+   s := newServer(cfg, []string{"sdxl_base", "flux1-dev"}, []string{"ink_style"}, 1)
+   ts := httptest.NewServer(s.handler())
+   t.Cleanup(ts.Close)
+   return s, forgeclient.NewClient(ts.URL), ts.URL
+}
+
+func TestFakeForgeGeneratesDeterministicImages(t *testing.T) {
+   _, c, _ := newTestServer(t, config{})
+   seed := 7
+   req := &forgeclient.Txt2ImgRequest{Prompt: "a red fox", Seed: &seed, Width: 64, Height: 48, BatchSize: 2}
+   first, err := c.Txt2Img(context.Background(), req)
+   if err != nil {
+       t.Fatal(err)
+   }
+   second, err := c.Txt2Img(context.Background(), req)
+   if err != nil {
+       t.Fatal(err)
+   }
+   if len(first.Images) != 2 || first.Images[0] != second.Images[0] || first.Images[0] == first.Images[1] {
+       t.Fatalf("expected two distinct images repeated exactly across runs")
+   }
+   data, _ := base64.StdEncoding.DecodeString(first.Images[1])
+   img, err := imaging.Decode(data)
+   if err != nil || img.Bounds().Dx() != 64 || img.Bounds().Dy() != 48 {
+       t.Fatalf("unexpected image: %v", err)
+   }
+   info, err := first.ParseInfo()
+   if err != nil || info.Seed != 7 || len(info.AllSeeds) != 2 || info.AllSeeds[1] != 8 || info.ModelName != "sdxl_base" {
+       t.Errorf("unexpected info %+v (%v)", info, err)
+   }
+
+   // img2img keeps the init image outside the mask
+   init, _ := imaging.EncodePNG(render(1, "init", 32, 32, 1))
+   mask, _ := imaging.EncodePNG(imaging.Rasterize(32, 32, []imaging.Shape{{Type: "rect", X: 0, Y: 0, Width: 0.5, Height: 1}}))
+   resp, err := c.Img2Img(context.Background(), &forgeclient.Img2ImgRequest{
+       InitImages: []string{base64.StdEncoding.EncodeToString(init)}, Mask: base64.StdEncoding.EncodeToString(mask), Prompt: "a fox",
+   })
+   if err != nil {
+       t.Fatal(err)
+   }
+   data, _ = base64.StdEncoding.DecodeString(resp.Images[0])
+   out, _ := imaging.Decode(data)
+   src, _ := imaging.Decode(init)
+   if out.NRGBAAt(31, 16) != src.NRGBAAt(31, 16) || out.NRGBAAt(2, 16) == src.NRGBAAt(2, 16) {
+       t.Errorf("expected changes inside the mask only")
+   }
+}
+
+func TestFakeForgeProgressInterruptAndModels(t *testing.T) {
+   s, c, _ := newTestServer(t, config{Latency: 2 * time.Second})
+   done := make(chan *forgeclient.ImageResponse)
+   go func() {
+       resp, _ := c.Txt2Img(context.Background(), &forgeclient.Txt2ImgRequest{Prompt: "slow", Width: 32, Height: 32, NIter: 3})
+       done <- resp
+   }()
+   var progress *forgeclient.ProgressResponse
+   for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+       progress, _ = c.Progress(context.Background(), false)
+       if progress.State.SamplingStep > 0 {
+           break
+       }
+   }
+   if progress.State.Job == "" || progress.Progress <= 0 || progress.CurrentImage == "" {
+       t.Fatalf("expected progress with a preview, got %+v", progress.State)
+   }
+   if err := c.Interrupt(context.Background()); err != nil {
+       t.Fatal(err)
+   }
+   if resp := <-done; resp == nil || len(resp.Images) != 0 {
+       t.Errorf("interrupting the first image should return no images, got %v", resp)
+   }
+
+   if err := c.SwitchModel(context.Background(), "flux1-dev.safetensors"); err != nil {
+       t.Fatal(err)
+   }
+   opts, _ := c.Options(context.Background())
+   if opts["sd_model_checkpoint"] != modelTitle("flux1-dev") {
+       t.Errorf("unexpected checkpoint %v", opts["sd_model_checkpoint"])
+   }
+   if err := c.SwitchModel(context.Background(), "missing"); err == nil {
+       t.Errorf("switching to an unknown model should fail")
+   }
+   loras, err := c.Loras(context.Background())
+   if err != nil || len(loras) != 1 || len(loras[0].TriggerWords) == 0 {
+       t.Errorf("unexpected loras %v (%v)", loras, err)
+   }
+   s.mu.Lock()
+   defer s.mu.Unlock()
+   if s.job != nil {
+       t.Errorf("no job should be left running")
+   }
+}
+
+func TestFakeForgeInjectsFailures(t *testing.T) {
+   _, c, url := newTestServer(t, config{FailRate: 1, FailPaths: []string{"/sdapi/v1/txt2img"}})
+   if _, err := c.Txt2Img(context.Background(), &forgeclient.Txt2ImgRequest{}); err == nil || !strings.Contains(err.Error(), "status 500") {
+       t.Errorf("expected an injected 500, got %v", err)
+   }
+   if err := c.Ping(context.Background()); err != nil {
+       t.Errorf("ping is outside the failing paths: %v", err)
+   }
+
+   // Switch to dropped connections at runtime
+   req, _ := http.NewRequest(http.MethodPut, url+"/fakeforge/config", bytes.NewBufferString(`{"fail_mode": "drop", "fail_paths": []}`))
+   resp, err := http.DefaultClient.Do(req)
+   if err != nil || resp.StatusCode != http.StatusOK {
+       t.Fatalf("config update failed: %v %v", resp, err)
+   }
+   resp.Body.Close()
+   if err := c.Ping(context.Background()); err == nil || strings.Contains(err.Error(), "status") {
+       t.Errorf("expected a transport error from a dropped connection, got %v", err)
+   }
+   req, _ = http.NewRequest(http.MethodPut, url+"/fakeforge/config", bytes.NewBufferString(`{"fail_rate": 2}`))
+   if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusBadRequest {
+       t.Errorf("expected an invalid fail_rate to be rejected, got %v %v", resp, err)
+   }
+}
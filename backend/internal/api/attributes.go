@@ -0,0 +1,77 @@
+package api
+
+import (
+   "net/http"
+   "path/filepath"
+   "strings"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/storage"
+)
+
+// handleGetAttributes returns the tags and rating for an image.
+func handleGetAttributes(c *gin.Context) {
+   sub := c.Query("path")
+   idHash := c.Param("id")
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err := findFilenameByHash(baseDir, idHash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve image ID"})
+       return
+   }
+   if filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return
+   }
+   attrs, err := storage.LoadAttributes(baseDir, idHash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load attributes"})
+       return
+   }
+   c.JSON(http.StatusOK, attrs)
+}
+
+// handleSetAttributes replaces the tags and rating for an image.
+func handleSetAttributes(c *gin.Context) {
+   sub := c.Query("path")
+   idHash := c.Param("id")
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err := findFilenameByHash(baseDir, idHash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve image ID"})
+       return
+   }
+   if filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return
+   }
+   var attrs storage.Attributes
+   if err := c.ShouldBindJSON(&attrs); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if attrs.Rating < 0 || attrs.Rating > 5 {
+       c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be between 0 and 5"})
+       return
+   }
+   // Normalize tags: trim whitespace, drop empties and case-insensitive duplicates
+   tags := []string{}
+   for _, t := range attrs.Tags {
+       t = strings.TrimSpace(t)
+       if t != "" && !containsFold(tags, t) {
+           tags = append(tags, t)
+       }
+   }
+   attrs.Tags = tags
+   if err := storage.SaveAttributes(baseDir, idHash, attrs); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save attributes"})
+       return
+   }
+   c.JSON(http.StatusOK, attrs)
+}
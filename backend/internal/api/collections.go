@@ -0,0 +1,323 @@
+package api
+
+import (
+   "errors"
+   "net/http"
+   "path/filepath"
+   "sort"
+   "strings"
+   "time"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/storage"
+)
+
+// collectionRequest is the JSON payload for creating or updating a collection.
+type collectionRequest struct {
+   Name        string                  `json:"name" binding:"required"`
+   Query       storage.CollectionQuery `json:"query"`
+   ManualOrder bool                    `json:"manual_order"`
+}
+
+// handleListCollections returns all saved collections.
+func handleListCollections(c *gin.Context) {
+   cols, err := storage.ListCollections(ImageDir)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list collections"})
+       return
+   }
+   c.JSON(http.StatusOK, cols)
+}
+
+// handleCreateCollection saves a new collection definition.
+func handleCreateCollection(c *gin.Context) {
+   var req collectionRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if err := validateCollectionQuery(req.Query); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   col := &storage.Collection{
+       ID:          storage.NewCollectionID(),
+       Name:        req.Name,
+       Query:       req.Query,
+       ManualOrder: req.ManualOrder,
+   }
+   if err := storage.SaveCollection(ImageDir, col); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save collection"})
+       return
+   }
+   c.JSON(http.StatusCreated, col)
+}
+
+// handleGetCollection returns a single collection definition.
+func handleGetCollection(c *gin.Context) {
+   col, ok := loadCollectionOr404(c)
+   if !ok {
+       return
+   }
+   c.JSON(http.StatusOK, col)
+}
+
+// handleUpdateCollection replaces the name, query and ordering mode of a collection.
+func handleUpdateCollection(c *gin.Context) {
+   col, ok := loadCollectionOr404(c)
+   if !ok {
+       return
+   }
+   var req collectionRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if err := validateCollectionQuery(req.Query); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   col.Name = req.Name
+   col.Query = req.Query
+   col.ManualOrder = req.ManualOrder
+   if !col.ManualOrder {
+       col.Order = nil
+   }
+   if err := storage.SaveCollection(ImageDir, col); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save collection"})
+       return
+   }
+   c.JSON(http.StatusOK, col)
+}
+
+// handleDeleteCollection removes a collection definition; member images are untouched.
+func handleDeleteCollection(c *gin.Context) {
+   if err := storage.DeleteCollection(ImageDir, c.Param("id")); err != nil {
+       if errors.Is(err, storage.ErrCollectionNotFound) {
+           c.JSON(http.StatusNotFound, gin.H{"error": "collection not found"})
+           return
+       }
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete collection"})
+       return
+   }
+   c.Status(http.StatusNoContent)
+}
+
+// handleSetCollectionOrder stores a manual member order for collections that allow it.
+func handleSetCollectionOrder(c *gin.Context) {
+   col, ok := loadCollectionOr404(c)
+   if !ok {
+       return
+   }
+   if !col.ManualOrder {
+       c.JSON(http.StatusConflict, gin.H{"error": "collection does not allow manual ordering"})
+       return
+   }
+   var req struct {
+       Order []string `json:"order"`
+   }
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   col.Order = req.Order
+   if err := storage.SaveCollection(ImageDir, col); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save collection"})
+       return
+   }
+   c.JSON(http.StatusOK, col)
+}
+
+// loadCollectionRecords resolves the members of the collection with the given ID,
+// writing an error response on failure.
+func loadCollectionRecords(c *gin.Context, id string) ([]imageRecord, bool) {
+   col, err := storage.LoadCollection(ImageDir, id)
+   if err != nil {
+       if errors.Is(err, storage.ErrCollectionNotFound) {
+           c.JSON(http.StatusNotFound, gin.H{"error": "collection not found"})
+       } else {
+           c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load collection"})
+       }
+       return nil, false
+   }
+   return resolveCollection(col), true
+}
+
+// loadCollectionOr404 loads the collection named by the :id parameter, writing an error response on failure.
+func loadCollectionOr404(c *gin.Context) (*storage.Collection, bool) {
+   col, err := storage.LoadCollection(ImageDir, c.Param("id"))
+   if err != nil {
+       if errors.Is(err, storage.ErrCollectionNotFound) {
+           c.JSON(http.StatusNotFound, gin.H{"error": "collection not found"})
+       } else {
+           c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load collection"})
+       }
+       return nil, false
+   }
+   return col, true
+}
+
+// validateCollectionQuery checks that the date bounds of a query are parseable.
+func validateCollectionQuery(q storage.CollectionQuery) error {
+   if q.From != "" {
+       if _, _, err := parseQueryTime(q.From); err != nil {
+           return errors.New("invalid from date")
+       }
+   }
+   if q.To != "" {
+       if _, _, err := parseQueryTime(q.To); err != nil {
+           return errors.New("invalid to date")
+       }
+   }
+   return nil
+}
+
+// parseQueryTime accepts RFC 3339 timestamps or plain YYYY-MM-DD dates.
+// The second return value reports whether only a date was given.
+func parseQueryTime(s string) (time.Time, bool, error) {
+   if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+       return t, false, nil
+   }
+   t, err := time.Parse("2006-01-02", s)
+   return t, true, err
+}
+
+// resolveCollection evaluates a collection's query across all image directories
+// and returns the members in collection order.
+func resolveCollection(col *storage.Collection) []imageRecord {
+   var members []imageRecord
+   seen := make(map[string]bool)
+   for _, sub := range walkImageDirs() {
+       if !pathSelected(col.Query.Paths, sub) {
+           continue
+       }
+       baseDir := ImageDir
+       if sub != "" {
+           baseDir = filepath.Join(ImageDir, sub)
+       }
+       for _, rec := range listImageRecords(sub) {
+           if seen[rec.ID] || !matchesQuery(col.Query, baseDir, rec) {
+               continue
+           }
+           seen[rec.ID] = true
+           rec.Path = sub
+           members = append(members, rec)
+       }
+   }
+   sort.SliceStable(members, func(i, j int) bool { return members[i].TS.Before(members[j].TS) })
+   if col.ManualOrder && len(col.Order) > 0 {
+       members = applyManualOrder(members, col.Order)
+   }
+   return members
+}
+
+// applyManualOrder places members listed in order first, in that order,
+// followed by any remaining members in their existing order.
+func applyManualOrder(members []imageRecord, order []string) []imageRecord {
+   byID := make(map[string]imageRecord, len(members))
+   for _, m := range members {
+       byID[m.ID] = m
+   }
+   out := make([]imageRecord, 0, len(members))
+   placed := make(map[string]bool, len(order))
+   for _, id := range order {
+       if m, ok := byID[id]; ok && !placed[id] {
+           out = append(out, m)
+           placed[id] = true
+       }
+   }
+   for _, m := range members {
+       if !placed[m.ID] {
+           out = append(out, m)
+       }
+   }
+   return out
+}
+
+// pathSelected reports whether sub lies within one of the given directory prefixes.
+// An empty prefix list selects every directory.
+func pathSelected(paths []string, sub string) bool {
+   if len(paths) == 0 {
+       return true
+   }
+   for _, p := range paths {
+       p = strings.Trim(filepath.ToSlash(p), "/")
+       if p == "" || sub == p || strings.HasPrefix(sub, p+"/") {
+           return true
+       }
+   }
+   return false
+}
+
+// matchesQuery reports whether an image record satisfies every constraint in q.
+func matchesQuery(q storage.CollectionQuery, baseDir string, rec imageRecord) bool {
+   if q.From != "" {
+       if from, _, err := parseQueryTime(q.From); err == nil && rec.TS.Before(from) {
+           return false
+       }
+   }
+   if q.To != "" {
+       if to, dateOnly, err := parseQueryTime(q.To); err == nil {
+           // A plain date includes the whole day.
+           if dateOnly {
+               to = to.Add(24 * time.Hour)
+               if !rec.TS.Before(to) {
+                   return false
+               }
+           } else if rec.TS.After(to) {
+               return false
+           }
+       }
+   }
+   if len(q.Tags) > 0 || q.MinRating != nil || q.MaxRating != nil {
+       attrs, err := storage.LoadAttributes(baseDir, rec.ID)
+       if err != nil {
+           return false
+       }
+       for _, tag := range q.Tags {
+           if !containsFold(attrs.Tags, tag) {
+               return false
+           }
+       }
+       if q.MinRating != nil && attrs.Rating < *q.MinRating {
+           return false
+       }
+       if q.MaxRating != nil && attrs.Rating > *q.MaxRating {
+           return false
+       }
+   }
+   if q.DialogText != "" {
+       entries, err := storage.LoadDialogFile(baseDir, rec.Name)
+       if err != nil {
+           return false
+       }
+       needle := strings.ToLower(q.DialogText)
+       found := false
+       for _, e := range entries {
+           if strings.Contains(strings.ToLower(e), needle) {
+               found = true
+               break
+           }
+       }
+       if !found {
+           return false
+       }
+   }
+   if q.Model != "" {
+       params, err := storage.LoadGenerationParams(baseDir, rec.Name, rec.ID)
+       if err != nil || !(generationFilter{Model: q.Model}).matches(params) {
+           return false
+       }
+   }
+   return true
+}
+
+// containsFold checks if a slice contains a string, ignoring case.
+func containsFold(a []string, s string) bool {
+   for _, v := range a {
+       if strings.EqualFold(v, s) {
+           return true
+       }
+   }
+   return false
+}
@@ -0,0 +1,205 @@
+package api_test
+
+import (
+   "bytes"
+   "encoding/binary"
+   "encoding/json"
+   "hash/crc32"
+   "image"
+   "image/color"
+   "image/png"
+   "net/http"
+   "net/http/httptest"
+   "os"
+   "path/filepath"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/storage"
+)
+
+// writeTestPNG writes a small solid-color PNG and returns its content hash.
+func writeTestPNG(t *testing.T, path string, c color.Color) string {
+   t.Helper()
+   if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+       t.Fatalf("mkdir: %v", err)
+   }
+   img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+   for y := 0; y < 4; y++ {
+       for x := 0; x < 4; x++ {
+           img.Set(x, y, c)
+       }
+   }
+   f, err := os.Create(path)
+   if err != nil {
+       t.Fatalf("create %s: %v", path, err)
+   }
+   defer f.Close()
+   if err := png.Encode(f, img); err != nil {
+       t.Fatalf("encode png: %v", err)
+   }
+   f.Close()
+   h, err := storage.HashFile(path)
+   if err != nil {
+       t.Fatalf("hash: %v", err)
+   }
+   return h
+}
+
+// writeLibraryImage points the API at a new image directory and stores a 4x4 image of
+// color c in its sub directory, dated 2024-01-01 in the metadata. It returns the image
+// directory and the image's content hash.
+func writeLibraryImage(t *testing.T, sub string, c color.Color) (string, string) {
+   t.Helper()
+   root := t.TempDir()
+   api.SetImageDir(root)
+   dir := filepath.Join(root, sub)
+   name := "20240101000000-000000000.png"
+   hash := writeTestPNG(t, filepath.Join(dir, name), c)
+   if err := storage.SaveMetaEntry(dir, name, "2024-01-01T00:00:00Z"); err != nil {
+       t.Fatalf("save meta: %v", err)
+   }
+   return root, hash
+}
+
+// writeInfotextPNG writes a 2x2 PNG carrying the given generation infotext in a tEXt chunk.
+func writeInfotextPNG(t *testing.T, path, infotext string) {
+   t.Helper()
+   if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+       t.Fatalf("mkdir: %v", err)
+   }
+   var buf bytes.Buffer
+   if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+       t.Fatalf("encode: %v", err)
+   }
+   raw := buf.Bytes()
+   data := append([]byte("parameters\x00"), infotext...)
+   chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+   chunk = append(chunk, "tEXt"...)
+   chunk = append(chunk, data...)
+   chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+   out := append(append(append([]byte{}, raw[:33]...), chunk...), raw[33:]...)
+   if err := os.WriteFile(path, out, 0644); err != nil {
+       t.Fatalf("write: %v", err)
+   }
+}
+
+// doJSON performs a request against the router with an optional JSON body.
+func doJSON(t *testing.T, method, target string, body interface{}) *httptest.ResponseRecorder {
+   t.Helper()
+   var buf bytes.Buffer
+   if body != nil {
+       if err := json.NewEncoder(&buf).Encode(body); err != nil {
+           t.Fatalf("encode body: %v", err)
+       }
+   }
+   req := httptest.NewRequest(method, target, &buf)
+   req.Header.Set("Content-Type", "application/json")
+   w := httptest.NewRecorder()
+   api.SetupRouter().ServeHTTP(w, req)
+   return w
+}
+
+func TestCollectionResolvesAcrossDirectories(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   red := writeTestPNG(t, filepath.Join(root, "20240101000000-000000001.png"), color.RGBA{255, 0, 0, 255})
+   writeTestPNG(t, filepath.Join(root, "chapter1", "20240102000000-000000001.png"), color.RGBA{0, 255, 0, 255})
+   blue := writeTestPNG(t, filepath.Join(root, "chapter1", "20240103000000-000000001.png"), color.RGBA{0, 0, 255, 255})
+
+   if w := doJSON(t, http.MethodPost, "/api/images/"+red+"/attributes", storage.Attributes{Tags: []string{"Hero"}, Rating: 4}); w.Code != http.StatusOK {
+       t.Fatalf("set attributes: status %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodPost, "/api/images/"+blue+"/attributes?path=chapter1", storage.Attributes{Tags: []string{"hero"}, Rating: 2}); w.Code != http.StatusOK {
+       t.Fatalf("set attributes: status %d", w.Code)
+   }
+
+   w := doJSON(t, http.MethodPost, "/api/collections", map[string]interface{}{
+       "name":         "Heroes",
+       "query":        map[string]interface{}{"tags": []string{"HERO"}},
+       "manual_order": true,
+   })
+   if w.Code != http.StatusCreated {
+       t.Fatalf("create collection: status %d: %s", w.Code, w.Body.String())
+   }
+   var col storage.Collection
+   if err := json.Unmarshal(w.Body.Bytes(), &col); err != nil {
+       t.Fatalf("unmarshal collection: %v", err)
+   }
+
+   w = doJSON(t, http.MethodGet, "/api/images?collection="+col.ID, nil)
+   var imgs []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal images: %v", err)
+   }
+   if len(imgs) != 2 || imgs[0].ID != red || imgs[1].ID != blue {
+       t.Fatalf("unexpected members: %+v", imgs)
+   }
+   if imgs[1].Path != "chapter1" {
+       t.Errorf("expected path chapter1, got %q", imgs[1].Path)
+   }
+
+   if w := doJSON(t, http.MethodPut, "/api/collections/"+col.ID+"/order", map[string][]string{"order": {blue}}); w.Code != http.StatusOK {
+       t.Fatalf("set order: status %d", w.Code)
+   }
+   w = doJSON(t, http.MethodGet, "/api/images?collection="+col.ID, nil)
+   imgs = nil
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal images: %v", err)
+   }
+   if len(imgs) != 2 || imgs[0].ID != blue || imgs[1].ID != red {
+       t.Fatalf("manual order not applied: %+v", imgs)
+   }
+
+   min := 3
+   w = doJSON(t, http.MethodPut, "/api/collections/"+col.ID, map[string]interface{}{
+       "name":  "Top heroes",
+       "query": storage.CollectionQuery{Tags: []string{"hero"}, MinRating: &min},
+   })
+   if w.Code != http.StatusOK {
+       t.Fatalf("update collection: status %d", w.Code)
+   }
+   w = doJSON(t, http.MethodGet, "/api/images?collection="+col.ID, nil)
+   imgs = nil
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal images: %v", err)
+   }
+   if len(imgs) != 1 || imgs[0].ID != red {
+       t.Fatalf("rating filter not applied: %+v", imgs)
+   }
+
+   if w := doJSON(t, http.MethodDelete, "/api/collections/"+col.ID, nil); w.Code != http.StatusNoContent {
+       t.Fatalf("delete collection: status %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodGet, "/api/images?collection="+col.ID, nil); w.Code != http.StatusNotFound {
+       t.Fatalf("expected 404 after delete, got %d", w.Code)
+   }
+}
+
+func TestCollectionSelectsByGenerationModel(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   writeTestPNG(t, filepath.Join(root, "20240101000000-000000001.png"), color.RGBA{255, 0, 0, 255})
+   writeInfotextPNG(t, filepath.Join(root, "chapter1", "20240102000000-000000001.png"), "a cat\nSteps: 20, Sampler: Euler a, Seed: 7, Model: sdxl_base")
+   writeInfotextPNG(t, filepath.Join(root, "20240103000000-000000001.png"), "a dog\nSteps: 20, Sampler: Euler a, Seed: 8, Model: flux_dev")
+
+   w := doJSON(t, http.MethodPost, "/api/collections", map[string]interface{}{
+       "name":  "SDXL",
+       "query": storage.CollectionQuery{Model: "SDXL"},
+   })
+   if w.Code != http.StatusCreated {
+       t.Fatalf("create collection: status %d: %s", w.Code, w.Body.String())
+   }
+   var col storage.Collection
+   if err := json.Unmarshal(w.Body.Bytes(), &col); err != nil {
+       t.Fatalf("unmarshal collection: %v", err)
+   }
+   w = doJSON(t, http.MethodGet, "/api/images?collection="+col.ID, nil)
+   var imgs []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal images: %v", err)
+   }
+   if len(imgs) != 1 || imgs[0].Path != "chapter1" {
+       t.Fatalf("expected only the sdxl image, got %+v", imgs)
+   }
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+   "encoding/base64"
+   "fmt"
+   "image"
+   "net/http"
+
+   "image-processor-backend/internal/imaging"
+   "github.com/gin-gonic/gin"
+)
+
+// cropRequest is the /api/images/:id/crop payload: the rectangle to keep, in pixels of
+// the source image. Without save_to, the crop replaces the image and the previous content
+// is kept as a version.
+type cropRequest struct {
+   X      int `json:"x"`
+   Y      int `json:"y"`
+   Width  int `json:"width"`
+   Height int `json:"height"`
+   editTarget
+}
+
+// handleCrop crops the library image :id (in directory ?path=) to a rectangle.
+func handleCrop(c *gin.Context) {
+   var req cropRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if req.SaveTo == nil {
+       req.Replace = true
+   }
+   if !validateEditTarget(c, req.editTarget, false) {
+       return
+   }
+   sub, err := resolveSubDir(c.Query("path"))
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   sources, err := loadSourceImages(sub, []string{c.Param("id")}, nil)
+   if err != nil {
+       writeSourceError(c, err)
+       return
+   }
+   src, err := imaging.Decode(sources[0].data)
+   if err != nil {
+       c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported image format: " + err.Error()})
+       return
+   }
+   rect := image.Rect(req.X, req.Y, req.X+req.Width, req.Y+req.Height)
+   if req.X < 0 || req.Y < 0 || req.Width < 1 || req.Height < 1 || !rect.In(src.Bounds()) || rect == src.Bounds() {
+       b := src.Bounds()
+       c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("crop must be a smaller rectangle inside the %dx%d image", b.Dx(), b.Dy())})
+       return
+   }
+   data, err := imaging.EncodePNG(imaging.Crop(src, rect))
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   saved, err := storeEditedImages("crop", sub, req.editTarget, req, sources, [][]byte{data}, "", nil)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   c.JSON(http.StatusOK, EditResponse{Image: base64.StdEncoding.EncodeToString(data), Info: []string{}, Saved: saved})
+}
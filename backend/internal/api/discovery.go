@@ -0,0 +1,91 @@
+package api
+
+import (
+   "context"
+   "net/http"
+   "sync"
+
+   "github.com/gin-gonic/gin"
+)
+
+// forgeCache holds discovery results (samplers, upscalers, ...) fetched from SD-Forge.
+// Entries live until a refresh or a model switch, since they only change when the
+// server's configuration does.
+var forgeCache = struct {
+   mu      sync.Mutex
+   entries map[string]interface{}
+}{entries: make(map[string]interface{})}
+
+// invalidateForgeCache drops the given cached discovery results, or all of them if no keys are given.
+func invalidateForgeCache(keys ...string) {
+   forgeCache.mu.Lock()
+   defer forgeCache.mu.Unlock()
+   if len(keys) == 0 {
+       forgeCache.entries = make(map[string]interface{})
+       return
+   }
+   for _, k := range keys {
+       delete(forgeCache.entries, k)
+   }
+}
+
+// cachedDiscovery returns the cached value for key, fetching it from SD-Forge on a miss.
+// Failed fetches are not cached.
+func cachedDiscovery(ctx context.Context, key string, fetch func(context.Context) (interface{}, error)) (interface{}, error) {
+   forgeCache.mu.Lock()
+   v, ok := forgeCache.entries[key]
+   forgeCache.mu.Unlock()
+   if ok {
+       return v, nil
+   }
+   v, err := fetch(ctx)
+   if err != nil {
+       return nil, err
+   }
+   forgeCache.mu.Lock()
+   forgeCache.entries[key] = v
+   forgeCache.mu.Unlock()
+   return v, nil
+}
+
+// discoveryHandler builds a GET handler serving a cached discovery list.
+func discoveryHandler(key string, fetch func(context.Context) (interface{}, error)) gin.HandlerFunc {
+   return func(c *gin.Context) {
+       v, err := cachedDiscovery(c.Request.Context(), key, fetch)
+       if err != nil {
+           writeForgeError(c, err)
+           return
+       }
+       c.JSON(http.StatusOK, v)
+   }
+}
+
+var (
+   handleGetSamplers = discoveryHandler("samplers", func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.Samplers(ctx)
+   })
+   handleGetSchedulers = discoveryHandler("schedulers", func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.Schedulers(ctx)
+   })
+   handleGetUpscalers = discoveryHandler("upscalers", func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.Upscalers(ctx)
+   })
+   handleGetVAEs = discoveryHandler("vaes", func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.VAEs(ctx)
+   })
+   handleGetEmbeddings = discoveryHandler("embeddings", func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.Embeddings(ctx)
+   })
+   handleGetStyles = discoveryHandler("styles", func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.PromptStyles(ctx)
+   })
+   handleGetOptions = discoveryHandler("options", func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.Options(ctx)
+   })
+)
+
+// handleRefreshDiscovery clears the discovery cache so the next requests refetch from SD-Forge.
+func handleRefreshDiscovery(c *gin.Context) {
+   invalidateForgeCache()
+   c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package api_test
+
+import (
+   "context"
+   "encoding/json"
+   "errors"
+   "net/http"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+)
+
+func TestDiscoveryCachingAndInvalidation(t *testing.T) {
+   samplerCalls, embeddingCalls := 0, 0
+   fail := false
+   api.SetForgeClient(&forgeclient.MockClient{
+       SamplersFunc: func(ctx context.Context) ([]forgeclient.SamplerInfo, error) {
+           samplerCalls++
+           if fail {
+               return nil, errors.New("forge down")
+           }
+           return []forgeclient.SamplerInfo{{Name: "Euler a", Aliases: []string{"k_euler_a"}}}, nil
+       },
+       EmbeddingsFunc: func(ctx context.Context) (*forgeclient.EmbeddingsResponse, error) {
+           embeddingCalls++
+           return &forgeclient.EmbeddingsResponse{Loaded: map[string]forgeclient.EmbeddingInfo{"easynegative": {Vectors: 8}}}, nil
+       },
+   })
+
+   for i := 0; i < 2; i++ {
+       w := doJSON(t, http.MethodGet, "/api/v1/samplers", nil)
+       if w.Code != http.StatusOK {
+           t.Fatalf("samplers: expected 200, got %d", w.Code)
+       }
+       var samplers []forgeclient.SamplerInfo
+       if err := json.Unmarshal(w.Body.Bytes(), &samplers); err != nil || len(samplers) != 1 || samplers[0].Name != "Euler a" {
+           t.Fatalf("unexpected samplers: %s", w.Body.String())
+       }
+       doJSON(t, http.MethodGet, "/api/v1/embeddings", nil)
+   }
+   if samplerCalls != 1 || embeddingCalls != 1 {
+       t.Fatalf("expected cached results, got %d sampler and %d embedding calls", samplerCalls, embeddingCalls)
+   }
+
+   // Switching models drops the cache
+   if w := doJSON(t, http.MethodPost, "/api/v1/models/switch", map[string]string{"model": "other"}); w.Code != http.StatusNoContent {
+       t.Fatalf("switch: expected 204, got %d", w.Code)
+   }
+   doJSON(t, http.MethodGet, "/api/v1/embeddings", nil)
+   if embeddingCalls != 2 {
+       t.Errorf("expected refetch after model switch, got %d calls", embeddingCalls)
+   }
+
+   // Refresh drops the cache; failures are reported and not cached
+   fail = true
+   if w := doJSON(t, http.MethodPost, "/api/v1/refresh", nil); w.Code != http.StatusNoContent {
+       t.Fatalf("refresh: expected 204, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodGet, "/api/v1/samplers", nil); w.Code != http.StatusBadGateway {
+       t.Fatalf("expected 502 on upstream failure, got %d", w.Code)
+   }
+   fail = false
+   if w := doJSON(t, http.MethodGet, "/api/v1/samplers", nil); w.Code != http.StatusOK {
+       t.Fatalf("expected recovery after failure, got %d", w.Code)
+   }
+   if samplerCalls != 3 {
+       t.Errorf("expected 3 sampler calls, got %d", samplerCalls)
+   }
+}
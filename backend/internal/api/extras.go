@@ -0,0 +1,340 @@
+package api
+
+import (
+   "crypto/sha256"
+   "encoding/base64"
+   "encoding/hex"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "io/ioutil"
+   "log"
+   "net/http"
+   "path/filepath"
+   "time"
+
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+   "github.com/gin-gonic/gin"
+)
+
+// maxExtrasScale bounds the upscaling factor accepted for extras.
+const maxExtrasScale = 8
+
+// editTarget says where edited images go besides the response: new library images
+// (save_to) or in place of the source images, keeping the old content as a version (replace).
+type editTarget struct {
+   SaveOptions
+   Replace bool `json:"replace,omitempty"`
+}
+
+// extrasRequest is the /api/v1/extras payload. The source is either a library image
+// (image_id in directory path) or inline base64 data (image), not both.
+type extrasRequest struct {
+   forgeclient.ExtrasOptions
+   ImageID string `json:"image_id,omitempty"`
+   Path    string `json:"path,omitempty"`
+   Image   string `json:"image,omitempty"`
+   editTarget
+}
+
+// extrasBatchRequest is the /api/v1/extras/batch payload, with library images (image_ids
+// in directory path) and/or inline base64 images.
+type extrasBatchRequest struct {
+   forgeclient.ExtrasOptions
+   ImageIDs []string `json:"image_ids,omitempty"`
+   Path     string   `json:"path,omitempty"`
+   Images   []string `json:"images,omitempty"`
+   editTarget
+}
+
+// ExtrasResult is the Forge extras response plus any library images saved or replaced.
+type ExtrasResult struct {
+   *forgeclient.ExtrasResponse
+   Saved []ImageResponse `json:"saved,omitempty"`
+}
+
+// ExtrasBatchResult is the Forge batch extras response plus any library images saved or replaced.
+type ExtrasBatchResult struct {
+   *forgeclient.ExtrasBatchResponse
+   Saved []ImageResponse `json:"saved,omitempty"`
+}
+
+// sourceImage is an input image for an edit; name is set for library images.
+type sourceImage struct {
+   data []byte
+   name string
+   hash string
+}
+
+// checkExtrasOptions validates the ranges of extras parameters.
+func checkExtrasOptions(o *forgeclient.ExtrasOptions) error {
+   var p paramChecker
+   p.intRange("resize_mode", o.ResizeMode, 0, 1)
+   p.floatRange("upscaling_resize", o.UpscalingResize, 0, maxExtrasScale)
+   p.intRange("upscaling_resize_w", o.UpscalingResizeW, 0, maxDimension*2)
+   p.intRange("upscaling_resize_h", o.UpscalingResizeH, 0, maxDimension*2)
+   p.floatRange("extras_upscaler_2_visibility", o.Upscaler2Visibility, 0, 1)
+   p.floatRange("gfpgan_visibility", o.GFPGANVisibility, 0, 1)
+   p.floatRange("codeformer_visibility", o.CodeFormerVisibility, 0, 1)
+   p.floatRange("codeformer_weight", o.CodeFormerWeight, 0, 1)
+   return p.err
+}
+
+// validateExtras checks options, sources and the save target of an extras request,
+// writing a 400 response if they are invalid. libraryImages is the number of sources
+// given by image ID and inlineImages the number given as base64 data.
+func validateExtras(c *gin.Context, o *forgeclient.ExtrasOptions, target editTarget, libraryImages, inlineImages int) bool {
+   if err := checkExtrasOptions(o); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   if libraryImages+inlineImages == 0 {
+       c.JSON(http.StatusBadRequest, gin.H{"error": "no source image given"})
+       return false
+   }
+   return validateEditTarget(c, target, inlineImages > 0)
+}
+
+// validateEditTarget checks where edited images go, writing a 400 response if invalid.
+// Replacing is only possible when all sources are library images.
+func validateEditTarget(c *gin.Context, target editTarget, inlineSources bool) bool {
+   if target.Replace {
+       if target.SaveTo != nil {
+           c.JSON(http.StatusBadRequest, gin.H{"error": "replace and save_to are mutually exclusive"})
+           return false
+       }
+       if inlineSources {
+           c.JSON(http.StatusBadRequest, gin.H{"error": "replace requires library images"})
+           return false
+       }
+   }
+   return validateSaveOptions(c, target.SaveOptions)
+}
+
+// loadSourceImages reads library images by ID from sub and decodes inline images.
+// A missing library image yields errImageNotFound.
+func loadSourceImages(sub string, ids, images []string) ([]sourceImage, error) {
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   sources := make([]sourceImage, 0, len(ids)+len(images))
+   for _, id := range ids {
+       name, err := findFilenameByHash(baseDir, id)
+       if err != nil {
+           return nil, err
+       }
+       if name == "" {
+           return nil, fmt.Errorf("%w: %s", errImageNotFound, id)
+       }
+       data, err := ioutil.ReadFile(filepath.Join(baseDir, name))
+       if err != nil {
+           return nil, err
+       }
+       sources = append(sources, sourceImage{data: data, name: name, hash: id})
+   }
+   for i, img := range images {
+       data, err := decodeBase64Image(img)
+       if err != nil {
+           return nil, fmt.Errorf("%w: image %d: %v", errInvalidImage, i, err)
+       }
+       sum := sha256.Sum256(data)
+       sources = append(sources, sourceImage{data: data, hash: hex.EncodeToString(sum[:])})
+   }
+   return sources, nil
+}
+
+var (
+   errImageNotFound = errors.New("image not found")
+   errInvalidImage  = errors.New("invalid image data")
+)
+
+// writeSourceError maps a source loading failure to 404, 400 or 500.
+func writeSourceError(c *gin.Context, err error) {
+   switch {
+   case errors.Is(err, errImageNotFound):
+       c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+   case errors.Is(err, errInvalidImage):
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+   default:
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+   }
+}
+
+// decodeExtrasResults decodes the base64 extras results, which must correspond one to one with sources.
+func decodeExtrasResults(sources []sourceImage, results []string) ([][]byte, error) {
+   if len(results) != len(sources) {
+       return nil, fmt.Errorf("expected %d extras results, got %d", len(sources), len(results))
+   }
+   decoded := make([][]byte, len(results))
+   for i, b64 := range results {
+       data, err := decodeBase64Image(b64)
+       if err != nil {
+           return nil, fmt.Errorf("decode extras result %d: %w", i, err)
+       }
+       decoded[i] = data
+   }
+   return decoded, nil
+}
+
+// storeEditedImages saves edited images as new library images with provenance, or writes
+// them over their sources (library images in directory sub) keeping the old content as a
+// version. decoded corresponds one to one with sources; request and expansion, the
+// templates of the prompts if any, are recorded in provenance.
+func storeEditedImages(op, sub string, target editTarget, request interface{}, sources []sourceImage, decoded [][]byte, info string, expansion *storage.PromptExpansion) ([]ImageResponse, error) {
+   saved := make([]ImageResponse, 0, len(decoded))
+   if target.Replace {
+       baseDir := ImageDir
+       if sub != "" {
+           baseDir = filepath.Join(ImageDir, sub)
+       }
+       for i, data := range decoded {
+           _, hash, err := replaceImageInLibrary(sub, sources[i].name, data, op)
+           if err != nil {
+               return saved, fmt.Errorf("replace %s: %w", sources[i].name, err)
+           }
+           ts, _ := storage.LoadMetaEntry(baseDir, hash)
+           saved = append(saved, ImageResponse{ID: hash, URL: imageURL(sub, hash), Timestamp: ts, Path: sub})
+       }
+       return saved, nil
+   }
+   dest, err := resolveSubDir(*target.SaveTo)
+   if err != nil {
+       return nil, err
+   }
+   times, err := placementTimes(dest, target.Position, len(decoded))
+   if err != nil {
+       return nil, err
+   }
+   destDir := ImageDir
+   if dest != "" {
+       destDir = filepath.Join(ImageDir, dest)
+   }
+   reqJSON, _ := json.Marshal(request)
+   for i, data := range decoded {
+       name, hash, err := saveImageToLibrary(dest, data, times[i])
+       if err != nil {
+           return saved, fmt.Errorf("save %s result %d: %w", op, i, err)
+       }
+       prov := &storage.Provenance{
+           Operation:       op,
+           CreatedAt:       time.Now().UTC().Format(time.RFC3339Nano),
+           SourceHash:      sources[i].hash,
+           Index:           i,
+           Request:         reqJSON,
+           Info:            info,
+           PromptExpansion: expansion,
+       }
+       if err := storage.SaveProvenance(destDir, hash, prov); err != nil {
+           log.Printf("Error saving provenance for %s: %v", name, err)
+       }
+       ts, _ := storage.LoadMetaEntry(destDir, hash)
+       saved = append(saved, ImageResponse{ID: hash, URL: imageURL(dest, hash), Timestamp: ts, Path: dest})
+   }
+   return saved, nil
+}
+
+// handleExtras upscales and/or restores faces in one image via SD-Forge.
+// The image may be referenced by library ID; results can be saved or replace the source.
+func handleExtras(c *gin.Context) {
+   var req extrasRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if req.ImageID != "" && req.Image != "" {
+       c.JSON(http.StatusBadRequest, gin.H{"error": "image_id and image are mutually exclusive"})
+       return
+   }
+   var ids, images []string
+   if req.ImageID != "" {
+       ids = []string{req.ImageID}
+   } else if req.Image != "" {
+       images = []string{req.Image}
+   }
+   if !validateExtras(c, &req.ExtrasOptions, req.editTarget, len(ids), len(images)) {
+       return
+   }
+   sub, err := resolveSubDir(req.Path)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   sources, err := loadSourceImages(sub, ids, images)
+   if err != nil {
+       writeSourceError(c, err)
+       return
+   }
+   resp, err := ForgeSvc.Extras(forgeContext(c), &forgeclient.ExtrasRequest{
+       ExtrasOptions: req.ExtrasOptions,
+       Image:         base64.StdEncoding.EncodeToString(sources[0].data),
+   })
+   if err != nil {
+       writeForgeError(c, err)
+       return
+   }
+   out := ExtrasResult{ExtrasResponse: resp}
+   if req.Replace || req.SaveTo != nil {
+       decoded, err := decodeExtrasResults(sources, []string{resp.Image})
+       if err == nil {
+           out.Saved, err = storeEditedImages("extras", sub, req.editTarget, req.ExtrasOptions, sources, decoded, resp.HTMLInfo, nil)
+       }
+       if err != nil {
+           c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+           return
+       }
+   }
+   c.JSON(http.StatusOK, out)
+}
+
+// handleExtrasBatch applies the same extras to several images via SD-Forge.
+// Images may be referenced by library ID; results can be saved or replace the sources.
+func handleExtrasBatch(c *gin.Context) {
+   var req extrasBatchRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if !validateExtras(c, &req.ExtrasOptions, req.editTarget, len(req.ImageIDs), len(req.Images)) {
+       return
+   }
+   sub, err := resolveSubDir(req.Path)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   sources, err := loadSourceImages(sub, req.ImageIDs, req.Images)
+   if err != nil {
+       writeSourceError(c, err)
+       return
+   }
+   batch := &forgeclient.ExtrasBatchRequest{ExtrasOptions: req.ExtrasOptions}
+   for i, src := range sources {
+       name := src.name
+       if name == "" {
+           name = fmt.Sprintf("image-%d%s", i, imageExtension(src.data))
+       }
+       batch.ImageList = append(batch.ImageList, forgeclient.ExtrasBatchImage{
+           Data: base64.StdEncoding.EncodeToString(src.data),
+           Name: name,
+       })
+   }
+   resp, err := ForgeSvc.ExtrasBatch(forgeContext(c), batch)
+   if err != nil {
+       writeForgeError(c, err)
+       return
+   }
+   out := ExtrasBatchResult{ExtrasBatchResponse: resp}
+   if req.Replace || req.SaveTo != nil {
+       decoded, err := decodeExtrasResults(sources, resp.Images)
+       if err == nil {
+           out.Saved, err = storeEditedImages("extras", sub, req.editTarget, req.ExtrasOptions, sources, decoded, resp.HTMLInfo, nil)
+       }
+       if err != nil {
+           c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+           return
+       }
+   }
+   c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,116 @@
+package api_test
+
+import (
+   "context"
+   "encoding/base64"
+   "encoding/json"
+   "image/color"
+   "net/http"
+   "os"
+   "path/filepath"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+)
+
+func TestExtrasReplaceWithHistoryAndSave(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   album := filepath.Join(root, "album")
+   name := "20240101000000-000000000.png"
+   oldHash := writeTestPNG(t, filepath.Join(album, name), color.RGBA{255, 0, 0, 255})
+   if err := storage.SaveMetaEntry(album, name, "2024-01-01T00:00:00Z"); err != nil {
+       t.Fatalf("save meta: %v", err)
+   }
+   if err := storage.SaveAttributes(album, oldHash, storage.Attributes{Tags: []string{"keep"}, Rating: 4}); err != nil {
+       t.Fatalf("save attributes: %v", err)
+   }
+
+   var gotScale float32
+   var gotImage string
+   upscaled := testPNGBase64(t, 200)
+   api.SetForgeClient(&forgeclient.MockClient{
+       ExtrasFunc: func(ctx context.Context, req *forgeclient.ExtrasRequest) (*forgeclient.ExtrasResponse, error) {
+           gotScale, gotImage = req.UpscalingResize, req.Image
+           return &forgeclient.ExtrasResponse{Image: upscaled, HTMLInfo: "upscaled"}, nil
+       },
+       ExtrasBatchFunc: func(ctx context.Context, req *forgeclient.ExtrasBatchRequest) (*forgeclient.ExtrasBatchResponse, error) {
+           out := &forgeclient.ExtrasBatchResponse{}
+           for i := range req.ImageList {
+               out.Images = append(out.Images, testPNGBase64(t, uint8(10+i)))
+           }
+           return out, nil
+       },
+   })
+
+   body := map[string]interface{}{
+       "image_id": oldHash, "path": "album", "replace": true,
+       "upscaler_1": "R-ESRGAN 4x+", "upscaling_resize": 2, "codeformer_visibility": 0.5,
+   }
+   w := doJSON(t, http.MethodPost, "/api/v1/extras", body)
+   if w.Code != http.StatusOK {
+       t.Fatalf("extras: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   var res api.ExtrasResult
+   if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res.Saved) != 1 {
+       t.Fatalf("unexpected response: %s", w.Body.String())
+   }
+   orig, _ := os.ReadFile(filepath.Join(album, name))
+   if gotScale != 2 || gotImage == "" {
+       t.Errorf("options or source not forwarded: scale=%v", gotScale)
+   }
+   newHash := res.Saved[0].ID
+   if newHash == oldHash || res.Saved[0].Timestamp != "2024-01-01T00:00:00Z" {
+       t.Fatalf("expected new ID with preserved timestamp, got %+v", res.Saved[0])
+   }
+   if want, _ := base64.StdEncoding.DecodeString(upscaled); string(orig) != string(want) {
+       t.Errorf("image file was not replaced in place")
+   }
+   attrs, _ := storage.LoadAttributes(album, newHash)
+   if attrs.Rating != 4 || len(attrs.Tags) != 1 {
+       t.Errorf("attributes not carried over: %+v", attrs)
+   }
+   versions, err := storage.ListVersions(album, newHash)
+   if err != nil || len(versions) != 1 || versions[0].Hash != oldHash || versions[0].Operation != "extras" {
+       t.Fatalf("expected archived version, got %+v (%v)", versions, err)
+   }
+   if _, err := os.Stat(storage.VersionPath(album, newHash, versions[0])); err != nil {
+       t.Errorf("archived file missing: %v", err)
+   }
+
+   // Batch results saved as new images with provenance pointing at the sources
+   body = map[string]interface{}{
+       "image_ids": []string{newHash}, "path": "album",
+       "images":    []string{testPNGBase64(t, 99)},
+       "save_to":   "upscaled", "upscaling_resize": 4,
+   }
+   w = doJSON(t, http.MethodPost, "/api/v1/extras/batch", body)
+   if w.Code != http.StatusOK {
+       t.Fatalf("batch: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   var batch api.ExtrasBatchResult
+   if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil || len(batch.Saved) != 2 {
+       t.Fatalf("unexpected batch response: %s", w.Body.String())
+   }
+   prov, err := storage.LoadProvenance(filepath.Join(root, "upscaled"), batch.Saved[0].ID)
+   if err != nil || prov.Operation != "extras" || prov.SourceHash != newHash {
+       t.Errorf("unexpected provenance: %+v (%v)", prov, err)
+   }
+
+   for _, bad := range []map[string]interface{}{
+       {"image_id": newHash, "path": "album", "upscaling_resize": 20},
+       {"image": testPNGBase64(t, 1), "replace": true},
+       {"image_id": newHash, "path": "album", "image": testPNGBase64(t, 1)},
+       {"image_id": newHash, "path": "album", "replace": true, "save_to": "x"},
+       {},
+   } {
+       if w := doJSON(t, http.MethodPost, "/api/v1/extras", bad); w.Code != http.StatusBadRequest {
+           t.Errorf("%v: expected 400, got %d", bad, w.Code)
+       }
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/extras", map[string]interface{}{"image_id": oldHash, "path": "album"}); w.Code != http.StatusNotFound {
+       t.Errorf("stale ID: expected 404, got %d", w.Code)
+   }
+}
@@ -6,7 +6,19 @@ import "image-processor-backend/internal/forgeclient"
 // It can be overridden in tests with a mock implementation.
 var ForgeSvc forgeclient.Client
 
+// switchNotifier is implemented by clients that switch checkpoints on their own, e.g. for
+// generations requesting one.
+type switchNotifier interface {
+   OnSwitch(fn func(model string))
+}
+
 // SetForgeClient replaces the default ForgeSvc with the provided implementation.
+// Cached discovery results from the previous client are dropped, as they are whenever
+// the client switches checkpoints.
 func SetForgeClient(c forgeclient.Client) {
    ForgeSvc = c
+   invalidateForgeCache()
+   if n, ok := c.(switchNotifier); ok {
+       n.OnSwitch(func(string) { invalidateForgeCache() })
+   }
 }
\ No newline at end of file
@@ -1,89 +1,305 @@
 package api
 
 import (
+   "context"
+   "crypto/sha256"
+   "encoding/base64"
+   "encoding/hex"
+   "errors"
+   "fmt"
    "net/http"
+   "path/filepath"
+   "time"
 
    "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/imaging"
+   "image-processor-backend/internal/storage"
    "github.com/gin-gonic/gin"
 )
 
-// handleTxt2Img handles text-to-image generation via SD-Forge.
-func handleTxt2Img(c *gin.Context) {
-   var req forgeclient.Txt2ImgRequest
-   if err := c.ShouldBindJSON(&req); err != nil {
-       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-       return
+// txt2ImgRequest is the txt2img payload: Forge parameters plus optional library save options
+// and LoRAs to apply. Checkpoint, if set, is loaded for the generation (see withCheckpoint).
+// The prompts are expanded from templates first (see expandPrompts).
+type txt2ImgRequest struct {
+   forgeclient.Txt2ImgRequest
+   SaveOptions
+   promptOptions
+   Loras      []LoraRef `json:"loras,omitempty"`
+   Checkpoint string    `json:"checkpoint,omitempty"`
+}
+
+// img2ImgRequest is the img2img payload: Forge parameters plus optional library save options
+// and LoRAs to apply. ImageID (in directory Path) selects a library image as the init image
+// when InitImages is empty, and MaskID one of its stored masks as the inpainting mask.
+// With Replace, the first generated image is written over the library image. Checkpoint,
+// if set, is loaded for the generation (see withCheckpoint). The prompts are expanded from
+// templates first (see expandPrompts).
+type img2ImgRequest struct {
+   forgeclient.Img2ImgRequest
+   editTarget
+   promptOptions
+   Loras      []LoraRef `json:"loras,omitempty"`
+   ImageID    string    `json:"image_id,omitempty"`
+   Path       string    `json:"path,omitempty"`
+   MaskID     string    `json:"mask_id,omitempty"`
+   Checkpoint string    `json:"checkpoint,omitempty"`
+}
+
+// withCheckpoint returns overrides requesting checkpoint, which the client loads before
+// the generation once running ones are done and, unless
+// override_settings_restore_afterwards is false, switches back from afterwards.
+// overrides is not modified.
+func withCheckpoint(overrides map[string]interface{}, checkpoint string) map[string]interface{} {
+   if checkpoint == "" {
+       return overrides
+   }
+   out := map[string]interface{}{"sd_model_checkpoint": checkpoint}
+   for k, v := range overrides {
+       if k != "sd_model_checkpoint" {
+           out[k] = v
+       }
    }
-   resp, err := ForgeSvc.Txt2Img(c.Request.Context(), &req)
+   return out
+}
+
+// resolveLibraryInputs fills the init image and mask of forgeReq from the library image and
+// stored mask referenced by req, returning the library image (nil when there is none).
+func resolveLibraryInputs(req *img2ImgRequest, forgeReq *forgeclient.Img2ImgRequest) ([]sourceImage, error) {
+   if req.ImageID == "" {
+       return nil, nil
+   }
+   sub, err := resolveSubDir(req.Path)
    if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-       return
+       return nil, err
    }
-   c.JSON(http.StatusOK, resp)
+   sources, err := loadSourceImages(sub, []string{req.ImageID}, nil)
+   if err != nil {
+       return nil, err
+   }
+   if len(forgeReq.InitImages) == 0 {
+       forgeReq.InitImages = []string{base64.StdEncoding.EncodeToString(sources[0].data)}
+   }
+   if req.MaskID == "" {
+       return sources, nil
+   }
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   m, err := storage.LoadMask(baseDir, req.ImageID, req.MaskID)
+   if err != nil {
+       return nil, err
+   }
+   mask, err := renderMask(baseDir, sources[0].name, req.ImageID, m.MaskSpec)
+   if err != nil {
+       return nil, err
+   }
+   data, err := imaging.EncodePNG(mask)
+   if err != nil {
+       return nil, err
+   }
+   forgeReq.Mask = base64.StdEncoding.EncodeToString(data)
+   return sources, nil
 }
 
-// handleImg2Img handles image-to-image (inpainting) requests via SD-Forge.
-func handleImg2Img(c *gin.Context) {
-   var req forgeclient.Img2ImgRequest
-   if err := c.ShouldBindJSON(&req); err != nil {
-       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// upstreamError marks a failure reported by the SD-Forge server rather than by the backend.
+type upstreamError struct {
+   err error
+}
+
+func (e *upstreamError) Error() string { return e.err.Error() }
+func (e *upstreamError) Unwrap() error { return e.err }
+
+// writeGenerationError maps a generation failure to the Forge error status for Forge errors,
+// 400 for prompts that do not expand or positions that do not resolve and 500 otherwise.
+func writeGenerationError(c *gin.Context, err error) {
+   var up *upstreamError
+   if errors.As(err, &up) {
+       writeForgeError(c, err)
        return
    }
-   resp, err := ForgeSvc.Img2Img(c.Request.Context(), &req)
-   if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+   var pe *promptError
+   if errors.As(err, &pe) || errors.Is(err, errInvalidPosition) {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
        return
    }
-   c.JSON(http.StatusOK, resp)
+   c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
 
-// handleProgress retrieves the queue progress from SD-Forge.
-func handleProgress(c *gin.Context) {
-   skip := false
-   if c.Query("skip_current_image") == "true" {
-       skip = true
+// forgeErrorStatus maps a failed Forge call to a response status: 503 when the server is
+// unavailable (or its circuit is open), 504 when it timed out, 501 when the backend does not
+// offer the operation and 502 for errors it reported.
+func forgeErrorStatus(err error) int {
+   switch {
+   case errors.Is(err, forgeclient.ErrTimeout):
+       return http.StatusGatewayTimeout
+   case errors.Is(err, forgeclient.ErrUnavailable), errors.Is(err, forgeclient.ErrNoServers):
+       return http.StatusServiceUnavailable
+   case errors.Is(err, forgeclient.ErrNotSupported):
+       return http.StatusNotImplemented
    }
-   resp, err := ForgeSvc.Progress(c.Request.Context(), skip)
+   return http.StatusBadGateway
+}
+
+// writeForgeError responds to a failed Forge call with the status from forgeErrorStatus.
+func writeForgeError(c *gin.Context, err error) {
+   c.JSON(forgeErrorStatus(err), gin.H{"error": err.Error()})
+}
+
+// runTxt2Img performs a txt2img generation and saves the results if requested.
+func runTxt2Img(ctx context.Context, req *txt2ImgRequest) (*GenerationResponse, error) {
+   forgeReq := req.Txt2ImgRequest
+   expansion, err := expandPrompts(&forgeReq.Prompt, &forgeReq.NegativePrompt, forgeReq.Seed, req.promptOptions)
    if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-       return
+       return nil, err
    }
-   c.JSON(http.StatusOK, resp)
+   forgeReq.Prompt = withLoras(forgeReq.Prompt, req.Loras)
+   forgeReq.OverrideSettings = withCheckpoint(forgeReq.OverrideSettings, req.Checkpoint)
+   resp, err := ForgeSvc.Txt2Img(ctx, &forgeReq)
+   if err != nil {
+       return nil, &upstreamError{err}
+   }
+   out := &GenerationResponse{ImageResponse: resp}
+   if req.SaveTo != nil {
+       if out.Saved, err = saveGeneratedImages("txt2img", req.SaveOptions, resp, forgeReq, "", expansion); err != nil {
+           return nil, err
+       }
+   }
+   return out, nil
 }
 
-// handleRegions is a stub for region-based editing via SD-Forge.
-// TODO: implement region-based editing logic.
-func handleRegions(c *gin.Context) {
-   c.JSON(http.StatusNotImplemented, gin.H{"error": "region editing not implemented"})
+// runImg2Img performs an img2img generation and saves the results or replaces the
+// library source image if requested.
+func runImg2Img(ctx context.Context, req *img2ImgRequest) (*GenerationResponse, error) {
+   forgeReq := req.Img2ImgRequest
+   expansion, err := expandPrompts(&forgeReq.Prompt, &forgeReq.NegativePrompt, forgeReq.Seed, req.promptOptions)
+   if err != nil {
+       return nil, err
+   }
+   forgeReq.Prompt = withLoras(forgeReq.Prompt, req.Loras)
+   forgeReq.OverrideSettings = withCheckpoint(forgeReq.OverrideSettings, req.Checkpoint)
+   sources, err := resolveLibraryInputs(req, &forgeReq)
+   if err != nil {
+       return nil, err
+   }
+   resp, err := ForgeSvc.Img2Img(ctx, &forgeReq)
+   if err != nil {
+       return nil, &upstreamError{err}
+   }
+   out := &GenerationResponse{ImageResponse: resp}
+   if req.Replace {
+       info, err := resp.ParseInfo()
+       if err != nil {
+           info = &forgeclient.GenerationInfo{}
+       }
+       images := generatedImages(resp, info)
+       if len(images) == 0 {
+           return nil, &upstreamError{errors.New("img2img returned no images")}
+       }
+       data, err := decodeBase64Image(images[0])
+       if err != nil {
+           return nil, &upstreamError{fmt.Errorf("decode img2img result: %w", err)}
+       }
+       sub, _ := resolveSubDir(req.Path)
+       if out.Saved, err = storeEditedImages("img2img", sub, req.editTarget, nil, sources, [][]byte{data}, resp.Info, expansion); err != nil {
+           return nil, err
+       }
+       return out, nil
+   }
+   if req.SaveTo != nil {
+       // Record the source image by content hash rather than storing inline image data
+       sourceHash := req.ImageID
+       if sourceHash == "" && len(req.InitImages) > 0 {
+           if data, err := decodeBase64Image(req.InitImages[0]); err == nil {
+               sum := sha256.Sum256(data)
+               sourceHash = hex.EncodeToString(sum[:])
+           }
+       }
+       logged := forgeReq
+       logged.InitImages = nil
+       logged.Mask = ""
+       if out.Saved, err = saveGeneratedImages("img2img", req.SaveOptions, resp, logged, sourceHash, expansion); err != nil {
+           return nil, err
+       }
+   }
+   return out, nil
 }
-// handleExtras applies a single-image extra operation via SD-Forge.
-func handleExtras(c *gin.Context) {
-   var req struct {
-       Operation string `json:"operation"`
-       Image     string `json:"image"`
+
+// forgeContext returns the request's context tagged with the caller's request ID, taken
+// from ?job= or the X-Request-ID header, so that with a server pool progress, interrupt
+// and skip reach the server running that request.
+func forgeContext(c *gin.Context) context.Context {
+   id := c.Query("job")
+   if id == "" {
+       id = c.GetHeader("X-Request-ID")
    }
+   if id == "" {
+       return c.Request.Context()
+   }
+   return forgeclient.WithRequestID(c.Request.Context(), id)
+}
+
+// handleTxt2Img handles text-to-image generation via SD-Forge.
+// When save_to is set, the generated images are also stored in that library directory.
+func handleTxt2Img(c *gin.Context) {
+   var req txt2ImgRequest
    if err := c.ShouldBindJSON(&req); err != nil {
        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
        return
    }
-   resp, err := ForgeSvc.Extras(c.Request.Context(), req.Operation, req.Image)
+   if !validateTxt2Img(c, &req) {
+       return
+   }
+   resp, err := runTxt2Img(forgeContext(c), &req)
    if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+       writeGenerationError(c, err)
        return
    }
    c.JSON(http.StatusOK, resp)
 }
 
-// handleExtrasBatch applies batch extra operations via SD-Forge.
-func handleExtrasBatch(c *gin.Context) {
-   var req struct { Operations []string `json:"operations"`; Images []string `json:"images"` }
+// handleImg2Img handles image-to-image (inpainting) requests via SD-Forge.
+// When save_to is set, the generated images are also stored in that library directory.
+func handleImg2Img(c *gin.Context) {
+   var req img2ImgRequest
    if err := c.ShouldBindJSON(&req); err != nil {
        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
        return
    }
-   resp, err := ForgeSvc.ExtrasBatch(c.Request.Context(), req.Operations, req.Images)
+   if !validateImg2Img(c, &req) {
+       return
+   }
+   resp, err := runImg2Img(forgeContext(c), &req)
    if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+       writeGenerationError(c, err)
+       return
+   }
+   c.JSON(http.StatusOK, resp)
+}
+
+// validateSaveOptions checks the save target before any generation work is done,
+// writing a 400 response if it is invalid.
+func validateSaveOptions(c *gin.Context, opts SaveOptions) bool {
+   if opts.SaveTo == nil {
+       return true
+   }
+   if _, err := resolveSubDir(*opts.SaveTo); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   return true
+}
+
+// handleProgress retrieves the queue progress from SD-Forge, for the request named by
+// ?job= or X-Request-ID when given.
+func handleProgress(c *gin.Context) {
+   skip := false
+   if c.Query("skip_current_image") == "true" {
+       skip = true
+   }
+   resp, err := ForgeSvc.Progress(forgeContext(c), skip)
+   if err != nil {
+       writeForgeError(c, err)
        return
    }
    c.JSON(http.StatusOK, resp)
@@ -93,56 +309,134 @@ func handleExtrasBatch(c *gin.Context) {
 func handleGetModels(c *gin.Context) {
    models, err := ForgeSvc.Models(c.Request.Context())
    if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+       writeForgeError(c, err)
        return
    }
    c.JSON(http.StatusOK, models)
 }
 
-// handleSwitchModel switches the SD model.
+// handleSwitchModel switches the SD model and drops cached discovery results. With a
+// coordinating client, the switch waits for running generations to finish.
 func handleSwitchModel(c *gin.Context) {
    var req struct { Model string `json:"model"` }
    if err := c.ShouldBindJSON(&req); err != nil {
        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
        return
    }
-   if err := ForgeSvc.SwitchModel(c.Request.Context(), req.Model); err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+   err := ForgeSvc.SwitchModel(c.Request.Context(), req.Model)
+   // Embeddings and options depend on the loaded model; even a failed switch may have changed it
+   invalidateForgeCache()
+   if err != nil {
+       writeForgeError(c, err)
        return
    }
    c.Status(http.StatusNoContent)
 }
 
-// handleGetLoras returns list of available LoRAs.
+// modelCoordinator is implemented by clients serializing checkpoint switches with generations.
+type modelCoordinator interface {
+   Status() forgeclient.ModelStatus
+}
+
+// loadedCheckpoint returns the checkpoint loaded on SD-Forge, as known to a coordinating
+// client or read from the options.
+func loadedCheckpoint(ctx context.Context) (string, error) {
+   if co, ok := ForgeSvc.(modelCoordinator); ok {
+       if model := co.Status().Model; model != "" {
+           return model, nil
+       }
+   }
+   opts, err := ForgeSvc.Options(ctx)
+   if err != nil {
+       return "", err
+   }
+   model, _ := opts["sd_model_checkpoint"].(string)
+   return model, nil
+}
+
+// handleGetCurrentModel reports the loaded checkpoint and the progress of a switch. The
+// checkpoint is read from SD-Forge's options when the coordinator does not know it yet, as
+// after startup or a failed switch, or without coordination.
+func handleGetCurrentModel(c *gin.Context) {
+   var st forgeclient.ModelStatus
+   if co, ok := ForgeSvc.(modelCoordinator); ok {
+       st = co.Status()
+   }
+   if st.Model == "" && !st.Switching {
+       model, err := loadedCheckpoint(c.Request.Context())
+       if err != nil {
+           writeForgeError(c, err)
+           return
+       }
+       st.Model = model
+   }
+   c.JSON(http.StatusOK, st)
+}
+
+// handleGetLoras returns list of available LoRAs with their metadata.
 func handleGetLoras(c *gin.Context) {
-   loras, err := ForgeSvc.Loras(c.Request.Context())
+   loras, err := cachedLoras(c.Request.Context())
    if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+       writeForgeError(c, err)
        return
    }
    c.JSON(http.StatusOK, loras)
 }
 
+// handleRefreshLoras makes SD-Forge rescan its LoRA directory and drops the cached listing.
+func handleRefreshLoras(c *gin.Context) {
+   if err := ForgeSvc.RefreshLoras(c.Request.Context()); err != nil {
+       writeForgeError(c, err)
+       return
+   }
+   invalidateForgeCache(lorasCacheKey)
+   c.Status(http.StatusNoContent)
+}
+
 // handlePing checks health of SD-Forge and returns 200 if reachable.
 func handlePing(c *gin.Context) {
    if err := ForgeSvc.Ping(c.Request.Context()); err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+       writeForgeError(c, err)
        return
    }
    c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// handleGetHistory retrieves previous versions for an image.
-func handleGetHistory(c *gin.Context) {
-   imageID := c.Query("imageID")
-   if imageID == "" {
-       c.JSON(http.StatusBadRequest, gin.H{"error": "missing imageID"})
+// serverLister is implemented by clients spreading work over several SD-Forge servers.
+type serverLister interface {
+   Servers() []forgeclient.ServerStatus
+}
+
+// handleGetServers reports the health, load and loaded checkpoint of each SD-Forge server
+// when a server pool is configured. A single server is reported by pinging it.
+func handleGetServers(c *gin.Context) {
+   if pool, ok := ForgeSvc.(serverLister); ok {
+       c.JSON(http.StatusOK, pool.Servers())
        return
    }
-   entries, err := ForgeSvc.History(c.Request.Context(), imageID)
-   if err != nil {
-       c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+   status := forgeclient.ServerStatus{Healthy: true, CheckedAt: time.Now()}
+   if err := ForgeSvc.Ping(c.Request.Context()); err != nil {
+       status.Healthy, status.Error = false, err.Error()
+   }
+   c.JSON(http.StatusOK, []forgeclient.ServerStatus{status})
+}
+
+// handleInterrupt stops the generation currently running on SD-Forge, or only the request
+// named by ?job= or X-Request-ID.
+func handleInterrupt(c *gin.Context) {
+   if err := ForgeSvc.Interrupt(forgeContext(c)); err != nil {
+       writeForgeError(c, err)
        return
    }
-   c.JSON(http.StatusOK, entries)
-}
\ No newline at end of file
+   c.Status(http.StatusNoContent)
+}
+
+// handleSkip skips the current image of a batch on SD-Forge, or of the request named by
+// ?job= or X-Request-ID.
+func handleSkip(c *gin.Context) {
+   if err := ForgeSvc.Skip(forgeContext(c)); err != nil {
+       writeForgeError(c, err)
+       return
+   }
+   c.Status(http.StatusNoContent)
+}
@@ -3,13 +3,24 @@ package api_test
 import (
    "bytes"
    "context"
+   "encoding/base64"
    "encoding/json"
+   "errors"
+   "fmt"
+   "image"
+   "image/color"
+   "image/png"
    "net/http"
    "net/http/httptest"
+   "os"
+   "path/filepath"
+   "strings"
    "testing"
+   "time"
 
    "image-processor-backend/internal/api"
    "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
 )
 
 func TestTxt2ImgHandler(t *testing.T) {
@@ -106,23 +117,400 @@ func TestProgressHandler(t *testing.T) {
    }
 }
 
-func TestRegionsHandler(t *testing.T) {
-   // No need to mock, stub returns 501
-   api.SetForgeClient(&forgeclient.MockClient{})
+// testPNGBase64 returns a base64-encoded 2x2 PNG of the given gray level.
+func testPNGBase64(t *testing.T, level uint8) string {
+   t.Helper()
+   return solidPNGBase64(t, 2, 2, color.Gray{level})
+}
+
+// solidPNG returns a PNG of size w x h filled with c.
+func solidPNG(t *testing.T, w, h int, c color.Color) []byte {
+   t.Helper()
+   img := image.NewNRGBA(image.Rect(0, 0, w, h))
+   for y := 0; y < h; y++ {
+       for x := 0; x < w; x++ {
+           img.Set(x, y, c)
+       }
+   }
+   var buf bytes.Buffer
+   if err := png.Encode(&buf, img); err != nil {
+       t.Fatalf("encode png: %v", err)
+   }
+   return buf.Bytes()
+}
+
+// solidPNGBase64 returns a base64 PNG of size w x h filled with c.
+func solidPNGBase64(t *testing.T, w, h int, c color.Color) string {
+   t.Helper()
+   return base64.StdEncoding.EncodeToString(solidPNG(t, w, h, c))
+}
+
+// decodeB64PNG decodes a base64 PNG.
+func decodeB64PNG(t *testing.T, s string) image.Image {
+   t.Helper()
+   data, err := base64.StdEncoding.DecodeString(s)
+   if err != nil {
+       t.Fatalf("decode base64: %v", err)
+   }
+   img, err := png.Decode(bytes.NewReader(data))
+   if err != nil {
+       t.Fatalf("decode png: %v", err)
+   }
+   return img
+}
+
+func TestTxt2ImgSaveToLibrary(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   info := `{"seed": 42, "all_seeds": [42, 43], "sd_model_name": "sdxl_base", "sd_model_hash": "abc", "infotexts": ["grid", "a cat\nSteps: 5, Seed: 42, Model: sdxl_base", "a cat\nSteps: 5, Seed: 43, Model: sdxl_base"], "index_of_first_image": 1}`
+   mock := &forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return &forgeclient.ImageResponse{
+               Images: []string{testPNGBase64(t, 0), "data:image/png;base64," + testPNGBase64(t, 100), testPNGBase64(t, 200)},
+               Info:   info,
+           }, nil
+       },
+   }
+   api.SetForgeClient(mock)
+
+   w := doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{"prompt": "a cat", "steps": 5, "save_to": "generated"})
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+   }
+   var resp api.GenerationResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+       t.Fatalf("unmarshal response: %v", err)
+   }
+   if len(resp.Images) != 3 || len(resp.Saved) != 2 {
+       t.Fatalf("expected 3 returned and 2 saved images (grid skipped), got %d/%d", len(resp.Images), len(resp.Saved))
+   }
+   if resp.Saved[0].Path != "generated" {
+       t.Errorf("unexpected saved path: %q", resp.Saved[0].Path)
+   }
+
+   w = doJSON(t, http.MethodGet, "/api/images?path=generated", nil)
+   var imgs []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal listing: %v", err)
+   }
+   if len(imgs) != 2 || imgs[0].ID != resp.Saved[0].ID || imgs[1].ID != resp.Saved[1].ID {
+       t.Fatalf("saved images not listed in order: %+v", imgs)
+   }
+
+   w = doJSON(t, http.MethodGet, "/api/images/"+resp.Saved[1].ID+"/provenance?path=generated", nil)
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected provenance, got %d", w.Code)
+   }
+   var prov storage.Provenance
+   if err := json.Unmarshal(w.Body.Bytes(), &prov); err != nil {
+       t.Fatalf("unmarshal provenance: %v", err)
+   }
+   if prov.Operation != "txt2img" || prov.Model != "sdxl_base" || prov.Seed == nil || *prov.Seed != 43 {
+       t.Errorf("unexpected provenance: %+v", prov)
+   }
+   if !strings.Contains(prov.Infotext, "Seed: 43") || !strings.Contains(string(prov.Request), `"prompt":"a cat"`) {
+       t.Errorf("unexpected provenance request/infotext: %s / %s", prov.Request, prov.Infotext)
+   }
+
+   if w := doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{"prompt": "x", "save_to": "metadata"}); w.Code != http.StatusBadRequest {
+       t.Errorf("expected 400 for reserved directory, got %d", w.Code)
+   }
+}
+
+func TestSavedImageTakesNextFreeNameAfterItsPosition(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   prev := writeTestPNG(t, filepath.Join(root, "20240101000000-000000000.png"), color.Black)
+   next := writeTestPNG(t, filepath.Join(root, "20240101000001-000000000.png"), color.White)
+   for name, ts := range map[string]string{
+       "20240101000000-000000000.png": "2024-01-01T00:00:00Z",
+       "20240101000001-000000000.png": "2024-01-01T00:00:01Z",
+   } {
+       if err := storage.SaveMetaEntry(root, name, ts); err != nil {
+           t.Fatalf("save meta: %v", err)
+       }
+   }
+   // The midpoint name is already taken
+   writeTestPNG(t, filepath.Join(root, "20240101000000-500000000.png"), color.Gray{128})
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 10)}, Info: `{"seed": 1}`}, nil
+       },
+   })
+   w := doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{
+       "prompt": "x", "save_to": "", "position": map[string]string{"prev_id": prev, "next_id": next},
+   })
+   var resp api.GenerationResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || len(resp.Saved) != 1 {
+       t.Fatalf("txt2img: %d %s", w.Code, w.Body.String())
+   }
+   if _, err := os.Stat(filepath.Join(root, "20240101000000-500000001.png")); err != nil {
+       t.Errorf("expected the name one nanosecond after the taken one: %v", err)
+   }
+   if ts, err := time.Parse(time.RFC3339Nano, resp.Saved[0].Timestamp); err != nil || ts.Nanosecond() != 500000001 {
+       t.Errorf("unexpected timestamp %s", resp.Saved[0].Timestamp)
+   }
+}
+
+func TestSavedImageStaysNextToASingleNeighbor(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   var ids []string
+   for i, ts := range []string{"2024-01-01T00:00:00.25Z", "2024-01-01T00:00:00.5Z", "2024-01-01T00:00:00.75Z"} {
+       name := fmt.Sprintf("20240101000000-%d50000000.png", i)
+       ids = append(ids, writeTestPNG(t, filepath.Join(root, name), color.Gray{uint8(50 * i)}))
+       if err := storage.SaveMetaEntry(root, name, ts); err != nil {
+           t.Fatalf("save meta: %v", err)
+       }
+   }
+   generated := 0
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           generated++
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, uint8(200+generated))}, Info: `{"seed": 1}`}, nil
+       },
+   })
+   for _, tc := range []struct {
+       position map[string]string
+       lo, hi   time.Duration
+   }{
+       {map[string]string{"prev_id": ids[0]}, 250 * time.Millisecond, 500 * time.Millisecond},
+       {map[string]string{"next_id": ids[2]}, 500 * time.Millisecond, 750 * time.Millisecond},
+   } {
+       w := doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{"prompt": "x", "save_to": "", "position": tc.position})
+       var resp api.GenerationResponse
+       if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || len(resp.Saved) != 1 {
+           t.Fatalf("txt2img: %d %s", w.Code, w.Body.String())
+       }
+       ts, _ := time.Parse(time.RFC3339Nano, resp.Saved[0].Timestamp)
+       if offset := ts.Sub(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); offset <= tc.lo || offset >= tc.hi {
+           t.Errorf("%v: expected a timestamp between its neighbors, got %s", tc.position, resp.Saved[0].Timestamp)
+       }
+   }
+
+   w := doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{
+       "prompt": "x", "save_to": "", "position": map[string]string{"prev_id": ids[2], "next_id": ids[0]},
+   })
+   if w.Code != http.StatusBadRequest {
+       t.Errorf("neighbors out of order: expected 400, got %d: %s", w.Code, w.Body.String())
+   }
+}
+
+func TestInterruptAndSkipHandlers(t *testing.T) {
+   started := make(chan struct{})
+   mock := &forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           close(started)
+           <-ctx.Done()
+           return nil, ctx.Err()
+       },
+   }
+   api.SetForgeClient(mock)
+
+   if w := doJSON(t, http.MethodPost, "/api/v1/interrupt", nil); w.Code != http.StatusNoContent {
+       t.Fatalf("interrupt: expected 204, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/skip", nil); w.Code != http.StatusNoContent {
+       t.Fatalf("skip: expected 204, got %d", w.Code)
+   }
+   if mock.InterruptCalls() != 1 || mock.SkipCalls() != 1 {
+       t.Fatalf("unexpected calls: interrupt=%d skip=%d", mock.InterruptCalls(), mock.SkipCalls())
+   }
+
+   // A client disconnect cancels the request context, which interrupts the generation
+   ctx, cancel := context.WithCancel(context.Background())
+   done := make(chan struct{})
+   go func() {
+       defer close(done)
+       req := httptest.NewRequest(http.MethodPost, "/api/v1/txt2img", strings.NewReader(`{"prompt":"x"}`)).WithContext(ctx)
+       req.Header.Set("Content-Type", "application/json")
+       api.SetupRouter().ServeHTTP(httptest.NewRecorder(), req)
+   }()
+   <-started
+   cancel()
+   <-done
+   if mock.InterruptCalls() != 2 {
+       t.Errorf("expected interrupt after cancellation, got %d calls", mock.InterruptCalls())
+   }
+
+   mock.SkipFunc = func(ctx context.Context) error { return errors.New("forge down") }
+   if w := doJSON(t, http.MethodPost, "/api/v1/skip", nil); w.Code != http.StatusBadGateway {
+       t.Errorf("skip failure: expected 502, got %d", w.Code)
+   }
+}
+
+func TestGenerationParamsPassThroughAndValidation(t *testing.T) {
+   var got forgeclient.Txt2ImgRequest
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           got = *req
+           return &forgeclient.ImageResponse{}, nil
+       },
+   })
+
+   body := map[string]interface{}{
+       "prompt":            "castle",
+       "sampler_name":      "DPM++ 2M",
+       "scheduler":         "Karras",
+       "batch_size":        2,
+       "n_iter":            3,
+       "enable_hr":         true,
+       "hr_scale":          2,
+       "tiling":            false,
+       "override_settings": map[string]interface{}{"CLIP_stop_at_last_layers": 2},
+       "alwayson_scripts":  map[string]interface{}{"controlnet": map[string]interface{}{"args": []interface{}{map[string]interface{}{"module": "canny"}}}},
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/txt2img", body); w.Code != http.StatusOK {
+       t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   if got.SamplerName != "DPM++ 2M" || got.Scheduler != "Karras" || got.BatchSize != 2 || got.NIter != 3 || !got.EnableHR || got.HRScale != 2 {
+       t.Errorf("parameters not forwarded: %+v", got)
+   }
+   if got.Tiling == nil || *got.Tiling {
+       t.Errorf("explicit tiling=false not preserved")
+   }
+   if !strings.Contains(string(got.AlwaysonScripts), `"module":"canny"`) {
+       t.Errorf("alwayson_scripts not passed through: %s", got.AlwaysonScripts)
+   }
+
+   for _, tc := range []struct {
+       path string
+       body map[string]interface{}
+   }{
+       {"/api/v1/txt2img", map[string]interface{}{"prompt": "x", "steps": 500}},
+       {"/api/v1/txt2img", map[string]interface{}{"prompt": "x", "width": 100}},
+       {"/api/v1/txt2img", map[string]interface{}{"prompt": "x", "batch_size": 64}},
+       {"/api/v1/txt2img", map[string]interface{}{"prompt": "x", "alwayson_scripts": []int{1}}},
+       {"/api/v1/img2img", map[string]interface{}{"prompt": "x"}},
+       {"/api/v1/img2img", map[string]interface{}{"prompt": "x", "init_images": []string{"a"}, "denoising_strength": 1.5}},
+       {"/api/v1/img2img", map[string]interface{}{"prompt": "x", "init_images": []string{"a"}, "inpainting_fill": 7}},
+       {"/api/v1/img2img", map[string]interface{}{"prompt": "x", "init_images": []string{"a"}, "mask_blur": -1}},
+   } {
+       if w := doJSON(t, http.MethodPost, tc.path, tc.body); w.Code != http.StatusBadRequest {
+           t.Errorf("%s %v: expected 400, got %d", tc.path, tc.body, w.Code)
+       }
+   }
+}
+
+func TestForgeErrorsMapToStatus(t *testing.T) {
+   var modelsErr error
+   api.SetForgeClient(&forgeclient.MockClient{
+       ModelsFunc: func(ctx context.Context) ([]forgeclient.ModelInfo, error) { return nil, modelsErr },
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return nil, &forgeclient.RequestError{Op: "txt2img", Kind: forgeclient.ErrTimeout, Err: errors.New("timed out after 10m0s")}
+       },
+   })
    router := api.SetupRouter()
+   for _, tc := range []struct {
+       err  error
+       want int
+   }{
+       {&forgeclient.RequestError{Op: "models", Kind: forgeclient.ErrUnavailable, Err: errors.New("connection refused")}, http.StatusServiceUnavailable},
+       {&forgeclient.RequestError{Op: "models", Kind: forgeclient.ErrCircuitOpen, Err: forgeclient.ErrCircuitOpen}, http.StatusServiceUnavailable},
+       {&forgeclient.RequestError{Op: "models", Kind: forgeclient.ErrTimeout, Err: errors.New("timed out")}, http.StatusGatewayTimeout},
+       {&forgeclient.RequestError{Op: "models", StatusCode: 500, Err: errors.New("boom")}, http.StatusBadGateway},
+       {forgeclient.ErrNoServers, http.StatusServiceUnavailable},
+       {fmt.Errorf("%w: models", forgeclient.ErrNotSupported), http.StatusNotImplemented},
+   } {
+       modelsErr = tc.err
+       w := httptest.NewRecorder()
+       router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/models", nil))
+       if w.Code != tc.want {
+           t.Errorf("%v: expected %d, got %d", tc.err, tc.want, w.Code)
+       }
+   }
 
    w := httptest.NewRecorder()
-   req := httptest.NewRequest(http.MethodPost, "/api/v1/regions", nil)
+   req := httptest.NewRequest(http.MethodPost, "/api/v1/txt2img", strings.NewReader(`{"prompt": "x"}`))
+   req.Header.Set("Content-Type", "application/json")
    router.ServeHTTP(w, req)
+   if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), "txt2img timed out") {
+       t.Errorf("expected a generation timeout to give 504, got %d %s", w.Code, w.Body)
+   }
+}
+
+func TestCurrentModelIsKnownBeforeTheFirstGeneration(t *testing.T) {
+   switchErr := errors.New("out of memory")
+   api.SetForgeClient(forgeclient.NewCoordinator(&forgeclient.MockClient{
+       OptionsFunc: func(ctx context.Context) (map[string]interface{}, error) {
+           return map[string]interface{}{"sd_model_checkpoint": "sdxl.safetensors"}, nil
+       },
+       SwitchModelFunc: func(ctx context.Context, model string) error {
+           return switchErr
+       },
+   }))
+
+   w := doJSON(t, http.MethodGet, "/api/v1/models/current", nil)
+   var st forgeclient.ModelStatus
+   if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || w.Code != http.StatusOK || st.Model != "sdxl.safetensors" {
+       t.Errorf("fresh coordinator: expected the loaded checkpoint, got %d %s", w.Code, w.Body.String())
+   }
+
+   if w := doJSON(t, http.MethodPost, "/api/v1/models/switch", map[string]string{"model": "flux1-dev"}); w.Code == http.StatusNoContent {
+       t.Fatalf("expected the switch to fail")
+   }
+   w = doJSON(t, http.MethodGet, "/api/v1/models/current", nil)
+   st = forgeclient.ModelStatus{}
+   if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || w.Code != http.StatusOK || st.Model != "sdxl.safetensors" || st.LastError == "" {
+       t.Errorf("after a failed switch: expected the loaded checkpoint and the error, got %d %s", w.Code, w.Body.String())
+   }
+}
+
+func TestGenerationCheckpointAndCurrentModel(t *testing.T) {
+   loaded := "sdxl.safetensors [0123456789]"
+   var sent map[string]interface{}
+   mock := &forgeclient.MockClient{
+       OptionsFunc: func(ctx context.Context) (map[string]interface{}, error) {
+           return map[string]interface{}{"sd_model_checkpoint": loaded}, nil
+       },
+       SwitchModelFunc: func(ctx context.Context, model string) error {
+           loaded = model
+           return nil
+       },
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           sent = req.OverrideSettings
+           return &forgeclient.ImageResponse{Images: []string{"AAA"}}, nil
+       },
+   }
+   // Without coordination the checkpoint is passed on to the server
+   api.SetForgeClient(mock)
+   router := api.SetupRouter()
+   post := func(body string) int {
+       w := httptest.NewRecorder()
+       req := httptest.NewRequest(http.MethodPost, "/api/v1/txt2img", strings.NewReader(body))
+       req.Header.Set("Content-Type", "application/json")
+       router.ServeHTTP(w, req)
+       return w.Code
+   }
+   if code := post(`{"prompt": "x", "checkpoint": "flux1-dev", "override_settings": {"CLIP_stop_at_last_layers": 2}}`); code != http.StatusOK {
+       t.Fatalf("expected 200, got %d", code)
+   }
+   if sent["sd_model_checkpoint"] != "flux1-dev" || sent["CLIP_stop_at_last_layers"] != float64(2) {
+       t.Errorf("unexpected override settings: %v", sent)
+   }
+   current := func() forgeclient.ModelStatus {
+       w := httptest.NewRecorder()
+       router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/models/current", nil))
+       var st forgeclient.ModelStatus
+       if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &st) != nil {
+           t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+       }
+       return st
+   }
+   if st := current(); st.Model != loaded {
+       t.Errorf("expected the model from the options, got %+v", st)
+   }
 
-   if w.Code != http.StatusNotImplemented {
-       t.Fatalf("expected status 501, got %d", w.Code)
+   // With coordination the backend switches itself and keeps the checkpoint when asked to
+   api.SetForgeClient(forgeclient.NewCoordinator(mock))
+   router = api.SetupRouter()
+   if code := post(`{"prompt": "x", "checkpoint": "flux1-dev", "override_settings_restore_afterwards": false}`); code != http.StatusOK {
+       t.Fatalf("expected 200, got %d", code)
    }
-   var errResp map[string]string
-   if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
-       t.Fatalf("unmarshal error response: %v", err)
+   if _, ok := sent["sd_model_checkpoint"]; ok || loaded != "flux1-dev" {
+       t.Errorf("expected the backend to switch to flux1-dev, sent %v with %s loaded", sent, loaded)
    }
-   if msg, ok := errResp["error"]; !ok || msg == "" {
-       t.Errorf("unexpected error message: %v", errResp)
+   if st := current(); st.Model != "flux1-dev" || st.Switching || st.RestoreTo != "" {
+       t.Errorf("unexpected model status: %+v", st)
    }
-}
\ No newline at end of file
+}
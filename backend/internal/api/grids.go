@@ -0,0 +1,470 @@
+package api
+
+import (
+   "bytes"
+   "context"
+   "encoding/json"
+   "fmt"
+   "image"
+   "log"
+   "math/rand"
+   "net/http"
+   "os"
+   "path"
+   "path/filepath"
+   "sort"
+   "strings"
+   "time"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/imaging"
+   "image-processor-backend/internal/jobs"
+   "image-processor-backend/internal/storage"
+)
+
+// Limits of an X/Y/Z grid, and the size of its cells on the contact sheet.
+const (
+   maxGridAxes  = 3
+   maxGridCells = 256
+   gridCellSize = 256
+)
+
+// promptSR is the axis parameter replacing text in the prompts ("prompt search/replace"):
+// the first value is searched for in the base prompts and replaced with each value in turn.
+const promptSR = "prompt_sr"
+
+// gridFixedParams cannot be varied: saving is done by the grid and every cell is one image.
+var gridFixedParams = map[string]bool{"save_to": true, "position": true, "batch_size": true, "n_iter": true}
+
+// gridAxis varies one txt2img parameter, named by its JSON field (e.g. seed, cfg_scale,
+// sampler_name, checkpoint, variables), over Values.
+type gridAxis struct {
+   Param  string            `json:"param"`
+   Values []json.RawMessage `json:"values"`
+}
+
+// gridRequest is an X/Y/Z grid: Base is generated once for every combination of the values
+// of up to three axes (X, Y and Z in that order). The images are saved in grid order into
+// the new library directory Name (grid-<time>-<random suffix> by default) under SaveTo,
+// followed by a labeled contact sheet with one panel per Z value.
+type gridRequest struct {
+   Base   txt2ImgRequest `json:"base"`
+   Axes   []gridAxis     `json:"axes"`
+   SaveTo string         `json:"save_to,omitempty"`
+   Name   string         `json:"name,omitempty"`
+}
+
+// gridCell is one generated combination: its position on the X, Y and Z axes, the axis
+// values as labels and the saved image.
+type gridCell struct {
+   X      int            `json:"x"`
+   Y      int            `json:"y"`
+   Z      int            `json:"z"`
+   Values []string       `json:"values"`
+   Image  *ImageResponse `json:"image,omitempty"`
+}
+
+// gridResult is the outcome of a grid: the library directory, the cells in grid order and
+// the contact sheet.
+type gridResult struct {
+   Path  string         `json:"path"`
+   Cells []gridCell     `json:"cells"`
+   Sheet *ImageResponse `json:"sheet,omitempty"`
+}
+
+// gridDir returns the library directory a grid is saved to.
+func (r *gridRequest) gridDir() (string, error) {
+   if strings.ContainsAny(r.Name, `/\`) {
+       return "", fmt.Errorf("invalid grid name %q", r.Name)
+   }
+   return resolveSubDir(path.Join(r.SaveTo, r.Name))
+}
+
+// axisLength returns the number of values of axis i, 1 for missing axes.
+func (r *gridRequest) axisLength(i int) int {
+   if i < len(r.Axes) {
+       return len(r.Axes[i].Values)
+   }
+   return 1
+}
+
+// cell returns the request for the combination of the values at x, y and z.
+func (r *gridRequest) cell(x, y, z int) (*txt2ImgRequest, error) {
+   data, err := json.Marshal(r.Base)
+   if err != nil {
+       return nil, err
+   }
+   var req txt2ImgRequest
+   if err := json.Unmarshal(data, &req); err != nil {
+       return nil, err
+   }
+   for i, pos := range []int{x, y, z}[:len(r.Axes)] {
+       if err := applyGridValue(&req, r.Axes[i], pos); err != nil {
+           return nil, err
+       }
+   }
+   return &req, nil
+}
+
+// applyGridValue sets the parameter of axis to its value at index i in req.
+func applyGridValue(req *txt2ImgRequest, axis gridAxis, i int) error {
+   if axis.Param == promptSR {
+       var search, replace string
+       if json.Unmarshal(axis.Values[0], &search) != nil || json.Unmarshal(axis.Values[i], &replace) != nil {
+           return fmt.Errorf("%s values must be strings", promptSR)
+       }
+       req.Prompt = strings.ReplaceAll(req.Prompt, search, replace)
+       req.NegativePrompt = strings.ReplaceAll(req.NegativePrompt, search, replace)
+       return nil
+   }
+   doc, err := json.Marshal(map[string]json.RawMessage{axis.Param: axis.Values[i]})
+   if err != nil {
+       return fmt.Errorf("axis %s: %w", axis.Param, err)
+   }
+   dec := json.NewDecoder(bytes.NewReader(doc))
+   dec.DisallowUnknownFields()
+   if err := dec.Decode(req); err != nil {
+       return fmt.Errorf("axis %s: %w", axis.Param, err)
+   }
+   return nil
+}
+
+// gridLabel formats the value at index i of axis for the contact sheet.
+func gridLabel(axis gridAxis, i int) string {
+   var s string
+   if json.Unmarshal(axis.Values[i], &s) != nil {
+       s = string(axis.Values[i])
+   }
+   return axis.Param + ": " + s
+}
+
+// prepareGrid fills in the defaults of a grid: a directory name, a fixed seed so that the
+// cells differ only in their axis values, and one image per cell.
+func prepareGrid(req *gridRequest) {
+   if req.Name == "" {
+       // The suffix keeps grids submitted within the same second apart
+       req.Name = fmt.Sprintf("grid-%s-%04x", time.Now().Format("20060102-150405"), rand.Intn(0x10000))
+   }
+   if req.Base.Seed == nil || *req.Base.Seed < 0 {
+       seed := int(rand.Int31())
+       req.Base.Seed = &seed
+   }
+   req.Base.BatchSize, req.Base.NIter = 1, 1
+   req.Base.SaveOptions = SaveOptions{}
+}
+
+// validateGrid checks a grid and every one of its cells before any generation work is
+// done, writing a 400 response (409 if the directory exists) if it is invalid.
+func validateGrid(c *gin.Context, req *gridRequest) bool {
+   if len(req.Axes) == 0 || len(req.Axes) > maxGridAxes {
+       c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a grid needs 1 to %d axes", maxGridAxes)})
+       return false
+   }
+   cells := 1
+   for _, axis := range req.Axes {
+       if axis.Param == "" || gridFixedParams[axis.Param] || len(axis.Values) == 0 {
+           c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid axis %q: needs a variable parameter and at least one value", axis.Param)})
+           return false
+       }
+       cells *= len(axis.Values)
+   }
+   if cells > maxGridCells {
+       c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a grid may have at most %d cells, not %d", maxGridCells, cells)})
+       return false
+   }
+   dir, err := req.gridDir()
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   if _, err := os.Stat(filepath.Join(ImageDir, dir)); err == nil {
+       c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("directory %q already exists", dir)})
+       return false
+   }
+   for _, axis := range req.Axes {
+       var search string
+       if axis.Param == promptSR && (json.Unmarshal(axis.Values[0], &search) != nil ||
+           !strings.Contains(req.Base.Prompt+"\n"+req.Base.NegativePrompt, search)) {
+           c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: the first value must be a string found in the prompt", promptSR)})
+           return false
+       }
+   }
+   for z := 0; z < req.axisLength(2); z++ {
+       for y := 0; y < req.axisLength(1); y++ {
+           for x := 0; x < req.axisLength(0); x++ {
+               cell, err := req.cell(x, y, z)
+               if err != nil {
+                   c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                   return false
+               }
+               if !validateTxt2Img(c, cell) {
+                   return false
+               }
+           }
+       }
+   }
+   return true
+}
+
+// checkpointAxis returns the index of the axis varying the checkpoint, or -1.
+func (r *gridRequest) checkpointAxis() int {
+   for i, axis := range r.Axes {
+       if axis.Param == "checkpoint" {
+           return i
+       }
+       if axis.Param == "override_settings" {
+           for _, v := range axis.Values {
+               var overrides map[string]interface{}
+               if json.Unmarshal(v, &overrides) == nil && overrides["sd_model_checkpoint"] != nil {
+                   return i
+               }
+           }
+       }
+   }
+   return -1
+}
+
+// generationOrder returns the X, Y and Z positions of all cells in the order they are
+// generated: grid order, except that the checkpoint axis is outermost so that each
+// checkpoint is loaded once.
+func (r *gridRequest) generationOrder() [][3]int {
+   var order [][3]int
+   for z := 0; z < r.axisLength(2); z++ {
+       for y := 0; y < r.axisLength(1); y++ {
+           for x := 0; x < r.axisLength(0); x++ {
+               order = append(order, [3]int{x, y, z})
+           }
+       }
+   }
+   if i := r.checkpointAxis(); i >= 0 {
+       sort.SliceStable(order, func(a, b int) bool { return order[a][i] < order[b][i] })
+   }
+   return order
+}
+
+// switchesCheckpoint reports whether the cells of r load a checkpoint of their own.
+func (r *gridRequest) switchesCheckpoint() bool {
+   return r.Base.Checkpoint != "" || r.Base.OverrideSettings["sd_model_checkpoint"] != nil || r.checkpointAxis() >= 0
+}
+
+// restoreCheckpoint loads model again after a grid that switched checkpoints, unless it is
+// empty.
+func restoreCheckpoint(ctx context.Context, model string) {
+   if model == "" {
+       return
+   }
+   err := ForgeSvc.SwitchModel(context.WithoutCancel(ctx), model)
+   invalidateForgeCache()
+   if err != nil {
+       log.Printf("Error restoring checkpoint %s after grid: %v", model, err)
+   }
+}
+
+// runGrid creates the grid's directory and generates the grid into it (see generateGrid).
+// The directory is removed again if the grid fails, so that it can be retried.
+func runGrid(ctx context.Context, req *gridRequest) (*gridResult, error) {
+   dir, err := req.gridDir()
+   if err != nil {
+       return nil, err
+   }
+   full := filepath.Join(ImageDir, dir)
+   if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+       return nil, err
+   }
+   if err := os.Mkdir(full, 0755); err != nil {
+       if os.IsExist(err) {
+           return nil, fmt.Errorf("directory %q already exists", dir)
+       }
+       return nil, err
+   }
+   result, err := generateGrid(ctx, req, dir)
+   if err != nil {
+       if rerr := os.RemoveAll(full); rerr != nil {
+           log.Printf("Error removing directory of failed grid %s: %v", dir, rerr)
+       }
+       return nil, err
+   }
+   return result, nil
+}
+
+// generateGrid generates the cells of a grid one after another, saving each into dir in
+// grid order, and then composes and saves the contact sheet. Cells that load a checkpoint
+// do not switch back; unless the base request disables restoring, the checkpoint loaded
+// before the grid is restored once at the end.
+func generateGrid(ctx context.Context, req *gridRequest, dir string) (*gridResult, error) {
+   nx, ny := req.axisLength(0), req.axisLength(1)
+   order := req.generationOrder()
+   // Cells may be generated out of grid order, so their timestamps are fixed up front
+   times, err := placementTimes(dir, nil, len(order)+1)
+   if err != nil {
+       return nil, err
+   }
+   switches := req.switchesCheckpoint()
+   if switches && (req.Base.OverrideSettingsRestoreAfterwards == nil || *req.Base.OverrideSettingsRestoreAfterwards) {
+       previous, err := loadedCheckpoint(ctx)
+       if err != nil {
+           log.Printf("Error reading the loaded checkpoint before grid: %v", err)
+       }
+       defer restoreCheckpoint(ctx, previous)
+   }
+   cells := make([]gridCell, len(order))
+   thumbs := make([]image.Image, len(order))
+   for _, p := range order {
+       x, y, z := p[0], p[1], p[2]
+       i := x + nx*(y+ny*z)
+       cell, err := req.cell(x, y, z)
+       if err != nil {
+           return nil, err
+       }
+       cell.SaveTo = &dir
+       cell.at = times[i]
+       if switches {
+           restore := false
+           cell.OverrideSettingsRestoreAfterwards = &restore
+       }
+       resp, err := runTxt2Img(ctx, cell)
+       if err != nil {
+           return nil, fmt.Errorf("cell %d,%d,%d: %w", x, y, z, err)
+       }
+       out := gridCell{X: x, Y: y, Z: z}
+       for i, pos := range p[:len(req.Axes)] {
+           out.Values = append(out.Values, gridLabel(req.Axes[i], pos))
+       }
+       if len(resp.Saved) > 0 {
+           out.Image = &resp.Saved[0]
+       }
+       cells[i], thumbs[i] = out, gridThumbnail(resp.ImageResponse)
+   }
+
+   panels := make([]imaging.SheetPanel, req.axisLength(2))
+   for z := range panels {
+       panel := &panels[z]
+       if len(req.Axes) > 2 {
+           panel.Title = gridLabel(req.Axes[2], z)
+       }
+       for x := 0; x < nx; x++ {
+           panel.ColLabels = append(panel.ColLabels, gridLabel(req.Axes[0], x))
+       }
+       for y := 0; y < ny; y++ {
+           if len(req.Axes) > 1 {
+               panel.RowLabels = append(panel.RowLabels, gridLabel(req.Axes[1], y))
+           }
+           start := nx * (y + ny*z)
+           panel.Cells = append(panel.Cells, thumbs[start:start+nx])
+       }
+   }
+   sheet, err := saveContactSheet(dir, times[len(order)], panels, req)
+   if err != nil {
+       return nil, err
+   }
+   return &gridResult{Path: dir, Cells: cells, Sheet: sheet}, nil
+}
+
+// gridThumbnail decodes the first generated image of resp scaled down to a contact sheet
+// cell, or returns nil if there is none.
+func gridThumbnail(resp *forgeclient.ImageResponse) image.Image {
+   info, err := resp.ParseInfo()
+   if err != nil {
+       info = &forgeclient.GenerationInfo{}
+   }
+   images := generatedImages(resp, info)
+   if len(images) == 0 {
+       return nil
+   }
+   data, err := decodeBase64Image(images[0])
+   if err != nil {
+       return nil
+   }
+   img, err := imaging.Decode(data)
+   if err != nil {
+       return nil
+   }
+   return imaging.Fit(img, gridCellSize, gridCellSize)
+}
+
+// saveContactSheet composes the contact sheet of a grid and saves it into dir with
+// timestamp ts, recording the grid request in its provenance.
+func saveContactSheet(dir string, ts time.Time, panels []imaging.SheetPanel, req *gridRequest) (*ImageResponse, error) {
+   data, err := imaging.EncodePNG(imaging.ContactSheet(panels, gridCellSize))
+   if err != nil {
+       return nil, err
+   }
+   name, hash, err := saveImageToLibrary(dir, data, ts)
+   if err != nil {
+       return nil, fmt.Errorf("save contact sheet: %w", err)
+   }
+   baseDir := ImageDir
+   if dir != "" {
+       baseDir = filepath.Join(ImageDir, dir)
+   }
+   reqJSON, _ := json.Marshal(req)
+   prov := &storage.Provenance{
+       Operation: "grid",
+       CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+       Request:   reqJSON,
+   }
+   if err := storage.SaveProvenance(baseDir, hash, prov); err != nil {
+       log.Printf("Error saving provenance for %s: %v", name, err)
+   }
+   saved, _ := storage.LoadMetaEntry(baseDir, hash)
+   return &ImageResponse{ID: hash, URL: imageURL(dir, hash), Timestamp: saved, Path: dir}, nil
+}
+
+// bindGrid reads, completes and validates a grid request, writing an error response on failure.
+func bindGrid(c *gin.Context, req *gridRequest) bool {
+   if err := c.ShouldBindJSON(req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   prepareGrid(req)
+   return validateGrid(c, req)
+}
+
+// handleGrid generates an X/Y/Z grid, waiting for all cells; see gridRequest.
+func handleGrid(c *gin.Context) {
+   var req gridRequest
+   if !bindGrid(c, &req) {
+       return
+   }
+   result, err := runGrid(forgeContext(c), &req)
+   if err != nil {
+       writeGenerationError(c, err)
+       return
+   }
+   c.JSON(http.StatusOK, result)
+}
+
+// handleSubmitGridJob queues an X/Y/Z grid and returns the job immediately; its result is
+// the grid result once all cells are done.
+func handleSubmitGridJob(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   var req gridRequest
+   if !bindGrid(c, &req) {
+       return
+   }
+   data, _ := json.Marshal(req)
+   c.JSON(http.StatusAccepted, q.Submit("grid", data))
+}
+
+// runGridJob executes a queued grid job. A job run again after the backend stopped during
+// an earlier attempt starts over, replacing what that attempt left in the directory.
+func runGridJob(ctx context.Context, job *jobs.Job) (json.RawMessage, error) {
+   var req gridRequest
+   if err := json.Unmarshal(job.Request, &req); err != nil {
+       return nil, err
+   }
+   if job.Attempts > 1 {
+       if dir, err := req.gridDir(); err == nil {
+           os.RemoveAll(filepath.Join(ImageDir, dir))
+       }
+   }
+   result, err := runGrid(ctx, &req)
+   if err != nil {
+       return nil, err
+   }
+   return json.Marshal(result)
+}
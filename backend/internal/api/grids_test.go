@@ -0,0 +1,279 @@
+package api_test
+
+import (
+   "context"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "image/png"
+   "net/http"
+   "os"
+   "path/filepath"
+   "sync"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/jobs"
+)
+
+// gridResult mirrors the grid endpoint response.
+type gridResult struct {
+   Path  string `json:"path"`
+   Cells []struct {
+       X, Y, Z int
+       Values  []string
+       Image   *api.ImageResponse
+   } `json:"cells"`
+   Sheet *api.ImageResponse `json:"sheet"`
+}
+
+func TestGridGeneratesCellsInOrderWithContactSheet(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   var mu sync.Mutex
+   var calls []string
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           mu.Lock()
+           calls = append(calls, fmt.Sprintf("%s|%v|%d|%d", req.Prompt, req.CFGScale, *req.Seed, req.BatchSize))
+           n := len(calls)
+           mu.Unlock()
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, uint8(10*n))}, Info: `{"seed": 7}`}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodPost, "/api/v1/grids", map[string]interface{}{
+       "base": map[string]interface{}{"prompt": "a red cat", "seed": 7, "batch_size": 4},
+       "axes": []map[string]interface{}{
+           {"param": "cfg_scale", "values": []float64{5, 7}},
+           {"param": "prompt_sr", "values": []string{"red", "blue", "green"}},
+       },
+       "save_to": "grids",
+       "name":    "cats",
+   })
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   var res gridResult
+   if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+       t.Fatalf("unmarshal grid: %v", err)
+   }
+   want := []string{
+       "a red cat|5|7|1", "a red cat|7|7|1",
+       "a blue cat|5|7|1", "a blue cat|7|7|1",
+       "a green cat|5|7|1", "a green cat|7|7|1",
+   }
+   if fmt.Sprint(calls) != fmt.Sprint(want) {
+       t.Fatalf("unexpected generations:\n got %v\nwant %v", calls, want)
+   }
+   if res.Path != "grids/cats" || len(res.Cells) != 6 || res.Sheet == nil {
+       t.Fatalf("unexpected grid result: %+v", res)
+   }
+   if c := res.Cells[3]; c.X != 1 || c.Y != 1 || fmt.Sprint(c.Values) != "[cfg_scale: 7 prompt_sr: blue]" || c.Image == nil {
+       t.Errorf("unexpected cell: %+v", c)
+   }
+
+   // Library order is grid order, with the contact sheet last
+   w = doJSON(t, http.MethodGet, "/api/images?path=grids/cats", nil)
+   var imgs []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal listing: %v", err)
+   }
+   if len(imgs) != 7 || imgs[6].ID != res.Sheet.ID {
+       t.Fatalf("expected 6 cells and the sheet, got %+v", imgs)
+   }
+   for i, c := range res.Cells {
+       if imgs[i].ID != c.Image.ID {
+           t.Errorf("image %d out of grid order", i)
+       }
+   }
+   w = doJSON(t, http.MethodGet, res.Sheet.URL, nil)
+   cfg, err := png.DecodeConfig(w.Body)
+   if err != nil {
+       t.Fatalf("decode sheet: %v", err)
+   }
+   if cfg.Width < 2*256 || cfg.Height < 3*256 {
+       t.Errorf("sheet too small for a 2x3 grid: %dx%d", cfg.Width, cfg.Height)
+   }
+}
+
+func TestGridRejectsInvalidRequests(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   if err := os.MkdirAll(filepath.Join(root, "grids", "cats"), 0755); err != nil {
+       t.Fatalf("mkdir: %v", err)
+   }
+   generated := false
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           generated = true
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 0)}}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodPost, "/api/v1/grids", map[string]interface{}{
+       "base": map[string]interface{}{"prompt": "x"}, "save_to": "grids", "name": "cats",
+       "axes": []map[string]interface{}{{"param": "steps", "values": []int{1}}},
+   })
+   if w.Code != http.StatusConflict {
+       t.Errorf("expected 409 for an existing grid directory, got %d", w.Code)
+   }
+   for name, body := range map[string]map[string]interface{}{
+       "no axes":        {"base": map[string]interface{}{"prompt": "x"}},
+       "fixed param":    {"base": map[string]interface{}{"prompt": "x"}, "axes": []map[string]interface{}{{"param": "batch_size", "values": []int{2}}}},
+       "unknown param":  {"base": map[string]interface{}{"prompt": "x"}, "axes": []map[string]interface{}{{"param": "nope", "values": []int{2}}}},
+       "bad value":      {"base": map[string]interface{}{"prompt": "x"}, "axes": []map[string]interface{}{{"param": "steps", "values": []int{-1}}}},
+       "missing search": {"base": map[string]interface{}{"prompt": "x"}, "axes": []map[string]interface{}{{"param": "prompt_sr", "values": []string{"y", "z"}}}},
+       "too many cells": {"base": map[string]interface{}{"prompt": "x"}, "axes": []map[string]interface{}{
+           {"param": "steps", "values": make([]int, 20)}, {"param": "seed", "values": make([]int, 20)},
+       }},
+   } {
+       if w := doJSON(t, http.MethodPost, "/api/v1/grids", body); w.Code != http.StatusBadRequest {
+           t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+       }
+   }
+   if generated {
+       t.Errorf("an invalid grid reached txt2img")
+   }
+}
+
+func TestGridLoadsEachCheckpointOnceAndRestores(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   var calls, switches []string
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           if r := req.OverrideSettingsRestoreAfterwards; r == nil || *r {
+               t.Errorf("cells must not restore the checkpoint")
+           }
+           calls = append(calls, fmt.Sprintf("%v/%d", req.OverrideSettings["sd_model_checkpoint"], req.Steps))
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, uint8(len(calls)))}}, nil
+       },
+       OptionsFunc: func(ctx context.Context) (map[string]interface{}, error) {
+           return map[string]interface{}{"sd_model_checkpoint": "base"}, nil
+       },
+       SwitchModelFunc: func(ctx context.Context, model string) error {
+           switches = append(switches, model)
+           return nil
+       },
+   })
+
+   // The checkpoint is the X axis but is generated outermost
+   w := doJSON(t, http.MethodPost, "/api/v1/grids", map[string]interface{}{
+       "base": map[string]interface{}{"prompt": "x"},
+       "axes": []map[string]interface{}{
+           {"param": "checkpoint", "values": []string{"a", "b"}},
+           {"param": "steps", "values": []int{10, 20}},
+       },
+       "save_to": "grids",
+   })
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   if want := "[a/10 a/20 b/10 b/20]"; fmt.Sprint(calls) != want {
+       t.Errorf("expected generations %s, got %v", want, calls)
+   }
+   if fmt.Sprint(switches) != "[base]" {
+       t.Errorf("expected one restore of the previous checkpoint, got %v", switches)
+   }
+   var res gridResult
+   if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+       t.Fatalf("unmarshal grid: %v", err)
+   }
+   // Cells and the library stay in grid order
+   w = doJSON(t, http.MethodGet, "/api/images?path="+res.Path, nil)
+   var imgs []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal listing: %v", err)
+   }
+   if len(imgs) != 5 || imgs[4].ID != res.Sheet.ID {
+       t.Fatalf("expected 4 cells and the sheet, got %+v", imgs)
+   }
+   for i, c := range res.Cells {
+       if c.X != i%2 || c.Y != i/2 || imgs[i].ID != c.Image.ID {
+           t.Errorf("cell %d out of grid order: %+v", i, c)
+       }
+   }
+}
+
+func TestFailedGridCanBeRetried(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   fail := true
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           if fail && req.Steps == 20 {
+               return nil, &forgeclient.RequestError{Op: "txt2img", StatusCode: 500, Err: errors.New("boom")}
+           }
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, uint8(req.Steps))}}, nil
+       },
+   })
+   body := map[string]interface{}{
+       "base": map[string]interface{}{"prompt": "x"},
+       "axes": []map[string]interface{}{{"param": "steps", "values": []int{10, 20}}},
+       "name": "retry",
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/grids", body); w.Code != http.StatusBadGateway {
+       t.Fatalf("expected 502, got %d: %s", w.Code, w.Body.String())
+   }
+   if _, err := os.Stat(filepath.Join(root, "retry")); !os.IsNotExist(err) {
+       t.Errorf("expected the failed grid's directory removed, got %v", err)
+   }
+   fail = false
+   if w := doJSON(t, http.MethodPost, "/api/v1/grids", body); w.Code != http.StatusOK {
+       t.Fatalf("expected the retry to succeed, got %d: %s", w.Code, w.Body.String())
+   }
+
+   // Default names differ even within the same second
+   delete(body, "name")
+   paths := map[string]bool{}
+   for i := 0; i < 2; i++ {
+       w := doJSON(t, http.MethodPost, "/api/v1/grids", body)
+       var res gridResult
+       if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+           t.Fatalf("grid %d: %d %s", i, w.Code, w.Body.String())
+       }
+       paths[res.Path] = true
+   }
+   if len(paths) != 2 {
+       t.Errorf("expected two grid directories, got %v", paths)
+   }
+}
+
+func TestGridJob(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, uint8(req.Steps))}}, nil
+       },
+   })
+   if err := api.StartJobQueue(root); err != nil {
+       t.Fatalf("start queue: %v", err)
+   }
+   defer api.StopJobQueue()
+
+   w := doJSON(t, http.MethodPost, "/api/v1/jobs/grid", map[string]interface{}{
+       "base": map[string]interface{}{"prompt": "x"},
+       "axes": []map[string]interface{}{{"param": "steps", "values": []int{10, 20}}},
+   })
+   if w.Code != http.StatusAccepted {
+       t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+   }
+   var job jobs.Job
+   if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+       t.Fatalf("unmarshal job: %v", err)
+   }
+   job = waitForJob(t, job.ID, jobs.StatusSucceeded, jobs.StatusFailed)
+   if job.Status != jobs.StatusSucceeded {
+       t.Fatalf("grid job failed: %s", job.Error)
+   }
+   var res gridResult
+   if err := json.Unmarshal(job.Result, &res); err != nil {
+       t.Fatalf("unmarshal result: %v", err)
+   }
+   if len(res.Cells) != 2 || res.Sheet == nil {
+       t.Errorf("unexpected grid job result: %+v", res)
+   }
+}
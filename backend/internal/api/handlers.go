@@ -3,9 +3,11 @@ package api
 import (
    "encoding/json"
    "fmt"
+   "io"
    "io/ioutil"
    "log"
    "math/rand"
+   "mime/multipart"
    "net/http"
    "net/url"
    "os"
@@ -25,29 +27,41 @@ type SpeakerMeta struct {
 }
 
 // handleGetAllDialogs retrieves dialogs for all images in the given path.
+// Supports the same sort, generation filter, pagination and recursive parameters as handleGetImages,
+// including the X-Next-Cursor header.
 func handleGetAllDialogs(c *gin.Context) {
    sub := c.Query("path")
-   baseDir := ImageDir
-   if sub != "" {
-       baseDir = filepath.Join(ImageDir, sub)
+   opts, err := parseListOptions(c)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
    }
    // get list of images
-   imgs := getImages(sub)
+   var recs []imageRecord
+   if c.Query("recursive") == "true" {
+       recs = listImageRecordsRecursive(sub)
+   } else {
+       recs = listImageRecords(sub)
+   }
+   recs = filterRecords(recs, opts)
+   keys := sortRecords(recs, opts)
+   page, next, err := paginateRecords(recs, keys, opts)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if next != "" {
+       c.Header("X-Next-Cursor", next)
+   }
    // assemble dialogs map
-   dialogs := make(map[string][]string, len(imgs))
-   for _, im := range imgs {
-       // resolve filename by hash
-       filename, err := findFilenameByHash(baseDir, im.ID)
-       if err != nil || filename == "" {
-           dialogs[im.ID] = []string{}
-           continue
-       }
-       entries, err := storage.LoadDialogFile(baseDir, filename)
+   dialogs := make(map[string][]string, len(page))
+   for _, rec := range page {
+       entries, err := storage.LoadDialogFile(rec.baseDir(), rec.Name)
        if err != nil {
-           dialogs[im.ID] = []string{}
+           dialogs[rec.ID] = []string{}
            continue
        }
-       dialogs[im.ID] = entries
+       dialogs[rec.ID] = entries
    }
    c.JSON(http.StatusOK, gin.H{"dialogs": dialogs})
 }
@@ -80,6 +94,18 @@ type ImageResponse struct {
    ID        string `json:"id"`
    URL       string `json:"url"`
    Timestamp string `json:"timestamp"`
+   Path      string `json:"path,omitempty"`
+   // Optional fields, populated only when requested via ?fields=
+   Width       int                       `json:"width,omitempty"`
+   Height      int                       `json:"height,omitempty"`
+   Size        int64                     `json:"size,omitempty"`
+   DialogCount *int                      `json:"dialog_count,omitempty"`
+   Tags        []string                  `json:"tags,omitempty"`
+   Rating      *int                      `json:"rating,omitempty"`
+   Format      string                    `json:"format,omitempty"`
+   BitDepth    int                       `json:"bit_depth,omitempty"`
+   ICCProfile  string                    `json:"icc_profile,omitempty"`
+   Generation  *storage.GenerationParams `json:"generation,omitempty"`
 }
 
 // DirEntry describes a subdirectory and its content counts.
@@ -235,13 +261,8 @@ func handleUpload(c *gin.Context) {
    n := len(files)
    for idx, fh := range files {
        frac := int64(idx+1) * int64(time.Second) / int64(n+1)
-       ts := now.Add(time.Duration(frac))
-       prefix := now.Format("20060102150405")
-       suffix := fmt.Sprintf("%09d", frac)
-       ext := filepath.Ext(fh.Filename)
-       newName := prefix + "-" + suffix + ext
-       dst := filepath.Join(baseDir, newName)
-       if err := c.SaveUploadedFile(fh, dst); err != nil {
+       newName, ts, err := saveUploadedImage(baseDir, fh, now.Add(time.Duration(frac)))
+       if err != nil {
            log.Printf("Error saving upload %s: %v", fh.Filename, err)
            continue
        }
@@ -253,11 +274,69 @@ func handleUpload(c *gin.Context) {
    c.JSON(http.StatusOK, gin.H{"uploaded": len(files)})
 }
 
+// saveUploadedImage stores an uploaded file in baseDir under a name derived from ts
+// (see createLibraryFile) and returns the name and the timestamp it encodes.
+func saveUploadedImage(baseDir string, fh *multipart.FileHeader, ts time.Time) (string, time.Time, error) {
+   src, err := fh.Open()
+   if err != nil {
+       return "", ts, err
+   }
+   defer src.Close()
+   dst, name, ts, err := createLibraryFile(baseDir, ts, filepath.Ext(fh.Filename))
+   if err != nil {
+       return "", ts, err
+   }
+   _, err = io.Copy(dst, src)
+   if cerr := dst.Close(); err == nil {
+       err = cerr
+   }
+   if err != nil {
+       os.Remove(filepath.Join(baseDir, name))
+       return "", ts, err
+   }
+   return name, ts, nil
+}
+
 // handleGetImages sends the list of images as JSON.
+// Query parameters: sort, order, limit, cursor, fields and generation filters (see parseListOptions);
+// recursive=true also lists images in all subdirectories of path.
+// When more results remain, the cursor for the next page is sent in the X-Next-Cursor header.
 func handleGetImages(c *gin.Context) {
-   sub := c.Query("path")
-   imgs := getImages(sub)
-   c.JSON(http.StatusOK, imgs)
+   opts, err := parseListOptions(c)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   var recs []imageRecord
+   var keys []listKey
+   if id := c.Query("collection"); id != "" {
+       var ok bool
+       if recs, ok = loadCollectionRecords(c, id); !ok {
+           return
+       }
+       recs = filterRecords(recs, opts)
+       // Collections keep their own order unless a sort key is requested
+       if c.Query("sort") != "" || opts.Desc {
+           keys = sortRecords(recs, opts)
+       }
+   } else {
+       if c.Query("recursive") == "true" {
+           recs = listImageRecordsRecursive(c.Query("path"))
+       } else {
+           recs = listImageRecords(c.Query("path"))
+       }
+       recs = filterRecords(recs, opts)
+       keys = sortRecords(recs, opts)
+   }
+   page, next, err := paginateRecords(recs, keys, opts)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if next != "" {
+       c.Header("X-Next-Cursor", next)
+   }
+   c.JSON(http.StatusOK, buildImageResponses(page, opts.Fields))
 }
 
 // handleGetImage serves the binary image data for a given hash ID.
@@ -325,8 +404,13 @@ func handleDeleteImage(c *gin.Context) {
 }
 
 // handleGetDirs lists subdirectories under a given path.
+// With recursive=true, image counts include all nested directories.
 func handleGetDirs(c *gin.Context) {
    sub := c.Query("path")
+   if c.Query("recursive") == "true" {
+       handleGetDirsRecursive(c, sub)
+       return
+   }
    // Determine which directory to list
    dir := ImageDir
    if sub != "" {
@@ -353,12 +437,9 @@ func handleGetDirs(c *gin.Context) {
            }
            found := false
            for _, child := range childrenRoot {
-               if !child.IsDir() {
-                   lname := strings.ToLower(child.Name())
-                   if strings.HasSuffix(lname, ".jpg") || strings.HasSuffix(lname, ".jpeg") || strings.HasSuffix(lname, ".png") {
-                       found = true
-                       break
-                   }
+               if !child.IsDir() && isImageFile(child.Name()) {
+                   found = true
+                   break
                }
            }
            if !found {
@@ -374,11 +455,8 @@ func handleGetDirs(c *gin.Context) {
        for _, child := range children {
            if child.IsDir() {
                dirCount++
-           } else {
-               lname := strings.ToLower(child.Name())
-               if strings.HasSuffix(lname, ".jpg") || strings.HasSuffix(lname, ".jpeg") || strings.HasSuffix(lname, ".png") {
-                   imgCount++
-               }
+           } else if isImageFile(child.Name()) {
+               imgCount++
            }
        }
        entries = append(entries, DirEntry{Name: fi.Name(), ImageCount: imgCount, DirCount: dirCount})
@@ -731,8 +809,36 @@ func handleReorder(c *gin.Context) {
    c.JSON(http.StatusOK, ReorderResponse{ID: newID, Timestamp: movedTSStr})
 }
 
+// imageRecord pairs a listed image with its on-disk location.
+type imageRecord struct {
+   ImageResponse
+   Sub     string
+   Name    string
+   TS      time.Time
+   Size    int64
+   ModTime time.Time
+}
+
+// baseDir returns the directory containing the record's image file.
+func (r imageRecord) baseDir() string {
+   if r.Sub == "" {
+       return ImageDir
+   }
+   return filepath.Join(ImageDir, r.Sub)
+}
+
 // getImages scans the given subdirectory and returns sorted images.
 func getImages(sub string) []ImageResponse {
+   recs := listImageRecords(sub)
+   resp := make([]ImageResponse, len(recs))
+   for i, rec := range recs {
+       resp[i] = rec.ImageResponse
+   }
+   return resp
+}
+
+// listImageRecords scans the given subdirectory and returns image records sorted by timestamp.
+func listImageRecords(sub string) []imageRecord {
    dir := ImageDir
    if sub != "" {
        dir = filepath.Join(ImageDir, sub)
@@ -743,8 +849,7 @@ func getImages(sub string) []ImageResponse {
    if err != nil {
        return nil
    }
-   type img struct { id string; ts time.Time; url string }
-   var imgs []img
+   var imgs []imageRecord
    // Build base API URL for fetching images by hash ID
    baseAPI := "/api/images"
    // Track seen content hashes to avoid duplicate entries
@@ -753,8 +858,7 @@ func getImages(sub string) []ImageResponse {
        if fi.IsDir() {
            continue
        }
-       lname := strings.ToLower(fi.Name())
-       if !(strings.HasSuffix(lname, ".jpg") || strings.HasSuffix(lname, ".jpeg") || strings.HasSuffix(lname, ".png")) {
+       if !isImageFile(fi.Name()) {
            continue
        }
        full := filepath.Join(dir, fi.Name())
@@ -794,15 +898,69 @@ func getImages(sub string) []ImageResponse {
        } else {
            imgURL = fmt.Sprintf("%s/%s", baseAPI, hash)
        }
-       imgs = append(imgs, img{id: hash, ts: t, url: imgURL})
+       imgs = append(imgs, imageRecord{
+           ImageResponse: ImageResponse{ID: hash, URL: imgURL, Timestamp: t.Format(time.RFC3339Nano)},
+           Sub:           sub,
+           Name:          fi.Name(),
+           TS:            t,
+           Size:          fi.Size(),
+           ModTime:       fi.ModTime(),
+       })
    }
    // sort images by timestamp ascending
-   sort.Slice(imgs, func(i, j int) bool { return imgs[i].ts.Before(imgs[j].ts) })
-   resp := make([]ImageResponse, len(imgs))
-   for i, im := range imgs {
-       resp[i] = ImageResponse{ID: im.id, URL: im.url, Timestamp: im.ts.Format(time.RFC3339Nano)}
-   }
-   return resp
+   sort.Slice(imgs, func(i, j int) bool { return imgs[i].TS.Before(imgs[j].TS) })
+   return imgs
+}
+
+// isImageFile reports whether the file name has a supported image extension.
+func isImageFile(name string) bool {
+   lname := strings.ToLower(name)
+   return strings.HasSuffix(lname, ".jpg") || strings.HasSuffix(lname, ".jpeg") || strings.HasSuffix(lname, ".png") || strings.HasSuffix(lname, ".webp")
+}
+
+// reservedDirs lists directory names used for internal storage in every image directory.
+var reservedDirs = map[string]bool{
+   "metadata": true,
+   "dialogs":  true,
+}
+
+// rootReservedDirs lists directory names used for backend state directly under ImageDir;
+// deeper down they are ordinary image directories.
+var rootReservedDirs = map[string]bool{
+   "collections": true,
+   "jobs":        true,
+   "prompts":     true,
+}
+
+// isReservedDir reports whether the directory name holds internal storage rather than
+// images; root says whether it is directly under ImageDir.
+func isReservedDir(name string, root bool) bool {
+   return reservedDirs[name] || (root && rootReservedDirs[name]) || strings.HasPrefix(name, ".")
+}
+
+// walkImageDirs returns the relative paths of all image directories under ImageDir,
+// including the root itself as "". Internal storage and hidden directories are skipped.
+func walkImageDirs() []string {
+   var subs []string
+   filepath.Walk(ImageDir, func(path string, info os.FileInfo, err error) error {
+       if err != nil || !info.IsDir() {
+           return nil
+       }
+       rel, err := filepath.Rel(ImageDir, path)
+       if err != nil {
+           return nil
+       }
+       if rel == "." {
+           subs = append(subs, "")
+           return nil
+       }
+       if isReservedDir(info.Name(), filepath.Dir(rel) == ".") {
+           return filepath.SkipDir
+       }
+       subs = append(subs, filepath.ToSlash(rel))
+       return nil
+   })
+   return subs
 }
 
 // contains checks if a slice contains a string.
@@ -0,0 +1,93 @@
+package api
+
+import (
+   "errors"
+   "net/http"
+   "path/filepath"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/storage"
+)
+
+// handleGetImageInfo returns technical metadata (dimensions, format, color profile, EXIF) for an image.
+func handleGetImageInfo(c *gin.Context) {
+   sub := c.Query("path")
+   idHash := c.Param("id")
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err := findFilenameByHash(baseDir, idHash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve image ID"})
+       return
+   }
+   if filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return
+   }
+   info, err := storage.LoadImageInfo(baseDir, filename, idHash)
+   if err != nil {
+       c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "could not read image metadata"})
+       return
+   }
+   c.JSON(http.StatusOK, info)
+}
+
+// handleGetGeneration returns the Stable Diffusion generation parameters embedded in an image.
+func handleGetGeneration(c *gin.Context) {
+   sub := c.Query("path")
+   idHash := c.Param("id")
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err := findFilenameByHash(baseDir, idHash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve image ID"})
+       return
+   }
+   if filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return
+   }
+   params, err := storage.LoadGenerationParams(baseDir, filename, idHash)
+   if err != nil {
+       if errors.Is(err, storage.ErrNoGenerationParams) {
+           c.JSON(http.StatusNotFound, gin.H{"error": "no generation parameters"})
+           return
+       }
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read generation parameters"})
+       return
+   }
+   c.JSON(http.StatusOK, params)
+}
+
+// handleGetProvenance returns the provenance record of an image generated by the backend.
+func handleGetProvenance(c *gin.Context) {
+   sub := c.Query("path")
+   idHash := c.Param("id")
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err := findFilenameByHash(baseDir, idHash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve image ID"})
+       return
+   }
+   if filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return
+   }
+   prov, err := storage.LoadProvenance(baseDir, idHash)
+   if err != nil {
+       if errors.Is(err, storage.ErrNoProvenance) {
+           c.JSON(http.StatusNotFound, gin.H{"error": "no provenance record"})
+           return
+       }
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load provenance"})
+       return
+   }
+   c.JSON(http.StatusOK, prov)
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+   "context"
+   "encoding/base64"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "io/ioutil"
+   "net/http"
+   "os"
+   "path/filepath"
+   "strconv"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/jobs"
+)
+
+// JobQueue runs generation jobs asynchronously; nil until StartJobQueue is called.
+var JobQueue *jobs.Queue
+
+// jobResult is the persisted outcome of a generation job. Images are stored as files
+// in the job's output directory and referenced by URL.
+type jobResult struct {
+   Images     []string               `json:"images"`
+   Parameters map[string]interface{} `json:"parameters,omitempty"`
+   Info       string                 `json:"info,omitempty"`
+   Saved      []ImageResponse        `json:"saved,omitempty"`
+}
+
+// img2ImgJob is the persisted request of a queued img2img job. Init images and the mask
+// are kept in the queue's blob store and referenced by content hash, so job records stay small.
+type img2ImgJob struct {
+   img2ImgRequest
+   InitImageHashes []string `json:"init_image_hashes,omitempty"`
+   MaskHash        string   `json:"mask_hash,omitempty"`
+}
+
+// newImg2ImgJob moves the inline images of req into the blob store of q.
+func newImg2ImgJob(q *jobs.Queue, req img2ImgRequest) (img2ImgJob, error) {
+   put := func(b64 string) (string, error) {
+       data, err := decodeBase64Image(b64)
+       if err != nil {
+           return "", fmt.Errorf("%w: %v", errInvalidImage, err)
+       }
+       return q.PutBlob(data)
+   }
+   job := img2ImgJob{img2ImgRequest: req}
+   for _, img := range req.InitImages {
+       hash, err := put(img)
+       if err != nil {
+           return job, err
+       }
+       job.InitImageHashes = append(job.InitImageHashes, hash)
+   }
+   if req.Mask != "" {
+       hash, err := put(req.Mask)
+       if err != nil {
+           return job, err
+       }
+       job.MaskHash = hash
+   }
+   job.InitImages = nil
+   job.Mask = ""
+   return job, nil
+}
+
+// request returns the img2img request with its images read back from the blob store of q.
+func (j img2ImgJob) request(q *jobs.Queue) (img2ImgRequest, error) {
+   req := j.img2ImgRequest
+   if len(j.InitImageHashes) > 0 {
+       req.InitImages = make([]string, len(j.InitImageHashes))
+   }
+   for i, hash := range j.InitImageHashes {
+       data, err := q.Blob(hash)
+       if err != nil {
+           return req, fmt.Errorf("init image %d: %w", i, err)
+       }
+       req.InitImages[i] = base64.StdEncoding.EncodeToString(data)
+   }
+   if j.MaskHash != "" {
+       data, err := q.Blob(j.MaskHash)
+       if err != nil {
+           return req, fmt.Errorf("mask: %w", err)
+       }
+       req.Mask = base64.StdEncoding.EncodeToString(data)
+   }
+   return req, nil
+}
+
+// StartJobQueue loads persisted jobs from dir/jobs and starts processing them.
+func StartJobQueue(dir string) error {
+   var q *jobs.Queue
+   q, err := jobs.NewQueue(filepath.Join(dir, "jobs"), func(ctx context.Context, job *jobs.Job) (json.RawMessage, error) {
+       return runGenerationJob(ctx, q, job)
+   })
+   if err != nil {
+       return err
+   }
+   q.OnUpdate(func(job jobs.Job) {
+       job.Request = nil
+       broadcastNamed("job", job)
+   })
+   JobQueue = q
+   q.Start()
+   return nil
+}
+
+// StopJobQueue stops the job queue started by StartJobQueue.
+func StopJobQueue() {
+   if JobQueue != nil {
+       JobQueue.Stop()
+       JobQueue = nil
+   }
+}
+
+// runGenerationJob executes a queued txt2img or img2img job of q against ForgeSvc,
+// writing the returned images to the job's output directory. Grid jobs save into the library instead.
+func runGenerationJob(ctx context.Context, q *jobs.Queue, job *jobs.Job) (json.RawMessage, error) {
+   ctx = forgeclient.WithRequestID(ctx, job.ID)
+   if job.Type == "grid" {
+       return runGridJob(ctx, job)
+   }
+   var resp *GenerationResponse
+   var err error
+   switch job.Type {
+   case "txt2img":
+       var req txt2ImgRequest
+       if err := json.Unmarshal(job.Request, &req); err != nil {
+           return nil, err
+       }
+       resp, err = runTxt2Img(ctx, &req)
+   case "img2img":
+       var stored img2ImgJob
+       if err := json.Unmarshal(job.Request, &stored); err != nil {
+           return nil, err
+       }
+       var req img2ImgRequest
+       if req, err = stored.request(q); err != nil {
+           return nil, err
+       }
+       resp, err = runImg2Img(ctx, &req)
+   default:
+       return nil, fmt.Errorf("unknown job type %q", job.Type)
+   }
+   if err != nil {
+       return nil, err
+   }
+   outDir := q.OutputDir(job.ID)
+   if err := os.MkdirAll(outDir, 0755); err != nil {
+       return nil, err
+   }
+   result := jobResult{Images: []string{}, Parameters: resp.Parameters, Info: resp.Info, Saved: resp.Saved}
+   for i, b64 := range resp.Images {
+       data, err := decodeBase64Image(b64)
+       if err != nil {
+           return nil, fmt.Errorf("decode image %d: %w", i, err)
+       }
+       if err := ioutil.WriteFile(filepath.Join(outDir, strconv.Itoa(i)), data, 0644); err != nil {
+           return nil, err
+       }
+       result.Images = append(result.Images, fmt.Sprintf("/api/v1/jobs/%s/images/%d", job.ID, i))
+   }
+   return json.Marshal(result)
+}
+
+// jobQueueOr503 returns the running queue or writes a 503 response.
+func jobQueueOr503(c *gin.Context) (*jobs.Queue, bool) {
+   if JobQueue == nil {
+       c.JSON(http.StatusServiceUnavailable, gin.H{"error": "job queue not running"})
+       return nil, false
+   }
+   return JobQueue, true
+}
+
+// writeJobError maps queue errors to HTTP responses.
+func writeJobError(c *gin.Context, err error) {
+   switch {
+   case errors.Is(err, jobs.ErrNotFound):
+       c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+   case errors.Is(err, jobs.ErrInvalidState):
+       c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+   default:
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+   }
+}
+
+// handleSubmitTxt2ImgJob queues a txt2img generation and returns the job immediately.
+func handleSubmitTxt2ImgJob(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   var req txt2ImgRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if !validateTxt2Img(c, &req) {
+       return
+   }
+   data, _ := json.Marshal(req)
+   c.JSON(http.StatusAccepted, q.Submit("txt2img", data))
+}
+
+// handleSubmitImg2ImgJob queues an img2img generation and returns the job immediately.
+func handleSubmitImg2ImgJob(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   var req img2ImgRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if !validateImg2Img(c, &req) {
+       return
+   }
+   stored, err := newImg2ImgJob(q, req)
+   if err != nil {
+       writeSourceError(c, err)
+       return
+   }
+   data, _ := json.Marshal(stored)
+   c.JSON(http.StatusAccepted, q.Submit("img2img", data))
+}
+
+// handleListJobs returns all jobs, oldest first, without their request payloads.
+func handleListJobs(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   list := q.List()
+   for i := range list {
+       list[i].Request = nil
+   }
+   c.JSON(http.StatusOK, list)
+}
+
+// handleGetJob returns a single job including its request and result.
+func handleGetJob(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   job, err := q.Get(c.Param("id"))
+   if err != nil {
+       writeJobError(c, err)
+       return
+   }
+   c.JSON(http.StatusOK, job)
+}
+
+// handleCancelJob cancels a queued or running job.
+func handleCancelJob(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   job, err := q.Cancel(c.Param("id"))
+   if err != nil {
+       writeJobError(c, err)
+       return
+   }
+   c.JSON(http.StatusOK, job)
+}
+
+// handleRetryJob re-queues a failed or canceled job.
+func handleRetryJob(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   job, err := q.Retry(c.Param("id"))
+   if err != nil {
+       writeJobError(c, err)
+       return
+   }
+   c.JSON(http.StatusAccepted, job)
+}
+
+// handleGetJobImage serves an output image of a finished job.
+func handleGetJobImage(c *gin.Context) {
+   q, ok := jobQueueOr503(c)
+   if !ok {
+       return
+   }
+   job, err := q.Get(c.Param("id"))
+   if err != nil {
+       writeJobError(c, err)
+       return
+   }
+   n, err := strconv.Atoi(c.Param("n"))
+   if err != nil || n < 0 {
+       c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image index"})
+       return
+   }
+   path := filepath.Join(q.OutputDir(job.ID), strconv.Itoa(n))
+   data, err := ioutil.ReadFile(path)
+   if err != nil {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return
+   }
+   c.Header("Cache-Control", "private, max-age=31536000, immutable")
+   c.Data(http.StatusOK, http.DetectContentType(data), data)
+}
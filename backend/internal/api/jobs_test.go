@@ -0,0 +1,225 @@
+package api_test
+
+import (
+   "bufio"
+   "context"
+   "encoding/json"
+   "fmt"
+   "net/http"
+   "net/http/httptest"
+   "os"
+   "path/filepath"
+   "strings"
+   "testing"
+   "time"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/jobs"
+)
+
+// waitForJob polls the job endpoint until the job reaches one of the given states.
+func waitForJob(t *testing.T, id string, states ...jobs.Status) jobs.Job {
+   t.Helper()
+   deadline := time.Now().Add(5 * time.Second)
+   for time.Now().Before(deadline) {
+       w := doJSON(t, http.MethodGet, "/api/v1/jobs/"+id, nil)
+       var job jobs.Job
+       if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+           t.Fatalf("unmarshal job: %v", err)
+       }
+       for _, s := range states {
+           if job.Status == s {
+               return job
+           }
+       }
+       time.Sleep(10 * time.Millisecond)
+   }
+   t.Fatalf("job %s did not reach %v", id, states)
+   return jobs.Job{}
+}
+
+func TestJobQueueRunsCancelsAndPersists(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   block := make(chan struct{})
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           if req.Prompt == "slow" {
+               select {
+               case <-block:
+               case <-ctx.Done():
+                   return nil, ctx.Err()
+               }
+           }
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 50)}, Info: `{"seed": 1}`}, nil
+       },
+   })
+   if err := api.StartJobQueue(root); err != nil {
+       t.Fatalf("start queue: %v", err)
+   }
+   defer api.StopJobQueue()
+
+   w := doJSON(t, http.MethodPost, "/api/v1/jobs/txt2img", map[string]interface{}{"prompt": "fast"})
+   if w.Code != http.StatusAccepted {
+       t.Fatalf("expected 202, got %d", w.Code)
+   }
+   var fast jobs.Job
+   if err := json.Unmarshal(w.Body.Bytes(), &fast); err != nil {
+       t.Fatalf("unmarshal job: %v", err)
+   }
+   done := waitForJob(t, fast.ID, jobs.StatusSucceeded, jobs.StatusFailed)
+   if done.Status != jobs.StatusSucceeded {
+       t.Fatalf("job failed: %s", done.Error)
+   }
+   var result struct {
+       Images []string `json:"images"`
+   }
+   if err := json.Unmarshal(done.Result, &result); err != nil || len(result.Images) != 1 {
+       t.Fatalf("unexpected result: %s", done.Result)
+   }
+   if w := doJSON(t, http.MethodGet, result.Images[0], nil); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+       t.Fatalf("job image not served: %d %s", w.Code, w.Header().Get("Content-Type"))
+   }
+
+   // A running job is canceled through its context and can then be retried
+   w = doJSON(t, http.MethodPost, "/api/v1/jobs/txt2img", map[string]interface{}{"prompt": "slow"})
+   var slow jobs.Job
+   if err := json.Unmarshal(w.Body.Bytes(), &slow); err != nil {
+       t.Fatalf("unmarshal job: %v", err)
+   }
+   waitForJob(t, slow.ID, jobs.StatusRunning)
+   if w := doJSON(t, http.MethodPost, "/api/v1/jobs/"+slow.ID+"/cancel", nil); w.Code != http.StatusOK {
+       t.Fatalf("cancel: status %d", w.Code)
+   }
+   waitForJob(t, slow.ID, jobs.StatusCanceled)
+   if w := doJSON(t, http.MethodPost, "/api/v1/jobs/"+fast.ID+"/retry", nil); w.Code != http.StatusConflict {
+       t.Errorf("expected 409 retrying a succeeded job, got %d", w.Code)
+   }
+   close(block)
+   if w := doJSON(t, http.MethodPost, "/api/v1/jobs/"+slow.ID+"/retry", nil); w.Code != http.StatusAccepted {
+       t.Fatalf("retry: status %d", w.Code)
+   }
+   retried := waitForJob(t, slow.ID, jobs.StatusSucceeded)
+   if retried.Attempts != 2 {
+       t.Errorf("expected 2 attempts, got %d", retried.Attempts)
+   }
+
+   // Job state survives a restart
+   api.StopJobQueue()
+   if err := api.StartJobQueue(root); err != nil {
+       t.Fatalf("restart queue: %v", err)
+   }
+   w = doJSON(t, http.MethodGet, "/api/v1/jobs", nil)
+   var list []jobs.Job
+   if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+       t.Fatalf("unmarshal list: %v", err)
+   }
+   if len(list) != 2 || list[0].ID != fast.ID || list[1].ID != slow.ID {
+       t.Fatalf("unexpected jobs after restart: %+v", list)
+   }
+}
+
+func TestImg2ImgJobStoresInitImagesByHash(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   init := testPNGBase64(t, 80)
+   var got []string
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           got = req.InitImages
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 50)}, Info: `{"seed": 1}`}, nil
+       },
+   })
+   if err := api.StartJobQueue(root); err != nil {
+       t.Fatalf("start queue: %v", err)
+   }
+   defer api.StopJobQueue()
+
+   w := doJSON(t, http.MethodPost, "/api/v1/jobs/img2img", map[string]interface{}{"prompt": "x", "init_images": []string{"data:image/png;base64," + init}})
+   if w.Code != http.StatusAccepted {
+       t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+   }
+   var job jobs.Job
+   if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+       t.Fatalf("unmarshal job: %v", err)
+   }
+   if strings.Contains(string(job.Request), init) {
+       t.Errorf("job record embeds the init image: %s", job.Request)
+   }
+   var stored struct {
+       InitImageHashes []string `json:"init_image_hashes"`
+   }
+   if err := json.Unmarshal(job.Request, &stored); err != nil || len(stored.InitImageHashes) != 1 {
+       t.Fatalf("expected one init image hash in %s", job.Request)
+   }
+   if done := waitForJob(t, job.ID, jobs.StatusSucceeded, jobs.StatusFailed); done.Status != jobs.StatusSucceeded {
+       t.Fatalf("job failed: %s", done.Error)
+   }
+   if len(got) != 1 || got[0] != init {
+       t.Errorf("init image not restored for the generation")
+   }
+
+   if w := doJSON(t, http.MethodPost, "/api/v1/jobs/img2img", map[string]interface{}{"prompt": "x", "init_images": []string{"!!"}}); w.Code != http.StatusBadRequest {
+       t.Errorf("expected 400 for undecodable init image, got %d", w.Code)
+   }
+}
+
+func TestJobUpdatesReachStreamsDuringFileBursts(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           // Finish while the watcher is still broadcasting a burst of file updates
+           for i := 0; i < 500; i++ {
+               os.WriteFile(filepath.Join(root, fmt.Sprintf("burst-%03d.txt", i)), nil, 0644)
+           }
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 0)}}, nil
+       },
+   })
+   if err := api.StartJobQueue(root); err != nil {
+       t.Fatalf("start queue: %v", err)
+   }
+   defer api.StopJobQueue()
+   if err := api.StartWatcher(root); err != nil {
+       t.Fatalf("start watcher: %v", err)
+   }
+   srv := httptest.NewServer(api.SetupRouter())
+   defer srv.Close()
+   resp, err := http.Get(srv.URL + "/api/updates")
+   if err != nil {
+       t.Fatalf("subscribe: %v", err)
+   }
+   defer resp.Body.Close()
+
+   w := doJSON(t, http.MethodPost, "/api/v1/jobs/txt2img", map[string]interface{}{"prompt": "x"})
+   var job jobs.Job
+   if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil || w.Code != http.StatusAccepted {
+       t.Fatalf("submit: %d %s", w.Code, w.Body.String())
+   }
+
+   done := make(chan bool, 1)
+   go func() {
+       scanner := bufio.NewScanner(resp.Body)
+       event := ""
+       for scanner.Scan() {
+           line := scanner.Text()
+           switch {
+           case strings.HasPrefix(line, "event:"):
+               event = strings.TrimPrefix(line, "event:")
+           case event == "job" && strings.Contains(line, job.ID) && strings.Contains(line, `"succeeded"`):
+               done <- true
+               return
+           }
+       }
+       done <- false
+   }()
+   select {
+   case ok := <-done:
+       if !ok {
+           t.Fatalf("stream ended without the job's completion")
+       }
+   case <-time.After(5 * time.Second):
+       t.Fatalf("job completion was not pushed to /api/updates")
+   }
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+   "bytes"
+   "crypto/sha256"
+   "encoding/base64"
+   "encoding/hex"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "io/ioutil"
+   "log"
+   "net/url"
+   "os"
+   "path/filepath"
+   "strings"
+   "time"
+
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+)
+
+// SaveOptions asks the backend to store generated images in the library instead of
+// only returning them. SaveTo is a directory relative to ImageDir ("" is the root);
+// Position places the new images between two existing ones, like a reorder.
+type SaveOptions struct {
+   SaveTo   *string         `json:"save_to,omitempty"`
+   Position *ReorderRequest `json:"position,omitempty"`
+   // at, if set, is the timestamp of the first image instead of one taken from Position
+   at time.Time
+}
+
+// GenerationResponse is the Forge response plus any images saved to the library.
+type GenerationResponse struct {
+   *forgeclient.ImageResponse
+   Saved []ImageResponse `json:"saved,omitempty"`
+}
+
+// resolveSubDir validates a client-supplied directory and returns it cleaned and slash-separated.
+func resolveSubDir(sub string) (string, error) {
+   clean := filepath.ToSlash(filepath.Clean("/" + sub))
+   clean = strings.TrimPrefix(clean, "/")
+   for i, part := range strings.Split(clean, "/") {
+       if isReservedDir(part, i == 0) {
+           return "", fmt.Errorf("invalid directory %q", sub)
+       }
+   }
+   return clean, nil
+}
+
+// decodeBase64Image decodes a base64 image, accepting an optional data URL prefix.
+func decodeBase64Image(s string) ([]byte, error) {
+   if i := strings.Index(s, ","); i >= 0 && strings.HasPrefix(s, "data:") {
+       s = s[i+1:]
+   }
+   return base64.StdEncoding.DecodeString(s)
+}
+
+// imageExtension returns the file extension matching the image data's format.
+func imageExtension(data []byte) string {
+   switch {
+   case bytes.HasPrefix(data, []byte("\x89PNG")):
+       return ".png"
+   case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+       return ".jpg"
+   case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+       return ".webp"
+   }
+   return ".png"
+}
+
+// errInvalidPosition reports a position whose neighbors are missing or out of order.
+var errInvalidPosition = errors.New("invalid position")
+
+// placementTimes returns n ascending timestamps for new images in sub. Without a position
+// they follow the current time, as uploads do; otherwise they are spaced evenly between
+// the given neighbor and the image next to it in the listing, or between both neighbors.
+func placementTimes(sub string, pos *ReorderRequest, n int) ([]time.Time, error) {
+   var lo, hi time.Time
+   if pos != nil && (pos.PrevID != "" || pos.NextID != "") {
+       recs := listImageRecords(sub)
+       prev, next := -1, -1
+       for i, rec := range recs {
+           if rec.ID == pos.PrevID {
+               prev = i
+           }
+           if rec.ID == pos.NextID {
+               next = i
+           }
+       }
+       if (pos.PrevID != "" && prev < 0) || (pos.NextID != "" && next < 0) {
+           return nil, fmt.Errorf("%w: position image not found", errInvalidPosition)
+       }
+       switch {
+       case pos.NextID == "":
+           next = prev + 1
+       case pos.PrevID == "":
+           prev = next - 1
+       case prev >= next:
+           return nil, fmt.Errorf("%w: prev_id must come before next_id", errInvalidPosition)
+       }
+       if prev >= 0 {
+           lo = recs[prev].TS
+       }
+       if next < len(recs) {
+           hi = recs[next].TS
+       }
+   }
+   switch {
+   case lo.IsZero() && hi.IsZero():
+       lo = time.Now().Truncate(time.Second)
+       hi = lo.Add(time.Second)
+   case hi.IsZero():
+       hi = lo.Add(time.Second)
+   case lo.IsZero():
+       lo = hi.Add(-time.Second)
+   }
+   step := hi.Sub(lo) / time.Duration(n+1)
+   times := make([]time.Time, n)
+   for i := range times {
+       times[i] = lo.Add(step * time.Duration(i+1))
+   }
+   return times, nil
+}
+
+// createLibraryFile creates a new image file in baseDir named after ts as
+// <yyyymmddhhmmss>-<nanoseconds><ext>, the scheme shared by uploads and saved images.
+// If the name is taken, ts is advanced a nanosecond at a time, so the file still sorts
+// right after its intended position. Returns the open file, its name and the timestamp it encodes.
+func createLibraryFile(baseDir string, ts time.Time, ext string) (*os.File, string, time.Time, error) {
+   for {
+       name := ts.Format("20060102150405") + "-" + fmt.Sprintf("%09d", ts.Nanosecond()) + ext
+       f, err := os.OpenFile(filepath.Join(baseDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+       if err == nil {
+           return f, name, ts, nil
+       }
+       if !os.IsExist(err) {
+           return nil, "", ts, err
+       }
+       ts = ts.Add(time.Nanosecond)
+   }
+}
+
+// saveImageToLibrary writes image data into sub using the timestamp naming scheme
+// shared with uploads and records its metadata entry. Returns the file name and content hash.
+func saveImageToLibrary(sub string, data []byte, ts time.Time) (string, string, error) {
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   if err := os.MkdirAll(baseDir, 0755); err != nil {
+       return "", "", err
+   }
+   if err := storage.MigrateMetadata(baseDir); err != nil {
+       log.Printf("Error migrating metadata for %s: %v", baseDir, err)
+   }
+   f, name, ts, err := createLibraryFile(baseDir, ts, imageExtension(data))
+   if err != nil {
+       return "", "", err
+   }
+   _, err = f.Write(data)
+   if cerr := f.Close(); err == nil {
+       err = cerr
+   }
+   if err != nil {
+       os.Remove(filepath.Join(baseDir, name))
+       return "", "", err
+   }
+   if err := storage.SaveMetaEntry(baseDir, name, ts.Format(time.RFC3339Nano)); err != nil {
+       log.Printf("Error saving metadata for %s: %v", name, err)
+   }
+   sum := sha256.Sum256(data)
+   return name, hex.EncodeToString(sum[:]), nil
+}
+
+// replaceImageInLibrary overwrites the image name in sub with data, archiving the previous
+// content as a version and moving its metadata to the new content hash. The file keeps its
+// timestamp name; only the extension follows the new format. Returns the new name and hash.
+func replaceImageInLibrary(sub, name string, data []byte, op string) (string, string, error) {
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   oldPath := filepath.Join(baseDir, name)
+   oldData, err := ioutil.ReadFile(oldPath)
+   if err != nil {
+       return "", "", err
+   }
+   oldSum := sha256.Sum256(oldData)
+   oldHash := hex.EncodeToString(oldSum[:])
+   newSum := sha256.Sum256(data)
+   newHash := hex.EncodeToString(newSum[:])
+   if oldHash == newHash {
+       return name, newHash, nil
+   }
+   if _, err := storage.ArchiveVersion(baseDir, oldHash, oldData, filepath.Ext(name), op); err != nil {
+       return "", "", fmt.Errorf("archive previous version: %w", err)
+   }
+   newName := strings.TrimSuffix(name, filepath.Ext(name)) + imageExtension(data)
+   tmp := filepath.Join(baseDir, "."+newName+".tmp")
+   if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+       return "", "", err
+   }
+   if err := os.Rename(tmp, filepath.Join(baseDir, newName)); err != nil {
+       os.Remove(tmp)
+       return "", "", err
+   }
+   if newName != name {
+       if err := os.Remove(oldPath); err != nil {
+           log.Printf("Error removing replaced image %s: %v", name, err)
+       }
+   }
+   if err := storage.RekeyImage(baseDir, oldHash, newHash); err != nil {
+       return "", "", fmt.Errorf("move metadata to new content: %w", err)
+   }
+   return newName, newHash, nil
+}
+
+// imageURL builds the API URL for an image hash in sub.
+func imageURL(sub, hash string) string {
+   if sub != "" {
+       return fmt.Sprintf("/api/images/%s?path=%s", hash, url.QueryEscape(sub))
+   }
+   return "/api/images/" + hash
+}
+
+// generatedImages returns the generated images of a Forge response, skipping the batch grid
+// and any trailing non-generation images (e.g. control maps).
+func generatedImages(resp *forgeclient.ImageResponse, info *forgeclient.GenerationInfo) []string {
+   images := resp.Images
+   if info.IndexOfFirstImage > 0 && info.IndexOfFirstImage <= len(images) {
+       images = images[info.IndexOfFirstImage:]
+   }
+   if n := len(info.Infotexts) - info.IndexOfFirstImage; n > 0 && n < len(images) {
+       images = images[:n]
+   }
+   return images
+}
+
+// saveGeneratedImages stores the images of a Forge response into the library with provenance.
+// request is the generation request as sent to Forge (without inline image data),
+// sourceHash identifies the init image for img2img and expansion, if any, the templates
+// the prompts were expanded from.
+func saveGeneratedImages(op string, opts SaveOptions, resp *forgeclient.ImageResponse, request interface{}, sourceHash string, expansion *storage.PromptExpansion) ([]ImageResponse, error) {
+   sub, err := resolveSubDir(*opts.SaveTo)
+   if err != nil {
+       return nil, err
+   }
+   info, err := resp.ParseInfo()
+   if err != nil {
+       info = &forgeclient.GenerationInfo{}
+   }
+   images := generatedImages(resp, info)
+   first := info.IndexOfFirstImage
+   if len(images) == 0 {
+       return []ImageResponse{}, nil
+   }
+   var times []time.Time
+   if opts.at.IsZero() {
+       if times, err = placementTimes(sub, opts.Position, len(images)); err != nil {
+           return nil, err
+       }
+   } else {
+       for i := range images {
+           times = append(times, opts.at.Add(time.Duration(i)*time.Microsecond))
+       }
+   }
+   reqJSON, _ := json.Marshal(request)
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   saved := make([]ImageResponse, 0, len(images))
+   for i, b64 := range images {
+       data, err := decodeBase64Image(b64)
+       if err != nil {
+           return saved, fmt.Errorf("decode generated image %d: %w", i, err)
+       }
+       name, hash, err := saveImageToLibrary(sub, data, times[i])
+       if err != nil {
+           return saved, fmt.Errorf("save generated image %d: %w", i, err)
+       }
+       prov := &storage.Provenance{
+           Operation:       op,
+           CreatedAt:       time.Now().UTC().Format(time.RFC3339Nano),
+           Model:           info.ModelName,
+           ModelHash:       info.ModelHash,
+           SourceHash:      sourceHash,
+           Index:           i,
+           Request:         reqJSON,
+           PromptExpansion: expansion,
+           Info:            resp.Info,
+       }
+       if i < len(info.AllSeeds) {
+           seed := info.AllSeeds[i]
+           prov.Seed = &seed
+       } else if info.Seed != 0 {
+           seed := info.Seed
+           prov.Seed = &seed
+       }
+       if first+i < len(info.Infotexts) {
+           prov.Infotext = info.Infotexts[first+i]
+       }
+       if err := storage.SaveProvenance(baseDir, hash, prov); err != nil {
+           log.Printf("Error saving provenance for %s: %v", name, err)
+       }
+       ts, _ := storage.LoadMetaEntry(baseDir, hash)
+       saved = append(saved, ImageResponse{ID: hash, URL: imageURL(sub, hash), Timestamp: ts, Path: sub})
+   }
+   return saved, nil
+}
@@ -0,0 +1,340 @@
+package api
+
+import (
+   "cmp"
+   "encoding/base64"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "path/filepath"
+   "sort"
+   "strconv"
+   "strings"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/storage"
+)
+
+// maxPageSize caps the number of items returned in one page.
+const maxPageSize = 1000
+
+// listSortKeys are the accepted values of the sort query parameter.
+var listSortKeys = map[string]bool{
+   "timestamp": true,
+   "name":      true,
+   "size":      true,
+   "rating":    true,
+   "uploaded":  true,
+   "exif":      true,
+}
+
+// listFields are the accepted values of the fields query parameter.
+var listFields = map[string]bool{
+   "width":        true,
+   "height":       true,
+   "size":         true,
+   "dialog_count": true,
+   "tags":         true,
+   "rating":       true,
+   "format":       true,
+   "bit_depth":    true,
+   "icc_profile":  true,
+   "generation":   true,
+}
+
+// listOptions holds the sort, pagination and field selection for an image listing.
+type listOptions struct {
+   Sort   string
+   Desc   bool
+   Limit  int
+   Cursor string
+   Fields map[string]bool
+   Gen    generationFilter
+}
+
+// generationFilter selects images by their embedded generation parameters; empty fields match anything.
+type generationFilter struct {
+   Model   string
+   Sampler string
+   Seed    *int64
+   Prompt  string
+}
+
+// active reports whether any constraint is set.
+func (f generationFilter) active() bool {
+   return f.Model != "" || f.Sampler != "" || f.Seed != nil || f.Prompt != ""
+}
+
+// matches reports whether params satisfy the filter. Model matches the model name
+// (case-insensitive substring) or the exact model hash; prompt is a case-insensitive substring.
+func (f generationFilter) matches(params *storage.GenerationParams) bool {
+   if params == nil {
+       return false
+   }
+   if f.Model != "" && !strings.Contains(strings.ToLower(params.Model), strings.ToLower(f.Model)) && !strings.EqualFold(params.ModelHash, f.Model) {
+       return false
+   }
+   if f.Sampler != "" && !strings.EqualFold(params.Sampler, f.Sampler) {
+       return false
+   }
+   if f.Seed != nil && (params.Seed == nil || *params.Seed != *f.Seed) {
+       return false
+   }
+   if f.Prompt != "" && !strings.Contains(strings.ToLower(params.Prompt), strings.ToLower(f.Prompt)) {
+       return false
+   }
+   return true
+}
+
+// parseListOptions reads listing parameters from the query string:
+//   sort=timestamp|name|size|rating|uploaded|exif (default timestamp)
+//   order=asc|desc
+//   limit=N (0 or absent returns everything)
+//   cursor=<opaque value from a previous page>
+//   fields=width,height,size,dialog_count,tags,rating,format,bit_depth,icc_profile,generation
+//   model, sampler, seed, prompt: filter on embedded generation parameters
+func parseListOptions(c *gin.Context) (listOptions, error) {
+   opts := listOptions{Sort: "timestamp", Fields: map[string]bool{}}
+   if s := c.Query("sort"); s != "" {
+       if !listSortKeys[s] {
+           return opts, fmt.Errorf("invalid sort key %q", s)
+       }
+       opts.Sort = s
+   }
+   switch c.Query("order") {
+   case "", "asc":
+   case "desc":
+       opts.Desc = true
+   default:
+       return opts, errors.New("order must be asc or desc")
+   }
+   if l := c.Query("limit"); l != "" {
+       n, err := strconv.Atoi(l)
+       if err != nil || n < 0 {
+           return opts, errors.New("limit must be a non-negative integer")
+       }
+       if n > maxPageSize {
+           n = maxPageSize
+       }
+       opts.Limit = n
+   }
+   opts.Cursor = c.Query("cursor")
+   if f := c.Query("fields"); f != "" {
+       for _, name := range strings.Split(f, ",") {
+           name = strings.TrimSpace(name)
+           if name == "" {
+               continue
+           }
+           if !listFields[name] {
+               return opts, fmt.Errorf("unknown field %q", name)
+           }
+           opts.Fields[name] = true
+       }
+   }
+   opts.Gen.Model = c.Query("model")
+   opts.Gen.Sampler = c.Query("sampler")
+   opts.Gen.Prompt = c.Query("prompt")
+   if v := c.Query("seed"); v != "" {
+       seed, err := strconv.ParseInt(v, 10, 64)
+       if err != nil {
+           return opts, errors.New("seed must be an integer")
+       }
+       opts.Gen.Seed = &seed
+   }
+   return opts, nil
+}
+
+// filterRecords drops records that do not satisfy the generation filter.
+func filterRecords(recs []imageRecord, opts listOptions) []imageRecord {
+   if !opts.Gen.active() {
+       return recs
+   }
+   out := make([]imageRecord, 0, len(recs))
+   for _, rec := range recs {
+       params, err := storage.LoadGenerationParams(rec.baseDir(), rec.Name, rec.ID)
+       if err == nil && opts.Gen.matches(params) {
+           out = append(out, rec)
+       }
+   }
+   return out
+}
+
+// listKey is the position of a record in a listing: its sort key, timestamp and ID.
+// Cursors carry the key of the last item of a page, so the next page resumes after that
+// position even if the item itself was deleted in between.
+type listKey struct {
+   Str string `json:"s,omitempty"`
+   Num int64  `json:"n,omitempty"`
+   TS  int64  `json:"t,omitempty"`
+   ID  string `json:"i"`
+}
+
+// compare orders keys by sort value, then timestamp, then ID.
+func (k listKey) compare(o listKey) int {
+   switch {
+   case k.Str != o.Str:
+       return strings.Compare(k.Str, o.Str)
+   case k.Num != o.Num:
+       return cmp.Compare(k.Num, o.Num)
+   case k.TS != o.TS:
+       return cmp.Compare(k.TS, o.TS)
+   }
+   return strings.Compare(k.ID, o.ID)
+}
+
+// sortRecords orders records in place by the requested key, breaking ties by timestamp and ID,
+// and returns the key of each record in the new order.
+func sortRecords(recs []imageRecord, opts listOptions) []listKey {
+   type keyed struct {
+       rec imageRecord
+       key listKey
+   }
+   items := make([]keyed, len(recs))
+   for i, rec := range recs {
+       k := listKey{TS: rec.TS.UnixNano(), ID: rec.ID}
+       switch opts.Sort {
+       case "name":
+           k.Str = strings.ToLower(rec.Name)
+       case "size":
+           k.Num = rec.Size
+       case "rating":
+           if attrs, err := storage.LoadAttributes(rec.baseDir(), rec.ID); err == nil {
+               k.Num = int64(attrs.Rating)
+           }
+       case "uploaded":
+           k.Num = rec.ModTime.UnixNano()
+       case "exif":
+           if t, err := storage.ParseExifTimestamp(filepath.Join(rec.baseDir(), rec.Name)); err == nil {
+               k.Num = t.UnixNano()
+           } else {
+               k.Num = rec.TS.UnixNano()
+           }
+       default:
+           k.Num = rec.TS.UnixNano()
+       }
+       items[i] = keyed{rec: rec, key: k}
+   }
+   sort.SliceStable(items, func(i, j int) bool {
+       if opts.Desc {
+           return items[j].key.compare(items[i].key) < 0
+       }
+       return items[i].key.compare(items[j].key) < 0
+   })
+   keys := make([]listKey, len(items))
+   for i := range items {
+       recs[i] = items[i].rec
+       keys[i] = items[i].key
+   }
+   return keys
+}
+
+// encodeCursor returns the opaque cursor pointing after the given listing position.
+func encodeCursor(k listKey) string {
+   data, _ := json.Marshal(k)
+   return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor.
+func decodeCursor(cursor string) (listKey, error) {
+   var k listKey
+   raw, err := base64.RawURLEncoding.DecodeString(cursor)
+   if err != nil || json.Unmarshal(raw, &k) != nil || k.ID == "" {
+       return k, errors.New("invalid cursor")
+   }
+   return k, nil
+}
+
+// paginateRecords returns the page of records following the cursor and the cursor for the next page.
+// keys are the sort keys returned by sortRecords; nil means recs are in their own order (a
+// collection), where the cursor resumes after its image or, once that is gone, at the index it had.
+func paginateRecords(recs []imageRecord, keys []listKey, opts listOptions) ([]imageRecord, string, error) {
+   start := 0
+   if opts.Cursor != "" {
+       after, err := decodeCursor(opts.Cursor)
+       if err != nil {
+           return nil, "", err
+       }
+       if keys != nil {
+           start = sort.Search(len(keys), func(i int) bool {
+               if opts.Desc {
+                   return keys[i].compare(after) < 0
+               }
+               return keys[i].compare(after) > 0
+           })
+       } else {
+           start = min(int(max(after.Num, 0)), len(recs))
+           for i, rec := range recs {
+               if rec.ID == after.ID {
+                   start = i + 1
+                   break
+               }
+           }
+       }
+   }
+   page := recs[start:]
+   if opts.Limit == 0 || len(page) <= opts.Limit {
+       return page, "", nil
+   }
+   page = page[:opts.Limit]
+   last := start + len(page) - 1
+   next := listKey{Num: int64(last), ID: recs[last].ID}
+   if keys != nil {
+       next = keys[last]
+   }
+   return page, encodeCursor(next), nil
+}
+
+// buildImageResponses converts records to responses, filling in the requested optional fields.
+func buildImageResponses(recs []imageRecord, fields map[string]bool) []ImageResponse {
+   resp := make([]ImageResponse, len(recs))
+   for i, rec := range recs {
+       out := rec.ImageResponse
+       baseDir := rec.baseDir()
+       if fields["width"] || fields["height"] || fields["format"] || fields["bit_depth"] || fields["icc_profile"] {
+           if info, err := storage.LoadImageInfo(baseDir, rec.Name, rec.ID); err == nil {
+               if fields["width"] {
+                   out.Width = info.Width
+               }
+               if fields["height"] {
+                   out.Height = info.Height
+               }
+               if fields["format"] {
+                   out.Format = info.Format
+               }
+               if fields["bit_depth"] {
+                   out.BitDepth = info.BitDepth
+               }
+               if fields["icc_profile"] {
+                   out.ICCProfile = info.ICCProfile
+               }
+           }
+       }
+       if fields["size"] {
+           out.Size = rec.Size
+       }
+       if fields["dialog_count"] {
+           n := 0
+           if entries, err := storage.LoadDialogFile(baseDir, rec.Name); err == nil {
+               n = len(entries)
+           }
+           out.DialogCount = &n
+       }
+       if fields["tags"] || fields["rating"] {
+           attrs, _ := storage.LoadAttributes(baseDir, rec.ID)
+           if fields["tags"] {
+               out.Tags = attrs.Tags
+           }
+           if fields["rating"] {
+               rating := attrs.Rating
+               out.Rating = &rating
+           }
+       }
+       if fields["generation"] {
+           if params, err := storage.LoadGenerationParams(baseDir, rec.Name, rec.ID); err == nil {
+               out.Generation = params
+           }
+       }
+       resp[i] = out
+   }
+   return resp
+}
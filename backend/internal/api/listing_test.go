@@ -0,0 +1,140 @@
+package api_test
+
+import (
+   "encoding/json"
+   "image/color"
+   "net/http"
+   "os"
+   "path/filepath"
+   "testing"
+
+   "image-processor-backend/internal/api"
+)
+
+func TestGetImagesPaginationAndFields(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   a := writeTestPNG(t, filepath.Join(root, "20240101000000-000000001.png"), color.RGBA{255, 0, 0, 255})
+   b := writeTestPNG(t, filepath.Join(root, "20240102000000-000000001.png"), color.RGBA{0, 255, 0, 255})
+   c := writeTestPNG(t, filepath.Join(root, "20240103000000-000000001.png"), color.RGBA{0, 0, 255, 255})
+
+   w := doJSON(t, http.MethodGet, "/api/images?order=desc&limit=2&fields=width,height,dialog_count", nil)
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected status 200, got %d", w.Code)
+   }
+   var page1 []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &page1); err != nil {
+       t.Fatalf("unmarshal page 1: %v", err)
+   }
+   if len(page1) != 2 || page1[0].ID != c || page1[1].ID != b {
+       t.Fatalf("unexpected first page: %+v", page1)
+   }
+   if page1[0].Width != 4 || page1[0].Height != 4 {
+       t.Errorf("expected 4x4 dimensions, got %dx%d", page1[0].Width, page1[0].Height)
+   }
+   if page1[0].DialogCount == nil || *page1[0].DialogCount != 0 {
+       t.Errorf("expected dialog_count 0, got %v", page1[0].DialogCount)
+   }
+   if page1[0].Size != 0 {
+       t.Errorf("size should be omitted when not requested")
+   }
+   cursor := w.Header().Get("X-Next-Cursor")
+   if cursor == "" {
+       t.Fatalf("expected next cursor header")
+   }
+
+   w = doJSON(t, http.MethodGet, "/api/images?order=desc&limit=2&cursor="+cursor, nil)
+   var page2 []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &page2); err != nil {
+       t.Fatalf("unmarshal page 2: %v", err)
+   }
+   if len(page2) != 1 || page2[0].ID != a {
+       t.Fatalf("unexpected second page: %+v", page2)
+   }
+   if w.Header().Get("X-Next-Cursor") != "" {
+       t.Errorf("expected no cursor on last page")
+   }
+
+   if w := doJSON(t, http.MethodGet, "/api/images?sort=bogus", nil); w.Code != http.StatusBadRequest {
+       t.Errorf("expected 400 for invalid sort, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodGet, "/api/images?cursor=!!", nil); w.Code != http.StatusBadRequest {
+       t.Errorf("expected 400 for invalid cursor, got %d", w.Code)
+   }
+}
+
+func TestCursorSurvivesDeletedImage(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   writeTestPNG(t, filepath.Join(root, "20240101000000-000000001.png"), color.RGBA{255, 0, 0, 255})
+   writeTestPNG(t, filepath.Join(root, "20240102000000-000000001.png"), color.RGBA{0, 255, 0, 255})
+   c := writeTestPNG(t, filepath.Join(root, "20240103000000-000000001.png"), color.RGBA{0, 0, 255, 255})
+
+   w := doJSON(t, http.MethodGet, "/api/images?limit=2", nil)
+   cursor := w.Header().Get("X-Next-Cursor")
+   if cursor == "" {
+       t.Fatalf("expected next cursor header")
+   }
+   if err := os.Remove(filepath.Join(root, "20240102000000-000000001.png")); err != nil {
+       t.Fatalf("remove: %v", err)
+   }
+   w = doJSON(t, http.MethodGet, "/api/images?limit=2&cursor="+cursor, nil)
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected status 200 after deleting the cursor image, got %d: %s", w.Code, w.Body.String())
+   }
+   var page []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+       t.Fatalf("unmarshal: %v", err)
+   }
+   if len(page) != 1 || page[0].ID != c {
+       t.Fatalf("expected to resume after the deleted image, got %+v", page)
+   }
+}
+
+func TestDialogsSendCursorInHeader(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   a := writeTestPNG(t, filepath.Join(root, "20240101000000-000000001.png"), color.RGBA{255, 0, 0, 255})
+   b := writeTestPNG(t, filepath.Join(root, "20240102000000-000000001.png"), color.RGBA{0, 255, 0, 255})
+
+   w := doJSON(t, http.MethodGet, "/api/dialogs?limit=1", nil)
+   var resp struct {
+       Dialogs map[string][]string `json:"dialogs"`
+   }
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+       t.Fatalf("unmarshal: %v", err)
+   }
+   cursor := w.Header().Get("X-Next-Cursor")
+   if len(resp.Dialogs) != 1 || resp.Dialogs[a] == nil || cursor == "" {
+       t.Fatalf("unexpected first page %s (cursor %q)", w.Body.String(), cursor)
+   }
+   w = doJSON(t, http.MethodGet, "/api/dialogs?limit=1&cursor="+cursor, nil)
+   resp.Dialogs = nil
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+       t.Fatalf("unmarshal: %v", err)
+   }
+   if len(resp.Dialogs) != 1 || resp.Dialogs[b] == nil || w.Header().Get("X-Next-Cursor") != "" {
+       t.Fatalf("unexpected second page %s", w.Body.String())
+   }
+}
+
+func TestDialogsApplyGenerationFilter(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   writeTestPNG(t, filepath.Join(root, "20240101000000-000000001.png"), color.RGBA{255, 0, 0, 255})
+   writeInfotextPNG(t, filepath.Join(root, "20240102000000-000000001.png"), "a cat\nSteps: 20, Sampler: Euler a, Seed: 7, Model: sdxl_base")
+
+   w := doJSON(t, http.MethodGet, "/api/dialogs?sampler=euler%20a", nil)
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected status 200, got %d", w.Code)
+   }
+   var resp struct {
+       Dialogs map[string][]string `json:"dialogs"`
+   }
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+       t.Fatalf("unmarshal: %v", err)
+   }
+   if len(resp.Dialogs) != 1 {
+       t.Fatalf("expected only the generated image, got %s", w.Body.String())
+   }
+}
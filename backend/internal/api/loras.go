@@ -0,0 +1,92 @@
+package api
+
+import (
+   "context"
+   "fmt"
+   "net/http"
+   "strconv"
+   "strings"
+
+   "image-processor-backend/internal/forgeclient"
+   "github.com/gin-gonic/gin"
+)
+
+// lorasCacheKey is the discovery cache entry holding the LoRA listing.
+const lorasCacheKey = "loras"
+
+// maxLoraWeight bounds the absolute weight accepted for a LoRA reference.
+const maxLoraWeight = 10
+
+// LoraRef selects a LoRA by name or alias for a generation request.
+// Weight defaults to 1 when omitted.
+type LoraRef struct {
+   Name   string   `json:"name"`
+   Weight *float64 `json:"weight,omitempty"`
+}
+
+// cachedLoras returns the LoRA listing from the discovery cache.
+func cachedLoras(ctx context.Context) ([]forgeclient.LoraInfo, error) {
+   v, err := cachedDiscovery(ctx, lorasCacheKey, func(ctx context.Context) (interface{}, error) {
+       return ForgeSvc.Loras(ctx)
+   })
+   if err != nil {
+       return nil, err
+   }
+   return v.([]forgeclient.LoraInfo), nil
+}
+
+// validateLoras checks that every referenced LoRA exists on the server and has a sane weight,
+// writing a 400 (or 502 if the listing cannot be fetched) response otherwise.
+func validateLoras(c *gin.Context, refs []LoraRef) bool {
+   if len(refs) == 0 {
+       return true
+   }
+   loras, err := cachedLoras(c.Request.Context())
+   if err != nil {
+       writeForgeError(c, err)
+       return false
+   }
+   known := make(map[string]bool, 2*len(loras))
+   for _, l := range loras {
+       known[l.Name] = true
+       if l.Alias != "" {
+           known[l.Alias] = true
+       }
+   }
+   for _, ref := range refs {
+       if ref.Name == "" || strings.ContainsAny(ref.Name, "<>:") {
+           c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid lora name %q", ref.Name)})
+           return false
+       }
+       if !known[ref.Name] {
+           c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown lora %q", ref.Name)})
+           return false
+       }
+       if ref.Weight != nil && (*ref.Weight < -maxLoraWeight || *ref.Weight > maxLoraWeight) {
+           c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("lora %q weight must be between %d and %d", ref.Name, -maxLoraWeight, maxLoraWeight)})
+           return false
+       }
+   }
+   return true
+}
+
+// withLoras appends <lora:name:weight> tags for refs to prompt, skipping LoRAs the prompt
+// already references.
+func withLoras(prompt string, refs []LoraRef) string {
+   for _, ref := range refs {
+       if strings.Contains(prompt, "<lora:"+ref.Name+":") || strings.Contains(prompt, "<lora:"+ref.Name+">") {
+           continue
+       }
+       weight := 1.0
+       if ref.Weight != nil {
+           weight = *ref.Weight
+       }
+       tag := "<lora:" + ref.Name + ":" + strconv.FormatFloat(weight, 'g', -1, 64) + ">"
+       if strings.TrimSpace(prompt) == "" {
+           prompt = tag
+       } else {
+           prompt += " " + tag
+       }
+   }
+   return prompt
+}
@@ -0,0 +1,70 @@
+package api_test
+
+import (
+   "context"
+   "encoding/json"
+   "net/http"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+)
+
+func TestLoraListingRefreshAndInjection(t *testing.T) {
+   listCalls, refreshCalls := 0, 0
+   var prompt string
+   api.SetForgeClient(&forgeclient.MockClient{
+       LorasFunc: func(ctx context.Context) ([]forgeclient.LoraInfo, error) {
+           listCalls++
+           return []forgeclient.LoraInfo{
+               {Name: "detail_tweaker", Alias: "add_detail", Path: "/models/Lora/detail_tweaker.safetensors", TriggerWords: []string{"detailed"}},
+               {Name: "pixel_art"},
+           }, nil
+       },
+       RefreshLorasFunc: func(ctx context.Context) error {
+           refreshCalls++
+           return nil
+       },
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           prompt = req.Prompt
+           return &forgeclient.ImageResponse{}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodGet, "/api/v1/loras", nil)
+   var loras []forgeclient.LoraInfo
+   if err := json.Unmarshal(w.Body.Bytes(), &loras); err != nil || len(loras) != 2 || loras[0].Alias != "add_detail" || loras[0].TriggerWords[0] != "detailed" {
+       t.Fatalf("unexpected listing: %s", w.Body.String())
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/loras/refresh", nil); w.Code != http.StatusNoContent {
+       t.Fatalf("refresh: expected 204, got %d", w.Code)
+   }
+   doJSON(t, http.MethodGet, "/api/v1/loras", nil)
+   if refreshCalls != 1 || listCalls != 2 {
+       t.Errorf("expected refresh to drop the cache: refresh=%d list=%d", refreshCalls, listCalls)
+   }
+
+   body := map[string]interface{}{
+       "prompt": "a castle <lora:pixel_art:0.3>",
+       "loras": []map[string]interface{}{
+           {"name": "add_detail", "weight": 0.6},
+           {"name": "pixel_art", "weight": 1},
+       },
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/txt2img", body); w.Code != http.StatusOK {
+       t.Fatalf("txt2img: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   if prompt != "a castle <lora:pixel_art:0.3> <lora:add_detail:0.6>" {
+       t.Errorf("unexpected prompt: %q", prompt)
+   }
+
+   for _, loras := range [][]map[string]interface{}{
+       {{"name": "missing"}},
+       {{"name": "pixel_art", "weight": 50}},
+       {{"name": "pixel<art"}},
+   } {
+       if w := doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{"prompt": "x", "loras": loras}); w.Code != http.StatusBadRequest {
+           t.Errorf("%v: expected 400, got %d", loras, w.Code)
+       }
+   }
+}
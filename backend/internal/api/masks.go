@@ -0,0 +1,208 @@
+package api
+
+import (
+   "errors"
+   "fmt"
+   "image"
+   "net/http"
+   "path/filepath"
+   "strconv"
+   "time"
+
+   "image-processor-backend/internal/imaging"
+   "image-processor-backend/internal/storage"
+   "github.com/gin-gonic/gin"
+)
+
+// maskRequest is the payload for creating or replacing a mask.
+type maskRequest struct {
+   Name string `json:"name"`
+   imaging.MaskSpec
+}
+
+// libraryImageOr404 resolves the :id image (in directory ?path=) of a per-image route,
+// writing an error response if the path is invalid or the image does not exist.
+func libraryImageOr404(c *gin.Context) (baseDir, filename, hash string, ok bool) {
+   sub, err := resolveSubDir(c.Query("path"))
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return "", "", "", false
+   }
+   hash = c.Param("id")
+   baseDir = ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err = findFilenameByHash(baseDir, hash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve image ID"})
+       return "", "", "", false
+   }
+   if filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return "", "", "", false
+   }
+   return baseDir, filename, hash, true
+}
+
+// writeMaskError maps a mask storage error to a response.
+func writeMaskError(c *gin.Context, err error) {
+   if errors.Is(err, storage.ErrMaskNotFound) {
+       c.JSON(http.StatusNotFound, gin.H{"error": "mask not found"})
+       return
+   }
+   c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// renderMask rasterizes spec at the resolution of the image filename in baseDir.
+func renderMask(baseDir, filename, hash string, spec imaging.MaskSpec) (*image.Gray, error) {
+   info, err := storage.LoadImageInfo(baseDir, filename, hash)
+   if err != nil {
+       return nil, fmt.Errorf("read image dimensions: %w", err)
+   }
+   if info.Width <= 0 || info.Height <= 0 {
+       return nil, errors.New("image has no dimensions")
+   }
+   return spec.Render(info.Width, info.Height), nil
+}
+
+// handleListMasks returns the masks stored for an image.
+func handleListMasks(c *gin.Context) {
+   baseDir, _, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   masks, err := storage.ListMasks(baseDir, hash)
+   if err != nil {
+       writeMaskError(c, err)
+       return
+   }
+   c.JSON(http.StatusOK, masks)
+}
+
+// handleCreateMask stores a new mask for an image.
+func handleCreateMask(c *gin.Context) {
+   baseDir, _, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   var req maskRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if err := req.Validate(); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   now := time.Now().UTC().Format(time.RFC3339Nano)
+   m := &storage.Mask{ID: storage.NewMaskID(), Name: req.Name, CreatedAt: now, UpdatedAt: now, MaskSpec: req.MaskSpec}
+   if err := storage.SaveMask(baseDir, hash, m); err != nil {
+       writeMaskError(c, err)
+       return
+   }
+   c.JSON(http.StatusCreated, m)
+}
+
+// handleGetMask returns a single mask definition.
+func handleGetMask(c *gin.Context) {
+   baseDir, _, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   m, err := storage.LoadMask(baseDir, hash, c.Param("mask"))
+   if err != nil {
+       writeMaskError(c, err)
+       return
+   }
+   c.JSON(http.StatusOK, m)
+}
+
+// handleUpdateMask replaces the name and contents of a mask.
+func handleUpdateMask(c *gin.Context) {
+   baseDir, _, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   m, err := storage.LoadMask(baseDir, hash, c.Param("mask"))
+   if err != nil {
+       writeMaskError(c, err)
+       return
+   }
+   var req maskRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if err := req.Validate(); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   m.Name = req.Name
+   m.MaskSpec = req.MaskSpec
+   m.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+   if err := storage.SaveMask(baseDir, hash, m); err != nil {
+       writeMaskError(c, err)
+       return
+   }
+   c.JSON(http.StatusOK, m)
+}
+
+// handleDeleteMask removes a mask.
+func handleDeleteMask(c *gin.Context) {
+   baseDir, _, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   if err := storage.DeleteMask(baseDir, hash, c.Param("mask")); err != nil {
+       writeMaskError(c, err)
+       return
+   }
+   c.Status(http.StatusNoContent)
+}
+
+// handleRenderMask returns a mask rasterized as a grayscale PNG at the image's resolution.
+// The feather, expand and invert query parameters override the stored options.
+func handleRenderMask(c *gin.Context) {
+   baseDir, filename, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   m, err := storage.LoadMask(baseDir, hash, c.Param("mask"))
+   if err != nil {
+       writeMaskError(c, err)
+       return
+   }
+   spec := m.MaskSpec
+   for _, opt := range []struct {
+       name string
+       dst  *int
+   }{{"feather", &spec.Feather}, {"expand", &spec.Expand}} {
+       if v := c.Query(opt.name); v != "" {
+           n, err := strconv.Atoi(v)
+           if err != nil {
+               c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + opt.name})
+               return
+           }
+           *opt.dst = n
+       }
+   }
+   if v := c.Query("invert"); v != "" {
+       spec.Invert = v == "true"
+   }
+   if err := spec.Validate(); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   mask, err := renderMask(baseDir, filename, hash, spec)
+   if err != nil {
+       c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+       return
+   }
+   data, err := imaging.EncodePNG(mask)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   c.Data(http.StatusOK, "image/png", data)
+}
@@ -0,0 +1,148 @@
+package api_test
+
+import (
+   "bytes"
+   "context"
+   "encoding/json"
+   "image/color"
+   "image/png"
+   "net/http"
+   "path/filepath"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+)
+
+// skyMask covers the top half of an image except a brush dab in its top left corner.
+var skyMask = map[string]interface{}{
+   "name":   "sky",
+   "shapes": []map[string]interface{}{{"type": "rect", "x": 0, "y": 0, "width": 1, "height": 0.5}},
+   "strokes": []map[string]interface{}{
+       {"points": []map[string]float64{{"x": 0.125, "y": 0.125}}, "radius": 0.1, "erase": true},
+   },
+}
+
+func TestMaskCRUD(t *testing.T) {
+   _, hash := writeLibraryImage(t, "scenes", color.White)
+   base := "/api/images/" + hash + "/masks"
+
+   w := doJSON(t, http.MethodPost, base+"?path=scenes", skyMask)
+   if w.Code != http.StatusCreated {
+       t.Fatalf("create: expected 201, got %d: %s", w.Code, w.Body.String())
+   }
+   var m storage.Mask
+   if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil || m.ID == "" || len(m.Strokes) != 1 {
+       t.Fatalf("unexpected mask: %s", w.Body.String())
+   }
+   spec := map[string]interface{}{"name": "sky", "shapes": skyMask["shapes"], "expand": 1}
+   if w := doJSON(t, http.MethodPut, base+"/"+m.ID+"?path=scenes", spec); w.Code != http.StatusOK {
+       t.Fatalf("update: expected 200, got %d", w.Code)
+   }
+   w = doJSON(t, http.MethodGet, base+"?path=scenes", nil)
+   var list []storage.Mask
+   if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Expand != 1 || len(list[0].Strokes) != 0 {
+       t.Errorf("update not listed: %s", w.Body.String())
+   }
+   if w := doJSON(t, http.MethodDelete, base+"/"+m.ID+"?path=scenes", nil); w.Code != http.StatusNoContent {
+       t.Fatalf("delete: expected 204, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodGet, base+"/"+m.ID+"?path=scenes", nil); w.Code != http.StatusNotFound {
+       t.Errorf("expected 404 after delete, got %d", w.Code)
+   }
+}
+
+func TestMaskRendersAtImageSize(t *testing.T) {
+   _, hash := writeLibraryImage(t, "scenes", color.White)
+   base := "/api/images/" + hash + "/masks"
+   w := doJSON(t, http.MethodPost, base+"?path=scenes", skyMask)
+   var m storage.Mask
+   if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil || w.Code != http.StatusCreated {
+       t.Fatalf("create: %d %s", w.Code, w.Body.String())
+   }
+
+   w = doJSON(t, http.MethodGet, base+"/"+m.ID+"/png?path=scenes", nil)
+   if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+       t.Fatalf("render: %d %s", w.Code, w.Header().Get("Content-Type"))
+   }
+   img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+   if err != nil || img.Bounds().Dx() != 4 || img.Bounds().Dy() != 4 {
+       t.Fatalf("expected a 4x4 mask, got %v (%v)", img.Bounds(), err)
+   }
+   gray := func(x, y int) uint32 { v, _, _, _ := img.At(x, y).RGBA(); return v >> 8 }
+   if gray(0, 0) != 0 || gray(3, 0) != 255 || gray(3, 3) != 0 {
+       t.Errorf("unexpected mask pixels: %d %d %d", gray(0, 0), gray(3, 0), gray(3, 3))
+   }
+   w = doJSON(t, http.MethodGet, base+"/"+m.ID+"/png?path=scenes&invert=true", nil)
+   img, _ = png.Decode(bytes.NewReader(w.Body.Bytes()))
+   if gray(3, 3) != 255 || gray(3, 0) != 0 {
+       t.Errorf("invert override not applied")
+   }
+}
+
+func TestImg2ImgReadsImageAndMaskFromLibrary(t *testing.T) {
+   _, hash := writeLibraryImage(t, "scenes", color.White)
+   w := doJSON(t, http.MethodPost, "/api/images/"+hash+"/masks?path=scenes", skyMask)
+   var m storage.Mask
+   if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil || w.Code != http.StatusCreated {
+       t.Fatalf("create: %d %s", w.Code, w.Body.String())
+   }
+   var got forgeclient.Img2ImgRequest
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           got = *req
+           return &forgeclient.ImageResponse{}, nil
+       },
+   })
+
+   body := map[string]interface{}{"prompt": "clouds", "image_id": hash, "path": "scenes", "mask_id": m.ID}
+   if w := doJSON(t, http.MethodPost, "/api/v1/img2img", body); w.Code != http.StatusOK {
+       t.Fatalf("img2img: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+
+   if len(got.InitImages) != 1 || got.Mask == "" {
+       t.Fatalf("library inputs not resolved: %d init images, mask %q", len(got.InitImages), got.Mask)
+   }
+   if mask := decodeB64PNG(t, got.Mask); mask.Bounds().Dx() != 4 {
+       t.Errorf("mask rendered at wrong size: %v", mask.Bounds())
+   }
+}
+
+func TestMaskRejectsInvalidRequests(t *testing.T) {
+   _, hash := writeLibraryImage(t, "scenes", color.White)
+   w := doJSON(t, http.MethodPost, "/api/images/"+hash+"/masks?path=scenes", skyMask)
+   var m storage.Mask
+   if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil || w.Code != http.StatusCreated {
+       t.Fatalf("create: %d %s", w.Code, w.Body.String())
+   }
+   api.SetForgeClient(&forgeclient.MockClient{})
+
+   if w := doJSON(t, http.MethodPost, "/api/images/"+hash+"/masks?path=scenes", map[string]interface{}{"name": "empty"}); w.Code != http.StatusBadRequest {
+       t.Errorf("empty mask: expected 400, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/img2img", map[string]interface{}{"prompt": "x", "mask_id": m.ID}); w.Code != http.StatusBadRequest {
+       t.Errorf("mask without image: expected 400, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/img2img", map[string]interface{}{"prompt": "x", "image_id": hash, "path": "scenes", "mask_id": "missing"}); w.Code != http.StatusNotFound {
+       t.Errorf("unknown mask: expected 404, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/img2img", map[string]interface{}{"prompt": "x", "image_id": "0000", "path": "scenes"}); w.Code != http.StatusNotFound {
+       t.Errorf("unknown image: expected 404, got %d", w.Code)
+   }
+}
+
+func TestMaskRoutesRejectInvalidPath(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   hash := writeTestPNG(t, filepath.Join(root, "20240101000000-000000000.png"), color.White)
+   for _, path := range []string{"metadata", "scenes/dialogs", "collections"} {
+       if w := doJSON(t, http.MethodGet, "/api/images/"+hash+"/masks?path="+path, nil); w.Code != http.StatusBadRequest {
+           t.Errorf("path %q: expected 400, got %d", path, w.Code)
+       }
+   }
+   // Parent references are cleaned to stay inside the image directory
+   if w := doJSON(t, http.MethodGet, "/api/images/"+hash+"/masks?path=../..", nil); w.Code != http.StatusOK {
+       t.Errorf("expected ../.. to resolve to the root, got %d", w.Code)
+   }
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+   "context"
+   "encoding/base64"
+   "fmt"
+   "image"
+   "image/draw"
+   "net/http"
+   "sort"
+   "strings"
+
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/imaging"
+   "image-processor-backend/internal/storage"
+   "github.com/gin-gonic/gin"
+)
+
+// Outpainting limits and defaults, in pixels.
+const (
+   maxOutpaintSize        = 8192
+   minOutpaintStep        = 64
+   maxOutpaintStep        = 1024
+   defaultOutpaintStep    = 256
+   maxOutpaintOverlap     = 256
+   defaultOutpaintOverlap = 32
+)
+
+// Inpainting defaults for the added area: it has no content to keep, so it starts from
+// latent noise and is fully denoised.
+const (
+   defaultOutpaintFill    = 2
+   defaultOutpaintDenoise = 1.0
+)
+
+// outpaintAnchors place the source image on the extended canvas, as fractions of the free
+// space to its left and above it.
+var outpaintAnchors = map[string][2]float64{
+   "top-left":     {0, 0},
+   "top":          {0.5, 0},
+   "top-right":    {1, 0},
+   "left":         {0, 0.5},
+   "center":       {0.5, 0.5},
+   "right":        {1, 0.5},
+   "bottom-left":  {0, 1},
+   "bottom":       {0.5, 1},
+   "bottom-right": {1, 1},
+}
+
+// outpaintRequest is the /api/v1/outpaint payload. The library image is extended to
+// Width x Height with the source placed by Anchor. Expansions larger than MaxStep on a side
+// are done in several passes; each pass repaints the new strip plus Overlap pixels of the
+// existing image. Params holds the shared img2img settings (its images, mask, prompts and
+// size are filled in by the backend). InpaintingFill defaults to Params.inpainting_fill if
+// that is set, otherwise latent noise, and an unset denoising strength to 1. The prompts
+// are expanded like those of a generation (see expandPrompts). Without replace or save_to,
+// the result is saved next to the source.
+type outpaintRequest struct {
+   ImageID        string                     `json:"image_id"`
+   Path           string                     `json:"path,omitempty"`
+   Width          int                        `json:"width"`
+   Height         int                        `json:"height"`
+   Anchor         string                     `json:"anchor,omitempty"`
+   MaxStep        int                        `json:"max_step,omitempty"`
+   Overlap        *int                       `json:"overlap,omitempty"`
+   Prompt         string                     `json:"prompt,omitempty"`
+   NegativePrompt string                     `json:"negative_prompt,omitempty"`
+   InpaintingFill *int                       `json:"inpainting_fill,omitempty"`
+   Params         forgeclient.Img2ImgRequest `json:"params"`
+   editTarget
+   promptOptions
+}
+
+// outpaintSide is one edge of the canvas: the direction towards the image interior.
+type outpaintSide int
+
+const (
+   sideLeft outpaintSide = iota
+   sideRight
+   sideTop
+   sideBottom
+)
+
+// inward grows r by n pixels towards the interior of a canvas from side s.
+func (s outpaintSide) inward(r image.Rectangle, n int) image.Rectangle {
+   switch s {
+   case sideLeft:
+       r.Max.X += n
+   case sideRight:
+       r.Min.X -= n
+   case sideTop:
+       r.Max.Y += n
+   case sideBottom:
+       r.Min.Y -= n
+   }
+   return r
+}
+
+// strip returns the rectangle of the n pixels added on side s of a w x h canvas.
+func (s outpaintSide) strip(w, h, n int) image.Rectangle {
+   switch s {
+   case sideLeft:
+       return image.Rect(0, 0, n, h)
+   case sideRight:
+       return image.Rect(w-n, 0, w, h)
+   case sideTop:
+       return image.Rect(0, 0, w, n)
+   }
+   return image.Rect(0, h-n, w, h)
+}
+
+// segments splits a strip on side s into pieces of up to n pixels along its length.
+func (s outpaintSide) segments(strip image.Rectangle, n int) []image.Rectangle {
+   var out []image.Rectangle
+   if s == sideLeft || s == sideRight {
+       for y := strip.Min.Y; y < strip.Max.Y; y += n {
+           out = append(out, image.Rect(strip.Min.X, y, strip.Max.X, min(y+n, strip.Max.Y)))
+       }
+       return out
+   }
+   for x := strip.Min.X; x < strip.Max.X; x += n {
+       out = append(out, image.Rect(x, strip.Min.Y, min(x+n, strip.Max.X), strip.Max.Y))
+   }
+   return out
+}
+
+// back grows r by n pixels along a strip on side s, towards the previous segment.
+func (s outpaintSide) back(r image.Rectangle, n int) image.Rectangle {
+   if s == sideLeft || s == sideRight {
+       r.Min.Y -= n
+   } else {
+       r.Min.X -= n
+   }
+   return r
+}
+
+// outpaintPadding returns the pixels to add on the left, right, top and bottom of a
+// w x h image to reach the requested size at the requested anchor.
+func outpaintPadding(req *outpaintRequest, w, h int) [4]int {
+   a := outpaintAnchors[req.Anchor]
+   if req.Anchor == "" {
+       a = outpaintAnchors["center"]
+   }
+   left := int(float64(req.Width-w) * a[0])
+   top := int(float64(req.Height-h) * a[1])
+   return [4]int{left, req.Width - w - left, top, req.Height - h - top}
+}
+
+// validateOutpaint checks an outpaint request, writing a 400 response if it is invalid.
+// The source size is checked once the image is loaded.
+func validateOutpaint(c *gin.Context, req *outpaintRequest) bool {
+   fail := func(msg string) bool {
+       c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+       return false
+   }
+   if req.ImageID == "" {
+       return fail("image_id is required")
+   }
+   if _, ok := outpaintAnchors[req.Anchor]; req.Anchor != "" && !ok {
+       anchors := make([]string, 0, len(outpaintAnchors))
+       for a := range outpaintAnchors {
+           anchors = append(anchors, a)
+       }
+       sort.Strings(anchors)
+       return fail(fmt.Sprintf("invalid anchor %q (one of %s)", req.Anchor, strings.Join(anchors, ", ")))
+   }
+   var p paramChecker
+   p.intRange("width", req.Width, 1, maxOutpaintSize)
+   p.intRange("height", req.Height, 1, maxOutpaintSize)
+   if req.MaxStep != 0 {
+       p.intRange("max_step", req.MaxStep, minOutpaintStep, maxOutpaintStep)
+   }
+   p.optionalIntRange("overlap", req.Overlap, 0, maxOutpaintOverlap)
+   p.optionalIntRange("inpainting_fill", req.InpaintingFill, 0, maxInpaintFill)
+   if p.err != nil {
+       return fail(p.err.Error())
+   }
+   // The canvas and mask are built by the backend; check the remaining parameters
+   params := req.Params
+   params.InitImages = []string{req.ImageID}
+   params.Width, params.Height = 0, 0
+   if err := checkImg2ImgParams(&params); err != nil {
+       return fail(err.Error())
+   }
+   return validateEditTarget(c, req.editTarget, false)
+}
+
+// runOutpaint extends src to the requested size. The padding is added in up to MaxStep
+// pixel increments per side; in each step every new strip is inpainted in segments of up
+// to twice MaxStep along its length. Each tile holds a segment, up to MaxStep pixels of
+// the existing image next to it and of the previous segment, so large canvases never go
+// to Forge in one piece; Overlap pixels of both are repainted to hide the seams.
+func runOutpaint(ctx context.Context, src *image.NRGBA, req *outpaintRequest) (*image.NRGBA, []string, *storage.PromptExpansion, error) {
+   params := req.Params
+   params.Prompt = req.Prompt
+   params.NegativePrompt = req.NegativePrompt
+   expansion, err := expandPrompts(&params.Prompt, &params.NegativePrompt, params.Seed, req.promptOptions)
+   if err != nil {
+       return nil, nil, nil, err
+   }
+   b := src.Bounds()
+   pad := outpaintPadding(req, b.Dx(), b.Dy())
+   step := req.MaxStep
+   if step == 0 {
+       step = defaultOutpaintStep
+   }
+   overlap := defaultOutpaintOverlap
+   if req.Overlap != nil {
+       overlap = *req.Overlap
+   }
+   steps := 1
+   for _, n := range pad {
+       steps = max(steps, (n+step-1)/step)
+   }
+   params.Width, params.Height = 0, 0
+   switch {
+   case req.InpaintingFill != nil:
+       params.InpaintingFill = *req.InpaintingFill
+   case params.InpaintingFill == 0:
+       params.InpaintingFill = defaultOutpaintFill
+   }
+   if params.DenoisingStrength == 0 {
+       params.DenoisingStrength = defaultOutpaintDenoise
+   }
+
+   current := src
+   var done [4]int
+   var infos []string
+   for k := 1; k <= steps; k++ {
+       var add [4]int
+       for i, n := range pad {
+           add[i] = n*k/steps - done[i]
+           done[i] += add[i]
+       }
+       current = imaging.Extend(current, add[sideLeft], add[sideTop], add[sideRight], add[sideBottom])
+       w, h := current.Bounds().Dx(), current.Bounds().Dy()
+       for _, s := range []outpaintSide{sideLeft, sideRight, sideTop, sideBottom} {
+           n := add[s]
+           if n == 0 {
+               continue
+           }
+           strip := s.strip(w, h, n)
+           for i, seg := range s.segments(strip, 2*step) {
+               tile, masked := s.inward(seg, step), s.inward(seg, overlap)
+               if i > 0 {
+                   tile, masked = s.back(tile, step), s.back(masked, overlap)
+               }
+               tile = tile.Intersect(current.Bounds())
+               mask := image.NewGray(image.Rect(0, 0, tile.Dx(), tile.Dy()))
+               draw.Draw(mask, masked.Intersect(tile).Sub(tile.Min), image.White, image.Point{}, draw.Src)
+               out, info, err := inpaint(ctx, imaging.Crop(current, tile), mask, params)
+               if err != nil {
+                   return nil, nil, nil, err
+               }
+               imaging.Paste(current, out, tile.Min)
+               infos = append(infos, info)
+           }
+       }
+   }
+   return current, infos, expansion, nil
+}
+
+// handleOutpaint extends a library image to a new size and aspect ratio via SD-Forge
+// img2img, painting the added area to match. The result is saved next to the source with
+// provenance unless the request asks to replace the source or save elsewhere.
+func handleOutpaint(c *gin.Context) {
+   var req outpaintRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if !validateOutpaint(c, &req) {
+       return
+   }
+   sub, err := resolveSubDir(req.Path)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   sources, err := loadSourceImages(sub, []string{req.ImageID}, nil)
+   if err != nil {
+       writeSourceError(c, err)
+       return
+   }
+   src, err := imaging.Decode(sources[0].data)
+   if err != nil {
+       c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported image format: " + err.Error()})
+       return
+   }
+   if b := src.Bounds(); req.Width < b.Dx() || req.Height < b.Dy() || (req.Width == b.Dx() && req.Height == b.Dy()) {
+       c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("target size must extend the %dx%d image", b.Dx(), b.Dy())})
+       return
+   }
+   edited, infos, expansion, err := runOutpaint(forgeContext(c), src, &req)
+   if err != nil {
+       writeGenerationError(c, err)
+       return
+   }
+   data, err := imaging.EncodePNG(edited)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   target := req.editTarget
+   if !target.Replace && target.SaveTo == nil {
+       target.SaveTo = &sub
+       target.Position = &ReorderRequest{PrevID: req.ImageID}
+   }
+   saved, err := storeEditedImages("outpaint", sub, target, req, sources, [][]byte{data}, strings.Join(infos, "\n"), expansion)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   c.JSON(http.StatusOK, EditResponse{Image: base64.StdEncoding.EncodeToString(data), Info: infos, Saved: saved})
+}
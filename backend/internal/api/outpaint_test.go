@@ -0,0 +1,179 @@
+package api_test
+
+import (
+   "context"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "image/color"
+   "net/http"
+   "os"
+   "testing"
+   "time"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+)
+
+func TestOutpaintExtendsInStepsAndSavesNextToSource(t *testing.T) {
+   root, srcHash := writeLibraryImage(t, "", color.RGBA{255, 0, 0, 255})
+   var tiles []int
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           mask := decodeB64PNG(t, req.Mask)
+           tiles = append(tiles, mask.Bounds().Dx())
+           if req.InpaintingFill != 2 || req.DenoisingStrength != 1 {
+               t.Errorf("expected latent noise fully denoised by default, got fill %d denoise %v", req.InpaintingFill, req.DenoisingStrength)
+           }
+           if req.Prompt != "meadow" || req.Width%8 != 0 || req.Height%8 != 0 {
+               t.Errorf("unexpected pass: %q %dx%d", req.Prompt, req.Width, req.Height)
+           }
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, req.Width, req.Height, color.RGBA{0, 255, 0, 255})}}, nil
+       },
+   })
+
+   body := map[string]interface{}{
+       "image_id": srcHash, "width": 134, "height": 4, "anchor": "left",
+       "max_step": 64, "overlap": 0, "prompt": "meadow",
+       "params": map[string]interface{}{"mask_blur": 0},
+   }
+   w := doJSON(t, http.MethodPost, "/api/v1/outpaint", body)
+   if w.Code != http.StatusOK {
+       t.Fatalf("outpaint: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   // 130 new pixels in steps of at most 64: 43, 43 and 44, each tile including up to 64 pixels of context
+   if len(tiles) != 3 || tiles[0] != 47 || tiles[1] != 90 || tiles[2] != 108 {
+       t.Errorf("unexpected tiles: %v", tiles)
+   }
+   var resp api.EditResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Saved) != 1 || len(resp.Info) != 3 {
+       t.Fatalf("unexpected response: %s", w.Body.String())
+   }
+   out := decodeB64PNG(t, resp.Image)
+   if out.Bounds().Dx() != 134 || out.Bounds().Dy() != 4 {
+       t.Fatalf("unexpected size %v", out.Bounds())
+   }
+   if r, g, _, _ := out.At(3, 2).RGBA(); r>>8 != 255 || g != 0 {
+       t.Errorf("source pixels changed")
+   }
+   if r, g, _, _ := out.At(133, 0).RGBA(); r != 0 || g>>8 != 255 {
+       t.Errorf("extension not painted")
+   }
+   prov, err := storage.LoadProvenance(root, resp.Saved[0].ID)
+   if err != nil || prov.Operation != "outpaint" || prov.SourceHash != srcHash {
+       t.Errorf("unexpected provenance %+v (%v)", prov, err)
+   }
+   if ts, err := time.Parse(time.RFC3339Nano, resp.Saved[0].Timestamp); err != nil || !ts.After(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+       t.Errorf("expected the result placed after the source, got %s", resp.Saved[0].Timestamp)
+   }
+}
+
+func TestOutpaintRejectsInvalidTargets(t *testing.T) {
+   _, srcHash := writeLibraryImage(t, "", color.RGBA{255, 0, 0, 255})
+   passes := 0
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           passes++
+           return &forgeclient.ImageResponse{}, nil
+       },
+   })
+
+   for _, bad := range []map[string]interface{}{
+       {"image_id": srcHash, "width": 2, "height": 8},
+       {"image_id": srcHash, "width": 4, "height": 4},
+       {"image_id": srcHash, "width": 8, "height": 8, "anchor": "middle"},
+       {"image_id": srcHash, "width": 8, "height": 8, "max_step": 8},
+       {"width": 8, "height": 8},
+   } {
+       if w := doJSON(t, http.MethodPost, "/api/v1/outpaint", bad); w.Code != http.StatusBadRequest {
+           t.Errorf("%v: expected 400, got %d", bad, w.Code)
+       }
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/outpaint", map[string]interface{}{"image_id": "0000", "width": 8, "height": 8}); w.Code != http.StatusNotFound {
+       t.Errorf("missing image: expected 404, got %d", w.Code)
+   }
+   if passes != 0 {
+       t.Errorf("invalid requests reached img2img %d times", passes)
+   }
+}
+
+func TestOutpaintSavesNothingWhenAPassFails(t *testing.T) {
+   root, srcHash := writeLibraryImage(t, "", color.RGBA{255, 0, 0, 255})
+   passes := 0
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           if passes++; passes == 2 {
+               return nil, &forgeclient.RequestError{Op: "img2img", Kind: forgeclient.ErrUnavailable, Err: errors.New("connection refused")}
+           }
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, req.Width, req.Height, color.RGBA{0, 255, 0, 255})}}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodPost, "/api/v1/outpaint", map[string]interface{}{"image_id": srcHash, "width": 134, "height": 4, "anchor": "left", "max_step": 64})
+
+   if w.Code != http.StatusServiceUnavailable {
+       t.Fatalf("expected 503, got %d: %s", w.Code, w.Body.String())
+   }
+   if entries, _ := os.ReadDir(root); len(entries) != 2 {
+       t.Errorf("expected only the source and its metadata, got %d entries", len(entries))
+   }
+}
+
+func TestOutpaintKeepsRequestedFillSettings(t *testing.T) {
+   _, srcHash := writeLibraryImage(t, "", color.RGBA{255, 0, 0, 255})
+   var got []string
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           got = append(got, fmt.Sprintf("%d/%v", req.InpaintingFill, req.DenoisingStrength))
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, req.Width, req.Height, color.RGBA{0, 255, 0, 255})}}, nil
+       },
+   })
+   for _, tc := range []struct {
+       body map[string]interface{}
+       want string
+   }{
+       {map[string]interface{}{"inpainting_fill": 0, "params": map[string]interface{}{"denoising_strength": 0.8}}, "0/0.8"},
+       {map[string]interface{}{"params": map[string]interface{}{"inpainting_fill": 1}}, "1/1"},
+   } {
+       got = nil
+       tc.body["image_id"], tc.body["width"], tc.body["height"] = srcHash, 8, 4
+       if w := doJSON(t, http.MethodPost, "/api/v1/outpaint", tc.body); w.Code != http.StatusOK {
+           t.Fatalf("outpaint: expected 200, got %d: %s", w.Code, w.Body.String())
+       }
+       if len(got) == 0 || got[0] != tc.want {
+           t.Errorf("%v: expected fill/denoise %s, got %v", tc.body, tc.want, got)
+       }
+   }
+}
+
+func TestOutpaintTilesLongStripsAlongTheirLength(t *testing.T) {
+   _, srcHash := writeLibraryImage(t, "", color.RGBA{255, 0, 0, 255})
+   var largest int
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           largest = max(largest, req.Width, req.Height)
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, req.Width, req.Height, color.RGBA{0, 255, 0, 255})}}, nil
+       },
+   })
+   w := doJSON(t, http.MethodPost, "/api/v1/outpaint", map[string]interface{}{
+       "image_id": srcHash, "width": 600, "height": 500, "anchor": "top-left", "max_step": 64,
+   })
+   if w.Code != http.StatusOK {
+       t.Fatalf("outpaint: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   // A tile holds at most 2*64 new pixels along the strip plus 64 of context on each axis
+   if largest == 0 || largest > 3*64 {
+       t.Errorf("expected tiles of at most 192 pixels, got %d", largest)
+   }
+   var resp api.EditResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+       t.Fatalf("unmarshal: %v", err)
+   }
+   out := decodeB64PNG(t, resp.Image)
+   for _, p := range [][2]int{{599, 0}, {0, 499}, {599, 499}, {300, 250}} {
+       if r, g, _, _ := out.At(p[0], p[1]).RGBA(); r != 0 || g>>8 != 255 {
+           t.Errorf("pixel %v not painted", p)
+       }
+   }
+}
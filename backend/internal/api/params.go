@@ -0,0 +1,186 @@
+package api
+
+import (
+   "bytes"
+   "fmt"
+   "net/http"
+   "path/filepath"
+
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+   "github.com/gin-gonic/gin"
+)
+
+// Limits for generation parameters. Zero always means "use the SD-Forge default".
+const (
+   maxSteps           = 150
+   maxCFGScale        = 30
+   minDimension       = 64
+   maxDimension       = 4096
+   maxBatchSize       = 8
+   maxBatchCount      = 100
+   maxHRScale         = 4
+   maxMaskBlur        = 64
+   maxInpaintPad      = 256
+   maxResizeMode      = 3
+   maxInpaintFill     = 3
+   maxNoiseMultiplier = 2
+)
+
+// paramChecker accumulates the first range violation found in a request.
+type paramChecker struct {
+   err error
+}
+
+func (p *paramChecker) intRange(name string, v, min, max int) {
+   if p.err == nil && (v < min || v > max) {
+       p.err = fmt.Errorf("%s must be between %d and %d", name, min, max)
+   }
+}
+
+func (p *paramChecker) floatRange(name string, v float32, min, max float32) {
+   if p.err == nil && (v < min || v > max) {
+       p.err = fmt.Errorf("%s must be between %g and %g", name, min, max)
+   }
+}
+
+// dimension checks an optional image size: 0 or a multiple of 8 within the allowed range.
+func (p *paramChecker) dimension(name string, v int) {
+   if p.err != nil || v == 0 {
+       return
+   }
+   if v < minDimension || v > maxDimension || v%8 != 0 {
+       p.err = fmt.Errorf("%s must be a multiple of 8 between %d and %d", name, minDimension, maxDimension)
+   }
+}
+
+// optionalIntRange checks a pointer field only when it is set.
+func (p *paramChecker) optionalIntRange(name string, v *int, min, max int) {
+   if v != nil {
+       p.intRange(name, *v, min, max)
+   }
+}
+
+// scripts checks that alwayson_scripts, when given, is a JSON object.
+func (p *paramChecker) scripts(raw []byte) {
+   raw = bytes.TrimSpace(raw)
+   if p.err == nil && len(raw) > 0 && !bytes.Equal(raw, []byte("null")) && raw[0] != '{' {
+       p.err = fmt.Errorf("alwayson_scripts must be a JSON object")
+   }
+}
+
+// checkTxt2ImgParams validates the ranges of a txt2img request.
+func checkTxt2ImgParams(r *forgeclient.Txt2ImgRequest) error {
+   var p paramChecker
+   p.intRange("steps", r.Steps, 0, maxSteps)
+   p.floatRange("cfg_scale", r.CFGScale, 0, maxCFGScale)
+   p.floatRange("distilled_cfg_scale", r.DistilledCFGScale, 0, maxCFGScale)
+   p.dimension("width", r.Width)
+   p.dimension("height", r.Height)
+   p.floatRange("subseed_strength", r.SubseedStrength, 0, 1)
+   p.intRange("seed_resize_from_w", r.SeedResizeFromW, 0, maxDimension)
+   p.intRange("seed_resize_from_h", r.SeedResizeFromH, 0, maxDimension)
+   p.intRange("batch_size", r.BatchSize, 0, maxBatchSize)
+   p.intRange("n_iter", r.NIter, 0, maxBatchCount)
+   p.floatRange("denoising_strength", r.DenoisingStrength, 0, 1)
+   p.floatRange("hr_scale", r.HRScale, 0, maxHRScale)
+   p.intRange("hr_second_pass_steps", r.HRSecondPassSteps, 0, maxSteps)
+   p.dimension("hr_resize_x", r.HRResizeX)
+   p.dimension("hr_resize_y", r.HRResizeY)
+   p.scripts(r.AlwaysonScripts)
+   return p.err
+}
+
+// checkImg2ImgParams validates the ranges of an img2img request.
+func checkImg2ImgParams(r *forgeclient.Img2ImgRequest) error {
+   var p paramChecker
+   if len(r.InitImages) == 0 {
+       return fmt.Errorf("init_images is required")
+   }
+   p.intRange("steps", r.Steps, 0, maxSteps)
+   p.floatRange("cfg_scale", r.CFGScale, 0, maxCFGScale)
+   p.floatRange("distilled_cfg_scale", r.DistilledCFGScale, 0, maxCFGScale)
+   p.floatRange("image_cfg_scale", r.ImageCFGScale, 0, maxCFGScale)
+   p.floatRange("denoising_strength", r.DenoisingStrength, 0, 1)
+   p.dimension("width", r.Width)
+   p.dimension("height", r.Height)
+   p.floatRange("subseed_strength", r.SubseedStrength, 0, 1)
+   p.intRange("seed_resize_from_w", r.SeedResizeFromW, 0, maxDimension)
+   p.intRange("seed_resize_from_h", r.SeedResizeFromH, 0, maxDimension)
+   p.intRange("batch_size", r.BatchSize, 0, maxBatchSize)
+   p.intRange("n_iter", r.NIter, 0, maxBatchCount)
+   p.intRange("resize_mode", r.ResizeMode, 0, maxResizeMode)
+   p.optionalIntRange("mask_blur", r.MaskBlur, 0, maxMaskBlur)
+   p.optionalIntRange("mask_blur_x", r.MaskBlurX, 0, maxMaskBlur)
+   p.optionalIntRange("mask_blur_y", r.MaskBlurY, 0, maxMaskBlur)
+   p.intRange("inpainting_fill", r.InpaintingFill, 0, maxInpaintFill)
+   p.intRange("inpaint_full_res_padding", r.InpaintFullResPadding, 0, maxInpaintPad)
+   p.intRange("inpainting_mask_invert", r.InpaintingMaskInvert, 0, 1)
+   p.floatRange("initial_noise_multiplier", r.InitialNoiseMultiplier, 0, maxNoiseMultiplier)
+   p.scripts(r.AlwaysonScripts)
+   return p.err
+}
+
+// validateTxt2Img checks a txt2img payload before any generation work is done,
+// writing a 400 response if it is invalid.
+func validateTxt2Img(c *gin.Context, req *txt2ImgRequest) bool {
+   if err := checkTxt2ImgParams(&req.Txt2ImgRequest); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   return validatePrompts(c, req.Prompt, req.NegativePrompt, req.promptOptions) &&
+       validateLoras(c, req.Loras) && validateSaveOptions(c, req.SaveOptions)
+}
+
+// validateImg2Img checks an img2img payload before any generation work is done,
+// writing a 400 response if it is invalid.
+func validateImg2Img(c *gin.Context, req *img2ImgRequest) bool {
+   params := req.Img2ImgRequest
+   if req.ImageID != "" && len(params.InitImages) == 0 {
+       // The init image is read from the library when the request runs
+       params.InitImages = []string{req.ImageID}
+   }
+   if err := checkImg2ImgParams(&params); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   if req.Replace && req.ImageID == "" {
+       c.JSON(http.StatusBadRequest, gin.H{"error": "replace requires image_id"})
+       return false
+   }
+   return validateLibraryInputs(c, req) && validatePrompts(c, req.Prompt, req.NegativePrompt, req.promptOptions) &&
+       validateLoras(c, req.Loras) && validateEditTarget(c, req.editTarget, false)
+}
+
+// validateLibraryInputs checks that the library image and mask referenced by an img2img
+// request exist, writing a 400 or 404 response otherwise.
+func validateLibraryInputs(c *gin.Context, req *img2ImgRequest) bool {
+   if req.ImageID == "" {
+       if req.MaskID != "" {
+           c.JSON(http.StatusBadRequest, gin.H{"error": "mask_id requires image_id"})
+           return false
+       }
+       return true
+   }
+   sub, err := resolveSubDir(req.Path)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err := findFilenameByHash(baseDir, req.ImageID)
+   if err != nil || filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return false
+   }
+   if req.MaskID != "" {
+       if _, err := storage.LoadMask(baseDir, req.ImageID, req.MaskID); err != nil {
+           writeMaskError(c, err)
+           return false
+       }
+   }
+   return true
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+   "bytes"
+   "context"
+   "encoding/base64"
+   "image"
+   _ "image/jpeg"
+   _ "image/png"
+   "net/http"
+   "sync"
+   "time"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/forgeclient"
+)
+
+// ProgressInterval is how often the backend polls SD-Forge for progress while stream subscribers exist.
+var ProgressInterval = time.Second
+
+// ProgressEvent is pushed to progress stream subscribers on every poll.
+type ProgressEvent struct {
+   JobID         string                     `json:"job_id,omitempty"`
+   Progress      float32                    `json:"progress"`
+   ETA           float32                    `json:"eta"`
+   State         *forgeclient.ProgressState `json:"state,omitempty"`
+   Preview       string                     `json:"preview,omitempty"`
+   PreviewWidth  int                        `json:"preview_width,omitempty"`
+   PreviewHeight int                        `json:"preview_height,omitempty"`
+   Error         string                     `json:"error,omitempty"`
+   Time          string                     `json:"time"`
+}
+
+var (
+   progressMu     sync.Mutex
+   progressSubs   = make(map[chan ProgressEvent]struct{})
+   progressCancel context.CancelFunc
+)
+
+// subscribeProgress registers a subscriber, starting the shared poller if it is the first.
+func subscribeProgress() chan ProgressEvent {
+   ch := make(chan ProgressEvent, 1)
+   progressMu.Lock()
+   defer progressMu.Unlock()
+   progressSubs[ch] = struct{}{}
+   if progressCancel == nil {
+       ctx, cancel := context.WithCancel(context.Background())
+       progressCancel = cancel
+       go pollProgress(ctx)
+   }
+   return ch
+}
+
+// unsubscribeProgress removes a subscriber, stopping the poller after the last one leaves.
+func unsubscribeProgress(ch chan ProgressEvent) {
+   progressMu.Lock()
+   defer progressMu.Unlock()
+   delete(progressSubs, ch)
+   if len(progressSubs) == 0 && progressCancel != nil {
+       progressCancel()
+       progressCancel = nil
+   }
+}
+
+// pollProgress queries ForgeSvc once per interval and fans the result out to all subscribers.
+func pollProgress(ctx context.Context) {
+   ticker := time.NewTicker(ProgressInterval)
+   defer ticker.Stop()
+   for {
+       publishProgress(buildProgressEvent(ctx))
+       select {
+       case <-ctx.Done():
+           return
+       case <-ticker.C:
+       }
+   }
+}
+
+// buildProgressEvent polls SD-Forge once, for the running job if any, and converts the
+// response to an event.
+func buildProgressEvent(ctx context.Context) ProgressEvent {
+   ev := ProgressEvent{Time: time.Now().UTC().Format(time.RFC3339Nano)}
+   if JobQueue != nil {
+       ev.JobID = JobQueue.Running()
+   }
+   if ev.JobID != "" {
+       ctx = forgeclient.WithRequestID(ctx, ev.JobID)
+   }
+   resp, err := ForgeSvc.Progress(ctx, false)
+   if err != nil {
+       ev.Error = err.Error()
+       return ev
+   }
+   if resp == nil {
+       return ev
+   }
+   ev.Progress = resp.Progress
+   ev.ETA = resp.ETA
+   ev.State = resp.State
+   if resp.CurrentImage != "" {
+       if data, err := decodeBase64Image(resp.CurrentImage); err == nil {
+           ev.Preview = "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+           if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+               ev.PreviewWidth = cfg.Width
+               ev.PreviewHeight = cfg.Height
+           }
+       }
+   }
+   return ev
+}
+
+// publishProgress delivers an event to every subscriber, replacing any event they have not read yet.
+func publishProgress(ev ProgressEvent) {
+   progressMu.Lock()
+   defer progressMu.Unlock()
+   for ch := range progressSubs {
+       select {
+       case <-ch:
+       default:
+       }
+       select {
+       case ch <- ev:
+       default:
+       }
+   }
+}
+
+// handleProgressStream streams generation progress as server-sent "progress" events.
+// With ?job=<id>, only events while that job is running are sent.
+func handleProgressStream(c *gin.Context) {
+   jobID := c.Query("job")
+   c.Writer.Header().Set("Cache-Control", "no-cache")
+   c.Writer.Header().Set("Connection", "keep-alive")
+   c.Writer.Header().Set("Content-Type", "text/event-stream")
+   c.Writer.WriteHeader(http.StatusOK)
+   c.Writer.Flush()
+   ch := subscribeProgress()
+   defer unsubscribeProgress(ch)
+   for {
+       select {
+       case <-c.Request.Context().Done():
+           return
+       case ev := <-ch:
+           if jobID != "" && ev.JobID != jobID {
+               continue
+           }
+           c.SSEvent("progress", ev)
+           c.Writer.Flush()
+       }
+   }
+}
@@ -0,0 +1,61 @@
+package api_test
+
+import (
+   "bufio"
+   "context"
+   "encoding/json"
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "testing"
+   "time"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+)
+
+func TestProgressStreamFansOutPreview(t *testing.T) {
+   api.ProgressInterval = 10 * time.Millisecond
+   api.SetForgeClient(&forgeclient.MockClient{
+       ProgressFunc: func(ctx context.Context, skip bool) (*forgeclient.ProgressResponse, error) {
+           return &forgeclient.ProgressResponse{
+               CurrentImage: testPNGBase64(t, 128),
+               Progress:     0.25,
+               ETA:          3,
+               State:        &forgeclient.ProgressState{SamplingStep: 5, SamplingSteps: 20},
+           }, nil
+       },
+   })
+   srv := httptest.NewServer(api.SetupRouter())
+   defer srv.Close()
+
+   ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+   defer cancel()
+   req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/progress/stream", nil)
+   resp, err := http.DefaultClient.Do(req)
+   if err != nil {
+       t.Fatalf("open stream: %v", err)
+   }
+   defer resp.Body.Close()
+
+   scanner := bufio.NewScanner(resp.Body)
+   scanner.Buffer(make([]byte, 64*1024), 1<<20)
+   for scanner.Scan() {
+       line := scanner.Text()
+       if !strings.HasPrefix(line, "data:") {
+           continue
+       }
+       var ev api.ProgressEvent
+       if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &ev); err != nil {
+           t.Fatalf("unmarshal event: %v", err)
+       }
+       if ev.Progress != 0.25 || ev.State == nil || ev.State.SamplingStep != 5 {
+           t.Errorf("unexpected event: %+v", ev)
+       }
+       if !strings.HasPrefix(ev.Preview, "data:image/png;base64,") || ev.PreviewWidth != 2 {
+           t.Errorf("unexpected preview: %.40s (%dx%d)", ev.Preview, ev.PreviewWidth, ev.PreviewHeight)
+       }
+       return
+   }
+   t.Fatalf("no progress event received: %v", scanner.Err())
+}
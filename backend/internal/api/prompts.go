@@ -0,0 +1,407 @@
+package api
+
+import (
+   "errors"
+   "fmt"
+   "math/rand"
+   "net/http"
+   "strings"
+
+   "github.com/gin-gonic/gin"
+   "image-processor-backend/internal/prompts"
+   "image-processor-backend/internal/storage"
+)
+
+// promptOptions selects a prompt preset, saved negative prompts and template variables
+// for a generation; see expandPrompts.
+type promptOptions struct {
+   Preset          string            `json:"preset,omitempty"`
+   NegativePresets []string          `json:"negative_presets,omitempty"`
+   Variables       map[string]string `json:"variables,omitempty"`
+}
+
+// promptError marks a prompt that could not be expanded, a client error.
+type promptError struct {
+   err error
+}
+
+func (e *promptError) Error() string { return e.err.Error() }
+func (e *promptError) Unwrap() error { return e.err }
+
+// needsExpansion reports whether a prompt may contain template syntax.
+func needsExpansion(s string) bool {
+   return strings.ContainsAny(s, `{}\`) || strings.Contains(s, "__")
+}
+
+// expandPrompts expands the prompt and negative prompt of a generation in place. With a
+// preset, its prompt becomes the template, with {prompt} standing for the request's
+// prompt (appended when the preset does not use it), and its negative prompt is added to
+// the request's; saved negative prompts are added after that. Variables override the
+// preset's defaults. Random picks follow seed unless it is nil or negative (random).
+// Returns nil when there was nothing to expand.
+func expandPrompts(prompt, negative *string, seed *int, opts promptOptions) (*storage.PromptExpansion, error) {
+   if opts.Preset == "" && len(opts.NegativePresets) == 0 && len(opts.Variables) == 0 &&
+       !needsExpansion(*prompt) && !needsExpansion(*negative) {
+       return nil, nil
+   }
+   exp := &storage.PromptExpansion{
+       Preset:                 opts.Preset,
+       NegativePresets:        opts.NegativePresets,
+       PromptTemplate:         *prompt,
+       NegativePromptTemplate: *negative,
+       Variables:              map[string]string{},
+   }
+   if opts.Preset != "" {
+       preset, err := storage.LoadPromptPreset(ImageDir, opts.Preset)
+       if err != nil {
+           return nil, promptStoreError("prompt preset", opts.Preset, err)
+       }
+       for k, v := range preset.Variables {
+           exp.Variables[k] = v
+       }
+       exp.Variables["prompt"] = *prompt
+       exp.PromptTemplate = preset.Prompt
+       if !contains(prompts.Variables(preset.Prompt), "prompt") {
+           exp.PromptTemplate = joinPrompts(*prompt, preset.Prompt)
+       }
+       exp.NegativePromptTemplate = joinPrompts(*negative, preset.NegativePrompt)
+   }
+   for _, id := range opts.NegativePresets {
+       n, err := storage.LoadNegativePrompt(ImageDir, id)
+       if err != nil {
+           return nil, promptStoreError("negative prompt", id, err)
+       }
+       exp.NegativePromptTemplate = joinPrompts(exp.NegativePromptTemplate, n.Text)
+   }
+   for k, v := range opts.Variables {
+       exp.Variables[k] = v
+   }
+   if seed != nil && *seed >= 0 {
+       exp.Seed = int64(*seed)
+   } else {
+       exp.Seed = rand.Int63()
+   }
+   e := prompts.NewExpander(exp.Variables, loadWildcardEntries, exp.Seed)
+   var err error
+   if exp.Prompt, err = e.Expand(exp.PromptTemplate); err != nil {
+       return nil, &promptError{fmt.Errorf("prompt: %w", err)}
+   }
+   if exp.NegativePrompt, err = e.Expand(exp.NegativePromptTemplate); err != nil {
+       return nil, &promptError{fmt.Errorf("negative prompt: %w", err)}
+   }
+   if len(exp.Variables) == 0 {
+       exp.Variables = nil
+   }
+   *prompt, *negative = exp.Prompt, exp.NegativePrompt
+   return exp, nil
+}
+
+// promptStoreError describes a failure to load a stored preset, negative prompt or wildcard.
+func promptStoreError(kind, id string, err error) error {
+   if errors.Is(err, storage.ErrPromptNotFound) {
+       return &promptError{fmt.Errorf("%s %q not found", kind, id)}
+   }
+   return fmt.Errorf("load %s %q: %w", kind, id, err)
+}
+
+// loadWildcardEntries returns the entries of a stored wildcard for prompt expansion.
+func loadWildcardEntries(name string) ([]string, error) {
+   w, err := storage.LoadWildcard(ImageDir, name)
+   if err != nil {
+       if errors.Is(err, storage.ErrPromptNotFound) {
+           return nil, errors.New("not found")
+       }
+       return nil, err
+   }
+   return w.Entries, nil
+}
+
+// validatePrompts checks that a generation's prompts expand, writing a 400 response if not.
+func validatePrompts(c *gin.Context, prompt, negative string, opts promptOptions) bool {
+   if _, err := expandPrompts(&prompt, &negative, nil, opts); err != nil {
+       writeGenerationError(c, err)
+       return false
+   }
+   return true
+}
+
+// checkTemplates checks the syntax of templates, writing a 400 response if one is invalid.
+func checkTemplates(c *gin.Context, templates ...string) bool {
+   for _, t := range templates {
+       if err := prompts.Check(t); err != nil {
+           c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+           return false
+       }
+   }
+   return true
+}
+
+// writePromptStoreError maps a storage error for a prompt resource to 404 or 500.
+func writePromptStoreError(c *gin.Context, kind string, err error) {
+   if errors.Is(err, storage.ErrPromptNotFound) {
+       c.JSON(http.StatusNotFound, gin.H{"error": kind + " not found"})
+       return
+   }
+   c.JSON(http.StatusInternalServerError, gin.H{"error": "could not access " + kind})
+}
+
+// promptPresetRequest is the JSON payload for creating or updating a prompt preset.
+type promptPresetRequest struct {
+   Name           string            `json:"name" binding:"required"`
+   Description    string            `json:"description"`
+   Prompt         string            `json:"prompt"`
+   NegativePrompt string            `json:"negative_prompt"`
+   Variables      map[string]string `json:"variables"`
+}
+
+// bindPromptPreset reads and checks a preset payload into p, writing a 400 response on failure.
+func bindPromptPreset(c *gin.Context, p *storage.PromptPreset) bool {
+   var req promptPresetRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   templates := []string{req.Prompt, req.NegativePrompt}
+   for name, v := range req.Variables {
+       if !prompts.ValidName(name) {
+           c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid variable name %q", name)})
+           return false
+       }
+       templates = append(templates, v)
+   }
+   if !checkTemplates(c, templates...) {
+       return false
+   }
+   p.Name, p.Description, p.Prompt, p.NegativePrompt, p.Variables = req.Name, req.Description, req.Prompt, req.NegativePrompt, req.Variables
+   return true
+}
+
+// handleListPromptPresets returns all prompt presets.
+func handleListPromptPresets(c *gin.Context) {
+   presets, err := storage.ListPromptPresets(ImageDir)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list prompt presets"})
+       return
+   }
+   c.JSON(http.StatusOK, presets)
+}
+
+// handleCreatePromptPreset saves a new prompt preset.
+func handleCreatePromptPreset(c *gin.Context) {
+   p := &storage.PromptPreset{ID: storage.NewPromptID()}
+   if !bindPromptPreset(c, p) {
+       return
+   }
+   if err := storage.SavePromptPreset(ImageDir, p); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save prompt preset"})
+       return
+   }
+   c.JSON(http.StatusCreated, p)
+}
+
+// handleGetPromptPreset returns a single prompt preset.
+func handleGetPromptPreset(c *gin.Context) {
+   p, err := storage.LoadPromptPreset(ImageDir, c.Param("id"))
+   if err != nil {
+       writePromptStoreError(c, "prompt preset", err)
+       return
+   }
+   c.JSON(http.StatusOK, p)
+}
+
+// handleUpdatePromptPreset replaces a prompt preset.
+func handleUpdatePromptPreset(c *gin.Context) {
+   p, err := storage.LoadPromptPreset(ImageDir, c.Param("id"))
+   if err != nil {
+       writePromptStoreError(c, "prompt preset", err)
+       return
+   }
+   if !bindPromptPreset(c, p) {
+       return
+   }
+   if err := storage.SavePromptPreset(ImageDir, p); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save prompt preset"})
+       return
+   }
+   c.JSON(http.StatusOK, p)
+}
+
+// handleDeletePromptPreset removes a prompt preset.
+func handleDeletePromptPreset(c *gin.Context) {
+   if err := storage.DeletePromptPreset(ImageDir, c.Param("id")); err != nil {
+       writePromptStoreError(c, "prompt preset", err)
+       return
+   }
+   c.Status(http.StatusNoContent)
+}
+
+// negativePromptRequest is the JSON payload for creating or updating a saved negative prompt.
+type negativePromptRequest struct {
+   Name string `json:"name" binding:"required"`
+   Text string `json:"text" binding:"required"`
+}
+
+// bindNegativePrompt reads and checks a negative prompt payload into n, writing a 400
+// response on failure.
+func bindNegativePrompt(c *gin.Context, n *storage.NegativePrompt) bool {
+   var req negativePromptRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return false
+   }
+   if !checkTemplates(c, req.Text) {
+       return false
+   }
+   n.Name, n.Text = req.Name, req.Text
+   return true
+}
+
+// handleListNegativePrompts returns all saved negative prompts.
+func handleListNegativePrompts(c *gin.Context) {
+   negs, err := storage.ListNegativePrompts(ImageDir)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list negative prompts"})
+       return
+   }
+   c.JSON(http.StatusOK, negs)
+}
+
+// handleCreateNegativePrompt saves a new negative prompt.
+func handleCreateNegativePrompt(c *gin.Context) {
+   n := &storage.NegativePrompt{ID: storage.NewPromptID()}
+   if !bindNegativePrompt(c, n) {
+       return
+   }
+   if err := storage.SaveNegativePrompt(ImageDir, n); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save negative prompt"})
+       return
+   }
+   c.JSON(http.StatusCreated, n)
+}
+
+// handleGetNegativePrompt returns a single saved negative prompt.
+func handleGetNegativePrompt(c *gin.Context) {
+   n, err := storage.LoadNegativePrompt(ImageDir, c.Param("id"))
+   if err != nil {
+       writePromptStoreError(c, "negative prompt", err)
+       return
+   }
+   c.JSON(http.StatusOK, n)
+}
+
+// handleUpdateNegativePrompt replaces a saved negative prompt.
+func handleUpdateNegativePrompt(c *gin.Context) {
+   n, err := storage.LoadNegativePrompt(ImageDir, c.Param("id"))
+   if err != nil {
+       writePromptStoreError(c, "negative prompt", err)
+       return
+   }
+   if !bindNegativePrompt(c, n) {
+       return
+   }
+   if err := storage.SaveNegativePrompt(ImageDir, n); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save negative prompt"})
+       return
+   }
+   c.JSON(http.StatusOK, n)
+}
+
+// handleDeleteNegativePrompt removes a saved negative prompt.
+func handleDeleteNegativePrompt(c *gin.Context) {
+   if err := storage.DeleteNegativePrompt(ImageDir, c.Param("id")); err != nil {
+       writePromptStoreError(c, "negative prompt", err)
+       return
+   }
+   c.Status(http.StatusNoContent)
+}
+
+// handleListWildcards returns all wildcards with their entries.
+func handleListWildcards(c *gin.Context) {
+   names, err := storage.ListWildcards(ImageDir)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list wildcards"})
+       return
+   }
+   out := []storage.Wildcard{}
+   for _, name := range names {
+       if w, err := storage.LoadWildcard(ImageDir, name); err == nil {
+           out = append(out, *w)
+       }
+   }
+   c.JSON(http.StatusOK, out)
+}
+
+// handleGetWildcard returns the entries of a wildcard.
+func handleGetWildcard(c *gin.Context) {
+   w, err := storage.LoadWildcard(ImageDir, c.Param("name"))
+   if err != nil {
+       writePromptStoreError(c, "wildcard", err)
+       return
+   }
+   c.JSON(http.StatusOK, w)
+}
+
+// handlePutWildcard creates or replaces a wildcard. Entries spanning several lines are
+// split into one entry per line, as they are stored one per line.
+func handlePutWildcard(c *gin.Context) {
+   name := c.Param("name")
+   if !prompts.ValidName(name) {
+       c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wildcard name"})
+       return
+   }
+   var req struct {
+       Entries []string `json:"entries" binding:"required"`
+   }
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   w := &storage.Wildcard{Name: name, Entries: []string{}}
+   for _, entry := range req.Entries {
+       for _, line := range strings.Split(entry, "\n") {
+           if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+               w.Entries = append(w.Entries, line)
+           }
+       }
+   }
+   if !checkTemplates(c, w.Entries...) {
+       return
+   }
+   if err := storage.SaveWildcard(ImageDir, w); err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save wildcard"})
+       return
+   }
+   c.JSON(http.StatusOK, w)
+}
+
+// handleDeleteWildcard removes a wildcard.
+func handleDeleteWildcard(c *gin.Context) {
+   if err := storage.DeleteWildcard(ImageDir, c.Param("name")); err != nil {
+       writePromptStoreError(c, "wildcard", err)
+       return
+   }
+   c.Status(http.StatusNoContent)
+}
+
+// handleExpandPrompt previews the expansion of a generation's prompts without generating.
+func handleExpandPrompt(c *gin.Context) {
+   var req struct {
+       Prompt         string `json:"prompt"`
+       NegativePrompt string `json:"negative_prompt"`
+       Seed           *int   `json:"seed"`
+       promptOptions
+   }
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   exp, err := expandPrompts(&req.Prompt, &req.NegativePrompt, req.Seed, req.promptOptions)
+   if err != nil {
+       writeGenerationError(c, err)
+       return
+   }
+   if exp == nil {
+       exp = &storage.PromptExpansion{PromptTemplate: req.Prompt, NegativePromptTemplate: req.NegativePrompt, Prompt: req.Prompt, NegativePrompt: req.NegativePrompt}
+   }
+   c.JSON(http.StatusOK, exp)
+}
@@ -0,0 +1,203 @@
+package api_test
+
+import (
+   "context"
+   "encoding/json"
+   "image/color"
+   "net/http"
+   "strings"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+)
+
+func TestPromptPresetsExpandBeforeGeneration(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   var sent *forgeclient.Txt2ImgRequest
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           sent = req
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 0)}, Info: `{"seed": 7}`}, nil
+       },
+   })
+
+   if w := doJSON(t, http.MethodPut, "/api/v1/prompts/wildcards/hair_color", map[string][]string{"entries": {"red\n# comment", "", "blonde"}}); w.Code != http.StatusOK {
+       t.Fatalf("put wildcard: status %d: %s", w.Code, w.Body.String())
+   }
+   w := doJSON(t, http.MethodPost, "/api/v1/prompts/presets", map[string]interface{}{
+       "name":            "Portrait",
+       "prompt":          "portrait of {prompt}, __hair_color__ hair, {lighting}",
+       "negative_prompt": "blurry",
+       "variables":       map[string]string{"lighting": "soft light"},
+   })
+   if w.Code != http.StatusCreated {
+       t.Fatalf("create preset: status %d: %s", w.Code, w.Body.String())
+   }
+   var preset storage.PromptPreset
+   json.Unmarshal(w.Body.Bytes(), &preset)
+   w = doJSON(t, http.MethodPost, "/api/v1/prompts/negatives", map[string]string{"name": "Hands", "text": "{extra|missing} fingers"})
+   if w.Code != http.StatusCreated {
+       t.Fatalf("create negative prompt: status %d: %s", w.Code, w.Body.String())
+   }
+   var neg storage.NegativePrompt
+   json.Unmarshal(w.Body.Bytes(), &neg)
+
+   w = doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{
+       "prompt":           "a {knight|wizard}",
+       "negative_prompt":  "lowres",
+       "seed":             5,
+       "preset":           preset.ID,
+       "negative_presets": []string{neg.ID},
+       "variables":        map[string]string{"lighting": "rim light"},
+       "save_to":          "",
+   })
+   if w.Code != http.StatusOK {
+       t.Fatalf("txt2img: status %d: %s", w.Code, w.Body.String())
+   }
+   if !strings.HasPrefix(sent.Prompt, "portrait of a ") || !strings.HasSuffix(sent.Prompt, " hair, rim light") || strings.ContainsAny(sent.Prompt, "{}_") {
+       t.Errorf("unexpected prompt %q", sent.Prompt)
+   }
+   if !strings.HasPrefix(sent.NegativePrompt, "lowres, blurry, ") || !strings.HasSuffix(sent.NegativePrompt, " fingers") {
+       t.Errorf("unexpected negative prompt %q", sent.NegativePrompt)
+   }
+   var resp api.GenerationResponse
+   json.Unmarshal(w.Body.Bytes(), &resp)
+   w = doJSON(t, http.MethodGet, "/api/images/"+resp.Saved[0].ID+"/provenance", nil)
+   var prov storage.Provenance
+   if err := json.Unmarshal(w.Body.Bytes(), &prov); err != nil || prov.PromptExpansion == nil {
+       t.Fatalf("expected a prompt expansion in the provenance: %s", w.Body.String())
+   }
+   if exp := prov.PromptExpansion; exp.Prompt != sent.Prompt || exp.Seed != 5 || exp.Preset != preset.ID || exp.Variables["prompt"] != "a {knight|wizard}" {
+       t.Errorf("unexpected prompt expansion %+v", exp)
+   }
+
+   // The preview gives the same expansion for the same seed
+   w = doJSON(t, http.MethodPost, "/api/v1/prompts/expand", map[string]interface{}{
+       "prompt": "a {knight|wizard}", "negative_prompt": "lowres", "seed": 5, "preset": preset.ID,
+       "negative_presets": []string{neg.ID}, "variables": map[string]string{"lighting": "rim light"},
+   })
+   var preview storage.PromptExpansion
+   if json.Unmarshal(w.Body.Bytes(), &preview); preview.Prompt != sent.Prompt || preview.NegativePrompt != sent.NegativePrompt {
+       t.Errorf("preview differs from the generation: %+v", preview)
+   }
+}
+
+func TestPlainPromptsAreSentUnchanged(t *testing.T) {
+   api.SetImageDir(t.TempDir())
+   var sent string
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           sent = req.Prompt
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 0)}}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodPost, "/api/v1/txt2img", map[string]interface{}{"prompt": "(a cat:1.2), snake_case"})
+
+   if w.Code != http.StatusOK || sent != "(a cat:1.2), snake_case" {
+       t.Errorf("plain prompt changed: %d %q", w.Code, sent)
+   }
+}
+
+func TestPromptTemplatesThatDoNotExpandAreRejected(t *testing.T) {
+   api.SetImageDir(t.TempDir())
+   generated := false
+   api.SetForgeClient(&forgeclient.MockClient{
+       Txt2ImgFunc: func(ctx context.Context, req *forgeclient.Txt2ImgRequest) (*forgeclient.ImageResponse, error) {
+           generated = true
+           return &forgeclient.ImageResponse{Images: []string{testPNGBase64(t, 0)}}, nil
+       },
+   })
+
+   for _, body := range []map[string]interface{}{
+       {"prompt": "{undefined}"},
+       {"prompt": "__unknown__"},
+       {"prompt": "x", "preset": "missing"},
+   } {
+       if w := doJSON(t, http.MethodPost, "/api/v1/txt2img", body); w.Code != http.StatusBadRequest {
+           t.Errorf("%v: expected 400, got %d", body, w.Code)
+       }
+   }
+   if generated {
+       t.Errorf("a template that does not expand reached txt2img")
+   }
+   if w := doJSON(t, http.MethodPost, "/api/v1/prompts/presets", map[string]string{"name": "Bad", "prompt": "{a|b"}); w.Code != http.StatusBadRequest {
+       t.Errorf("expected 400 for a malformed template, got %d", w.Code)
+   }
+}
+
+func TestPromptLibraryListsAndDeletes(t *testing.T) {
+   api.SetImageDir(t.TempDir())
+   if w := doJSON(t, http.MethodPut, "/api/v1/prompts/wildcards/hair_color", map[string][]string{"entries": {"red\n# comment", "", "blonde"}}); w.Code != http.StatusOK {
+       t.Fatalf("put wildcard: status %d: %s", w.Code, w.Body.String())
+   }
+   var preset storage.PromptPreset
+   w := doJSON(t, http.MethodPost, "/api/v1/prompts/presets", map[string]string{"name": "Portrait", "prompt": "portrait of {prompt}"})
+   if err := json.Unmarshal(w.Body.Bytes(), &preset); err != nil || w.Code != http.StatusCreated {
+       t.Fatalf("create preset: status %d: %s", w.Code, w.Body.String())
+   }
+   var neg storage.NegativePrompt
+   w = doJSON(t, http.MethodPost, "/api/v1/prompts/negatives", map[string]string{"name": "Hands", "text": "extra fingers"})
+   if err := json.Unmarshal(w.Body.Bytes(), &neg); err != nil || w.Code != http.StatusCreated {
+       t.Fatalf("create negative prompt: status %d: %s", w.Code, w.Body.String())
+   }
+
+   w = doJSON(t, http.MethodGet, "/api/v1/prompts/wildcards", nil)
+   var wildcards []storage.Wildcard
+   if json.Unmarshal(w.Body.Bytes(), &wildcards); len(wildcards) != 1 || strings.Join(wildcards[0].Entries, ",") != "red,blonde" {
+       t.Errorf("unexpected wildcards: %s", w.Body.String())
+   }
+   if w := doJSON(t, http.MethodGet, "/api/images", nil); strings.Contains(w.Body.String(), "prompts") {
+       t.Errorf("prompt storage listed as images: %s", w.Body.String())
+   }
+   for _, target := range []string{"/api/v1/prompts/presets/" + preset.ID, "/api/v1/prompts/negatives/" + neg.ID, "/api/v1/prompts/wildcards/hair_color"} {
+       if w := doJSON(t, http.MethodDelete, target, nil); w.Code != http.StatusNoContent {
+           t.Errorf("delete %s: status %d", target, w.Code)
+       }
+       if w := doJSON(t, http.MethodGet, target, nil); w.Code != http.StatusNotFound {
+           t.Errorf("get %s after delete: status %d", target, w.Code)
+       }
+   }
+}
+
+func TestEditPromptsExpandBeforeInpainting(t *testing.T) {
+   _, srcHash := writeLibraryImage(t, "", color.Black)
+   var sent []string
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           sent = append(sent, req.Prompt)
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, req.Width, req.Height, color.White)}}, nil
+       },
+   })
+   rect := map[string]interface{}{"type": "rect", "x": 0, "y": 0, "width": 0.5, "height": 1}
+   for _, tc := range []struct {
+       url  string
+       body map[string]interface{}
+       want string
+   }{
+       {"/api/v1/regions", map[string]interface{}{
+           "prompt": "{style}", "regions": []map[string]interface{}{{"prompt": "{subject}", "shape": rect}},
+       }, "oil painting, a castle"},
+       {"/api/v1/outpaint", map[string]interface{}{"prompt": "{style}, {subject}", "width": 8, "height": 4}, "oil painting, a castle"},
+   } {
+       sent = nil
+       tc.body["image_id"], tc.body["save_to"] = srcHash, "edits"
+       tc.body["variables"] = map[string]string{"style": "oil painting", "subject": "a castle"}
+       w := doJSON(t, http.MethodPost, tc.url, tc.body)
+       var resp api.EditResponse
+       if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || len(resp.Saved) != 1 {
+           t.Fatalf("%s: %d %s", tc.url, w.Code, w.Body.String())
+       }
+       if len(sent) == 0 || sent[0] != tc.want {
+           t.Errorf("%s: expected the prompt %q, got %q", tc.url, tc.want, sent)
+       }
+       w = doJSON(t, http.MethodGet, "/api/images/"+resp.Saved[0].ID+"/provenance?path=edits", nil)
+       var prov storage.Provenance
+       if err := json.Unmarshal(w.Body.Bytes(), &prov); err != nil || prov.PromptExpansion == nil || prov.PromptExpansion.Variables["style"] != "oil painting" {
+           t.Errorf("%s: expected the expansion in the provenance: %s", tc.url, w.Body.String())
+       }
+   }
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+   "context"
+   "encoding/base64"
+   "fmt"
+   "image"
+   "net/http"
+   "strings"
+
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/imaging"
+   "image-processor-backend/internal/storage"
+   "github.com/gin-gonic/gin"
+)
+
+// Region editing modes: one img2img pass per region, or a single pass over all regions.
+const (
+   regionsSequential = "sequential"
+   regionsCombined   = "combined"
+)
+
+// defaultMaskBlur matches SD-Forge's default mask blur, used to feather the composite.
+const defaultMaskBlur = 4
+
+// Region is an area of an image to repaint with its own prompt.
+type Region struct {
+   Shape             imaging.Shape `json:"shape"`
+   Prompt            string        `json:"prompt"`
+   NegativePrompt    string        `json:"negative_prompt,omitempty"`
+   DenoisingStrength float32       `json:"denoising_strength,omitempty"`
+}
+
+// regionsRequest is the /api/v1/regions payload. Prompt and NegativePrompt are prepended
+// to every region's prompts; Params holds the img2img settings shared by all passes
+// (its images, mask and prompts are filled in by the backend). The shared prompts are
+// expanded like those of a generation (see expandPrompts); region prompts may use
+// templates and the request's variables too.
+type regionsRequest struct {
+   ImageID        string                     `json:"image_id"`
+   Path           string                     `json:"path,omitempty"`
+   Regions        []Region                   `json:"regions"`
+   Mode           string                     `json:"mode,omitempty"`
+   Prompt         string                     `json:"prompt,omitempty"`
+   NegativePrompt string                     `json:"negative_prompt,omitempty"`
+   Params         forgeclient.Img2ImgRequest `json:"params"`
+   editTarget
+   promptOptions
+}
+
+// EditResponse is the result of a multi-pass edit (regions, outpainting): the edited image
+// as base64 PNG, the Forge info of each pass, and any library images saved or replaced.
+type EditResponse struct {
+   Image string          `json:"image"`
+   Info  []string        `json:"info"`
+   Saved []ImageResponse `json:"saved,omitempty"`
+}
+
+// joinPrompts joins non-empty prompt parts with commas.
+func joinPrompts(parts ...string) string {
+   var out []string
+   for _, p := range parts {
+       if p = strings.TrimSpace(p); p != "" {
+           out = append(out, p)
+       }
+   }
+   return strings.Join(out, ", ")
+}
+
+// generationDims returns the img2img size for a w x h source: the same aspect ratio,
+// scaled down to fit maxDimension and rounded to multiples of 8.
+func generationDims(w, h int) (int, int) {
+   if longest := max(w, h); longest > maxDimension {
+       w = w * maxDimension / longest
+       h = h * maxDimension / longest
+   }
+   return min(imaging.GenerationSize(w), maxDimension), min(imaging.GenerationSize(h), maxDimension)
+}
+
+// inpaint runs one img2img pass over base restricted to mask and blends the result back,
+// so pixels outside the feathered mask keep their original values. Returns the blended
+// image and the Forge info string.
+func inpaint(ctx context.Context, base *image.NRGBA, mask *image.Gray, params forgeclient.Img2ImgRequest) (*image.NRGBA, string, error) {
+   b := base.Bounds()
+   initPNG, err := imaging.EncodePNG(base)
+   if err != nil {
+       return nil, "", err
+   }
+   maskPNG, err := imaging.EncodePNG(mask)
+   if err != nil {
+       return nil, "", err
+   }
+   params.InitImages = []string{base64.StdEncoding.EncodeToString(initPNG)}
+   params.Mask = base64.StdEncoding.EncodeToString(maskPNG)
+   if params.Width == 0 || params.Height == 0 {
+       params.Width, params.Height = generationDims(b.Dx(), b.Dy())
+   }
+   params.BatchSize, params.NIter = 1, 1
+   resp, err := ForgeSvc.Img2Img(ctx, &params)
+   if err != nil {
+       return nil, "", &upstreamError{err}
+   }
+   first := 0
+   if info, err := resp.ParseInfo(); err == nil && info.IndexOfFirstImage < len(resp.Images) {
+       first = info.IndexOfFirstImage
+   }
+   if first >= len(resp.Images) {
+       return nil, "", &upstreamError{fmt.Errorf("img2img returned no images")}
+   }
+   data, err := decodeBase64Image(resp.Images[first])
+   if err != nil {
+       return nil, "", &upstreamError{fmt.Errorf("decode img2img result: %w", err)}
+   }
+   result, err := imaging.Decode(data)
+   if err != nil {
+       return nil, "", &upstreamError{fmt.Errorf("decode img2img result: %w", err)}
+   }
+   result = imaging.Resize(result, b.Dx(), b.Dy())
+   blur := defaultMaskBlur
+   if params.MaskBlur != nil {
+       blur = *params.MaskBlur
+   }
+   return imaging.Composite(base, result, imaging.Feather(mask, blur)), resp.Info, nil
+}
+
+// validateRegions checks a regions request, writing a 400 response if it is invalid.
+func validateRegions(c *gin.Context, req *regionsRequest) bool {
+   fail := func(msg string) bool {
+       c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+       return false
+   }
+   if req.ImageID == "" {
+       return fail("image_id is required")
+   }
+   if len(req.Regions) == 0 {
+       return fail("at least one region is required")
+   }
+   if req.Mode != "" && req.Mode != regionsSequential && req.Mode != regionsCombined {
+       return fail(fmt.Sprintf("invalid mode %q", req.Mode))
+   }
+   for i, r := range req.Regions {
+       if err := r.Shape.Validate(); err != nil {
+           return fail(fmt.Sprintf("region %d: %v", i, err))
+       }
+       if r.DenoisingStrength < 0 || r.DenoisingStrength > 1 {
+           return fail(fmt.Sprintf("region %d: denoising_strength must be between 0 and 1", i))
+       }
+   }
+   // The source image is supplied by the backend; check the remaining parameters
+   params := req.Params
+   params.InitImages = []string{req.ImageID}
+   if err := checkImg2ImgParams(&params); err != nil {
+       return fail(err.Error())
+   }
+   return validateEditTarget(c, req.editTarget, false)
+}
+
+// expandRegionPrompts expands the shared prompts of req, returning them with the expansion
+// to record, and the prompts of each region with the request's variables.
+func expandRegionPrompts(req *regionsRequest) (string, string, []Region, *storage.PromptExpansion, error) {
+   prompt, negative := req.Prompt, req.NegativePrompt
+   expansion, err := expandPrompts(&prompt, &negative, req.Params.Seed, req.promptOptions)
+   if err != nil {
+       return "", "", nil, nil, err
+   }
+   regions := append([]Region(nil), req.Regions...)
+   for i := range regions {
+       r := &regions[i]
+       if _, err := expandPrompts(&r.Prompt, &r.NegativePrompt, req.Params.Seed, promptOptions{Variables: req.Variables}); err != nil {
+           return "", "", nil, nil, fmt.Errorf("region %d: %w", i, err)
+       }
+   }
+   return prompt, negative, regions, expansion, nil
+}
+
+// runRegions repaints the regions of src, either one pass per region on the progressively
+// edited image or a single pass with the union of all masks and the region prompts joined.
+// Returns the prompt expansion of the shared prompts, if any.
+func runRegions(ctx context.Context, src *image.NRGBA, req *regionsRequest) (*image.NRGBA, []string, *storage.PromptExpansion, error) {
+   prompt, negative, regions, expansion, err := expandRegionPrompts(req)
+   if err != nil {
+       return nil, nil, nil, err
+   }
+   b := src.Bounds()
+   if req.Mode == regionsCombined {
+       masks := make([]*image.Gray, len(regions))
+       prompts := []string{prompt}
+       negatives := []string{negative}
+       for i, r := range regions {
+           masks[i] = imaging.Rasterize(b.Dx(), b.Dy(), []imaging.Shape{r.Shape})
+           prompts = append(prompts, r.Prompt)
+           negatives = append(negatives, r.NegativePrompt)
+       }
+       params := req.Params
+       params.Prompt = joinPrompts(prompts...)
+       params.NegativePrompt = joinPrompts(negatives...)
+       out, info, err := inpaint(ctx, src, imaging.Union(masks...), params)
+       if err != nil {
+           return nil, nil, nil, err
+       }
+       return out, []string{info}, expansion, nil
+   }
+   current := src
+   infos := make([]string, 0, len(regions))
+   for _, r := range regions {
+       params := req.Params
+       params.Prompt = joinPrompts(prompt, r.Prompt)
+       params.NegativePrompt = joinPrompts(negative, r.NegativePrompt)
+       if r.DenoisingStrength > 0 {
+           params.DenoisingStrength = r.DenoisingStrength
+       }
+       mask := imaging.Rasterize(b.Dx(), b.Dy(), []imaging.Shape{r.Shape})
+       next, info, err := inpaint(ctx, current, mask, params)
+       if err != nil {
+           return nil, nil, nil, err
+       }
+       current = next
+       infos = append(infos, info)
+   }
+   return current, infos, expansion, nil
+}
+
+// handleRegions repaints regions of a library image, each with its own prompt, via
+// SD-Forge img2img inpainting. The edited image is returned and optionally saved as a
+// new image or written over the source.
+func handleRegions(c *gin.Context) {
+   var req regionsRequest
+   if err := c.ShouldBindJSON(&req); err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   if !validateRegions(c, &req) {
+       return
+   }
+   sub, err := resolveSubDir(req.Path)
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   sources, err := loadSourceImages(sub, []string{req.ImageID}, nil)
+   if err != nil {
+       writeSourceError(c, err)
+       return
+   }
+   src, err := imaging.Decode(sources[0].data)
+   if err != nil {
+       c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported image format: " + err.Error()})
+       return
+   }
+   edited, infos, expansion, err := runRegions(forgeContext(c), src, &req)
+   if err != nil {
+       writeGenerationError(c, err)
+       return
+   }
+   data, err := imaging.EncodePNG(edited)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   out := EditResponse{Image: base64.StdEncoding.EncodeToString(data), Info: infos}
+   if req.Replace || req.SaveTo != nil {
+       out.Saved, err = storeEditedImages("regions", sub, req.editTarget, req, sources, [][]byte{data}, strings.Join(infos, "\n"), expansion)
+       if err != nil {
+           c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+           return
+       }
+   }
+   c.JSON(http.StatusOK, out)
+}
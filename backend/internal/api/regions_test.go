@@ -0,0 +1,144 @@
+package api_test
+
+import (
+   "context"
+   "encoding/json"
+   "errors"
+   "image/color"
+   "net/http"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+)
+
+// skyAndGrass paints the left half as sky and the lower right quarter as grass.
+var skyAndGrass = []map[string]interface{}{
+   {"prompt": "blue sky", "shape": map[string]interface{}{"type": "rect", "x": 0, "y": 0, "width": 0.5, "height": 1}},
+   {"prompt": "grass", "shape": map[string]interface{}{"type": "polygon", "points": []map[string]float64{{"x": 0.5, "y": 0.5}, {"x": 1, "y": 0.5}, {"x": 1, "y": 1}, {"x": 0.5, "y": 1}}}},
+}
+
+func TestRegionsSequentialInpaintAndReplace(t *testing.T) {
+   root, srcHash := writeLibraryImage(t, "", color.RGBA{255, 0, 0, 255})
+   var prompts []string
+   fills := []color.Color{color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}}
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           mask := decodeB64PNG(t, req.Mask)
+           if mask.Bounds().Dx() != 4 || req.Width != 64 || req.Height != 64 {
+               t.Errorf("unexpected mask size %v or generation size %dx%d", mask.Bounds(), req.Width, req.Height)
+           }
+           fill := fills[len(prompts)]
+           prompts = append(prompts, req.Prompt)
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, req.Width, req.Height, fill)}, Info: `{"seed": 7}`}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodPost, "/api/v1/regions", map[string]interface{}{
+       "image_id": srcHash,
+       "prompt":   "photo",
+       "params":   map[string]interface{}{"mask_blur": 0, "denoising_strength": 0.6},
+       "regions":  skyAndGrass,
+       "replace":  true,
+   })
+   if w.Code != http.StatusOK {
+       t.Fatalf("regions: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   if len(prompts) != 2 || prompts[0] != "photo, blue sky" || prompts[1] != "photo, grass" {
+       t.Fatalf("unexpected passes: %q", prompts)
+   }
+   var resp api.EditResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Saved) != 1 {
+       t.Fatalf("unexpected response: %s", w.Body.String())
+   }
+   out := decodeB64PNG(t, resp.Image)
+   for _, tc := range []struct {
+       x, y    int
+       r, g, b uint32
+   }{{0, 0, 0, 0, 255}, {1, 3, 0, 0, 255}, {3, 0, 255, 0, 0}, {3, 3, 0, 255, 0}} {
+       r, g, b, _ := out.At(tc.x, tc.y).RGBA()
+       if r>>8 != tc.r || g>>8 != tc.g || b>>8 != tc.b {
+           t.Errorf("pixel (%d,%d) = %d,%d,%d, want %d,%d,%d", tc.x, tc.y, r>>8, g>>8, b>>8, tc.r, tc.g, tc.b)
+       }
+   }
+   versions, _ := storage.ListVersions(root, resp.Saved[0].ID)
+   if len(versions) != 1 || versions[0].Hash != srcHash || versions[0].Operation != "regions" {
+       t.Errorf("expected the source archived as a version, got %+v", versions)
+   }
+}
+
+func TestRegionsCombinedModeMakesOnePass(t *testing.T) {
+   _, srcHash := writeLibraryImage(t, "", color.White)
+   var prompts []string
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           prompts = append(prompts, req.Prompt)
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, req.Width, req.Height, color.Black)}}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodPost, "/api/v1/regions", map[string]interface{}{
+       "image_id": srcHash, "prompt": "photo", "mode": "combined", "regions": skyAndGrass,
+   })
+
+   if w.Code != http.StatusOK {
+       t.Fatalf("combined: expected 200, got %d: %s", w.Code, w.Body.String())
+   }
+   if len(prompts) != 1 || prompts[0] != "photo, blue sky, grass" {
+       t.Errorf("unexpected combined pass: %q", prompts)
+   }
+}
+
+func TestRegionsRejectsInvalidRequests(t *testing.T) {
+   _, srcHash := writeLibraryImage(t, "", color.White)
+   passes := 0
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           passes++
+           return &forgeclient.ImageResponse{}, nil
+       },
+   })
+   rect := map[string]interface{}{"type": "rect", "width": 1, "height": 1}
+
+   for _, bad := range []map[string]interface{}{
+       {"image_id": srcHash},
+       {"image_id": srcHash, "regions": []map[string]interface{}{{"shape": map[string]interface{}{"type": "rect", "x": 0.8, "y": 0, "width": 0.5, "height": 1}}}},
+       {"image_id": srcHash, "regions": []map[string]interface{}{{"shape": map[string]interface{}{"type": "polygon", "points": []map[string]float64{{"x": 0, "y": 0}}}}}},
+       {"image_id": srcHash, "mode": "parallel", "regions": []map[string]interface{}{{"shape": rect}}},
+   } {
+       if w := doJSON(t, http.MethodPost, "/api/v1/regions", bad); w.Code != http.StatusBadRequest {
+           t.Errorf("%v: expected 400, got %d", bad, w.Code)
+       }
+   }
+   missing := map[string]interface{}{"image_id": "0000", "regions": []map[string]interface{}{{"shape": rect}}}
+   if w := doJSON(t, http.MethodPost, "/api/v1/regions", missing); w.Code != http.StatusNotFound {
+       t.Errorf("missing image: expected 404, got %d", w.Code)
+   }
+   if passes != 0 {
+       t.Errorf("invalid requests reached img2img %d times", passes)
+   }
+}
+
+func TestRegionsReportForgeFailures(t *testing.T) {
+   root, srcHash := writeLibraryImage(t, "", color.White)
+   var forgeErr error
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return nil, forgeErr
+       },
+   })
+   body := map[string]interface{}{"image_id": srcHash, "regions": skyAndGrass}
+
+   forgeErr = &forgeclient.RequestError{Op: "img2img", Kind: forgeclient.ErrTimeout, Err: errors.New("timed out")}
+   if w := doJSON(t, http.MethodPost, "/api/v1/regions", body); w.Code != http.StatusGatewayTimeout {
+       t.Errorf("timeout: expected 504, got %d: %s", w.Code, w.Body.String())
+   }
+   forgeErr = &forgeclient.RequestError{Op: "img2img", StatusCode: 500, Err: errors.New("boom")}
+   if w := doJSON(t, http.MethodPost, "/api/v1/regions", body); w.Code != http.StatusBadGateway {
+       t.Errorf("server error: expected 502, got %d: %s", w.Code, w.Body.String())
+   }
+   if versions, _ := storage.ListVersions(root, srcHash); len(versions) != 0 {
+       t.Errorf("failed edits must not touch the source, got versions %+v", versions)
+   }
+}
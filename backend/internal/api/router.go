@@ -30,11 +30,17 @@ func SetupRouter() *gin.Engine {
        c.Writer.Header().Set("Cache-Control", "no-cache")
        c.Writer.Header().Set("Connection", "keep-alive")
        c.Writer.Header().Set("Content-Type", "text/event-stream")
-       ch := subscribe()
-       defer unsubscribe(ch)
+       sub := subscribe()
+       defer unsubscribe(sub)
        c.Writer.Flush()
-       for path := range ch {
-           c.SSEvent("update", path)
+       for {
+           events, ok := sub.next(c.Request.Context())
+           if !ok {
+               return
+           }
+           for _, ev := range events {
+               c.SSEvent(ev.Name, ev.Data)
+           }
            c.Writer.Flush()
        }
    })
@@ -44,6 +50,7 @@ func SetupRouter() *gin.Engine {
    // Serve image bytes by hash ID
    r.GET("/api/images/:id", handleGetImage)
    r.GET("/api/dirs", handleGetDirs)
+   r.GET("/api/dirs/tree", handleGetDirTree)
   
    // Directory management: reinitialize filenames evenly
    r.POST("/api/dirs/reinit", handleReinit)
@@ -78,6 +85,36 @@ func SetupRouter() *gin.Engine {
   
    // Bulk dialog retrieval
    r.GET("/api/dialogs", handleGetAllDialogs)
+   // Per-image tags and rating
+   r.GET("/api/images/:id/attributes", handleGetAttributes)
+   r.POST("/api/images/:id/attributes", handleSetAttributes)
+   // Technical metadata (dimensions, format, ICC profile, EXIF)
+   r.GET("/api/images/:id/info", handleGetImageInfo)
+   // Embedded Stable Diffusion generation parameters
+   r.GET("/api/images/:id/generation", handleGetGeneration)
+   r.GET("/api/images/:id/provenance", handleGetProvenance)
+   // Stored inpainting masks
+   r.GET("/api/images/:id/masks", handleListMasks)
+   r.POST("/api/images/:id/masks", handleCreateMask)
+   r.GET("/api/images/:id/masks/:mask", handleGetMask)
+   r.PUT("/api/images/:id/masks/:mask", handleUpdateMask)
+   r.DELETE("/api/images/:id/masks/:mask", handleDeleteMask)
+   r.GET("/api/images/:id/masks/:mask/png", handleRenderMask)
+   // Earlier versions kept when edits replace an image
+   r.GET("/api/images/:id/versions", handleListVersions)
+   r.GET("/api/images/:id/versions/:version", handleGetVersion)
+   r.GET("/api/images/:id/versions/:version/diff", handleDiffVersion)
+   r.POST("/api/images/:id/versions/:version/revert", handleRevertVersion)
+   // Edits made by the backend itself
+   r.POST("/api/images/:id/crop", handleCrop)
+
+   // Smart collections (saved queries)
+   r.GET("/api/collections", handleListCollections)
+   r.POST("/api/collections", handleCreateCollection)
+   r.GET("/api/collections/:id", handleGetCollection)
+   r.PUT("/api/collections/:id", handleUpdateCollection)
+   r.DELETE("/api/collections/:id", handleDeleteCollection)
+   r.PUT("/api/collections/:id/order", handleSetCollectionOrder)
 
    // SD-Forge integration endpoints (v1)
    v1 := r.Group("/api/v1")
@@ -85,18 +122,61 @@ func SetupRouter() *gin.Engine {
        v1.POST("/txt2img", handleTxt2Img)
        v1.POST("/img2img", handleImg2Img)
        v1.GET("/progress", handleProgress)
+       v1.GET("/progress/stream", handleProgressStream)
+       v1.POST("/interrupt", handleInterrupt)
+       v1.POST("/skip", handleSkip)
        v1.POST("/regions", handleRegions)
+       v1.POST("/outpaint", handleOutpaint)
        // Extras
        v1.POST("/extras", handleExtras)
        v1.POST("/extras/batch", handleExtrasBatch)
        // Models & LoRAs
        v1.GET("/models", handleGetModels)
+       v1.GET("/models/current", handleGetCurrentModel)
        v1.POST("/models/switch", handleSwitchModel)
        v1.GET("/loras", handleGetLoras)
+       v1.POST("/loras/refresh", handleRefreshLoras)
+       // Prompt presets, saved negative prompts and wildcards
+       v1.GET("/prompts/presets", handleListPromptPresets)
+       v1.POST("/prompts/presets", handleCreatePromptPreset)
+       v1.GET("/prompts/presets/:id", handleGetPromptPreset)
+       v1.PUT("/prompts/presets/:id", handleUpdatePromptPreset)
+       v1.DELETE("/prompts/presets/:id", handleDeletePromptPreset)
+       v1.GET("/prompts/negatives", handleListNegativePrompts)
+       v1.POST("/prompts/negatives", handleCreateNegativePrompt)
+       v1.GET("/prompts/negatives/:id", handleGetNegativePrompt)
+       v1.PUT("/prompts/negatives/:id", handleUpdateNegativePrompt)
+       v1.DELETE("/prompts/negatives/:id", handleDeleteNegativePrompt)
+       v1.GET("/prompts/wildcards", handleListWildcards)
+       v1.GET("/prompts/wildcards/:name", handleGetWildcard)
+       v1.PUT("/prompts/wildcards/:name", handlePutWildcard)
+       v1.DELETE("/prompts/wildcards/:name", handleDeleteWildcard)
+       v1.POST("/prompts/expand", handleExpandPrompt)
+       // X/Y/Z parameter grids with a labeled contact sheet
+       v1.POST("/grids", handleGrid)
+       // Server capabilities, cached until refreshed or the model changes
+       v1.GET("/samplers", handleGetSamplers)
+       v1.GET("/schedulers", handleGetSchedulers)
+       v1.GET("/upscalers", handleGetUpscalers)
+       v1.GET("/vaes", handleGetVAEs)
+       v1.GET("/embeddings", handleGetEmbeddings)
+       v1.GET("/styles", handleGetStyles)
+       v1.GET("/options", handleGetOptions)
+       v1.POST("/refresh", handleRefreshDiscovery)
        // Ping
        v1.GET("/ping", handlePing)
-       // History
+       v1.GET("/servers", handleGetServers)
+       // Version history, kept by the backend (Forge has no history API)
        v1.GET("/history", handleGetHistory)
+       // Asynchronous generation jobs
+       v1.POST("/jobs/txt2img", handleSubmitTxt2ImgJob)
+       v1.POST("/jobs/img2img", handleSubmitImg2ImgJob)
+       v1.POST("/jobs/grid", handleSubmitGridJob)
+       v1.GET("/jobs", handleListJobs)
+       v1.GET("/jobs/:id", handleGetJob)
+       v1.POST("/jobs/:id/cancel", handleCancelJob)
+       v1.POST("/jobs/:id/retry", handleRetryJob)
+       v1.GET("/jobs/:id/images/:n", handleGetJobImage)
    }
    return r
 }
\ No newline at end of file
@@ -0,0 +1,188 @@
+package api
+
+import (
+   "io/ioutil"
+   "net/http"
+   "os"
+   "path/filepath"
+   "sort"
+   "strings"
+   "sync"
+   "time"
+
+   "github.com/gin-gonic/gin"
+)
+
+// DirTreeNode describes a directory and aggregate statistics for its whole subtree.
+type DirTreeNode struct {
+   Name         string        `json:"name"`
+   Path         string        `json:"path"`
+   ImageCount   int           `json:"image_count"`
+   TotalImages  int           `json:"total_images"`
+   TotalSize    int64         `json:"total_size"`
+   LastModified string        `json:"last_modified,omitempty"`
+   Children     []DirTreeNode `json:"children"`
+}
+
+// dirStats caches the direct (non-recursive) contents of one directory.
+type dirStats struct {
+   modTime    time.Time
+   imageCount int
+   size       int64
+   lastMod    time.Time
+   children   []string
+}
+
+var (
+   dirStatsMu    sync.Mutex
+   dirStatsCache = make(map[string]*dirStats)
+)
+
+// invalidateDirStats drops cached stats for the directory containing path and for path itself.
+// Called from the file watcher, since writes to existing files do not change the directory mtime.
+func invalidateDirStats(path string) {
+   dirStatsMu.Lock()
+   delete(dirStatsCache, path)
+   delete(dirStatsCache, filepath.Dir(path))
+   dirStatsMu.Unlock()
+}
+
+// getDirStats returns the direct stats for dir, rescanning only when the directory changed.
+func getDirStats(dir string) (*dirStats, error) {
+   info, err := os.Stat(dir)
+   if err != nil {
+       return nil, err
+   }
+   dirStatsMu.Lock()
+   cached, ok := dirStatsCache[dir]
+   dirStatsMu.Unlock()
+   if ok && cached.modTime.Equal(info.ModTime()) {
+       return cached, nil
+   }
+   files, err := ioutil.ReadDir(dir)
+   if err != nil {
+       return nil, err
+   }
+   st := &dirStats{modTime: info.ModTime()}
+   root := filepath.Clean(dir) == filepath.Clean(ImageDir)
+   for _, fi := range files {
+       name := fi.Name()
+       if fi.IsDir() {
+           if !isReservedDir(name, root) {
+               st.children = append(st.children, name)
+           }
+           continue
+       }
+       if !isImageFile(name) {
+           continue
+       }
+       st.imageCount++
+       st.size += fi.Size()
+       if fi.ModTime().After(st.lastMod) {
+           st.lastMod = fi.ModTime()
+       }
+   }
+   sort.Strings(st.children)
+   dirStatsMu.Lock()
+   dirStatsCache[dir] = st
+   dirStatsMu.Unlock()
+   return st, nil
+}
+
+// buildDirTree assembles the tree rooted at sub, aggregating counts, sizes and modification times.
+func buildDirTree(sub string) (DirTreeNode, error) {
+   dir := ImageDir
+   if sub != "" {
+       dir = filepath.Join(ImageDir, sub)
+   }
+   st, err := getDirStats(dir)
+   if err != nil {
+       return DirTreeNode{}, err
+   }
+   node := DirTreeNode{
+       Name:        filepath.Base(sub),
+       Path:        sub,
+       ImageCount:  st.imageCount,
+       TotalImages: st.imageCount,
+       TotalSize:   st.size,
+       Children:    []DirTreeNode{},
+   }
+   if sub == "" {
+       node.Name = ""
+   }
+   lastMod := st.lastMod
+   for _, name := range st.children {
+       childSub := name
+       if sub != "" {
+           childSub = sub + "/" + name
+       }
+       child, err := buildDirTree(childSub)
+       if err != nil {
+           continue
+       }
+       node.TotalImages += child.TotalImages
+       node.TotalSize += child.TotalSize
+       if child.LastModified != "" {
+           if t, err := time.Parse(time.RFC3339Nano, child.LastModified); err == nil && t.After(lastMod) {
+               lastMod = t
+           }
+       }
+       node.Children = append(node.Children, child)
+   }
+   if !lastMod.IsZero() {
+       node.LastModified = lastMod.UTC().Format(time.RFC3339Nano)
+   }
+   return node, nil
+}
+
+// handleGetDirTree returns the full folder hierarchy below ?path= with aggregate statistics.
+func handleGetDirTree(c *gin.Context) {
+   sub := strings.Trim(filepath.ToSlash(c.Query("path")), "/")
+   tree, err := buildDirTree(sub)
+   if err != nil {
+       c.JSON(http.StatusNotFound, gin.H{"error": "directory not found"})
+       return
+   }
+   c.JSON(http.StatusOK, tree)
+}
+
+// handleGetDirsRecursive lists the subdirectories of sub with aggregate image counts.
+// At the root, directories are hidden only if their whole subtree contains no images.
+func handleGetDirsRecursive(c *gin.Context, sub string) {
+   tree, err := buildDirTree(strings.Trim(filepath.ToSlash(sub), "/"))
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   entries := []DirEntry{}
+   for _, child := range tree.Children {
+       if sub == "" && child.TotalImages == 0 {
+           continue
+       }
+       entries = append(entries, DirEntry{Name: child.Name, ImageCount: child.TotalImages, DirCount: len(child.Children)})
+   }
+   c.JSON(http.StatusOK, entries)
+}
+
+// listImageRecordsRecursive returns records for sub and every image directory beneath it.
+// Images with identical content in several directories are listed once.
+func listImageRecordsRecursive(sub string) []imageRecord {
+   sub = strings.Trim(filepath.ToSlash(sub), "/")
+   var recs []imageRecord
+   seen := make(map[string]bool)
+   for _, d := range walkImageDirs() {
+       if sub != "" && d != sub && !strings.HasPrefix(d, sub+"/") {
+           continue
+       }
+       for _, rec := range listImageRecords(d) {
+           if seen[rec.ID] {
+               continue
+           }
+           seen[rec.ID] = true
+           rec.Path = d
+           recs = append(recs, rec)
+       }
+   }
+   sort.SliceStable(recs, func(i, j int) bool { return recs[i].TS.Before(recs[j].TS) })
+   return recs
+}
@@ -0,0 +1,83 @@
+package api_test
+
+import (
+   "encoding/json"
+   "image/color"
+   "net/http"
+   "path/filepath"
+   "testing"
+
+   "image-processor-backend/internal/api"
+)
+
+func TestDirTreeAndRecursiveListing(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   writeTestPNG(t, filepath.Join(root, "book", "20240101000000-000000001.png"), color.RGBA{255, 0, 0, 255})
+   writeTestPNG(t, filepath.Join(root, "book", "ch1", "20240102000000-000000001.png"), color.RGBA{0, 255, 0, 255})
+   writeTestPNG(t, filepath.Join(root, "book", "ch1", "scene", "20240103000000-000000001.png"), color.RGBA{0, 0, 255, 255})
+   writeTestPNG(t, filepath.Join(root, "deep", "only", "20240104000000-000000001.png"), color.RGBA{9, 9, 9, 255})
+
+   w := doJSON(t, http.MethodGet, "/api/dirs/tree", nil)
+   if w.Code != http.StatusOK {
+       t.Fatalf("expected status 200, got %d", w.Code)
+   }
+   var tree api.DirTreeNode
+   if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+       t.Fatalf("unmarshal tree: %v", err)
+   }
+   if tree.TotalImages != 4 || len(tree.Children) != 2 {
+       t.Fatalf("unexpected root aggregates: %+v", tree)
+   }
+   book := tree.Children[0]
+   if book.Name != "book" || book.ImageCount != 1 || book.TotalImages != 3 || book.TotalSize == 0 || book.LastModified == "" {
+       t.Errorf("unexpected book node: %+v", book)
+   }
+
+   w = doJSON(t, http.MethodGet, "/api/images?path=book&recursive=true", nil)
+   var imgs []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal images: %v", err)
+   }
+   if len(imgs) != 3 || imgs[2].Path != "book/ch1/scene" {
+       t.Fatalf("unexpected recursive listing: %+v", imgs)
+   }
+
+   // The plain listing hides "deep" (no direct images); the recursive one does not.
+   w = doJSON(t, http.MethodGet, "/api/dirs?recursive=true", nil)
+   var dirs []api.DirEntry
+   if err := json.Unmarshal(w.Body.Bytes(), &dirs); err != nil {
+       t.Fatalf("unmarshal dirs: %v", err)
+   }
+   if len(dirs) != 2 || dirs[1].Name != "deep" || dirs[1].ImageCount != 1 {
+       t.Fatalf("unexpected recursive dirs: %+v", dirs)
+   }
+}
+
+func TestBackendDirsAreReservedOnlyAtTheRoot(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   writeTestPNG(t, filepath.Join(root, "prompts", "20240101000000-000000001.png"), color.Black)
+   writeTestPNG(t, filepath.Join(root, "book", "prompts", "20240101000000-000000001.png"), color.Black)
+   writeTestPNG(t, filepath.Join(root, "book", "metadata", "20240101000000-000000001.png"), color.Black)
+
+   w := doJSON(t, http.MethodGet, "/api/images?path=book&recursive=true", nil)
+   var imgs []api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &imgs); err != nil {
+       t.Fatalf("unmarshal images: %v", err)
+   }
+   if len(imgs) != 1 || imgs[0].Path != "book/prompts" {
+       t.Fatalf("expected only book/prompts listed, got %+v", imgs)
+   }
+   if w := doJSON(t, http.MethodGet, "/api/images?path=book/prompts", nil); w.Code != http.StatusOK {
+       t.Errorf("book/prompts: expected 200, got %d", w.Code)
+   }
+   w = doJSON(t, http.MethodGet, "/api/dirs/tree", nil)
+   var tree api.DirTreeNode
+   if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+       t.Fatalf("unmarshal tree: %v", err)
+   }
+   if tree.TotalImages != 1 || len(tree.Children) != 1 || len(tree.Children[0].Children) != 1 {
+       t.Errorf("expected book with its prompts directory only: %+v", tree)
+   }
+}
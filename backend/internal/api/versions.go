@@ -0,0 +1,195 @@
+package api
+
+import (
+   "encoding/base64"
+   "errors"
+   "io/ioutil"
+   "net/http"
+   "net/url"
+   "path/filepath"
+
+   "image-processor-backend/internal/imaging"
+   "image-processor-backend/internal/storage"
+   "github.com/gin-gonic/gin"
+)
+
+// VersionResponse is an archived version of an image with the URL of its content.
+type VersionResponse struct {
+   storage.Version
+   URL string `json:"url"`
+}
+
+// VersionDiff compares an archived version with the current image or another version.
+// Image is a base64 PNG mapping the per-pixel difference (black is unchanged).
+type VersionDiff struct {
+   imaging.DiffStats
+   Width       int    `json:"width"`
+   Height      int    `json:"height"`
+   SizeChanged bool   `json:"size_changed"`
+   Image       string `json:"image"`
+}
+
+// versionURL builds the API URL of an archived version of the image hash in sub.
+func versionURL(sub, hash, version string) string {
+   u := "/api/images/" + hash + "/versions/" + version
+   if sub != "" {
+       u += "?path=" + url.QueryEscape(sub)
+   }
+   return u
+}
+
+// listVersions writes the archived versions of an image, oldest first.
+func listVersions(c *gin.Context, sub, baseDir, hash string) {
+   versions, err := storage.ListVersions(baseDir, hash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load versions"})
+       return
+   }
+   out := make([]VersionResponse, len(versions))
+   for i, v := range versions {
+       out[i] = VersionResponse{Version: v, URL: versionURL(sub, hash, v.Hash)}
+   }
+   c.JSON(http.StatusOK, out)
+}
+
+// loadVersionOr404 reads the :version of an image, writing an error response if it does not exist.
+func loadVersionOr404(c *gin.Context, baseDir, hash, version string) (*storage.Version, []byte, bool) {
+   v, err := storage.FindVersion(baseDir, hash, version)
+   if err != nil {
+       if errors.Is(err, storage.ErrVersionNotFound) {
+           c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+       } else {
+           c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load versions"})
+       }
+       return nil, nil, false
+   }
+   data, err := ioutil.ReadFile(storage.VersionPath(baseDir, hash, *v))
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read version"})
+       return nil, nil, false
+   }
+   return v, data, true
+}
+
+// handleListVersions returns the earlier versions of an image kept when edits replaced it.
+func handleListVersions(c *gin.Context) {
+   baseDir, _, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   listVersions(c, c.Query("path"), baseDir, hash)
+}
+
+// handleGetHistory lists the versions of the image given by the imageID query parameter.
+// It predates the per-image versions route and is kept for existing clients.
+func handleGetHistory(c *gin.Context) {
+   hash := c.Query("imageID")
+   if hash == "" {
+       c.JSON(http.StatusBadRequest, gin.H{"error": "missing imageID"})
+       return
+   }
+   sub := c.Query("path")
+   baseDir := ImageDir
+   if sub != "" {
+       baseDir = filepath.Join(ImageDir, sub)
+   }
+   filename, err := findFilenameByHash(baseDir, hash)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve image ID"})
+       return
+   }
+   if filename == "" {
+       c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+       return
+   }
+   listVersions(c, sub, baseDir, hash)
+}
+
+// handleGetVersion serves the content of an archived version for preview.
+func handleGetVersion(c *gin.Context) {
+   baseDir, _, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   v, _, ok := loadVersionOr404(c, baseDir, hash, c.Param("version"))
+   if !ok {
+       return
+   }
+   // Version files are named by content hash and never change
+   c.Header("Cache-Control", "public, max-age=31536000, immutable")
+   c.File(storage.VersionPath(baseDir, hash, *v))
+}
+
+// handleDiffVersion compares an archived version with the current image, or with the
+// version given by the against query parameter. The other image is scaled to the size of
+// the version when they differ.
+func handleDiffVersion(c *gin.Context) {
+   baseDir, filename, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   _, data, ok := loadVersionOr404(c, baseDir, hash, c.Param("version"))
+   if !ok {
+       return
+   }
+   var other []byte
+   if against := c.Query("against"); against != "" {
+       if _, other, ok = loadVersionOr404(c, baseDir, hash, against); !ok {
+           return
+       }
+   } else {
+       var err error
+       if other, err = ioutil.ReadFile(filepath.Join(baseDir, filename)); err != nil {
+           c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read image"})
+           return
+       }
+   }
+   a, err := imaging.Decode(data)
+   if err != nil {
+       c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported image format: " + err.Error()})
+       return
+   }
+   b, err := imaging.Decode(other)
+   if err != nil {
+       c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported image format: " + err.Error()})
+       return
+   }
+   diff, stats := imaging.Diff(a, b)
+   png, err := imaging.EncodePNG(diff)
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   c.JSON(http.StatusOK, VersionDiff{
+       DiffStats:   stats,
+       Width:       a.Bounds().Dx(),
+       Height:      a.Bounds().Dy(),
+       SizeChanged: a.Bounds().Size() != b.Bounds().Size(),
+       Image:       base64.StdEncoding.EncodeToString(png),
+   })
+}
+
+// handleRevertVersion restores an archived version as the current image. The content being
+// replaced is archived in turn, so a revert can itself be undone.
+func handleRevertVersion(c *gin.Context) {
+   baseDir, filename, hash, ok := libraryImageOr404(c)
+   if !ok {
+       return
+   }
+   sub, err := resolveSubDir(c.Query("path"))
+   if err != nil {
+       c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+       return
+   }
+   _, data, ok := loadVersionOr404(c, baseDir, hash, c.Param("version"))
+   if !ok {
+       return
+   }
+   _, newHash, err := replaceImageInLibrary(sub, filename, data, "revert")
+   if err != nil {
+       c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+       return
+   }
+   ts, _ := storage.LoadMetaEntry(baseDir, newHash)
+   c.JSON(http.StatusOK, ImageResponse{ID: newHash, URL: imageURL(sub, newHash), Timestamp: ts, Path: sub})
+}
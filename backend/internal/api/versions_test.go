@@ -0,0 +1,194 @@
+package api_test
+
+import (
+   "bytes"
+   "context"
+   "encoding/json"
+   "image/color"
+   "net/http"
+   "os"
+   "path/filepath"
+   "testing"
+
+   "image-processor-backend/internal/api"
+   "image-processor-backend/internal/forgeclient"
+   "image-processor-backend/internal/storage"
+)
+
+func TestImg2ImgReplaceKeepsSourceAsVersion(t *testing.T) {
+   _, origHash := writeLibraryImage(t, "album", color.Black)
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, 4, 4, color.White)}}, nil
+       },
+   })
+
+   w := doJSON(t, http.MethodPost, "/api/v1/img2img", map[string]interface{}{"prompt": "snow", "image_id": origHash, "path": "album", "replace": true})
+   var res api.GenerationResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || len(res.Saved) != 1 {
+       t.Fatalf("img2img: %d %s", w.Code, w.Body.String())
+   }
+   editedHash := res.Saved[0].ID
+   if editedHash == origHash {
+       t.Fatalf("image was not replaced")
+   }
+
+   base := "/api/images/" + editedHash + "/versions"
+   w = doJSON(t, http.MethodGet, base+"?path=album", nil)
+   var versions []api.VersionResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil || len(versions) != 1 {
+       t.Fatalf("expected one version, got %s", w.Body.String())
+   }
+   if v := versions[0]; v.Hash != origHash || v.Operation != "img2img" || v.URL != base+"/"+origHash+"?path=album" {
+       t.Errorf("unexpected version: %+v", v)
+   }
+   w = doJSON(t, http.MethodGet, "/api/v1/history?imageID="+editedHash+"&path=album", nil)
+   if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(origHash)) {
+       t.Errorf("history: %d %s", w.Code, w.Body.String())
+   }
+}
+
+func TestVersionPreviewAndDiff(t *testing.T) {
+   root, origHash := writeLibraryImage(t, "album", color.Black)
+   orig, _ := os.ReadFile(filepath.Join(root, "album", "20240101000000-000000000.png"))
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, 4, 4, color.White)}}, nil
+       },
+   })
+   w := doJSON(t, http.MethodPost, "/api/v1/img2img", map[string]interface{}{"prompt": "snow", "image_id": origHash, "path": "album", "replace": true})
+   var res api.GenerationResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || len(res.Saved) != 1 {
+       t.Fatalf("img2img: %d %s", w.Code, w.Body.String())
+   }
+   base := "/api/images/" + res.Saved[0].ID + "/versions/" + origHash
+
+   w = doJSON(t, http.MethodGet, base+"?path=album", nil)
+   if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), orig) {
+       t.Fatalf("preview: expected original content, got %d", w.Code)
+   }
+   w = doJSON(t, http.MethodGet, base+"/diff?path=album", nil)
+   var diff api.VersionDiff
+   if err := json.Unmarshal(w.Body.Bytes(), &diff); err != nil || w.Code != http.StatusOK {
+       t.Fatalf("diff: %d %s", w.Code, w.Body.String())
+   }
+   if diff.ChangedPixels != 16 || diff.TotalPixels != 16 || diff.SizeChanged || diff.MeanDifference != 1 {
+       t.Errorf("unexpected diff: %+v", diff.DiffStats)
+   }
+   if img := decodeB64PNG(t, diff.Image); img.Bounds().Dx() != 4 {
+       t.Errorf("unexpected diff image size %v", img.Bounds())
+   }
+}
+
+func TestVersionRevertKeepsTheEdit(t *testing.T) {
+   root, origHash := writeLibraryImage(t, "album", color.Black)
+   path := filepath.Join(root, "album", "20240101000000-000000000.png")
+   orig, _ := os.ReadFile(path)
+   api.SetForgeClient(&forgeclient.MockClient{
+       Img2ImgFunc: func(ctx context.Context, req *forgeclient.Img2ImgRequest) (*forgeclient.ImageResponse, error) {
+           return &forgeclient.ImageResponse{Images: []string{solidPNGBase64(t, 4, 4, color.White)}}, nil
+       },
+   })
+   w := doJSON(t, http.MethodPost, "/api/v1/img2img", map[string]interface{}{"prompt": "snow", "image_id": origHash, "path": "album", "replace": true})
+   var res api.GenerationResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || len(res.Saved) != 1 {
+       t.Fatalf("img2img: %d %s", w.Code, w.Body.String())
+   }
+   editedHash := res.Saved[0].ID
+
+   w = doJSON(t, http.MethodPost, "/api/images/"+editedHash+"/versions/"+origHash+"/revert?path=album", nil)
+   var reverted api.ImageResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &reverted); err != nil || w.Code != http.StatusOK {
+       t.Fatalf("revert: %d %s", w.Code, w.Body.String())
+   }
+   if reverted.ID != origHash {
+       t.Errorf("expected the original hash back, got %s", reverted.ID)
+   }
+   if data, _ := os.ReadFile(path); !bytes.Equal(data, orig) {
+       t.Errorf("file content not restored")
+   }
+   // The edit is kept too, so the revert can be undone
+   w = doJSON(t, http.MethodGet, "/api/images/"+origHash+"/versions?path=album", nil)
+   var versions []api.VersionResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil || len(versions) != 2 {
+       t.Fatalf("expected two versions after revert, got %s", w.Body.String())
+   }
+   if versions[1].Hash != editedHash || versions[1].Operation != "revert" {
+       t.Errorf("unexpected latest version: %+v", versions[1])
+   }
+}
+
+func TestVersionRoutesRejectUnknownVersions(t *testing.T) {
+   _, hash := writeLibraryImage(t, "album", color.Black)
+   base := "/api/images/" + hash + "/versions/missing"
+
+   if w := doJSON(t, http.MethodGet, base+"?path=album", nil); w.Code != http.StatusNotFound {
+       t.Errorf("missing version: expected 404, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodGet, base+"/diff?path=album", nil); w.Code != http.StatusNotFound {
+       t.Errorf("diff of a missing version: expected 404, got %d", w.Code)
+   }
+   if w := doJSON(t, http.MethodPost, base+"/revert?path=album", nil); w.Code != http.StatusNotFound {
+       t.Errorf("revert to a missing version: expected 404, got %d", w.Code)
+   }
+   api.SetForgeClient(&forgeclient.MockClient{})
+   if w := doJSON(t, http.MethodPost, "/api/v1/img2img", map[string]interface{}{"prompt": "x", "init_images": []string{"aGk="}, "replace": true}); w.Code != http.StatusBadRequest {
+       t.Errorf("replace without image_id: expected 400, got %d", w.Code)
+   }
+}
+
+func TestCropReplacesImageKeepingVersion(t *testing.T) {
+   _, origHash := writeLibraryImage(t, "", color.Black)
+
+   w := doJSON(t, http.MethodPost, "/api/images/"+origHash+"/crop", map[string]interface{}{"x": 1, "y": 0, "width": 2, "height": 3})
+   var res api.EditResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || len(res.Saved) != 1 {
+       t.Fatalf("crop: %d %s", w.Code, w.Body.String())
+   }
+   if b := decodeB64PNG(t, res.Image).Bounds(); b.Dx() != 2 || b.Dy() != 3 {
+       t.Errorf("unexpected crop size %v", b)
+   }
+   w = doJSON(t, http.MethodGet, "/api/images/"+res.Saved[0].ID+"/versions", nil)
+   var versions []api.VersionResponse
+   if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil || len(versions) != 1 {
+       t.Fatalf("expected one version, got %s", w.Body.String())
+   }
+   if versions[0].Hash != origHash || versions[0].Operation != "crop" {
+       t.Errorf("unexpected version: %+v", versions[0])
+   }
+}
+
+func TestCropRejectsRectanglesOutsideTheImage(t *testing.T) {
+   _, hash := writeLibraryImage(t, "", color.Black)
+
+   for _, bad := range []map[string]interface{}{
+       {"x": 0, "y": 0, "width": 0, "height": 1},
+       {"x": 1, "y": 2, "width": 2, "height": 3},
+       {"x": -1, "y": 0, "width": 1, "height": 1},
+       {"x": 3, "y": 0, "width": 2, "height": 1},
+   } {
+       if w := doJSON(t, http.MethodPost, "/api/images/"+hash+"/crop", bad); w.Code != http.StatusBadRequest {
+           t.Errorf("%v: expected 400, got %d", bad, w.Code)
+       }
+   }
+   if w := doJSON(t, http.MethodPost, "/api/images/0000/crop", map[string]interface{}{"x": 0, "y": 0, "width": 1, "height": 1}); w.Code != http.StatusNotFound {
+       t.Errorf("missing image: expected 404, got %d", w.Code)
+   }
+}
+
+func TestCropRejectsWebPWithClearError(t *testing.T) {
+   root := t.TempDir()
+   api.SetImageDir(root)
+   path := filepath.Join(root, "20240101000000-000000000.webp")
+   if err := os.WriteFile(path, []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"), 0644); err != nil {
+       t.Fatalf("write: %v", err)
+   }
+   hash, err := storage.HashFile(path)
+   if err != nil {
+       t.Fatalf("hash: %v", err)
+   }
+   w := doJSON(t, http.MethodPost, "/api/images/"+hash+"/crop", map[string]interface{}{"x": 0, "y": 0, "width": 1, "height": 1})
+   if w.Code != http.StatusUnsupportedMediaType || !bytes.Contains(w.Body.Bytes(), []byte("WebP")) {
+       t.Fatalf("expected 415 naming WebP, got %d: %s", w.Code, w.Body.String())
+   }
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+   "context"
    "log"
    "os"
    "path/filepath"
@@ -9,41 +10,97 @@ import (
    "github.com/fsnotify/fsnotify"
 )
 
+// serverEvent is a named event delivered over the /api/updates SSE stream.
+type serverEvent struct {
+   Name string
+   Data interface{}
+}
+
+// maxQueuedUpdates bounds the file updates waiting for one stream. Further updates are
+// dropped until the stream catches up; other events, such as job updates, are always queued.
+const maxQueuedUpdates = 256
+
+// subscriber queues the events for one /api/updates stream.
+type subscriber struct {
+   mu     sync.Mutex
+   events []serverEvent
+   // ready is signaled when events are queued
+   ready chan struct{}
+}
+
 var (
    subsMu           sync.Mutex
-   eventSubscribers = make(map[chan string]struct{})
+   eventSubscribers = make(map[*subscriber]struct{})
 )
 
-// subscribe registers a new channel to receive file change events.
-func subscribe() chan string {
-   ch := make(chan string)
+// subscribe registers a new subscriber for server events.
+func subscribe() *subscriber {
+   sub := &subscriber{ready: make(chan struct{}, 1)}
    subsMu.Lock()
-   eventSubscribers[ch] = struct{}{}
+   eventSubscribers[sub] = struct{}{}
    subsMu.Unlock()
-   return ch
+   return sub
 }
 
-// unsubscribe removes a channel from subscribers and closes it.
-func unsubscribe(ch chan string) {
+// unsubscribe removes a subscriber.
+func unsubscribe(sub *subscriber) {
    subsMu.Lock()
-   delete(eventSubscribers, ch)
+   delete(eventSubscribers, sub)
    subsMu.Unlock()
-   close(ch)
 }
 
-// broadcastEvent sends a path string to all subscribers.
+// push queues ev for the subscriber, dropping file updates once maxQueuedUpdates are waiting.
+func (s *subscriber) push(ev serverEvent) {
+   s.mu.Lock()
+   if ev.Name == "update" && len(s.events) >= maxQueuedUpdates {
+       s.mu.Unlock()
+       return
+   }
+   s.events = append(s.events, ev)
+   s.mu.Unlock()
+   select {
+   case s.ready <- struct{}{}:
+   default:
+   }
+}
+
+// next waits for queued events and returns them, or reports false once ctx is done.
+func (s *subscriber) next(ctx context.Context) ([]serverEvent, bool) {
+   select {
+   case <-ctx.Done():
+       return nil, false
+   case <-s.ready:
+   }
+   s.mu.Lock()
+   defer s.mu.Unlock()
+   events := s.events
+   s.events = nil
+   return events, true
+}
+
+// broadcastEvent sends a file change "update" event with the changed path to all subscribers.
 func broadcastEvent(path string) {
+   broadcastNamed("update", path)
+}
+
+// broadcastNamed sends a named event to all subscribers.
+func broadcastNamed(name string, data interface{}) {
    subsMu.Lock()
    defer subsMu.Unlock()
-   for ch := range eventSubscribers {
-       select {
-       case ch <- path:
-       default:
-       }
+   for sub := range eventSubscribers {
+       sub.push(serverEvent{Name: name, Data: data})
    }
 }
 
+// isStateDir reports whether path is one of the backend state directories (jobs,
+// collections, prompts) directly under root.
+func isStateDir(root, path string) bool {
+   return filepath.Dir(path) == filepath.Clean(root) && rootReservedDirs[filepath.Base(path)]
+}
+
 // StartWatcher begins watching the directory tree rooted at root for changes.
+// Backend state directories are skipped: they are not part of the image tree, and job
+// records are rewritten at every state change.
 func StartWatcher(root string) error {
    watcher, err := fsnotify.NewWatcher()
    if err != nil {
@@ -52,6 +109,9 @@ func StartWatcher(root string) error {
    // Walk initial directories
    filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
        if err == nil && info.IsDir() {
+           if isStateDir(root, path) {
+               return filepath.SkipDir
+           }
            watcher.Add(path)
        }
        return nil
@@ -64,7 +124,11 @@ func StartWatcher(root string) error {
                if !ok {
                    return
                }
+               if isStateDir(root, event.Name) {
+                   continue
+               }
                if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename|fsnotify.Chmod) != 0 {
+                   invalidateDirStats(event.Name)
                    broadcastEvent(event.Name)
                    if event.Op&fsnotify.Create != 0 {
                        if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
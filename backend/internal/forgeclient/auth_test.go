@@ -0,0 +1,119 @@
+package forgeclient
+
+import (
+   "context"
+   "crypto/ecdsa"
+   "crypto/elliptic"
+   "crypto/rand"
+   "crypto/tls"
+   "crypto/x509"
+   "crypto/x509/pkix"
+   "encoding/pem"
+   "math/big"
+   "net/http"
+   "net/http/httptest"
+   "os"
+   "path/filepath"
+   "strings"
+   "testing"
+   "time"
+)
+
+// writeClientCert creates a self-signed client certificate and key in dir.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+   key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+   if err != nil {
+       t.Fatal(err)
+   }
+   tmpl := &x509.Certificate{
+       SerialNumber:          big.NewInt(1),
+       Subject:               pkix.Name{CommonName: "image-processor"},
+       NotBefore:             time.Now().Add(-time.Hour),
+       NotAfter:              time.Now().Add(time.Hour),
+       KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+       ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+       IsCA:                  true,
+       BasicConstraintsValid: true,
+   }
+   der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+   if err != nil {
+       t.Fatal(err)
+   }
+   cert, _ = x509.ParseCertificate(der)
+   keyDER, _ := x509.MarshalECPrivateKey(key)
+   certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+   os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+   os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+   return certFile, keyFile, cert
+}
+
+func TestCredentialsAndTLS(t *testing.T) {
+   dir := t.TempDir()
+   certFile, keyFile, clientCert := writeClientCert(t, dir)
+   var gotAuth, gotKey string
+   srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       gotAuth, gotKey = r.Header.Get("Authorization"), r.Header.Get("X-Api-Key")
+       if r.URL.Path == "/sdapi/v1/options" {
+           // a misbehaving proxy echoing the request headers
+           http.Error(w, "denied: "+gotAuth+" "+gotKey, http.StatusUnauthorized)
+           return
+       }
+       w.Write([]byte(`{}`))
+   }))
+   clientCAs := x509.NewCertPool()
+   clientCAs.AddCert(clientCert)
+   srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+   srv.StartTLS()
+   defer srv.Close()
+   caFile := filepath.Join(dir, "ca.pem")
+   os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+
+   tlsCfg, err := LoadTLSConfig(caFile, certFile, keyFile)
+   if err != nil {
+       t.Fatal(err)
+   }
+   headers, err := ParseHeaders("X-Api-Key: proxy-secret-key; X-Debug: 1")
+   if err != nil {
+       t.Fatal(err)
+   }
+   // Credentials in the URL are used for basic auth
+   withUser := strings.Replace(srv.URL, "https://", "https://forge:hunter22@", 1)
+   c := NewClientWithOptions(withUser, ClientOptions{RequestTimeout: 5 * time.Second, TLS: tlsCfg, Credentials: Credentials{Headers: headers}})
+   if err := c.Ping(context.Background()); err != nil {
+       t.Fatalf("ping over mutual TLS failed: %v", err)
+   }
+   if gotAuth != "Basic Zm9yZ2U6aHVudGVyMjI=" || gotKey != "proxy-secret-key" {
+       t.Errorf("unexpected credentials sent: %q %q", gotAuth, gotKey)
+   }
+   _, err = c.Options(context.Background())
+   if err == nil || !strings.Contains(err.Error(), "status 401") {
+       t.Fatalf("expected a 401, got %v", err)
+   }
+   for _, secret := range []string{"hunter22", "Zm9yZ2U6aHVudGVyMjI=", "proxy-secret-key"} {
+       if strings.Contains(err.Error(), secret) {
+           t.Errorf("error leaks %q: %v", secret, err)
+       }
+   }
+
+   // Bearer tokens take precedence and are redacted too
+   c = NewClientWithOptions(srv.URL, ClientOptions{TLS: tlsCfg, Credentials: Credentials{Username: "u", Password: "p", Token: "tok-123456"}})
+   if _, err := c.Options(context.Background()); err == nil || strings.Contains(err.Error(), "tok-123456") || gotAuth != "Bearer tok-123456" {
+       t.Errorf("expected a redacted 401 after sending the token, got %v (sent %q)", err, gotAuth)
+   }
+
+   // Without the private CA the server is not trusted; the URL's password is not echoed
+   c = NewClientWithOptions(withUser, ClientOptions{Retries: 0})
+   if err := c.Ping(context.Background()); err == nil || strings.Contains(err.Error(), "hunter22") {
+       t.Errorf("expected an untrusted certificate error without credentials, got %v", err)
+   }
+   if p := NewPool([]string{withUser}, time.Minute, ClientOptions{}); p.Servers()[0].URL != srv.URL {
+       t.Errorf("server status should not show credentials: %s", p.Servers()[0].URL)
+   }
+
+   if _, err := ParseHeaders("X-Api-Key proxy-secret-key"); err == nil || strings.Contains(err.Error(), "proxy-secret-key") {
+       t.Errorf("expected a malformed header error without the value, got %v", err)
+   }
+   if _, err := LoadTLSConfig("", certFile, ""); err == nil {
+       t.Errorf("expected an error for a certificate without key")
+   }
+}
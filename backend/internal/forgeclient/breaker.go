@@ -0,0 +1,75 @@
+package forgeclient
+
+import (
+   "sync"
+   "time"
+)
+
+// breaker is a circuit breaker: after threshold consecutive failures it opens and calls
+// fail fast for cooldown, after which a single trial call decides whether it closes again
+// or stays open for another cooldown.
+type breaker struct {
+   threshold int
+   cooldown  time.Duration
+   now       func() time.Time
+
+   mu        sync.Mutex
+   failures  int
+   openUntil time.Time
+   trial     bool
+}
+
+// newBreaker returns a breaker, or nil (never open) if threshold is not positive.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+   if threshold <= 0 {
+       return nil
+   }
+   return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a call may proceed. While open it refuses calls; once the
+// cooldown is over it lets one trial call through at a time.
+func (b *breaker) allow() bool {
+   if b == nil {
+       return true
+   }
+   b.mu.Lock()
+   defer b.mu.Unlock()
+   if b.failures < b.threshold {
+       return true
+   }
+   if b.trial || b.now().Before(b.openUntil) {
+       return false
+   }
+   b.trial = true
+   return true
+}
+
+// record reports the outcome of an allowed call.
+func (b *breaker) record(failed bool) {
+   if b == nil {
+       return
+   }
+   b.mu.Lock()
+   defer b.mu.Unlock()
+   b.trial = false
+   if !failed {
+       b.failures = 0
+       return
+   }
+   b.failures++
+   if b.failures >= b.threshold {
+       b.openUntil = b.now().Add(b.cooldown)
+   }
+}
+
+// release ends a trial call whose outcome says nothing about the server (the caller gave
+// up), letting the next call try instead.
+func (b *breaker) release() {
+   if b == nil {
+       return
+   }
+   b.mu.Lock()
+   b.trial = false
+   b.mu.Unlock()
+}
@@ -3,212 +3,436 @@ package forgeclient
 import (
    "bytes"
    "context"
+   "crypto/rand"
+   "crypto/tls"
+   "encoding/hex"
    "encoding/json"
+   "errors"
    "fmt"
    "io"
+   "log"
+   mrand "math/rand"
+   "net"
    "net/http"
    "strings"
+   "sync/atomic"
+   "time"
 )
 
+// ClientOptions configures the HTTP behaviour of a RealClient. Zero values disable the
+// corresponding feature; DefaultClientOptions gives sensible settings.
+type ClientOptions struct {
+   // GenerationTimeout bounds txt2img, img2img and extras calls.
+   GenerationTimeout time.Duration
+   // SwitchTimeout bounds checkpoint switches, which load a model.
+   SwitchTimeout time.Duration
+   // RequestTimeout bounds every other call (listings, options, progress, ping, ...).
+   RequestTimeout time.Duration
+   // Retries is how often idempotent calls are retried when the server is unavailable.
+   Retries int
+   // RetryBackoff is the delay before the first retry; it doubles with each retry.
+   RetryBackoff time.Duration
+   // BreakerThreshold is the number of consecutive failures after which calls fail fast.
+   BreakerThreshold int
+   // BreakerCooldown is how long calls fail fast before the server is tried again.
+   BreakerCooldown time.Duration
+   // DialTimeout bounds establishing a connection.
+   DialTimeout time.Duration
+   // MaxIdleConnsPerHost is the number of keep-alive connections kept to the server.
+   MaxIdleConnsPerHost int
+   // Credentials authenticate every request; credentials in the base URL are used too.
+   Credentials Credentials
+   // TLS configures HTTPS (CA bundle, client certificate); nil uses the system defaults.
+   TLS *tls.Config
+}
+
+// DefaultClientOptions returns the options NewClient uses.
+func DefaultClientOptions() ClientOptions {
+   return ClientOptions{
+       GenerationTimeout:   10 * time.Minute,
+       SwitchTimeout:       5 * time.Minute,
+       RequestTimeout:      30 * time.Second,
+       Retries:             2,
+       RetryBackoff:        250 * time.Millisecond,
+       BreakerThreshold:    5,
+       BreakerCooldown:     30 * time.Second,
+       DialTimeout:         10 * time.Second,
+       MaxIdleConnsPerHost: 8,
+   }
+}
+
+// maxRetryBackoff caps the delay between retries.
+const maxRetryBackoff = 5 * time.Second
+
 // RealClient is the production implementation of Client that calls an SD-Forge server.
 type RealClient struct {
    baseURL string
+   opts    ClientOptions
+   creds   Credentials
+   secrets []string
+   http    *http.Client
+   breaker *breaker
 }
 
-// NewClient returns a new RealClient targeting the given baseURL.
+// NewClient returns a new RealClient targeting the given baseURL with DefaultClientOptions.
 func NewClient(baseURL string) Client {
-   return &RealClient{baseURL: baseURL}
+   return NewClientWithOptions(baseURL, DefaultClientOptions())
 }
 
-// Txt2Img sends a text-to-image request to the SD-Forge server.
-func (c *RealClient) Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error) {
-   // prepare request JSON
-   data, err := json.Marshal(req)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: marshal Txt2ImgRequest: %w", err)
+// NewClientWithOptions returns a new RealClient targeting the given baseURL.
+func NewClientWithOptions(baseURL string, opts ClientOptions) Client {
+   baseURL, creds := splitUserinfo(baseURL, opts.Credentials)
+   return &RealClient{
+       baseURL: strings.TrimRight(baseURL, "/"),
+       opts:    opts,
+       creds:   creds,
+       secrets: creds.secrets(),
+       http:    newHTTPClient(opts),
+       breaker: newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
    }
-   // build HTTP request
-   url := strings.TrimRight(c.baseURL, "/") + "/sdapi/v1/txt2img"
-   httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: new request txt2img: %w", err)
+}
+
+// newHTTPClient returns an HTTP client with the connection settings of opts (dial
+// timeout, keep-alive connections, TLS); per-call timeouts are up to its user.
+func newHTTPClient(opts ClientOptions) *http.Client {
+   transport := http.DefaultTransport.(*http.Transport).Clone()
+   transport.DialContext = (&net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+   if opts.MaxIdleConnsPerHost > 0 {
+       transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
    }
-   httpReq.Header.Set("Content-Type", "application/json")
-   // execute
-   resp, err := http.DefaultClient.Do(httpReq)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: txt2img request failed: %w", err)
+   if opts.TLS != nil {
+       transport.TLSClientConfig = opts.TLS.Clone()
    }
-   defer resp.Body.Close()
-   if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-       body, _ := io.ReadAll(resp.Body)
-       return nil, fmt.Errorf("forgeclient: txt2img status %d: %s", resp.StatusCode, string(body))
+   return &http.Client{Transport: transport}
+}
+
+// call describes one SD-Forge API call.
+type call struct {
+   op      string
+   method  string
+   path    string
+   in      interface{}   // JSON payload, if any
+   out     interface{}   // decoded JSON response, if wanted
+   timeout time.Duration // zero for none
+   retry   bool          // idempotent: retry while the server is unavailable
+   // generation marks long-running calls whose timeouts do not trip the circuit breaker
+   generation bool
+   // onResponse, if set, is called once the response headers arrive
+   onResponse func()
+}
+
+// Txt2Img sends a text-to-image request to the SD-Forge server.
+func (c *RealClient) Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error) {
+   r := *req
+   if r.ForceTaskID == "" {
+       r.ForceTaskID = newTaskID()
    }
-   // decode response
    var out ImageResponse
-   if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-       return nil, fmt.Errorf("forgeclient: decode Txt2Img response: %w", err)
+   if err := c.generate(ctx, "txt2img", "/sdapi/v1/txt2img", r.ForceTaskID, &r, &out); err != nil {
+       return nil, err
    }
    return &out, nil
 }
 
 // Img2Img sends an image-to-image (inpainting) request to the SD-Forge server.
 func (c *RealClient) Img2Img(ctx context.Context, req *Img2ImgRequest) (*ImageResponse, error) {
-   data, err := json.Marshal(req)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: marshal Img2ImgRequest: %w", err)
+   r := *req
+   if r.ForceTaskID == "" {
+       r.ForceTaskID = newTaskID()
    }
-   url := strings.TrimRight(c.baseURL, "/") + "/sdapi/v1/img2img"
-   httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: new request img2img: %w", err)
+   var out ImageResponse
+   if err := c.generate(ctx, "img2img", "/sdapi/v1/img2img", r.ForceTaskID, &r, &out); err != nil {
+       return nil, err
    }
-   httpReq.Header.Set("Content-Type", "application/json")
-   resp, err := http.DefaultClient.Do(httpReq)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: img2img request failed: %w", err)
+   return &out, nil
+}
+
+// generate runs a generation call. If the caller gives up before SD-Forge answers, the
+// server is interrupted, but only while task is the job it is running: an interrupt stops
+// whatever runs, which may be another user's work while this request is still queued.
+// Calls without a task are never interrupted. Running into the generation timeout is
+// handled like a caller giving up; it does not count against the circuit breaker, since a
+// long queue or batch says nothing about the server's health.
+func (c *RealClient) generate(ctx context.Context, op, path, task string, in, out interface{}) error {
+   var answered atomic.Bool
+   stop := func() bool { return false }
+   if task != "" {
+       stop = context.AfterFunc(ctx, func() {
+           if !answered.Load() {
+               c.interruptTask(task)
+           }
+       })
    }
-   defer resp.Body.Close()
-   if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-       body, _ := io.ReadAll(resp.Body)
-       return nil, fmt.Errorf("forgeclient: img2img status %d: %s", resp.StatusCode, string(body))
+   defer stop()
+   onResponse := func() {
+       answered.Store(true)
+       stop()
    }
-   var out ImageResponse
-   if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-       return nil, fmt.Errorf("forgeclient: decode Img2Img response: %w", err)
+   err := c.do(ctx, call{op: op, method: http.MethodPost, path: path, in: in, out: out, timeout: c.opts.GenerationTimeout, generation: true, onResponse: onResponse})
+   if errors.Is(err, ErrTimeout) && task != "" && !answered.Load() {
+       c.interruptTask(task)
    }
-   return &out, nil
+   return err
 }
 
 // Progress retrieves the current queue progress from the SD-Forge server.
 func (c *RealClient) Progress(ctx context.Context, skipCurrent bool) (*ProgressResponse, error) {
    // build URL with optional skip_current_image flag
-   base := strings.TrimRight(c.baseURL, "/") + "/sdapi/v1/progress"
+   path := "/sdapi/v1/progress"
    if skipCurrent {
-       base += "?skip_current_image=true"
-   }
-   httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, base, nil)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: new request progress: %w", err)
-   }
-   resp, err := http.DefaultClient.Do(httpReq)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: progress request failed: %w", err)
-   }
-   defer resp.Body.Close()
-   if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-       body, _ := io.ReadAll(resp.Body)
-       return nil, fmt.Errorf("forgeclient: progress status %d: %s", resp.StatusCode, string(body))
+       path += "?skip_current_image=true"
    }
    var out ProgressResponse
-   if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-       return nil, fmt.Errorf("forgeclient: decode Progress response: %w", err)
+   if err := c.get(ctx, "progress", path, &out); err != nil {
+       return nil, err
    }
    return &out, nil
 }
-// Extras calls single-image extra operations on SD-Forge.
-func (c *RealClient) Extras(ctx context.Context, operation, image string) (*ImageResponse, error) {
-   return nil, fmt.Errorf("forgeclient: Extras operation not implemented")
+
+// Extras runs upscaling and face restoration on a single image.
+func (c *RealClient) Extras(ctx context.Context, req *ExtrasRequest) (*ExtrasResponse, error) {
+   var out ExtrasResponse
+   if err := c.generate(ctx, "Extras", "/sdapi/v1/extra-single-image", "", req, &out); err != nil {
+       return nil, err
+   }
+   return &out, nil
 }
 
-// ExtrasBatch calls batch extra operations on SD-Forge.
-func (c *RealClient) ExtrasBatch(ctx context.Context, operations []string, images []string) ([]ImageResponse, error) {
-   return nil, fmt.Errorf("forgeclient: ExtrasBatch operation not implemented")
+// ExtrasBatch runs upscaling and face restoration on several images with the same options.
+func (c *RealClient) ExtrasBatch(ctx context.Context, req *ExtrasBatchRequest) (*ExtrasBatchResponse, error) {
+   var out ExtrasBatchResponse
+   if err := c.generate(ctx, "ExtrasBatch", "/sdapi/v1/extra-batch-images", "", req, &out); err != nil {
+       return nil, err
+   }
+   return &out, nil
 }
 
 // Models retrieves available models from SD-Forge.
 func (c *RealClient) Models(ctx context.Context) ([]ModelInfo, error) {
-   url := strings.TrimRight(c.baseURL, "/") + "/sdapi/v1/sd-models"
-   httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: new request models: %w", err)
-   }
-   resp, err := http.DefaultClient.Do(httpReq)
-   if err != nil {
-       return nil, fmt.Errorf("forgeclient: models request failed: %w", err)
-   }
-   defer resp.Body.Close()
-   if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-       body, _ := io.ReadAll(resp.Body)
-       return nil, fmt.Errorf("forgeclient: models status %d: %s", resp.StatusCode, string(body))
-   }
    var out []ModelInfo
-   if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-       return nil, fmt.Errorf("forgeclient: decode Models response: %w", err)
+   if err := c.get(ctx, "models", "/sdapi/v1/sd-models", &out); err != nil {
+       return nil, err
    }
    return out, nil
 }
 
 // SwitchModel instructs SD-Forge to switch to a checkpoint via options API.
 func (c *RealClient) SwitchModel(ctx context.Context, model string) error {
-   // send option to change checkpoint
+   // send option to change checkpoint; setting the same option twice is harmless
    payload := map[string]string{"sd_model_checkpoint": model}
-   data, err := json.Marshal(payload)
-   if err != nil {
-       return fmt.Errorf("forgeclient: marshal SwitchModel payload: %w", err)
+   return c.do(ctx, call{op: "SwitchModel", method: http.MethodPost, path: "/sdapi/v1/options", in: payload, timeout: c.opts.SwitchTimeout, retry: true})
+}
+
+// Loras retrieves available LoRAs from SD-Forge, deriving trigger words from their metadata.
+func (c *RealClient) Loras(ctx context.Context) ([]LoraInfo, error) {
+   var out []LoraInfo
+   if err := c.get(ctx, "Loras", "/sdapi/v1/loras", &out); err != nil {
+       return nil, err
    }
-   url := strings.TrimRight(c.baseURL, "/") + "/sdapi/v1/options"
-   httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
-   if err != nil {
-       return fmt.Errorf("forgeclient: new request SwitchModel: %w", err)
+   for i := range out {
+       out[i].TriggerWords = TriggerWords(out[i].Metadata, maxTriggerWords)
    }
-   httpReq.Header.Set("Content-Type", "application/json")
-   resp, err := http.DefaultClient.Do(httpReq)
-   if err != nil {
-       return fmt.Errorf("forgeclient: SwitchModel request failed: %w", err)
+   return out, nil
+}
+
+// RefreshLoras asks SD-Forge to rescan its LoRA directory.
+func (c *RealClient) RefreshLoras(ctx context.Context) error {
+   return c.do(ctx, call{op: "RefreshLoras", method: http.MethodPost, path: "/sdapi/v1/refresh-loras", timeout: c.opts.RequestTimeout, retry: true})
+}
+
+// Ping checks health of SD-Forge.
+func (c *RealClient) Ping(ctx context.Context) error {
+   return c.get(ctx, "Ping", "/internal/ping", nil)
+}
+
+// Interrupt stops the generation currently running on SD-Forge.
+func (c *RealClient) Interrupt(ctx context.Context) error {
+   return c.do(ctx, call{op: "Interrupt", method: http.MethodPost, path: "/sdapi/v1/interrupt", timeout: c.opts.RequestTimeout})
+}
+
+// Skip skips the current image of a batch on SD-Forge and continues with the next.
+func (c *RealClient) Skip(ctx context.Context) error {
+   return c.do(ctx, call{op: "Skip", method: http.MethodPost, path: "/sdapi/v1/skip", timeout: c.opts.RequestTimeout})
+}
+
+// Samplers lists the samplers supported by SD-Forge.
+func (c *RealClient) Samplers(ctx context.Context) ([]SamplerInfo, error) {
+   var out []SamplerInfo
+   err := c.get(ctx, "Samplers", "/sdapi/v1/samplers", &out)
+   return out, err
+}
+
+// Schedulers lists the noise schedules supported by SD-Forge.
+func (c *RealClient) Schedulers(ctx context.Context) ([]SchedulerInfo, error) {
+   var out []SchedulerInfo
+   err := c.get(ctx, "Schedulers", "/sdapi/v1/schedulers", &out)
+   return out, err
+}
+
+// Upscalers lists the available upscalers.
+func (c *RealClient) Upscalers(ctx context.Context) ([]UpscalerInfo, error) {
+   var out []UpscalerInfo
+   err := c.get(ctx, "Upscalers", "/sdapi/v1/upscalers", &out)
+   return out, err
+}
+
+// VAEs lists the available VAE files.
+func (c *RealClient) VAEs(ctx context.Context) ([]VAEInfo, error) {
+   var out []VAEInfo
+   err := c.get(ctx, "VAEs", "/sdapi/v1/sd-vae", &out)
+   return out, err
+}
+
+// Embeddings lists textual inversion embeddings for the current model.
+func (c *RealClient) Embeddings(ctx context.Context) (*EmbeddingsResponse, error) {
+   var out EmbeddingsResponse
+   if err := c.get(ctx, "Embeddings", "/sdapi/v1/embeddings", &out); err != nil {
+       return nil, err
    }
-   defer resp.Body.Close()
-   if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-       body, _ := io.ReadAll(resp.Body)
-       return fmt.Errorf("forgeclient: SwitchModel status %d: %s", resp.StatusCode, string(body))
+   return &out, nil
+}
+
+// PromptStyles lists the saved prompt styles.
+func (c *RealClient) PromptStyles(ctx context.Context) ([]PromptStyle, error) {
+   var out []PromptStyle
+   err := c.get(ctx, "PromptStyles", "/sdapi/v1/prompt-styles", &out)
+   return out, err
+}
+
+// Options returns the current server options (checkpoint, VAE, CLIP skip, ...).
+func (c *RealClient) Options(ctx context.Context) (map[string]interface{}, error) {
+   var out map[string]interface{}
+   err := c.get(ctx, "Options", "/sdapi/v1/options", &out)
+   return out, err
+}
+
+// get performs an idempotent GET on path and decodes the JSON response into out, if given.
+func (c *RealClient) get(ctx context.Context, op, path string, out interface{}) error {
+   return c.do(ctx, call{op: op, method: http.MethodGet, path: path, out: out, timeout: c.opts.RequestTimeout, retry: true})
+}
+
+// do performs a call through the circuit breaker, retrying idempotent calls with
+// exponential backoff while the server is unavailable.
+func (c *RealClient) do(ctx context.Context, cl call) error {
+   var payload []byte
+   if cl.in != nil {
+       var err error
+       if payload, err = json.Marshal(cl.in); err != nil {
+           return fmt.Errorf("forgeclient: marshal %s payload: %w", cl.op, err)
+       }
+   }
+   backoff := c.opts.RetryBackoff
+   for attempt := 0; ; attempt++ {
+       if !c.breaker.allow() {
+           return &RequestError{Op: cl.op, Kind: ErrCircuitOpen, Err: ErrCircuitOpen}
+       }
+       err := redact(c.attempt(ctx, cl, payload), c.secrets)
+       // callers giving up and slow generations say nothing about the server's health
+       if ctx.Err() != nil || (cl.generation && errors.Is(err, ErrTimeout)) {
+           c.breaker.release()
+       } else {
+           c.breaker.record(countsAsFailure(err))
+       }
+       // timeouts are not retried: the time budget of the call is spent
+       if err == nil || !cl.retry || attempt >= c.opts.Retries || ctx.Err() != nil || !isUnavailable(err) {
+           return err
+       }
+       // full jitter keeps clients that failed together from retrying together
+       delay := time.Duration(mrand.Int63n(int64(backoff) + 1))
+       log.Printf("forgeclient: %v; retrying in %s", err, delay.Round(time.Millisecond))
+       select {
+       case <-ctx.Done():
+           return err
+       case <-time.After(delay):
+       }
+       backoff = min(2*backoff, maxRetryBackoff)
    }
-   return nil
 }
 
-// Loras retrieves available hypernetworks from SD-Forge.
-func (c *RealClient) Loras(ctx context.Context) ([]LoraInfo, error) {
-   url := strings.TrimRight(c.baseURL, "/") + "/sdapi/v1/hypernetworks"
-   httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// isUnavailable reports whether err means the server is unavailable rather than slow.
+func isUnavailable(err error) bool {
+   return errors.Is(err, ErrUnavailable)
+}
+
+// attempt performs one HTTP round trip of a call within its timeout.
+func (c *RealClient) attempt(ctx context.Context, cl call, payload []byte) error {
+   opCtx := ctx
+   if cl.timeout > 0 {
+       var cancel context.CancelFunc
+       opCtx, cancel = context.WithTimeout(ctx, cl.timeout)
+       defer cancel()
+   }
+   var body io.Reader
+   if payload != nil {
+       body = bytes.NewReader(payload)
+   }
+   httpReq, err := http.NewRequestWithContext(opCtx, cl.method, c.baseURL+cl.path, body)
    if err != nil {
-       return nil, fmt.Errorf("forgeclient: new request Loras: %w", err)
+       return fmt.Errorf("forgeclient: new request %s: %w", cl.op, err)
+   }
+   if payload != nil {
+       httpReq.Header.Set("Content-Type", "application/json")
    }
-   resp, err := http.DefaultClient.Do(httpReq)
+   c.creds.apply(httpReq)
+   resp, err := c.http.Do(httpReq)
    if err != nil {
-       return nil, fmt.Errorf("forgeclient: Loras request failed: %w", err)
+       return transportError(ctx, opCtx, cl.op, cl.timeout, err)
    }
    defer resp.Body.Close()
+   if cl.onResponse != nil {
+       cl.onResponse()
+   }
    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-       body, _ := io.ReadAll(resp.Body)
-       return nil, fmt.Errorf("forgeclient: Loras status %d: %s", resp.StatusCode, string(body))
+       data, _ := io.ReadAll(resp.Body)
+       return statusError(cl.op, resp.StatusCode, string(data))
    }
-   var out []LoraInfo
-   if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-       return nil, fmt.Errorf("forgeclient: decode Loras response: %w", err)
+   if cl.out == nil {
+       return nil
    }
-   return out, nil
+   if err := json.NewDecoder(resp.Body).Decode(cl.out); err != nil {
+       if opCtx.Err() != nil {
+           // the body was cut off by the timeout or the caller
+           return transportError(ctx, opCtx, cl.op, cl.timeout, err)
+       }
+       return fmt.Errorf("forgeclient: decode %s response: %w", cl.op, err)
+   }
+   return nil
 }
 
-// Ping checks health of SD-Forge.
-func (c *RealClient) Ping(ctx context.Context) error {
-   url := strings.TrimRight(c.baseURL, "/") + "/internal/ping"
-   httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// interruptTimeout bounds the requests sent to interrupt a canceled or timed out generation.
+const interruptTimeout = 5 * time.Second
+
+// interruptTask interrupts SD-Forge if task is the job it is running, independent of the
+// (already canceled) request context. A task still waiting in the queue is left alone.
+func (c *RealClient) interruptTask(task string) {
+   ctx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+   defer cancel()
+   p, err := c.taskProgress(ctx, task)
    if err != nil {
-       return fmt.Errorf("forgeclient: new request Ping: %w", err)
+       log.Printf("forgeclient: looking up %s to interrupt it failed: %v", task, err)
+       return
    }
-   resp, err := http.DefaultClient.Do(httpReq)
-   if err != nil {
-       return fmt.Errorf("forgeclient: Ping request failed: %w", err)
+   if !p.Active {
+       return
    }
-   defer resp.Body.Close()
-   if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-       body, _ := io.ReadAll(resp.Body)
-       return fmt.Errorf("forgeclient: Ping status %d: %s", resp.StatusCode, string(body))
+   if err := c.Interrupt(ctx); err != nil {
+       log.Printf("forgeclient: interrupting %s failed: %v", task, err)
    }
-   return nil
 }
 
-// History retrieves history entries for an image.
-func (c *RealClient) History(ctx context.Context, imageID string) ([]HistoryEntry, error) {
-   // not implemented: history via SD-Forge API unsupported
-   return nil, fmt.Errorf("forgeclient: History not implemented")
-}
\ No newline at end of file
+// taskProgress asks SD-Forge whether task is queued, running or done.
+func (c *RealClient) taskProgress(ctx context.Context, task string) (*TaskProgress, error) {
+   payload := map[string]interface{}{"id_task": task, "id_live_preview": -1, "live_preview": false}
+   var out TaskProgress
+   if err := c.do(ctx, call{op: "TaskProgress", method: http.MethodPost, path: "/internal/progress", in: payload, out: &out, timeout: c.opts.RequestTimeout}); err != nil {
+       return nil, err
+   }
+   return &out, nil
+}
+
+// newTaskID returns a random SD-Forge task ID in the format of its web UI.
+func newTaskID() string {
+   id := make([]byte, 8)
+   rand.Read(id)
+   return "task(" + hex.EncodeToString(id) + ")"
+}
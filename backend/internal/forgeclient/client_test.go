@@ -0,0 +1,251 @@
+package forgeclient
+
+import (
+   "context"
+   "encoding/json"
+   "errors"
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "sync/atomic"
+   "testing"
+   "time"
+)
+
+func TestCancellationInterruptsOnlyTheRunningTask(t *testing.T) {
+   for _, running := range []bool{true, false} {
+       started := make(chan string, 1)
+       checked := make(chan struct{}, 1)
+       interrupted := make(chan struct{}, 1)
+       srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+           switch r.URL.Path {
+           case "/sdapi/v1/txt2img":
+               var req Txt2ImgRequest
+               json.NewDecoder(r.Body).Decode(&req)
+               started <- req.ForceTaskID
+               <-r.Context().Done()
+           case "/internal/progress":
+               var req struct {
+                   IDTask string `json:"id_task"`
+               }
+               json.NewDecoder(r.Body).Decode(&req)
+               json.NewEncoder(w).Encode(TaskProgress{Active: running && req.IDTask != "", Queued: !running})
+               checked <- struct{}{}
+           case "/sdapi/v1/interrupt":
+               interrupted <- struct{}{}
+           default:
+               http.NotFound(w, r)
+           }
+       }))
+
+       c := NewClient(srv.URL)
+       ctx, cancel := context.WithCancel(context.Background())
+       errc := make(chan error, 1)
+       go func() {
+           _, err := c.Txt2Img(ctx, &Txt2ImgRequest{Prompt: "x"})
+           errc <- err
+       }()
+       if task := <-started; !strings.HasPrefix(task, "task(") {
+           t.Errorf("expected a task ID, got %q", task)
+       }
+       cancel()
+       if err := <-errc; err == nil {
+           t.Fatal("expected error from canceled request")
+       }
+       select {
+       case <-checked:
+       case <-time.After(5 * time.Second):
+           t.Fatal("the task was not looked up after cancellation")
+       }
+       select {
+       case <-interrupted:
+           if !running {
+               t.Error("a queued task must not interrupt the running one")
+           }
+       case <-time.After(200 * time.Millisecond):
+           if running {
+               t.Error("server was not interrupted after cancellation")
+           }
+       }
+       srv.Close()
+   }
+}
+
+func TestAnsweredGenerationIsNotInterrupted(t *testing.T) {
+   var lookups int32
+   body := make(chan struct{})
+   srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       switch r.URL.Path {
+       case "/sdapi/v1/txt2img":
+           // headers first, then a body that never completes
+           w.WriteHeader(http.StatusOK)
+           w.(http.Flusher).Flush()
+           select {
+           case <-body:
+           case <-r.Context().Done():
+           }
+       default:
+           atomic.AddInt32(&lookups, 1)
+       }
+   }))
+   defer srv.Close()
+   defer close(body)
+
+   ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+   defer cancel()
+   if _, err := NewClient(srv.URL).Txt2Img(ctx, &Txt2ImgRequest{}); err == nil {
+       t.Fatal("expected error from canceled request")
+   }
+   time.Sleep(50 * time.Millisecond)
+   if n := atomic.LoadInt32(&lookups); n != 0 {
+       t.Errorf("expected no interrupt once SD-Forge answered, got %d calls", n)
+   }
+}
+
+func TestSkipStatusError(t *testing.T) {
+   srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       if r.Method != http.MethodPost || r.URL.Path != "/sdapi/v1/skip" {
+           http.NotFound(w, r)
+           return
+       }
+       http.Error(w, "busy", http.StatusInternalServerError)
+   }))
+   defer srv.Close()
+
+   if err := NewClient(srv.URL).Skip(context.Background()); err == nil {
+       t.Fatal("expected error for non-2xx skip response")
+   }
+}
+
+func TestLorasDerivesTriggerWords(t *testing.T) {
+   srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       if r.URL.Path != "/sdapi/v1/loras" {
+           http.NotFound(w, r)
+           return
+       }
+       w.Write([]byte(`[
+           {"name": "a", "alias": "alias_a", "path": "/l/a.safetensors",
+            "metadata": {"ss_tag_frequency": {"10_set": {"red hair": 3, "smile": 9}, "5_other": {"red hair": 8}}}},
+           {"name": "b", "alias": "b", "path": "/l/b.safetensors",
+            "metadata": {"ss_tag_frequency": "{\"set\": {\"pixel\": 2}}"}},
+           {"name": "c", "alias": "c", "path": "/l/c.pt", "metadata": {}}
+       ]`))
+   }))
+   defer srv.Close()
+
+   loras, err := NewClient(srv.URL).Loras(context.Background())
+   if err != nil {
+       t.Fatalf("Loras: %v", err)
+   }
+   if len(loras) != 3 || loras[0].Alias != "alias_a" || loras[0].Path != "/l/a.safetensors" {
+       t.Fatalf("unexpected loras: %+v", loras)
+   }
+   if got := loras[0].TriggerWords; len(got) != 2 || got[0] != "red hair" || got[1] != "smile" {
+       t.Errorf("unexpected trigger words: %v", got)
+   }
+   if got := loras[1].TriggerWords; len(got) != 1 || got[0] != "pixel" {
+       t.Errorf("expected trigger words from string metadata, got %v", got)
+   }
+   if loras[2].TriggerWords != nil {
+       t.Errorf("expected no trigger words, got %v", loras[2].TriggerWords)
+   }
+}
+
+func TestRetriesAndCircuitBreaker(t *testing.T) {
+   var hits, failures int32
+   srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       atomic.AddInt32(&hits, 1)
+       if atomic.AddInt32(&failures, -1) >= 0 {
+           http.Error(w, "starting up", http.StatusServiceUnavailable)
+           return
+       }
+       w.Write([]byte(`[{"model_name": "m"}]`))
+   }))
+   defer srv.Close()
+   opts := ClientOptions{RequestTimeout: time.Second, Retries: 2, RetryBackoff: time.Millisecond, BreakerThreshold: 3, BreakerCooldown: 50 * time.Millisecond}
+   c := NewClientWithOptions(srv.URL, opts)
+
+   // Idempotent calls are retried while the server is unavailable
+   atomic.StoreInt32(&failures, 2)
+   if models, err := c.Models(context.Background()); err != nil || len(models) != 1 || atomic.LoadInt32(&hits) != 3 {
+       t.Fatalf("expected success on the third attempt, got %v (%v) after %d hits", models, err, hits)
+   }
+   // Generations are not
+   atomic.StoreInt32(&hits, 0)
+   atomic.StoreInt32(&failures, 1)
+   if _, err := c.Txt2Img(context.Background(), &Txt2ImgRequest{}); !errors.Is(err, ErrUnavailable) || atomic.LoadInt32(&hits) != 1 {
+       t.Fatalf("expected one failed txt2img attempt, got %v after %d hits", err, hits)
+   }
+
+   // Three more failures in a row open the circuit: calls fail fast without reaching the server
+   atomic.StoreInt32(&failures, 100)
+   c = NewClientWithOptions(srv.URL, ClientOptions{RequestTimeout: time.Second, BreakerThreshold: 3, BreakerCooldown: 50 * time.Millisecond})
+   for i := 0; i < 3; i++ {
+       c.Ping(context.Background())
+   }
+   atomic.StoreInt32(&hits, 0)
+   err := c.Ping(context.Background())
+   if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrUnavailable) || atomic.LoadInt32(&hits) != 0 {
+       t.Fatalf("expected the open circuit to fail fast, got %v after %d hits", err, hits)
+   }
+   // After the cooldown a trial call goes through and closes the circuit again
+   atomic.StoreInt32(&failures, 0)
+   time.Sleep(60 * time.Millisecond)
+   if err := c.Ping(context.Background()); err != nil || atomic.LoadInt32(&hits) != 1 {
+       t.Fatalf("expected the trial call to succeed, got %v after %d hits", err, hits)
+   }
+   if err := c.Ping(context.Background()); err != nil {
+       t.Errorf("circuit should be closed: %v", err)
+   }
+}
+
+func TestOperationTimeouts(t *testing.T) {
+   var hits int32
+   interrupted := make(chan struct{}, 1)
+   release := make(chan struct{})
+   srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       switch r.URL.Path {
+       case "/sdapi/v1/interrupt":
+           interrupted <- struct{}{}
+           return
+       case "/internal/progress":
+           w.Write([]byte(`{"active": true}`))
+           return
+       }
+       atomic.AddInt32(&hits, 1)
+       select {
+       case <-r.Context().Done():
+       case <-release:
+       }
+   }))
+   defer srv.Close()
+   defer close(release)
+   c := NewClientWithOptions(srv.URL, ClientOptions{GenerationTimeout: 50 * time.Millisecond, RequestTimeout: 50 * time.Millisecond, Retries: 3, RetryBackoff: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Minute})
+
+   // Timeouts are typed and not retried
+   err := c.Ping(context.Background())
+   if !errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable) || atomic.LoadInt32(&hits) != 1 {
+       t.Fatalf("expected a single timed out attempt, got %v after %d hits", err, hits)
+   }
+   // A generation running into its timeout is interrupted on the server
+   if _, err := c.Txt2Img(context.Background(), &Txt2ImgRequest{}); !errors.Is(err, ErrTimeout) {
+       t.Fatalf("expected ErrTimeout, got %v", err)
+   }
+   select {
+   case <-interrupted:
+   case <-time.After(time.Second):
+       t.Fatal("server was not interrupted after the generation timed out")
+   }
+   // Generation timeouts do not open the circuit; the timed out ping above counts once
+   c.Txt2Img(context.Background(), &Txt2ImgRequest{})
+   <-interrupted
+   if err := c.Ping(context.Background()); errors.Is(err, ErrCircuitOpen) {
+       t.Errorf("generation timeouts should not open the circuit: %v", err)
+   }
+   // The caller giving up is neither a timeout nor unavailability
+   ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+   defer cancel()
+   if err := NewClient(srv.URL).Ping(ctx); err == nil || countsAsFailure(err) {
+       t.Errorf("expected an untyped error for a canceled call, got %v", err)
+   }
+}
@@ -0,0 +1,889 @@
+package forgeclient
+
+import (
+   "bytes"
+   "context"
+   "crypto/rand"
+   "encoding/base64"
+   "encoding/binary"
+   "encoding/hex"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "io"
+   "log"
+   "math"
+   mrand "math/rand"
+   "mime/multipart"
+   "net"
+   "net/http"
+   "net/url"
+   "path/filepath"
+   "sort"
+   "strings"
+   "sync"
+   "time"
+
+   "golang.org/x/net/websocket"
+)
+
+// ErrNotSupported is returned for operations the generation backend does not offer.
+var ErrNotSupported = errors.New("forgeclient: operation not supported by this backend")
+
+// ComfyOptions configures a ComfyClient.
+type ComfyOptions struct {
+   // WorkflowDir holds workflow templates (txt2img.json, img2img.json, inpaint.json)
+   // replacing the built-in ones; missing files fall back to the built-in templates.
+   WorkflowDir string
+   // Checkpoint is the checkpoint used until SwitchModel picks another; when empty the
+   // first checkpoint ComfyUI lists is used.
+   Checkpoint string
+   // Client holds the timeouts, credentials and TLS settings, as for SD-Forge.
+   // GenerationTimeout bounds waiting for a prompt, RequestTimeout every HTTP call.
+   // Retries and the circuit breaker are not used.
+   Client ClientOptions
+}
+
+// comfyPollInterval is how often the history is polled when the websocket is unavailable.
+const comfyPollInterval = 500 * time.Millisecond
+
+// ComfyClient implements Client on top of a ComfyUI server. Generations fill a workflow
+// template, queue it with /prompt, follow it over the /ws event stream (or by polling
+// /history) and download the outputs. SD-Forge parameters without a ComfyUI counterpart
+// in the template are ignored; extras and skipping are not supported.
+type ComfyClient struct {
+   baseURL  string
+   clientID string
+   opts     ComfyOptions
+   creds    Credentials
+   secrets  []string
+   http     *http.Client
+
+   mu      sync.Mutex
+   model   string
+   runs    int
+   prompts map[string]*comfyPrompt
+   queue   int
+}
+
+// comfyPrompt is the progress of a prompt queued by this client. ComfyUI runs one prompt
+// at a time; running is set once its events show it executing.
+type comfyPrompt struct {
+   running    bool
+   value, max int
+   preview    string
+}
+
+// NewComfyClient returns a ComfyClient targeting the ComfyUI server at baseURL.
+func NewComfyClient(baseURL string, opts ComfyOptions) *ComfyClient {
+   id := make([]byte, 16)
+   rand.Read(id)
+   baseURL, creds := splitUserinfo(baseURL, opts.Client.Credentials)
+   return &ComfyClient{
+       baseURL: strings.TrimRight(baseURL, "/"), clientID: hex.EncodeToString(id), opts: opts,
+       creds: creds, secrets: creds.secrets(), http: newHTTPClient(opts.Client), model: opts.Checkpoint,
+       prompts: map[string]*comfyPrompt{},
+   }
+}
+
+// comfyGeneration holds the parameters shared by txt2img and img2img.
+type comfyGeneration struct {
+   mode           string
+   prompt         string
+   negative       string
+   seed           *int
+   steps          int
+   cfg            float32
+   sampler        string
+   scheduler      string
+   width, height  int
+   batchSize      int
+   nIter          int
+   denoise        float32
+   overrides      map[string]interface{}
+   image, mask    string
+}
+
+// Txt2Img generates images from the txt2img workflow template.
+func (c *ComfyClient) Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error) {
+   return c.generate(ctx, &comfyGeneration{
+       mode: workflowTxt2Img, prompt: req.Prompt, negative: req.NegativePrompt, seed: req.Seed,
+       steps: req.Steps, cfg: req.CFGScale, sampler: req.SamplerName, scheduler: req.Scheduler,
+       width: req.Width, height: req.Height, batchSize: req.BatchSize, nIter: req.NIter,
+       denoise: 1, overrides: req.OverrideSettings,
+   })
+}
+
+// Img2Img uploads the init image (and mask) and generates from the img2img or inpaint
+// workflow template.
+func (c *ComfyClient) Img2Img(ctx context.Context, req *Img2ImgRequest) (*ImageResponse, error) {
+   if len(req.InitImages) == 0 {
+       return nil, errors.New("forgeclient: img2img needs an init image")
+   }
+   g := &comfyGeneration{
+       mode: workflowImg2Img, prompt: req.Prompt, negative: req.NegativePrompt, seed: req.Seed,
+       steps: req.Steps, cfg: req.CFGScale, sampler: req.SamplerName, scheduler: req.Scheduler,
+       width: req.Width, height: req.Height, batchSize: req.BatchSize, nIter: req.NIter,
+       denoise: req.DenoisingStrength, overrides: req.OverrideSettings,
+   }
+   if g.denoise == 0 {
+       g.denoise = 0.75
+   }
+   var err error
+   if g.image, err = c.upload(ctx, req.InitImages[0], "init"); err != nil {
+       return nil, err
+   }
+   if req.Mask != "" {
+       g.mode = workflowInpaint
+       if g.mask, err = c.upload(ctx, req.Mask, "mask"); err != nil {
+           return nil, err
+       }
+   }
+   return c.generate(ctx, g)
+}
+
+// generate runs the workflow for g once per batch (n_iter) and collects the outputs in an
+// SD-Forge shaped response.
+func (c *ComfyClient) generate(ctx context.Context, g *comfyGeneration) (*ImageResponse, error) {
+   checkpoint, _ := g.overrides["sd_model_checkpoint"].(string)
+   if checkpoint == "" {
+       var err error
+       if checkpoint, err = c.currentModel(ctx); err != nil {
+           return nil, err
+       }
+   }
+   prompt, loras := extractLoras(g.prompt)
+   var loraFiles map[string]string
+   if len(loras) > 0 {
+       available, err := c.Loras(ctx)
+       if err != nil {
+           return nil, err
+       }
+       loraFiles = map[string]string{}
+       for _, l := range available {
+           loraFiles[l.Name], loraFiles[l.Alias], loraFiles[l.Path] = l.Path, l.Path, l.Path
+       }
+   }
+   seed := int64(-1)
+   if g.seed != nil {
+       seed = int64(*g.seed)
+   }
+   if seed < 0 {
+       seed = mrand.Int63n(math.MaxUint32)
+   }
+   batch := max(g.batchSize, 1)
+   vars := map[string]interface{}{
+       "prompt":          prompt,
+       "negative_prompt": g.negative,
+       "steps":           valueOr(g.steps, 20),
+       "cfg":             float32(valueOr(float64(g.cfg), 7)),
+       "width":           valueOr(g.width, 512),
+       "height":          valueOr(g.height, 512),
+       "batch_size":      batch,
+       "denoise":         g.denoise,
+       "checkpoint":      checkpoint,
+       "image":           g.image,
+       "mask":            g.mask,
+   }
+   vars["sampler"], vars["scheduler"] = comfySampler(g.sampler, g.scheduler)
+
+   out := &ImageResponse{Images: []string{}}
+   info := map[string]interface{}{
+       "prompt": g.prompt, "negative_prompt": g.negative, "seed": seed,
+       "sd_model_name": strings.TrimSuffix(checkpoint, filepath.Ext(checkpoint)),
+       "index_of_first_image": 0, "width": vars["width"], "height": vars["height"],
+       "sampler_name": vars["sampler"], "cfg_scale": vars["cfg"], "steps": vars["steps"],
+   }
+   var seeds []int64
+   var infotexts []string
+   for i := 0; i < max(g.nIter, 1); i++ {
+       wf, err := loadWorkflow(c.opts.WorkflowDir, g.mode)
+       if err != nil {
+           return nil, err
+       }
+       vars["seed"] = seed + int64(i*batch)
+       if err := wf.fill(vars); err != nil {
+           return nil, err
+       }
+       if err := wf.addLoras(loras, loraFiles); err != nil {
+           return nil, err
+       }
+       images, err := c.run(ctx, wf)
+       if err != nil {
+           return nil, err
+       }
+       for j, img := range images {
+           s := seed + int64(i*batch+j)
+           seeds = append(seeds, s)
+           infotexts = append(infotexts, comfyInfotext(g.prompt, g.negative, vars["steps"].(int), vars["sampler"].(string), vars["scheduler"].(string), vars["cfg"].(float32), s, vars["width"].(int), vars["height"].(int), checkpoint))
+           out.Images = append(out.Images, base64.StdEncoding.EncodeToString(img))
+       }
+   }
+   info["all_seeds"], info["infotexts"] = seeds, infotexts
+   data, _ := json.Marshal(info)
+   out.Info = string(data)
+   return out, nil
+}
+
+// valueOr returns v, or def when v is zero.
+func valueOr[T int | float64](v, def T) T {
+   if v == 0 {
+       return def
+   }
+   return v
+}
+
+// comfyEvent is a JSON message on the ComfyUI websocket.
+type comfyEvent struct {
+   Type string `json:"type"`
+   Data struct {
+       PromptID         string  `json:"prompt_id"`
+       Node             *string `json:"node"`
+       Value            int     `json:"value"`
+       Max              int     `json:"max"`
+       ExceptionMessage string  `json:"exception_message"`
+       Status           struct {
+           ExecInfo struct {
+               QueueRemaining int `json:"queue_remaining"`
+           } `json:"exec_info"`
+       } `json:"status"`
+   } `json:"data"`
+}
+
+// comfyFrame is a websocket frame: JSON events are text, previews are binary.
+type comfyFrame struct {
+   data   []byte
+   binary bool
+}
+
+// frameCodec receives websocket frames keeping their payload type.
+var frameCodec = websocket.Codec{
+   Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+       f := v.(*comfyFrame)
+       f.data, f.binary = data, payloadType == websocket.BinaryFrame
+       return nil
+   },
+}
+
+// dialEvents opens the event stream for clientID, or returns nil if it is unavailable.
+func (c *ComfyClient) dialEvents(ctx context.Context, clientID string) *websocket.Conn {
+   u, err := url.Parse(c.baseURL)
+   if err != nil {
+       return nil
+   }
+   origin := *u
+   u.Scheme = map[string]string{"https": "wss"}[u.Scheme]
+   if u.Scheme == "" {
+       u.Scheme = "ws"
+   }
+   u.Path = strings.TrimRight(u.Path, "/") + "/ws"
+   u.RawQuery = "clientId=" + url.QueryEscape(clientID)
+   cfg, err := websocket.NewConfig(u.String(), origin.String())
+   if err != nil {
+       return nil
+   }
+   cfg.TlsConfig = c.opts.Client.TLS
+   cfg.Dialer = &net.Dialer{Timeout: c.opts.Client.DialTimeout}
+   c.creds.setHeaders(cfg.Header)
+   dialCtx, cancel := c.requestContext(ctx)
+   defer cancel()
+   ws, err := cfg.DialContext(dialCtx)
+   if err != nil {
+       err = redact(err, c.secrets)
+       log.Printf("forgeclient: ComfyUI websocket unavailable, polling instead: %v", err)
+       return nil
+   }
+   return ws
+}
+
+// run queues wf and waits for it, returning the output images in node order. Each run
+// uses its own client ID, so that ComfyUI sends it the events of its prompt only.
+func (c *ComfyClient) run(ctx context.Context, wf Workflow) ([][]byte, error) {
+   c.mu.Lock()
+   c.runs++
+   clientID := fmt.Sprintf("%s-%d", c.clientID, c.runs)
+   c.mu.Unlock()
+   ws := c.dialEvents(ctx, clientID)
+   if ws != nil {
+       defer ws.Close()
+   }
+   var queued struct {
+       PromptID string `json:"prompt_id"`
+   }
+   payload := map[string]interface{}{"prompt": wf, "client_id": clientID}
+   if err := c.postJSON(ctx, "/prompt", "prompt", payload, &queued); err != nil {
+       return nil, err
+   }
+   p := &comfyPrompt{}
+   c.mu.Lock()
+   c.prompts[queued.PromptID] = p
+   c.mu.Unlock()
+   defer func() {
+       c.mu.Lock()
+       delete(c.prompts, queued.PromptID)
+       c.mu.Unlock()
+   }()
+   runCtx, cancel := ctx, context.CancelFunc(func() {})
+   if t := c.opts.Client.GenerationTimeout; t > 0 {
+       runCtx, cancel = context.WithTimeout(ctx, t)
+   }
+   defer cancel()
+   var err error
+   if ws != nil {
+       err = c.watch(runCtx, ws, queued.PromptID, p)
+   } else {
+       err = c.poll(runCtx, queued.PromptID)
+   }
+   if err != nil {
+       if runCtx.Err() != nil {
+           c.cancelDetached(queued.PromptID)
+           if ctx.Err() == nil {
+               err = &RequestError{Op: "prompt", Kind: ErrTimeout, Err: fmt.Errorf("timed out after %s: %w", c.opts.Client.GenerationTimeout, err)}
+           }
+       }
+       return nil, err
+   }
+   return c.outputs(ctx, queued.PromptID)
+}
+
+// watch follows the event stream until the prompt finishes, recording progress and
+// previews in p.
+func (c *ComfyClient) watch(ctx context.Context, ws *websocket.Conn, promptID string, p *comfyPrompt) error {
+   frames := make(chan comfyFrame)
+   errc := make(chan error, 1)
+   go func() {
+       for {
+           var f comfyFrame
+           if err := frameCodec.Receive(ws, &f); err != nil {
+               errc <- err
+               return
+           }
+           select {
+           case frames <- f:
+           case <-ctx.Done():
+               return
+           }
+       }
+   }()
+   for {
+       select {
+       case <-ctx.Done():
+           return ctx.Err()
+       case err := <-errc:
+           // The stream dropped; the prompt keeps running, so fall back to polling
+           log.Printf("forgeclient: ComfyUI websocket closed, polling instead: %v", err)
+           return c.poll(ctx, promptID)
+       case f := <-frames:
+           if f.binary {
+               c.recordPreview(p, f.data)
+               continue
+           }
+           var ev comfyEvent
+           if json.Unmarshal(f.data, &ev) != nil {
+               continue
+           }
+           if ev.Type == "status" {
+               c.mu.Lock()
+               c.queue = ev.Data.Status.ExecInfo.QueueRemaining
+               c.mu.Unlock()
+               continue
+           }
+           if ev.Data.PromptID != promptID {
+               continue
+           }
+           switch ev.Type {
+           case "execution_start":
+               c.mu.Lock()
+               p.running = true
+               c.mu.Unlock()
+           case "progress":
+               c.mu.Lock()
+               p.running, p.value, p.max = true, ev.Data.Value, ev.Data.Max
+               c.mu.Unlock()
+           case "executing":
+               if ev.Data.Node == nil {
+                   return nil
+               }
+               c.mu.Lock()
+               p.running = true
+               c.mu.Unlock()
+           case "execution_success":
+               return nil
+           case "execution_error":
+               return fmt.Errorf("forgeclient: ComfyUI execution failed: %s", ev.Data.ExceptionMessage)
+           case "execution_interrupted":
+               return errors.New("forgeclient: ComfyUI execution interrupted")
+           }
+       }
+   }
+}
+
+// recordPreview keeps a binary preview frame of p (event type 1: format, then image data).
+func (c *ComfyClient) recordPreview(p *comfyPrompt, data []byte) {
+   if len(data) < 8 || binary.BigEndian.Uint32(data) != 1 {
+       return
+   }
+   c.mu.Lock()
+   p.preview = base64.StdEncoding.EncodeToString(data[8:])
+   c.mu.Unlock()
+}
+
+// comfyHistory is a /history entry of a prompt.
+type comfyHistory struct {
+   Outputs map[string]struct {
+       Images []struct {
+           Filename  string `json:"filename"`
+           Subfolder string `json:"subfolder"`
+           Type      string `json:"type"`
+       } `json:"images"`
+   } `json:"outputs"`
+   Status struct {
+       StatusStr string            `json:"status_str"`
+       Completed bool              `json:"completed"`
+       Messages  []json.RawMessage `json:"messages"`
+   } `json:"status"`
+}
+
+// history returns the history entry of a prompt, or nil if it has not finished.
+func (c *ComfyClient) history(ctx context.Context, promptID string) (*comfyHistory, error) {
+   var entries map[string]*comfyHistory
+   if err := c.getJSON(ctx, "/history/"+url.PathEscape(promptID), "history", &entries); err != nil {
+       return nil, err
+   }
+   return entries[promptID], nil
+}
+
+// poll waits for the prompt to appear in the history.
+func (c *ComfyClient) poll(ctx context.Context, promptID string) error {
+   ticker := time.NewTicker(comfyPollInterval)
+   defer ticker.Stop()
+   for {
+       h, err := c.history(ctx, promptID)
+       if err != nil {
+           return err
+       }
+       if h != nil && (h.Status.Completed || h.Status.StatusStr != "") {
+           return nil
+       }
+       select {
+       case <-ctx.Done():
+           return ctx.Err()
+       case <-ticker.C:
+       }
+   }
+}
+
+// outputs downloads the images a finished prompt saved, in node order.
+func (c *ComfyClient) outputs(ctx context.Context, promptID string) ([][]byte, error) {
+   h, err := c.history(ctx, promptID)
+   if err != nil {
+       return nil, err
+   }
+   if h == nil {
+       return nil, fmt.Errorf("forgeclient: ComfyUI prompt %s has no history", promptID)
+   }
+   if h.Status.StatusStr == "error" {
+       return nil, fmt.Errorf("forgeclient: ComfyUI execution failed: %s", bytes.Join(rawMessages(h.Status.Messages), []byte("; ")))
+   }
+   nodes := make([]string, 0, len(h.Outputs))
+   for id := range h.Outputs {
+       nodes = append(nodes, id)
+   }
+   sort.Strings(nodes)
+   var images [][]byte
+   for _, id := range nodes {
+       for _, img := range h.Outputs[id].Images {
+           if img.Type != "output" {
+               continue
+           }
+           q := url.Values{"filename": {img.Filename}, "subfolder": {img.Subfolder}, "type": {img.Type}}
+           data, err := c.getBytes(ctx, "/view?"+q.Encode(), "view")
+           if err != nil {
+               return nil, err
+           }
+           images = append(images, data)
+       }
+   }
+   return images, nil
+}
+
+// rawMessages converts history status messages for an error string.
+func rawMessages(msgs []json.RawMessage) [][]byte {
+   out := make([][]byte, len(msgs))
+   for i, m := range msgs {
+       out[i] = m
+   }
+   return out
+}
+
+// cancelDetached removes an abandoned prompt from the queue, or interrupts it if ComfyUI
+// is running it. An interrupt stops whatever runs, so it is only sent for this prompt.
+func (c *ComfyClient) cancelDetached(promptID string) {
+   ctx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+   defer cancel()
+   var ignored interface{}
+   if err := c.postJSON(ctx, "/queue", "queue", map[string]interface{}{"delete": []string{promptID}}, &ignored); err != nil {
+       log.Printf("forgeclient: removing canceled ComfyUI prompt failed: %v", err)
+   }
+   running, err := c.executing(ctx, promptID)
+   if err != nil {
+       log.Printf("forgeclient: looking up canceled ComfyUI prompt failed: %v", err)
+       return
+   }
+   if !running {
+       return
+   }
+   // newer servers only interrupt the prompt named; older ones ignore the body
+   if err := c.postJSON(ctx, "/interrupt", "Interrupt", map[string]string{"prompt_id": promptID}, &ignored); err != nil {
+       log.Printf("forgeclient: interrupt after cancellation failed: %v", err)
+   }
+}
+
+// executing reports whether ComfyUI is running promptID.
+func (c *ComfyClient) executing(ctx context.Context, promptID string) (bool, error) {
+   var queue struct {
+       Running [][]json.RawMessage `json:"queue_running"`
+   }
+   if err := c.getJSON(ctx, "/queue", "queue", &queue); err != nil {
+       return false, err
+   }
+   for _, item := range queue.Running {
+       var id string
+       if len(item) > 1 && json.Unmarshal(item[1], &id) == nil && id == promptID {
+           return true, nil
+       }
+   }
+   return false, nil
+}
+
+// upload stores a base64 image in ComfyUI's input directory and returns its name for
+// LoadImage nodes.
+func (c *ComfyClient) upload(ctx context.Context, b64, kind string) (string, error) {
+   if i := strings.Index(b64, ","); i >= 0 && strings.HasPrefix(b64, "data:") {
+       b64 = b64[i+1:]
+   }
+   data, err := base64.StdEncoding.DecodeString(b64)
+   if err != nil {
+       return "", fmt.Errorf("forgeclient: decode %s image: %w", kind, err)
+   }
+   id := make([]byte, 8)
+   rand.Read(id)
+   var body bytes.Buffer
+   mw := multipart.NewWriter(&body)
+   fw, err := mw.CreateFormFile("image", "image-processor-"+kind+"-"+hex.EncodeToString(id)+".png")
+   if err != nil {
+       return "", err
+   }
+   fw.Write(data)
+   mw.WriteField("type", "input")
+   mw.WriteField("overwrite", "true")
+   mw.Close()
+   var out struct {
+       Name      string `json:"name"`
+       Subfolder string `json:"subfolder"`
+   }
+   if err := c.send(ctx, http.MethodPost, "/upload/image", "upload", mw.FormDataContentType(), &body, &out); err != nil {
+       return "", err
+   }
+   if out.Subfolder != "" {
+       return out.Subfolder + "/" + out.Name, nil
+   }
+   return out.Name, nil
+}
+
+// currentModel returns the checkpoint generations use: the last one switched to, the
+// configured one, or the first ComfyUI lists.
+func (c *ComfyClient) currentModel(ctx context.Context) (string, error) {
+   c.mu.Lock()
+   model := c.model
+   c.mu.Unlock()
+   if model != "" {
+       return model, nil
+   }
+   models, err := c.Models(ctx)
+   if err != nil {
+       return "", err
+   }
+   if len(models) == 0 {
+       return "", errors.New("forgeclient: ComfyUI has no checkpoints")
+   }
+   c.mu.Lock()
+   c.model = models[0].Name
+   c.mu.Unlock()
+   return models[0].Name, nil
+}
+
+// Progress reports the sampling progress and latest preview of the prompt of this client
+// that ComfyUI is running, if any.
+func (c *ComfyClient) Progress(ctx context.Context, skipCurrent bool) (*ProgressResponse, error) {
+   c.mu.Lock()
+   defer c.mu.Unlock()
+   out := &ProgressResponse{State: &ProgressState{JobCount: c.queue}}
+   for id, p := range c.prompts {
+       if !p.running {
+           continue
+       }
+       out.State.Job, out.State.SamplingStep, out.State.SamplingSteps = id, p.value, p.max
+       if p.max > 0 {
+           out.Progress = float32(p.value) / float32(p.max)
+       }
+       if !skipCurrent {
+           out.CurrentImage = p.preview
+       }
+       break
+   }
+   return out, nil
+}
+
+// Extras is not available through ComfyUI.
+func (c *ComfyClient) Extras(ctx context.Context, req *ExtrasRequest) (*ExtrasResponse, error) {
+   return nil, fmt.Errorf("%w: extras", ErrNotSupported)
+}
+
+// ExtrasBatch is not available through ComfyUI.
+func (c *ComfyClient) ExtrasBatch(ctx context.Context, req *ExtrasBatchRequest) (*ExtrasBatchResponse, error) {
+   return nil, fmt.Errorf("%w: extras", ErrNotSupported)
+}
+
+// objectInfoList returns the choices of a node input from /object_info.
+func (c *ComfyClient) objectInfoList(ctx context.Context, class, input string) ([]string, error) {
+   var info map[string]struct {
+       Input struct {
+           Required map[string][]json.RawMessage `json:"required"`
+       } `json:"input"`
+   }
+   if err := c.getJSON(ctx, "/object_info/"+class, "object_info", &info); err != nil {
+       return nil, err
+   }
+   spec := info[class].Input.Required[input]
+   if len(spec) == 0 {
+       return []string{}, nil
+   }
+   var choices []string
+   if json.Unmarshal(spec[0], &choices) == nil {
+       return choices, nil
+   }
+   // Newer servers describe combos as ["COMBO", {"options": [...]}]
+   var opts struct {
+       Options []string `json:"options"`
+   }
+   if len(spec) > 1 && json.Unmarshal(spec[1], &opts) == nil {
+       return opts.Options, nil
+   }
+   return []string{}, nil
+}
+
+// Models lists the checkpoints ComfyUI can load, by file name.
+func (c *ComfyClient) Models(ctx context.Context) ([]ModelInfo, error) {
+   names, err := c.objectInfoList(ctx, "CheckpointLoaderSimple", "ckpt_name")
+   if err != nil {
+       return nil, err
+   }
+   out := make([]ModelInfo, len(names))
+   for i, n := range names {
+       out[i] = ModelInfo{Name: n}
+   }
+   return out, nil
+}
+
+// SwitchModel selects the checkpoint for later generations; ComfyUI loads it on demand.
+func (c *ComfyClient) SwitchModel(ctx context.Context, model string) error {
+   c.mu.Lock()
+   c.model = model
+   c.mu.Unlock()
+   return nil
+}
+
+// Loras lists the LoRA files ComfyUI can load, named by file name without extension.
+func (c *ComfyClient) Loras(ctx context.Context) ([]LoraInfo, error) {
+   files, err := c.objectInfoList(ctx, "LoraLoader", "lora_name")
+   if err != nil {
+       return nil, err
+   }
+   out := make([]LoraInfo, len(files))
+   for i, f := range files {
+       name := strings.TrimSuffix(filepath.Base(filepath.FromSlash(f)), filepath.Ext(f))
+       out[i] = LoraInfo{Name: name, Alias: name, Path: f}
+   }
+   return out, nil
+}
+
+// RefreshLoras is a no-op: ComfyUI rescans its model folders when listing them.
+func (c *ComfyClient) RefreshLoras(ctx context.Context) error {
+   return nil
+}
+
+// Ping checks that ComfyUI answers.
+func (c *ComfyClient) Ping(ctx context.Context) error {
+   var ignored interface{}
+   return c.getJSON(ctx, "/system_stats", "Ping", &ignored)
+}
+
+// Interrupt stops the prompt ComfyUI is running.
+func (c *ComfyClient) Interrupt(ctx context.Context) error {
+   var ignored interface{}
+   return c.send(ctx, http.MethodPost, "/interrupt", "Interrupt", "", nil, &ignored)
+}
+
+// Skip is not available: ComfyUI runs a batch as one prompt.
+func (c *ComfyClient) Skip(ctx context.Context) error {
+   return fmt.Errorf("%w: skip", ErrNotSupported)
+}
+
+// Samplers lists ComfyUI's sampler names.
+func (c *ComfyClient) Samplers(ctx context.Context) ([]SamplerInfo, error) {
+   names, err := c.objectInfoList(ctx, "KSampler", "sampler_name")
+   if err != nil {
+       return nil, err
+   }
+   out := make([]SamplerInfo, len(names))
+   for i, n := range names {
+       out[i] = SamplerInfo{Name: n, Aliases: []string{}, Options: map[string]string{}}
+   }
+   return out, nil
+}
+
+// Schedulers lists ComfyUI's scheduler names.
+func (c *ComfyClient) Schedulers(ctx context.Context) ([]SchedulerInfo, error) {
+   names, err := c.objectInfoList(ctx, "KSampler", "scheduler")
+   if err != nil {
+       return nil, err
+   }
+   out := make([]SchedulerInfo, len(names))
+   for i, n := range names {
+       out[i] = SchedulerInfo{Name: n, Label: n, Aliases: []string{}}
+   }
+   return out, nil
+}
+
+// Upscalers lists the upscale models ComfyUI can load.
+func (c *ComfyClient) Upscalers(ctx context.Context) ([]UpscalerInfo, error) {
+   names, err := c.objectInfoList(ctx, "UpscaleModelLoader", "model_name")
+   if err != nil {
+       return nil, err
+   }
+   out := make([]UpscalerInfo, len(names))
+   for i, n := range names {
+       out[i] = UpscalerInfo{Name: n}
+   }
+   return out, nil
+}
+
+// VAEs lists the VAE files ComfyUI can load.
+func (c *ComfyClient) VAEs(ctx context.Context) ([]VAEInfo, error) {
+   names, err := c.objectInfoList(ctx, "VAELoader", "vae_name")
+   if err != nil {
+       return nil, err
+   }
+   out := make([]VAEInfo, len(names))
+   for i, n := range names {
+       out[i] = VAEInfo{ModelName: strings.TrimSuffix(n, filepath.Ext(n)), Filename: n}
+   }
+   return out, nil
+}
+
+// Embeddings lists the embeddings ComfyUI found; it does not report their details.
+func (c *ComfyClient) Embeddings(ctx context.Context) (*EmbeddingsResponse, error) {
+   var names []string
+   if err := c.getJSON(ctx, "/embeddings", "Embeddings", &names); err != nil {
+       return nil, err
+   }
+   out := &EmbeddingsResponse{Loaded: map[string]EmbeddingInfo{}, Skipped: map[string]EmbeddingInfo{}}
+   for _, n := range names {
+       out.Loaded[n] = EmbeddingInfo{}
+   }
+   return out, nil
+}
+
+// PromptStyles returns no styles: ComfyUI has no saved prompt styles.
+func (c *ComfyClient) PromptStyles(ctx context.Context) ([]PromptStyle, error) {
+   return []PromptStyle{}, nil
+}
+
+// Options reports the selected checkpoint under SD-Forge's option name.
+func (c *ComfyClient) Options(ctx context.Context) (map[string]interface{}, error) {
+   model, err := c.currentModel(ctx)
+   if err != nil {
+       return nil, err
+   }
+   return map[string]interface{}{"sd_model_checkpoint": model}, nil
+}
+
+// getJSON performs a GET on path and decodes the JSON response into out.
+func (c *ComfyClient) getJSON(ctx context.Context, path, op string, out interface{}) error {
+   return c.send(ctx, http.MethodGet, path, op, "", nil, out)
+}
+
+// postJSON POSTs in as JSON to path and decodes the JSON response into out.
+func (c *ComfyClient) postJSON(ctx context.Context, path, op string, in, out interface{}) error {
+   data, err := json.Marshal(in)
+   if err != nil {
+       return fmt.Errorf("forgeclient: marshal %s payload: %w", op, err)
+   }
+   return c.send(ctx, http.MethodPost, path, op, "application/json", bytes.NewReader(data), out)
+}
+
+// getBytes performs a GET on path and returns the response body.
+func (c *ComfyClient) getBytes(ctx context.Context, path, op string) ([]byte, error) {
+   opCtx, cancel := c.requestContext(ctx)
+   defer cancel()
+   resp, err := c.do(ctx, opCtx, http.MethodGet, path, op, "", nil)
+   if err != nil {
+       return nil, err
+   }
+   defer resp.Body.Close()
+   return io.ReadAll(resp.Body)
+}
+
+// send performs a request and decodes the JSON response into out.
+func (c *ComfyClient) send(ctx context.Context, method, path, op, contentType string, body io.Reader, out interface{}) error {
+   opCtx, cancel := c.requestContext(ctx)
+   defer cancel()
+   resp, err := c.do(ctx, opCtx, method, path, op, contentType, body)
+   if err != nil {
+       return err
+   }
+   defer resp.Body.Close()
+   data, err := io.ReadAll(resp.Body)
+   if err != nil {
+       return fmt.Errorf("forgeclient: read %s response: %w", op, err)
+   }
+   if len(bytes.TrimSpace(data)) == 0 {
+       return nil
+   }
+   if err := json.Unmarshal(data, out); err != nil {
+       return fmt.Errorf("forgeclient: decode %s response: %w", op, err)
+   }
+   return nil
+}
+
+// requestContext bounds a single HTTP call by the request timeout, if any.
+func (c *ComfyClient) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+   if t := c.opts.Client.RequestTimeout; t > 0 {
+       return context.WithTimeout(ctx, t)
+   }
+   return ctx, func() {}
+}
+
+// do performs a request against ComfyUI within opCtx, derived from the caller's ctx, and
+// checks for a 2xx status.
+func (c *ComfyClient) do(ctx, opCtx context.Context, method, path, op, contentType string, body io.Reader) (*http.Response, error) {
+   httpReq, err := http.NewRequestWithContext(opCtx, method, c.baseURL+path, body)
+   if err != nil {
+       return nil, fmt.Errorf("forgeclient: new request %s: %w", op, err)
+   }
+   if contentType != "" {
+       httpReq.Header.Set("Content-Type", contentType)
+   }
+   c.creds.apply(httpReq)
+   resp, err := c.http.Do(httpReq)
+   if err != nil {
+       return nil, redact(transportError(ctx, opCtx, op, c.opts.Client.RequestTimeout, err), c.secrets)
+   }
+   if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+       defer resp.Body.Close()
+       data, _ := io.ReadAll(resp.Body)
+       return nil, redact(statusError(op, resp.StatusCode, string(data)), c.secrets)
+   }
+   return resp, nil
+}
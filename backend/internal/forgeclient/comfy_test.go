@@ -0,0 +1,347 @@
+package forgeclient
+
+import (
+   "context"
+   "encoding/base64"
+   "encoding/binary"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "io"
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "sync"
+   "testing"
+   "time"
+
+   "golang.org/x/net/websocket"
+)
+
+// fakeComfy is a minimal ComfyUI server that records queued workflows and uploads and
+// "executes" each prompt by emitting events and storing one output per batch item.
+type fakeComfy struct {
+   *httptest.Server
+   mu        sync.Mutex
+   workflows []Workflow
+   uploads   []string
+   sockets   map[string]*websocket.Conn
+   history   map[string]interface{}
+   // with hold set, prompts only finish once it is closed and only running gets progress
+   hold       chan struct{}
+   running    string
+   deleted    []string
+   interrupts []string
+}
+
+func newFakeComfy(t *testing.T) *fakeComfy {
+   f := &fakeComfy{sockets: map[string]*websocket.Conn{}, history: map[string]interface{}{}}
+   mux := http.NewServeMux()
+   mux.Handle("/ws", websocket.Handler(func(ws *websocket.Conn) {
+       f.mu.Lock()
+       f.sockets[ws.Request().URL.Query().Get("clientId")] = ws
+       f.mu.Unlock()
+       io.Copy(io.Discard, ws)
+   }))
+   mux.HandleFunc("/prompt", func(w http.ResponseWriter, r *http.Request) {
+       var req struct {
+           Prompt   Workflow `json:"prompt"`
+           ClientID string   `json:"client_id"`
+       }
+       json.NewDecoder(r.Body).Decode(&req)
+       f.mu.Lock()
+       f.workflows = append(f.workflows, req.Prompt)
+       id := fmt.Sprintf("prompt-%d", len(f.workflows))
+       batch := 1
+       for _, node := range req.Prompt {
+           if n, ok := node.Inputs["batch_size"].(float64); ok {
+               batch = int(n)
+           }
+       }
+       var images []map[string]string
+       for i := 0; i < batch; i++ {
+           images = append(images, map[string]string{"filename": fmt.Sprintf("%s_%d.png", id, i), "subfolder": "", "type": "output"})
+       }
+       f.history[id] = map[string]interface{}{
+           "outputs": map[string]interface{}{"9": map[string]interface{}{"images": images}},
+           "status":  map[string]interface{}{"status_str": "success", "completed": true},
+       }
+       f.mu.Unlock()
+       fmt.Fprintf(w, `{"prompt_id": %q, "number": 1}`, id)
+       go func() {
+           // The socket handler may still be registering the client's connection
+           var ws *websocket.Conn
+           for ws == nil {
+               f.mu.Lock()
+               ws = f.sockets[req.ClientID]
+               f.mu.Unlock()
+               time.Sleep(time.Millisecond)
+           }
+           f.mu.Lock()
+           hold, running := f.hold, f.running
+           f.mu.Unlock()
+           if hold == nil || id == running {
+               websocket.Message.Send(ws, fmt.Sprintf(`{"type": "progress", "data": {"prompt_id": %q, "value": 5, "max": 20}}`, id))
+               preview := make([]byte, 8, 12)
+               binary.BigEndian.PutUint32(preview, 1)
+               websocket.Message.Send(ws, append(preview, "jpeg"...))
+           }
+           if hold != nil {
+               <-hold
+           }
+           websocket.Message.Send(ws, fmt.Sprintf(`{"type": "executing", "data": {"prompt_id": %q, "node": null}}`, id))
+       }()
+   })
+   mux.HandleFunc("/history/", func(w http.ResponseWriter, r *http.Request) {
+       id := strings.TrimPrefix(r.URL.Path, "/history/")
+       f.mu.Lock()
+       defer f.mu.Unlock()
+       json.NewEncoder(w).Encode(map[string]interface{}{id: f.history[id]})
+   })
+   mux.HandleFunc("/view", func(w http.ResponseWriter, r *http.Request) {
+       w.Write([]byte("png:" + r.URL.Query().Get("filename")))
+   })
+   mux.HandleFunc("/upload/image", func(w http.ResponseWriter, r *http.Request) {
+       file, header, err := r.FormFile("image")
+       if err != nil {
+           http.Error(w, err.Error(), http.StatusBadRequest)
+           return
+       }
+       data, _ := io.ReadAll(file)
+       f.mu.Lock()
+       f.uploads = append(f.uploads, string(data))
+       f.mu.Unlock()
+       fmt.Fprintf(w, `{"name": %q, "subfolder": "", "type": "input"}`, header.Filename)
+   })
+   mux.HandleFunc("/object_info/", func(w http.ResponseWriter, r *http.Request) {
+       switch strings.TrimPrefix(r.URL.Path, "/object_info/") {
+       case "CheckpointLoaderSimple":
+           w.Write([]byte(`{"CheckpointLoaderSimple": {"input": {"required": {"ckpt_name": [["sd15.safetensors", "sdxl.safetensors"]]}}}}`))
+       case "LoraLoader":
+           w.Write([]byte(`{"LoraLoader": {"input": {"required": {"lora_name": ["COMBO", {"options": ["styles/ink.safetensors"]}]}}}}`))
+       default:
+           w.Write([]byte(`{}`))
+       }
+   })
+   mux.HandleFunc("/queue", func(w http.ResponseWriter, r *http.Request) {
+       f.mu.Lock()
+       defer f.mu.Unlock()
+       if r.Method == http.MethodPost {
+           var req struct {
+               Delete []string `json:"delete"`
+           }
+           json.NewDecoder(r.Body).Decode(&req)
+           f.deleted = append(f.deleted, req.Delete...)
+           return
+       }
+       fmt.Fprintf(w, `{"queue_running": [[1, %q, {}, {}, []]], "queue_pending": []}`, f.running)
+   })
+   mux.HandleFunc("/interrupt", func(w http.ResponseWriter, r *http.Request) {
+       var req struct {
+           PromptID string `json:"prompt_id"`
+       }
+       json.NewDecoder(r.Body).Decode(&req)
+       f.mu.Lock()
+       f.interrupts = append(f.interrupts, req.PromptID)
+       f.mu.Unlock()
+   })
+   mux.HandleFunc("/system_stats", func(w http.ResponseWriter, r *http.Request) {
+       w.Write([]byte(`{"system": {}}`))
+   })
+   f.Server = httptest.NewServer(mux)
+   t.Cleanup(f.Close)
+   return f
+}
+
+func TestComfyTxt2ImgFillsWorkflowWithLoras(t *testing.T) {
+   f := newFakeComfy(t)
+   c := NewComfyClient(f.URL, ComfyOptions{})
+   seed := 42
+   resp, err := c.Txt2Img(context.Background(), &Txt2ImgRequest{
+       Prompt: "a cat, <lora:ink:0.6>, watercolor", NegativePrompt: "blurry", Seed: &seed,
+       Steps: 12, SamplerName: "DPM++ 2M Karras", Width: 640, Height: 384, BatchSize: 2, NIter: 2,
+   })
+   if err != nil {
+       t.Fatal(err)
+   }
+   if len(resp.Images) != 4 || len(f.workflows) != 2 {
+       t.Fatalf("expected 4 images from 2 prompts, got %d from %d", len(resp.Images), len(f.workflows))
+   }
+   if data, _ := base64.StdEncoding.DecodeString(resp.Images[3]); string(data) != "png:prompt-2_1.png" {
+       t.Errorf("unexpected image %q", data)
+   }
+
+   wf := f.workflows[1]
+   sampler := wf["3"].Inputs
+   if sampler["seed"] != float64(44) || sampler["steps"] != float64(12) || sampler["sampler_name"] != "dpmpp_2m" || sampler["scheduler"] != "karras" {
+       t.Errorf("unexpected sampler inputs %v", sampler)
+   }
+   if wf["4"].Inputs["ckpt_name"] != "sd15.safetensors" || wf["5"].Inputs["width"] != float64(640) {
+       t.Errorf("unexpected checkpoint or latent inputs: %v %v", wf["4"].Inputs, wf["5"].Inputs)
+   }
+   if wf["6"].Inputs["text"] != "a cat, watercolor" {
+       t.Errorf("LoRA tag should be removed from the prompt, got %q", wf["6"].Inputs["text"])
+   }
+   // The LoRA sits between the checkpoint and its consumers
+   lora := wf["lora_1"]
+   if lora == nil || lora.Inputs["lora_name"] != "styles/ink.safetensors" || lora.Inputs["strength_model"] != 0.6 {
+       t.Fatalf("expected a LoraLoader node, got %+v", lora)
+   }
+   if fmt.Sprint(lora.Inputs["model"]) != "[4 0]" || fmt.Sprint(sampler["model"]) != "[lora_1 0]" || fmt.Sprint(wf["6"].Inputs["clip"]) != "[lora_1 1]" {
+       t.Errorf("LoRA not linked into the graph: %v %v %v", lora.Inputs["model"], sampler["model"], wf["6"].Inputs["clip"])
+   }
+
+   var info struct {
+       Seed      int64    `json:"seed"`
+       AllSeeds  []int64  `json:"all_seeds"`
+       Infotexts []string `json:"infotexts"`
+   }
+   json.Unmarshal([]byte(resp.Info), &info)
+   if info.Seed != 42 || fmt.Sprint(info.AllSeeds) != "[42 43 44 45]" || !strings.Contains(info.Infotexts[0], "Seed: 42, Size: 640x384, Model: sd15") {
+       t.Errorf("unexpected info %+v", info)
+   }
+   progress, _ := c.Progress(context.Background(), false)
+   if progress.State.Job != "" {
+       t.Errorf("no prompt should be running, got %+v", progress.State)
+   }
+}
+
+func TestComfySendsCredentialsWithEveryRequest(t *testing.T) {
+   f := newFakeComfy(t)
+   var mu sync.Mutex
+   seen := map[string]bool{}
+   inner := f.Config.Handler
+   f.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       if r.Header.Get("Authorization") != "Bearer comfy-token" || r.Header.Get("X-Api-Key") != "proxy-key" {
+           t.Errorf("%s %s sent without credentials", r.Method, r.URL.Path)
+           http.Error(w, "unauthorized", http.StatusUnauthorized)
+           return
+       }
+       mu.Lock()
+       seen[r.URL.Path] = true
+       mu.Unlock()
+       inner.ServeHTTP(w, r)
+   })
+   c := NewComfyClient(f.URL, ComfyOptions{Checkpoint: "sd15.safetensors", Client: ClientOptions{
+       Credentials: Credentials{Token: "comfy-token", Headers: map[string]string{"X-Api-Key": "proxy-key"}},
+   }})
+   if _, err := c.Txt2Img(context.Background(), &Txt2ImgRequest{Prompt: "a cat", Steps: 4}); err != nil {
+       t.Fatal(err)
+   }
+   mu.Lock()
+   defer mu.Unlock()
+   for _, path := range []string{"/ws", "/prompt", "/history/prompt-1", "/view"} {
+       if !seen[path] {
+           t.Errorf("expected an authenticated request to %s, saw %v", path, seen)
+       }
+   }
+}
+
+func TestComfyImg2ImgUploadsImageAndMask(t *testing.T) {
+   f := newFakeComfy(t)
+   c := NewComfyClient(f.URL, ComfyOptions{Checkpoint: "sdxl.safetensors"})
+   init := base64.StdEncoding.EncodeToString([]byte("init"))
+   mask := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("mask"))
+   resp, err := c.Img2Img(context.Background(), &Img2ImgRequest{InitImages: []string{init}, Mask: mask, DenoisingStrength: 0.4, Prompt: "a dog"})
+   if err != nil {
+       t.Fatal(err)
+   }
+   if len(resp.Images) != 1 || fmt.Sprint(f.uploads) != "[init mask]" {
+       t.Fatalf("unexpected images %d or uploads %v", len(resp.Images), f.uploads)
+   }
+   wf := f.workflows[0]
+   if wf["13"] == nil || wf["13"].ClassType != "LoadImageMask" {
+       t.Fatalf("expected the inpaint workflow, got %v", wf.nodeIDs())
+   }
+   if !strings.HasPrefix(wf["10"].Inputs["image"].(string), "image-processor-init-") || !strings.HasPrefix(wf["13"].Inputs["image"].(string), "image-processor-mask-") {
+       t.Errorf("uploaded names not used: %v %v", wf["10"].Inputs, wf["13"].Inputs)
+   }
+   if wf["3"].Inputs["denoise"] != 0.4 || wf["4"].Inputs["ckpt_name"] != "sdxl.safetensors" {
+       t.Errorf("unexpected inputs %v %v", wf["3"].Inputs, wf["4"].Inputs)
+   }
+
+   if err := c.Skip(context.Background()); !errors.Is(err, ErrNotSupported) {
+       t.Errorf("expected ErrNotSupported for skip, got %v", err)
+   }
+   loras, err := c.Loras(context.Background())
+   if err != nil || len(loras) != 1 || loras[0].Name != "ink" {
+       t.Errorf("unexpected loras %v (%v)", loras, err)
+   }
+}
+
+func TestComfyTimeoutsAndHungServer(t *testing.T) {
+   release := make(chan struct{})
+   srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       select {
+       case <-release:
+       case <-r.Context().Done():
+       }
+   }))
+   defer srv.Close()
+   defer close(release)
+   c := NewComfyClient(srv.URL, ComfyOptions{Checkpoint: "sd15.safetensors", Client: ClientOptions{RequestTimeout: 50 * time.Millisecond, GenerationTimeout: time.Second}})
+
+   start := time.Now()
+   if err := c.Ping(context.Background()); !errors.Is(err, ErrTimeout) {
+       t.Errorf("expected ErrTimeout from a hung server, got %v", err)
+   }
+   // The websocket handshake never completes either; the prompt request times out
+   if _, err := c.Txt2Img(context.Background(), &Txt2ImgRequest{Prompt: "x"}); !errors.Is(err, ErrTimeout) {
+       t.Errorf("expected ErrTimeout for a generation, got %v", err)
+   }
+   if d := time.Since(start); d > 2*time.Second {
+       t.Errorf("calls took %s despite the timeouts", d)
+   }
+}
+
+func TestComfyConcurrentPromptsKeepTheirOwnState(t *testing.T) {
+   f := newFakeComfy(t)
+   f.hold, f.running = make(chan struct{}), "prompt-1"
+   c := NewComfyClient(f.URL, ComfyOptions{Checkpoint: "sd15.safetensors"})
+   queued := func() int {
+       c.mu.Lock()
+       defer c.mu.Unlock()
+       return len(c.prompts)
+   }
+   start := func(n int) (context.CancelFunc, chan error) {
+       ctx, cancel := context.WithCancel(context.Background())
+       errc := make(chan error, 1)
+       go func() {
+           _, err := c.Txt2Img(ctx, &Txt2ImgRequest{Prompt: "x"})
+           errc <- err
+       }()
+       for queued() < n {
+           time.Sleep(time.Millisecond)
+       }
+       return cancel, errc
+   }
+   cancelRunning, runningErr := start(1)
+   cancelQueued, queuedErr := start(2)
+
+   var progress *ProgressResponse
+   for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+       if progress, _ = c.Progress(context.Background(), false); progress.State.Job != "" {
+           break
+       }
+   }
+   if progress.State.Job != "prompt-1" || progress.State.SamplingStep != 5 || progress.CurrentImage == "" {
+       t.Fatalf("expected the progress of the running prompt, got %+v", progress.State)
+   }
+
+   // Canceling the queued prompt removes it without interrupting the running one
+   cancelQueued()
+   if err := <-queuedErr; err == nil {
+       t.Fatal("expected an error from the canceled prompt")
+   }
+   if progress, _ := c.Progress(context.Background(), false); progress.State.Job != "prompt-1" {
+       t.Errorf("the running prompt's progress was lost: %+v", progress.State)
+   }
+   cancelRunning()
+   <-runningErr
+   f.mu.Lock()
+   defer f.mu.Unlock()
+   if fmt.Sprint(f.deleted) != "[prompt-2 prompt-1]" || fmt.Sprint(f.interrupts) != "[prompt-1]" {
+       t.Errorf("unexpected deletes %v or interrupts %v", f.deleted, f.interrupts)
+   }
+   close(f.hold)
+}
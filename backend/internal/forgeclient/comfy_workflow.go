@@ -0,0 +1,252 @@
+package forgeclient
+
+import (
+   "embed"
+   "encoding/json"
+   "fmt"
+   "os"
+   "path/filepath"
+   "regexp"
+   "sort"
+   "strconv"
+   "strings"
+)
+
+// defaultWorkflows are the built-in ComfyUI workflow templates, one per generation mode.
+//
+//go:embed workflows/*.json
+var defaultWorkflows embed.FS
+
+// Workflow templates by generation mode.
+const (
+   workflowTxt2Img = "txt2img"
+   workflowImg2Img = "img2img"
+   workflowInpaint = "inpaint"
+)
+
+// Workflow is a ComfyUI workflow in API format, keyed by node ID.
+//
+// Templates are workflows whose string inputs may be variables: an input whose whole value
+// is "$name" is replaced by the value of name, keeping its JSON type. The variables are
+// prompt, negative_prompt, seed, steps, cfg, sampler, scheduler, width, height,
+// batch_size, denoise, checkpoint and, for img2img and inpainting, image and mask
+// (names of uploaded images).
+type Workflow map[string]*WorkflowNode
+
+// WorkflowNode is one node of a Workflow. Inputs hold literal values or links to the
+// output of another node, encoded as [node ID, output index].
+type WorkflowNode struct {
+   ClassType string                 `json:"class_type"`
+   Inputs    map[string]interface{} `json:"inputs"`
+   Meta      json.RawMessage        `json:"_meta,omitempty"`
+}
+
+// loadWorkflow reads the template for mode from dir, falling back to the built-in one
+// when dir is empty or has no such template.
+func loadWorkflow(dir, mode string) (Workflow, error) {
+   var data []byte
+   var err error
+   if dir != "" {
+       data, err = os.ReadFile(filepath.Join(dir, mode+".json"))
+       if err != nil && !os.IsNotExist(err) {
+           return nil, fmt.Errorf("forgeclient: read %s workflow: %w", mode, err)
+       }
+   }
+   if data == nil {
+       if data, err = defaultWorkflows.ReadFile("workflows/" + mode + ".json"); err != nil {
+           return nil, fmt.Errorf("forgeclient: no %s workflow: %w", mode, err)
+       }
+   }
+   var wf Workflow
+   if err := json.Unmarshal(data, &wf); err != nil {
+       return nil, fmt.Errorf("forgeclient: decode %s workflow: %w", mode, err)
+   }
+   return wf, nil
+}
+
+// fill replaces the template variables in the workflow inputs with vars.
+func (w Workflow) fill(vars map[string]interface{}) error {
+   for id, node := range w {
+       for name, v := range node.Inputs {
+           s, ok := v.(string)
+           if !ok || !strings.HasPrefix(s, "$") {
+               continue
+           }
+           val, ok := vars[s[1:]]
+           if !ok {
+               return fmt.Errorf("forgeclient: workflow node %s input %s: unknown variable %s", id, name, s)
+           }
+           node.Inputs[name] = val
+       }
+   }
+   return nil
+}
+
+// promptLora is a LoRA requested in a prompt with A1111 <lora:name:weight> syntax.
+type promptLora struct {
+   Name   string
+   Weight float64
+}
+
+// loraTag matches A1111 LoRA tags: <lora:name>, <lora:name:weight> or <lora:name:unet:te>.
+var loraTag = regexp.MustCompile(`<lora:([^:>]+)(?::([^:>]*))?(?::[^>]*)?>`)
+
+// emptyPromptPart matches the runs of commas left where LoRA tags were removed.
+var emptyPromptPart = regexp.MustCompile(`\s*,(\s*,)+|\s+,`)
+
+// extractLoras removes LoRA tags from prompt, which ComfyUI does not understand, and
+// returns them so they can be applied as LoraLoader nodes instead.
+func extractLoras(prompt string) (string, []promptLora) {
+   var loras []promptLora
+   cleaned := loraTag.ReplaceAllStringFunc(prompt, func(tag string) string {
+       m := loraTag.FindStringSubmatch(tag)
+       weight := 1.0
+       if w, err := strconv.ParseFloat(strings.TrimSpace(m[2]), 64); err == nil {
+           weight = w
+       }
+       loras = append(loras, promptLora{Name: strings.TrimSpace(m[1]), Weight: weight})
+       return ""
+   })
+   cleaned = strings.Join(strings.Fields(emptyPromptPart.ReplaceAllString(cleaned, ",")), " ")
+   return strings.Trim(cleaned, ", "), loras
+}
+
+// addLoras chains a LoraLoader node per LoRA after the checkpoint loader and moves every
+// consumer of the checkpoint's model and CLIP outputs to the end of the chain. files maps
+// requested LoRA names to the file names ComfyUI knows them by.
+func (w Workflow) addLoras(loras []promptLora, files map[string]string) error {
+   if len(loras) == 0 {
+       return nil
+   }
+   ckpt := ""
+   for _, id := range w.nodeIDs() {
+       if w[id].ClassType == "CheckpointLoaderSimple" {
+           ckpt = id
+           break
+       }
+   }
+   if ckpt == "" {
+       return fmt.Errorf("forgeclient: workflow has no CheckpointLoaderSimple node for LoRAs")
+   }
+   model, clip := []interface{}{ckpt, 0}, []interface{}{ckpt, 1}
+   var chain []string
+   for i, l := range loras {
+       file, ok := files[l.Name]
+       if !ok {
+           return fmt.Errorf("forgeclient: unknown LoRA %q", l.Name)
+       }
+       id := fmt.Sprintf("lora_%d", i+1)
+       w[id] = &WorkflowNode{ClassType: "LoraLoader", Inputs: map[string]interface{}{
+           "lora_name":      file,
+           "strength_model": l.Weight,
+           "strength_clip":  l.Weight,
+           "model":          model,
+           "clip":           clip,
+       }}
+       model, clip = []interface{}{id, 0}, []interface{}{id, 1}
+       chain = append(chain, id)
+   }
+   inChain := map[string]bool{}
+   for _, id := range chain {
+       inChain[id] = true
+   }
+   for id, node := range w {
+       if inChain[id] {
+           continue
+       }
+       for name, v := range node.Inputs {
+           link, ok := v.([]interface{})
+           if !ok || len(link) != 2 || link[0] != ckpt {
+               continue
+           }
+           switch n, _ := link[1].(float64); n {
+           case 0:
+               node.Inputs[name] = model
+           case 1:
+               node.Inputs[name] = clip
+           }
+       }
+   }
+   return nil
+}
+
+// nodeIDs returns the node IDs in a stable order: numerically, then by name.
+func (w Workflow) nodeIDs() []string {
+   ids := make([]string, 0, len(w))
+   for id := range w {
+       ids = append(ids, id)
+   }
+   sort.Slice(ids, func(i, j int) bool {
+       a, aerr := strconv.Atoi(ids[i])
+       b, berr := strconv.Atoi(ids[j])
+       if aerr == nil && berr == nil {
+           return a < b
+       }
+       if (aerr == nil) != (berr == nil) {
+           return aerr == nil
+       }
+       return ids[i] < ids[j]
+   })
+   return ids
+}
+
+// comfySamplers maps SD-Forge sampler names to ComfyUI's.
+var comfySamplers = map[string]string{
+   "Euler":        "euler",
+   "Euler a":      "euler_ancestral",
+   "Heun":         "heun",
+   "DPM2":         "dpm_2",
+   "DPM2 a":       "dpm_2_ancestral",
+   "LMS":          "lms",
+   "DPM fast":     "dpm_fast",
+   "DPM adaptive": "dpm_adaptive",
+   "DPM++ 2S a":   "dpmpp_2s_ancestral",
+   "DPM++ SDE":    "dpmpp_sde",
+   "DPM++ 2M":     "dpmpp_2m",
+   "DPM++ 2M SDE": "dpmpp_2m_sde",
+   "DPM++ 3M SDE": "dpmpp_3m_sde",
+   "DDIM":         "ddim",
+   "UniPC":        "uni_pc",
+   "LCM":          "lcm",
+}
+
+// comfySampler converts an SD-Forge sampler and scheduler to ComfyUI names. Legacy Forge
+// names with the scheduler built in ("DPM++ 2M Karras") are split; names ComfyUI already
+// uses pass through.
+func comfySampler(sampler, scheduler string) (string, string) {
+   if strings.HasSuffix(sampler, " Karras") {
+       sampler, scheduler = strings.TrimSuffix(sampler, " Karras"), "karras"
+   }
+   if s, ok := comfySamplers[sampler]; ok {
+       sampler = s
+   }
+   if sampler == "" {
+       sampler = "euler"
+   }
+   scheduler = strings.ReplaceAll(strings.ToLower(scheduler), " ", "_")
+   switch scheduler {
+   case "", "automatic", "uniform":
+       scheduler = "normal"
+   case "ddim":
+       scheduler = "ddim_uniform"
+   }
+   return sampler, scheduler
+}
+
+// comfyInfotext formats generation parameters the way SD-Forge embeds them in images, so
+// results from either backend read the same.
+func comfyInfotext(prompt, negative string, steps int, sampler, scheduler string, cfg float32, seed int64, width, height int, model string) string {
+   var b strings.Builder
+   b.WriteString(prompt)
+   if negative != "" {
+       b.WriteString("\nNegative prompt: " + negative)
+   }
+   fmt.Fprintf(&b, "\nSteps: %d, Sampler: %s, Schedule type: %s, CFG scale: %g, Seed: %d", steps, sampler, scheduler, cfg, seed)
+   if width > 0 && height > 0 {
+       fmt.Fprintf(&b, ", Size: %dx%d", width, height)
+   }
+   if model != "" {
+       b.WriteString(", Model: " + strings.TrimSuffix(model, filepath.Ext(model)))
+   }
+   return b.String()
+}
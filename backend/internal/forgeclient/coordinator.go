@@ -0,0 +1,285 @@
+package forgeclient
+
+import (
+   "context"
+   "log"
+   "sync"
+   "time"
+)
+
+// ModelStatus describes the checkpoint loaded on a server and any switch in progress.
+type ModelStatus struct {
+   // Model is the loaded checkpoint, empty while unknown (e.g. after a failed switch).
+   Model     string `json:"model"`
+   Switching bool   `json:"switching"`
+   // Target is the checkpoint being loaded while Switching.
+   Target         string     `json:"target,omitempty"`
+   SwitchStarted  *time.Time `json:"switch_started_at,omitempty"`
+   ElapsedSeconds float64    `json:"elapsed_seconds,omitempty"`
+   // ExpectedSeconds is how long the last switch to Target took, and Progress the elapsed
+   // share of it (capped below 1), when Target was loaded before.
+   ExpectedSeconds float64 `json:"expected_seconds,omitempty"`
+   Progress        float64 `json:"progress,omitempty"`
+   // RestoreTo is the checkpoint that is switched back to once queued generations are done.
+   RestoreTo string `json:"restore_to,omitempty"`
+   // Active counts running generations, QueuedGenerations and QueuedSwitches the callers
+   // waiting for their turn.
+   Active            int    `json:"active_generations"`
+   QueuedGenerations int    `json:"queued_generations"`
+   QueuedSwitches    int    `json:"queued_switches"`
+   LastError         string `json:"last_error,omitempty"`
+}
+
+// Coordinator is a Client serializing checkpoint switches with generations on one server:
+// a switch waits for running generations to finish and holds back new ones until the
+// checkpoint is loaded, so no generation runs while the model changes under it.
+//
+// Generations requesting a checkpoint through override_settings.sd_model_checkpoint are
+// run after switching to it through the same queue instead of letting the server switch
+// by itself. As with SD-Forge, the previous checkpoint is restored afterwards unless
+// override_settings_restore_afterwards is false; to avoid switching back and forth, the
+// restore waits until no generation is running or queued.
+type Coordinator struct {
+   Client
+
+   mu        sync.Mutex
+   changed   chan struct{}
+   active    int
+   queued    int
+   pending   int
+   switching bool
+   current   string
+   target    string
+   started   time.Time
+   restoreTo string
+   lastErr   string
+   durations map[string]time.Duration
+   onSwitch  func(model string)
+}
+
+// NewCoordinator returns a Coordinator around client.
+func NewCoordinator(client Client) *Coordinator {
+   return &Coordinator{Client: client, changed: make(chan struct{}), durations: map[string]time.Duration{}}
+}
+
+// OnSwitch registers fn to be called after every switch attempt, including those made
+// for generations, with the checkpoint requested.
+func (c *Coordinator) OnSwitch(fn func(model string)) {
+   c.mu.Lock()
+   c.onSwitch = fn
+   c.mu.Unlock()
+}
+
+// Status reports the loaded checkpoint and the switch in progress, if any.
+func (c *Coordinator) Status() ModelStatus {
+   c.mu.Lock()
+   defer c.mu.Unlock()
+   st := ModelStatus{
+       Model: c.current, Switching: c.switching, RestoreTo: c.restoreTo, LastError: c.lastErr,
+       Active: c.active, QueuedGenerations: c.queued, QueuedSwitches: c.pending,
+   }
+   if c.switching {
+       started, elapsed := c.started, time.Since(c.started)
+       st.Target, st.SwitchStarted, st.ElapsedSeconds = c.target, &started, elapsed.Seconds()
+       if d := c.durations[checkpointKey(c.target)]; d > 0 {
+           st.ExpectedSeconds = d.Seconds()
+           st.Progress = min(elapsed.Seconds()/d.Seconds(), 0.99)
+       }
+   }
+   return st
+}
+
+// Txt2Img runs once no switch is pending, switching to the requested checkpoint first.
+func (c *Coordinator) Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error) {
+   forgeReq := *req
+   checkpoint := requestedCheckpoint(req.OverrideSettings)
+   forgeReq.OverrideSettings = withoutCheckpoint(req.OverrideSettings)
+   if err := c.acquire(ctx, checkpoint, restores(req.OverrideSettingsRestoreAfterwards)); err != nil {
+       return nil, err
+   }
+   defer c.release()
+   return c.Client.Txt2Img(ctx, &forgeReq)
+}
+
+// Img2Img runs once no switch is pending, switching to the requested checkpoint first.
+func (c *Coordinator) Img2Img(ctx context.Context, req *Img2ImgRequest) (*ImageResponse, error) {
+   forgeReq := *req
+   checkpoint := requestedCheckpoint(req.OverrideSettings)
+   forgeReq.OverrideSettings = withoutCheckpoint(req.OverrideSettings)
+   if err := c.acquire(ctx, checkpoint, restores(req.OverrideSettingsRestoreAfterwards)); err != nil {
+       return nil, err
+   }
+   defer c.release()
+   return c.Client.Img2Img(ctx, &forgeReq)
+}
+
+// SwitchModel loads model once running generations are done, holding back new ones
+// meanwhile. The checkpoint chosen this way is kept: a pending restore is dropped.
+func (c *Coordinator) SwitchModel(ctx context.Context, model string) error {
+   c.refresh(ctx)
+   c.mu.Lock()
+   defer c.mu.Unlock()
+   c.restoreTo = ""
+   return c.switchLocked(ctx, model)
+}
+
+// restores reports whether a generation's checkpoint override is undone afterwards,
+// which SD-Forge does unless told otherwise.
+func restores(restoreAfterwards *bool) bool {
+   return restoreAfterwards == nil || *restoreAfterwards
+}
+
+// withoutCheckpoint returns overrides without the checkpoint, which the Coordinator loads
+// itself. overrides is not modified.
+func withoutCheckpoint(overrides map[string]interface{}) map[string]interface{} {
+   if _, ok := overrides["sd_model_checkpoint"]; !ok {
+       return overrides
+   }
+   out := make(map[string]interface{}, len(overrides))
+   for k, v := range overrides {
+       if k != "sd_model_checkpoint" {
+           out[k] = v
+       }
+   }
+   return out
+}
+
+// acquire waits until a generation may run with checkpoint loaded (any checkpoint if
+// empty), switching to it if needed, and counts the generation as active. With restore,
+// the checkpoint loaded before is put back once the queue drains.
+func (c *Coordinator) acquire(ctx context.Context, checkpoint string, restore bool) error {
+   if checkpoint != "" {
+       c.refresh(ctx)
+   }
+   c.mu.Lock()
+   defer c.mu.Unlock()
+   c.queued++
+   err := c.wait(ctx, func() bool { return !c.switching && c.pending == 0 })
+   c.queued--
+   if err != nil {
+       c.broadcast()
+       return err
+   }
+   if checkpoint == "" || checkpointKey(c.current) == checkpointKey(checkpoint) {
+       c.active++
+       return nil
+   }
+   previous := c.current
+   if err := c.switchLocked(ctx, checkpoint); err != nil {
+       return err
+   }
+   switch {
+   case !restore:
+       c.restoreTo = ""
+   case c.restoreTo == "":
+       // Restoring goes back to the checkpoint loaded before the first of a run of switches
+       c.restoreTo = previous
+   }
+   c.active++
+   return nil
+}
+
+// release ends a generation, restoring the checkpoint of an earlier switch once nothing
+// else is running or waiting.
+func (c *Coordinator) release() {
+   c.mu.Lock()
+   defer c.mu.Unlock()
+   c.active--
+   c.broadcast()
+   if c.active > 0 || c.queued > 0 || c.pending > 0 || c.restoreTo == "" {
+       return
+   }
+   model := c.restoreTo
+   c.restoreTo = ""
+   go func() {
+       if err := c.SwitchModel(context.Background(), model); err != nil {
+           log.Printf("forgeclient: could not restore checkpoint %s: %v", model, err)
+       }
+   }()
+}
+
+// switchLocked waits for running generations and switches to model unless it is loaded
+// already. c.mu is held, but released while waiting and switching.
+func (c *Coordinator) switchLocked(ctx context.Context, model string) error {
+   c.pending++
+   err := c.wait(ctx, func() bool { return c.active == 0 && !c.switching })
+   c.pending--
+   if err != nil || checkpointKey(c.current) == checkpointKey(model) {
+       c.broadcast()
+       return err
+   }
+   c.switching, c.target, c.started = true, model, time.Now()
+   notify := c.onSwitch
+   c.broadcast()
+   c.mu.Unlock()
+   // The server keeps loading when the caller gives up, so the switch is seen through
+   ctx = context.WithoutCancel(ctx)
+   err = c.Client.SwitchModel(ctx, model)
+   loaded := model
+   if err == nil {
+       if l := c.loadedModel(ctx); l != "" {
+           loaded = l
+       }
+   }
+   if notify != nil {
+       notify(model)
+   }
+   c.mu.Lock()
+   c.switching, c.target = false, ""
+   if err != nil {
+       // The server may be anywhere between the two checkpoints
+       c.current, c.lastErr = "", err.Error()
+   } else {
+       c.current, c.lastErr = loaded, ""
+       c.durations[checkpointKey(model)] = time.Since(c.started)
+   }
+   c.broadcast()
+   return err
+}
+
+// refresh records the checkpoint loaded on the server unless a switch is in progress,
+// picking up switches made elsewhere, e.g. in SD-Forge's web UI.
+func (c *Coordinator) refresh(ctx context.Context) {
+   model := c.loadedModel(ctx)
+   if model == "" {
+       return
+   }
+   c.mu.Lock()
+   if !c.switching {
+       c.current = model
+   }
+   c.mu.Unlock()
+}
+
+// loadedModel asks the server for its loaded checkpoint, returning "" if that fails.
+func (c *Coordinator) loadedModel(ctx context.Context) string {
+   opts, err := c.Client.Options(ctx)
+   if err != nil {
+       return ""
+   }
+   model, _ := opts["sd_model_checkpoint"].(string)
+   return model
+}
+
+// wait blocks until cond holds or ctx is done. c.mu is held when called and on return,
+// and released while blocked.
+func (c *Coordinator) wait(ctx context.Context, cond func() bool) error {
+   for !cond() {
+       changed := c.changed
+       c.mu.Unlock()
+       select {
+       case <-ctx.Done():
+           c.mu.Lock()
+           return ctx.Err()
+       case <-changed:
+       }
+       c.mu.Lock()
+   }
+   return nil
+}
+
+// broadcast wakes every waiter to recheck its condition. c.mu is held.
+func (c *Coordinator) broadcast() {
+   close(c.changed)
+   c.changed = make(chan struct{})
+}
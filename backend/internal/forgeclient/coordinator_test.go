@@ -0,0 +1,156 @@
+package forgeclient
+
+import (
+   "context"
+   "sync"
+   "testing"
+   "time"
+)
+
+// switchingMock is a MockClient whose loaded checkpoint follows SwitchModel, logging
+// switches and the checkpoint each generation ran with. Generations block until released.
+type switchingMock struct {
+   MockClient
+   mu      sync.Mutex
+   model   string
+   events  []string
+   started chan string
+   release chan struct{}
+}
+
+func newSwitchingMock(model string) *switchingMock {
+   m := &switchingMock{model: model, started: make(chan string, 10), release: make(chan struct{})}
+   m.OptionsFunc = func(ctx context.Context) (map[string]interface{}, error) {
+       m.mu.Lock()
+       defer m.mu.Unlock()
+       return map[string]interface{}{"sd_model_checkpoint": m.model + ".safetensors [0123456789]"}, nil
+   }
+   m.SwitchModelFunc = func(ctx context.Context, model string) error {
+       m.mu.Lock()
+       defer m.mu.Unlock()
+       m.model, m.events = model, append(m.events, "switch "+model)
+       return nil
+   }
+   m.Txt2ImgFunc = func(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error) {
+       m.mu.Lock()
+       model := m.model
+       if req.OverrideSettings["sd_model_checkpoint"] != nil {
+           model = "override leaked to the server"
+       }
+       m.events = append(m.events, "generate "+req.Prompt+" on "+model)
+       m.mu.Unlock()
+       m.started <- req.Prompt
+       <-m.release
+       return &ImageResponse{}, nil
+   }
+   return m
+}
+
+// log returns the switches and generations so far.
+func (m *switchingMock) log() []string {
+   m.mu.Lock()
+   defer m.mu.Unlock()
+   return append([]string(nil), m.events...)
+}
+
+// waitFor polls until cond holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+   t.Helper()
+   deadline := time.Now().Add(time.Second)
+   for !cond() {
+       if time.Now().After(deadline) {
+           t.Fatalf("timed out waiting for %s", what)
+       }
+       time.Sleep(time.Millisecond)
+   }
+}
+
+func TestCoordinatorSerializesSwitchesWithGenerations(t *testing.T) {
+   m := newSwitchingMock("sdxl")
+   c := NewCoordinator(m)
+   var switched int
+   c.OnSwitch(func(string) { switched++ })
+   var wg sync.WaitGroup
+   run := func(req *Txt2ImgRequest) {
+       wg.Add(1)
+       go func() {
+           defer wg.Done()
+           if _, err := c.Txt2Img(context.Background(), req); err != nil {
+               t.Errorf("%s: %v", req.Prompt, err)
+           }
+       }()
+   }
+
+   // A switch requested during a generation waits for it, and holds back later generations
+   run(&Txt2ImgRequest{Prompt: "a"})
+   <-m.started
+   errc := make(chan error, 1)
+   go func() { errc <- c.SwitchModel(context.Background(), "flux") }()
+   waitFor(t, "the switch to queue", func() bool { return c.Status().QueuedSwitches == 1 })
+   run(&Txt2ImgRequest{Prompt: "b"})
+   waitFor(t, "the generation to queue", func() bool { return c.Status().QueuedGenerations == 1 })
+   m.release <- struct{}{}
+   if err := <-errc; err != nil {
+       t.Fatal(err)
+   }
+   <-m.started
+   m.release <- struct{}{}
+   wg.Wait()
+   want := []string{"generate a on sdxl", "switch flux", "generate b on flux"}
+   if got := m.log(); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+       t.Fatalf("expected %v, got %v", want, got)
+   }
+   if st := c.Status(); st.Model != "flux.safetensors [0123456789]" || st.Switching || st.Active != 0 || switched != 1 {
+       t.Errorf("unexpected status after the switch: %+v (%d switches)", st, switched)
+   }
+
+   // Generations requesting a checkpoint switch to it and back once the queue is empty,
+   // without passing the override on to the server
+   m.events = nil
+   run(&Txt2ImgRequest{Prompt: "c", OverrideSettings: map[string]interface{}{"sd_model_checkpoint": "sdxl", "CLIP_stop_at_last_layers": 2}})
+   <-m.started
+   run(&Txt2ImgRequest{Prompt: "d", OverrideSettings: map[string]interface{}{"sd_model_checkpoint": "models/sdxl.safetensors"}})
+   <-m.started
+   if st := c.Status(); st.RestoreTo != "flux.safetensors [0123456789]" || st.Active != 2 {
+       t.Errorf("expected two generations and a pending restore, got %+v", st)
+   }
+   m.release <- struct{}{}
+   m.release <- struct{}{}
+   wg.Wait()
+   waitFor(t, "the restore", func() bool { return len(m.log()) == 4 && !c.Status().Switching })
+   got := m.log()
+   if got[0] != "switch sdxl" || got[3] != "switch flux.safetensors [0123456789]" {
+       t.Errorf("expected a switch before and after the generations, got %v", got)
+   }
+   for _, e := range got[1:3] {
+       if e != "generate c on sdxl" && e != "generate d on sdxl" {
+           t.Errorf("unexpected generation %q", e)
+       }
+   }
+
+   // With restore disabled the checkpoint stays loaded
+   m.events = nil
+   keep := false
+   run(&Txt2ImgRequest{Prompt: "e", OverrideSettings: map[string]interface{}{"sd_model_checkpoint": "sdxl"}, OverrideSettingsRestoreAfterwards: &keep})
+   <-m.started
+   m.release <- struct{}{}
+   wg.Wait()
+   time.Sleep(10 * time.Millisecond)
+   if got := m.log(); len(got) != 2 || c.Status().RestoreTo != "" {
+       t.Errorf("expected no restore, got %v", got)
+   }
+
+   // Callers giving up while queued leave the queue
+   run(&Txt2ImgRequest{Prompt: "f"})
+   <-m.started
+   ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+   defer cancel()
+   if err := c.SwitchModel(ctx, "flux"); err != context.DeadlineExceeded {
+       t.Errorf("expected the queued switch to time out, got %v", err)
+   }
+   if st := c.Status(); st.QueuedSwitches != 0 || st.Model != "sdxl.safetensors [0123456789]" {
+       t.Errorf("unexpected status after giving up: %+v", st)
+   }
+   m.release <- struct{}{}
+   wg.Wait()
+}
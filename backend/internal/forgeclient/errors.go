@@ -0,0 +1,82 @@
+package forgeclient
+
+import (
+   "context"
+   "errors"
+   "fmt"
+   "time"
+)
+
+// Error kinds of a RequestError, for errors.Is.
+var (
+   // ErrUnavailable means the server could not be reached or said it is unavailable.
+   ErrUnavailable = errors.New("SD-Forge unavailable")
+   // ErrTimeout means the server did not answer within the operation's timeout.
+   ErrTimeout = errors.New("SD-Forge timed out")
+   // ErrCircuitOpen means calls fail fast because the server kept failing; it is also
+   // ErrUnavailable.
+   ErrCircuitOpen = fmt.Errorf("%w: circuit open after repeated failures", ErrUnavailable)
+)
+
+// RequestError is a failed call to the generation server. Kind classifies it as
+// ErrUnavailable, ErrTimeout or ErrCircuitOpen; it is nil when the server answered with
+// an error of its own.
+type RequestError struct {
+   Op         string
+   Kind       error
+   StatusCode int
+   Err        error
+}
+
+// Error describes the failure without the request URL.
+func (e *RequestError) Error() string {
+   switch {
+   case e.StatusCode != 0:
+       return fmt.Sprintf("forgeclient: %s status %d: %v", e.Op, e.StatusCode, e.Err)
+   case e.Kind == ErrCircuitOpen:
+       return fmt.Sprintf("forgeclient: %s: %v", e.Op, e.Kind)
+   case e.Kind == ErrTimeout:
+       return fmt.Sprintf("forgeclient: %s %v", e.Op, e.Err)
+   }
+   return fmt.Sprintf("forgeclient: %s request failed: %v", e.Op, e.Err)
+}
+
+// Unwrap exposes both the kind and the underlying error.
+func (e *RequestError) Unwrap() []error {
+   if e.Kind == nil {
+       return []error{e.Err}
+   }
+   return []error{e.Kind, e.Err}
+}
+
+// statusError classifies a non-2xx answer: gateway errors in front of the server mean it
+// is unavailable (or timed out), anything else is the server's own error.
+func statusError(op string, status int, body string) *RequestError {
+   e := &RequestError{Op: op, StatusCode: status, Err: errors.New(body)}
+   switch status {
+   case 502, 503:
+       e.Kind = ErrUnavailable
+   case 504:
+       e.Kind = ErrTimeout
+   }
+   return e
+}
+
+// transportError classifies a failed round trip. ctx is the caller's context and opCtx the
+// one bounded by the operation timeout: running out of the latter is a timeout, while a
+// caller giving up is reported as it is.
+func transportError(ctx, opCtx context.Context, op string, timeout time.Duration, err error) error {
+   if ctx.Err() != nil {
+       return &RequestError{Op: op, Err: err}
+   }
+   if errors.Is(opCtx.Err(), context.DeadlineExceeded) {
+       return &RequestError{Op: op, Kind: ErrTimeout, Err: fmt.Errorf("timed out after %s: %w", timeout, err)}
+   }
+   return &RequestError{Op: op, Kind: ErrUnavailable, Err: err}
+}
+
+// countsAsFailure reports whether err says the server is unhealthy, for the circuit
+// breaker: errors reported by a working server and callers giving up do not count.
+func countsAsFailure(err error) bool {
+   return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrTimeout)
+}
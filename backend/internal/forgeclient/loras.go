@@ -0,0 +1,54 @@
+package forgeclient
+
+import (
+   "encoding/json"
+   "sort"
+   "strings"
+)
+
+// maxTriggerWords caps the trigger words reported per LoRA.
+const maxTriggerWords = 10
+
+// TriggerWords returns up to n of the most frequent training tags recorded in a LoRA's
+// ss_tag_frequency metadata. The value may be a decoded object or a JSON string,
+// depending on the server version; tags are summed across all training datasets.
+func TriggerWords(metadata map[string]interface{}, n int) []string {
+   raw, ok := metadata["ss_tag_frequency"]
+   if !ok {
+       return nil
+   }
+   var datasets map[string]map[string]float64
+   switch v := raw.(type) {
+   case string:
+       if err := json.Unmarshal([]byte(v), &datasets); err != nil {
+           return nil
+       }
+   default:
+       data, err := json.Marshal(v)
+       if err != nil || json.Unmarshal(data, &datasets) != nil {
+           return nil
+       }
+   }
+   counts := make(map[string]float64)
+   for _, tags := range datasets {
+       for tag, count := range tags {
+           if tag = strings.TrimSpace(tag); tag != "" {
+               counts[tag] += count
+           }
+       }
+   }
+   words := make([]string, 0, len(counts))
+   for tag := range counts {
+       words = append(words, tag)
+   }
+   sort.Slice(words, func(i, j int) bool {
+       if counts[words[i]] != counts[words[j]] {
+           return counts[words[i]] > counts[words[j]]
+       }
+       return words[i] < words[j]
+   })
+   if len(words) > n {
+       words = words[:n]
+   }
+   return words
+}
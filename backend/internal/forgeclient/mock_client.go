@@ -2,6 +2,7 @@ package forgeclient
 
 import (
    "context"
+   "sync"
 )
 
 // MockClient is a mock implementation of Client for unit tests.
@@ -10,17 +11,32 @@ type MockClient struct {
    Txt2ImgFunc    func(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error)
    Img2ImgFunc    func(ctx context.Context, req *Img2ImgRequest) (*ImageResponse, error)
    ProgressFunc   func(ctx context.Context, skipCurrent bool) (*ProgressResponse, error)
-   ExtrasFunc     func(ctx context.Context, operation, image string) (*ImageResponse, error)
-   ExtrasBatchFunc func(ctx context.Context, operations []string, images []string) ([]ImageResponse, error)
+   ExtrasFunc     func(ctx context.Context, req *ExtrasRequest) (*ExtrasResponse, error)
+   ExtrasBatchFunc func(ctx context.Context, req *ExtrasBatchRequest) (*ExtrasBatchResponse, error)
    ModelsFunc     func(ctx context.Context) ([]ModelInfo, error)
    SwitchModelFunc func(ctx context.Context, model string) error
    LorasFunc      func(ctx context.Context) ([]LoraInfo, error)
+   RefreshLorasFunc func(ctx context.Context) error
    PingFunc       func(ctx context.Context) error
-   HistoryFunc    func(ctx context.Context, imageID string) ([]HistoryEntry, error)
+   InterruptFunc  func(ctx context.Context) error
+   SkipFunc       func(ctx context.Context) error
+   SamplersFunc   func(ctx context.Context) ([]SamplerInfo, error)
+   SchedulersFunc func(ctx context.Context) ([]SchedulerInfo, error)
+   UpscalersFunc  func(ctx context.Context) ([]UpscalerInfo, error)
+   VAEsFunc       func(ctx context.Context) ([]VAEInfo, error)
+   EmbeddingsFunc func(ctx context.Context) (*EmbeddingsResponse, error)
+   PromptStylesFunc func(ctx context.Context) ([]PromptStyle, error)
+   OptionsFunc    func(ctx context.Context) (map[string]interface{}, error)
+
+   mu             sync.Mutex
+   interruptCalls int
+   skipCalls      int
 }
 
 // Txt2Img calls the assigned Txt2ImgFunc or returns nil.
+// Like RealClient, it calls Interrupt if ctx is canceled during the call.
 func (m *MockClient) Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error) {
+   defer m.interruptIfCanceled(ctx)
    if m.Txt2ImgFunc != nil {
        return m.Txt2ImgFunc(ctx, req)
    }
@@ -28,7 +44,9 @@ func (m *MockClient) Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageRe
 }
 
 // Img2Img calls the assigned Img2ImgFunc or returns nil.
+// Like RealClient, it calls Interrupt if ctx is canceled during the call.
 func (m *MockClient) Img2Img(ctx context.Context, req *Img2ImgRequest) (*ImageResponse, error) {
+   defer m.interruptIfCanceled(ctx)
    if m.Img2ImgFunc != nil {
        return m.Img2ImgFunc(ctx, req)
    }
@@ -43,16 +61,16 @@ func (m *MockClient) Progress(ctx context.Context, skipCurrent bool) (*ProgressR
    return nil, nil
 }
 // Extras calls ExtrasFunc or returns nil.
-func (m *MockClient) Extras(ctx context.Context, operation, image string) (*ImageResponse, error) {
+func (m *MockClient) Extras(ctx context.Context, req *ExtrasRequest) (*ExtrasResponse, error) {
    if m.ExtrasFunc != nil {
-       return m.ExtrasFunc(ctx, operation, image)
+       return m.ExtrasFunc(ctx, req)
    }
    return nil, nil
 }
 // ExtrasBatch calls ExtrasBatchFunc or returns nil.
-func (m *MockClient) ExtrasBatch(ctx context.Context, operations []string, images []string) ([]ImageResponse, error) {
+func (m *MockClient) ExtrasBatch(ctx context.Context, req *ExtrasBatchRequest) (*ExtrasBatchResponse, error) {
    if m.ExtrasBatchFunc != nil {
-       return m.ExtrasBatchFunc(ctx, operations, images)
+       return m.ExtrasBatchFunc(ctx, req)
    }
    return nil, nil
 }
@@ -77,6 +95,14 @@ func (m *MockClient) Loras(ctx context.Context) ([]LoraInfo, error) {
    }
    return nil, nil
 }
+// RefreshLoras calls RefreshLorasFunc or returns nil.
+func (m *MockClient) RefreshLoras(ctx context.Context) error {
+   if m.RefreshLorasFunc != nil {
+       return m.RefreshLorasFunc(ctx)
+   }
+   return nil
+}
+
 // Ping calls PingFunc or returns nil.
 func (m *MockClient) Ping(ctx context.Context) error {
    if m.PingFunc != nil {
@@ -84,10 +110,103 @@ func (m *MockClient) Ping(ctx context.Context) error {
    }
    return nil
 }
-// History calls HistoryFunc or returns nil.
-func (m *MockClient) History(ctx context.Context, imageID string) ([]HistoryEntry, error) {
-   if m.HistoryFunc != nil {
-       return m.HistoryFunc(ctx, imageID)
+
+// Interrupt records the call and calls InterruptFunc or returns nil.
+func (m *MockClient) Interrupt(ctx context.Context) error {
+   m.mu.Lock()
+   m.interruptCalls++
+   m.mu.Unlock()
+   if m.InterruptFunc != nil {
+       return m.InterruptFunc(ctx)
+   }
+   return nil
+}
+
+// Skip records the call and calls SkipFunc or returns nil.
+func (m *MockClient) Skip(ctx context.Context) error {
+   m.mu.Lock()
+   m.skipCalls++
+   m.mu.Unlock()
+   if m.SkipFunc != nil {
+       return m.SkipFunc(ctx)
+   }
+   return nil
+}
+
+// Samplers calls the assigned SamplersFunc or returns nil.
+func (m *MockClient) Samplers(ctx context.Context) ([]SamplerInfo, error) {
+   if m.SamplersFunc != nil {
+       return m.SamplersFunc(ctx)
+   }
+   return nil, nil
+}
+
+// Schedulers calls the assigned SchedulersFunc or returns nil.
+func (m *MockClient) Schedulers(ctx context.Context) ([]SchedulerInfo, error) {
+   if m.SchedulersFunc != nil {
+       return m.SchedulersFunc(ctx)
+   }
+   return nil, nil
+}
+
+// Upscalers calls the assigned UpscalersFunc or returns nil.
+func (m *MockClient) Upscalers(ctx context.Context) ([]UpscalerInfo, error) {
+   if m.UpscalersFunc != nil {
+       return m.UpscalersFunc(ctx)
+   }
+   return nil, nil
+}
+
+// VAEs calls the assigned VAEsFunc or returns nil.
+func (m *MockClient) VAEs(ctx context.Context) ([]VAEInfo, error) {
+   if m.VAEsFunc != nil {
+       return m.VAEsFunc(ctx)
+   }
+   return nil, nil
+}
+
+// Embeddings calls the assigned EmbeddingsFunc or returns nil.
+func (m *MockClient) Embeddings(ctx context.Context) (*EmbeddingsResponse, error) {
+   if m.EmbeddingsFunc != nil {
+       return m.EmbeddingsFunc(ctx)
+   }
+   return nil, nil
+}
+
+// PromptStyles calls the assigned PromptStylesFunc or returns nil.
+func (m *MockClient) PromptStyles(ctx context.Context) ([]PromptStyle, error) {
+   if m.PromptStylesFunc != nil {
+       return m.PromptStylesFunc(ctx)
    }
    return nil, nil
-}
\ No newline at end of file
+}
+
+// Options calls the assigned OptionsFunc or returns nil.
+func (m *MockClient) Options(ctx context.Context) (map[string]interface{}, error) {
+   if m.OptionsFunc != nil {
+       return m.OptionsFunc(ctx)
+   }
+   return nil, nil
+}
+
+// InterruptCalls returns how many times Interrupt has been called.
+func (m *MockClient) InterruptCalls() int {
+   m.mu.Lock()
+   defer m.mu.Unlock()
+   return m.interruptCalls
+}
+
+// SkipCalls returns how many times Skip has been called.
+func (m *MockClient) SkipCalls() int {
+   m.mu.Lock()
+   defer m.mu.Unlock()
+   return m.skipCalls
+}
+
+// interruptIfCanceled mirrors RealClient's interrupt-on-cancel behaviour, synchronously
+// so tests can assert on InterruptCalls right after the call returns.
+func (m *MockClient) interruptIfCanceled(ctx context.Context) {
+   if ctx.Err() != nil {
+       _ = m.Interrupt(context.Background())
+   }
+}
@@ -0,0 +1,583 @@
+package forgeclient
+
+import (
+   "context"
+   "errors"
+   "fmt"
+   "log"
+   "net/url"
+   "path"
+   "strings"
+   "sync"
+   "time"
+)
+
+// ErrNoServers is returned by a Pool when no server could take a request.
+var ErrNoServers = errors.New("forgeclient: no SD-Forge server available")
+
+// DefaultHealthInterval is how often a Pool checks its servers when no interval is given.
+const DefaultHealthInterval = 15 * time.Second
+
+// ServerStatus describes one server of a Pool as of its last health check.
+type ServerStatus struct {
+   URL       string    `json:"url"`
+   Healthy   bool      `json:"healthy"`
+   Active    int       `json:"active"`
+   Model     string    `json:"model,omitempty"`
+   Switching string    `json:"switching,omitempty"`
+   Error     string    `json:"error,omitempty"`
+   CheckedAt time.Time `json:"checked_at,omitempty"`
+}
+
+// poolMember is a server of a Pool with its health and load.
+type poolMember struct {
+   url       string
+   client    Client
+   healthy   bool
+   active    int
+   model     string
+   lastErr   error
+   checkedAt time.Time
+}
+
+// requestIDKey is the context key of the ID set by WithRequestID.
+type requestIDKey struct{}
+
+// WithRequestID returns a context identifying the caller's request by id, so a Pool can
+// route Progress, Interrupt and Skip with the same ID to the server running that request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+   return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID set by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+   id, _ := ctx.Value(requestIDKey{}).(string)
+   return id
+}
+
+// Pool is a Client spreading work over several SD-Forge servers. Generations go to a
+// healthy server that already has the requested checkpoint loaded, otherwise to the least
+// busy one; requests that fail to reach a server are retried on the next. Servers are
+// health-checked with Ping, which also refreshes their loaded checkpoint. Progress,
+// Interrupt and Skip go to the server running the request named by the context's
+// RequestID; without one, they address every busy server.
+type Pool struct {
+   mu       sync.Mutex
+   members  []*poolMember
+   running  map[string]*poolMember
+   interval time.Duration
+   stop     chan struct{}
+   done     chan struct{}
+}
+
+// NewPool returns a Pool over the SD-Forge servers at urls, each called with opts,
+// checking their health every interval (DefaultHealthInterval if zero) once started.
+// Checkpoint switches are coordinated with generations on each server.
+func NewPool(urls []string, interval time.Duration, opts ClientOptions) *Pool {
+   clients := make([]Client, len(urls))
+   for i, u := range urls {
+       clients[i] = NewCoordinator(NewClientWithOptions(u, opts))
+   }
+   return newPool(urls, clients, interval)
+}
+
+// newPool builds a Pool over the given clients, named by urls.
+func newPool(urls []string, clients []Client, interval time.Duration) *Pool {
+   if interval <= 0 {
+       interval = DefaultHealthInterval
+   }
+   p := &Pool{interval: interval, running: make(map[string]*poolMember)}
+   for i, u := range urls {
+       // Servers are assumed healthy until the first check says otherwise
+       p.members = append(p.members, &poolMember{url: displayURL(u), client: clients[i], healthy: true})
+   }
+   return p
+}
+
+// Start checks all servers now and then periodically until Close.
+func (p *Pool) Start() {
+   p.mu.Lock()
+   if p.stop != nil {
+       p.mu.Unlock()
+       return
+   }
+   stop, done := make(chan struct{}), make(chan struct{})
+   p.stop, p.done = stop, done
+   p.mu.Unlock()
+   p.CheckHealth(context.Background())
+   go func() {
+       defer close(done)
+       ticker := time.NewTicker(p.interval)
+       defer ticker.Stop()
+       for {
+           select {
+           case <-stop:
+               return
+           case <-ticker.C:
+               p.CheckHealth(context.Background())
+           }
+       }
+   }()
+}
+
+// Close stops the periodic health checks.
+func (p *Pool) Close() {
+   p.mu.Lock()
+   stop, done := p.stop, p.done
+   p.stop = nil
+   p.mu.Unlock()
+   if stop != nil {
+       close(stop)
+       <-done
+   }
+}
+
+// CheckHealth pings every server and records its loaded checkpoint.
+func (p *Pool) CheckHealth(ctx context.Context) {
+   var wg sync.WaitGroup
+   for _, m := range p.snapshot() {
+       wg.Add(1)
+       go func(m *poolMember) {
+           defer wg.Done()
+           ctx, cancel := context.WithTimeout(ctx, p.interval)
+           defer cancel()
+           err := m.client.Ping(ctx)
+           model := ""
+           if err == nil {
+               if opts, oerr := m.client.Options(ctx); oerr == nil {
+                   model, _ = opts["sd_model_checkpoint"].(string)
+               }
+           }
+           p.mu.Lock()
+           if err != nil && m.healthy {
+               log.Printf("forgeclient: server %s is down: %v", m.url, err)
+           } else if err == nil && !m.healthy {
+               log.Printf("forgeclient: server %s is back up", m.url)
+           }
+           m.healthy, m.lastErr, m.checkedAt = err == nil, err, time.Now()
+           if model != "" {
+               m.model = model
+           }
+           p.mu.Unlock()
+       }(m)
+   }
+   wg.Wait()
+}
+
+// Servers reports the state of every server in the pool.
+func (p *Pool) Servers() []ServerStatus {
+   p.mu.Lock()
+   defer p.mu.Unlock()
+   out := make([]ServerStatus, len(p.members))
+   for i, m := range p.members {
+       out[i] = ServerStatus{URL: m.url, Healthy: m.healthy, Active: m.active, Model: m.model, CheckedAt: m.checkedAt}
+       if m.lastErr != nil {
+           out[i].Error = m.lastErr.Error()
+       }
+       if co, ok := m.client.(*Coordinator); ok {
+           st := co.Status()
+           if st.Model != "" {
+               out[i].Model = st.Model
+           }
+           out[i].Switching = st.Target
+       }
+   }
+   return out
+}
+
+// OnSwitch registers fn to be called after every checkpoint switch on a server.
+func (p *Pool) OnSwitch(fn func(model string)) {
+   for _, m := range p.snapshot() {
+       if co, ok := m.client.(*Coordinator); ok {
+           co.OnSwitch(fn)
+       }
+   }
+}
+
+// loadedModel returns the checkpoint m has loaded, or is switching to. The coordinator
+// sees switches made for generations and restores, which the last health check may
+// not have; p.mu is held.
+func (m *poolMember) loadedModel() string {
+   if co, ok := m.client.(*Coordinator); ok {
+       st := co.Status()
+       if st.Target != "" {
+           return st.Target
+       }
+       if st.Model != "" {
+           return st.Model
+       }
+   }
+   return m.model
+}
+
+// snapshot returns the members without holding the lock while they are used.
+func (p *Pool) snapshot() []*poolMember {
+   p.mu.Lock()
+   defer p.mu.Unlock()
+   return append([]*poolMember(nil), p.members...)
+}
+
+// checkpointKey normalizes a checkpoint title ("dir/name.safetensors [hash]") to its
+// base name, so titles, file names and model names compare equal.
+func checkpointKey(s string) string {
+   if i := strings.Index(s, " ["); i >= 0 {
+       s = s[:i]
+   }
+   s = path.Base(strings.ReplaceAll(strings.TrimSpace(s), "\\", "/"))
+   return strings.TrimSuffix(s, path.Ext(s))
+}
+
+// acquire picks the server for the next request and counts it as busy: healthy servers
+// before unreachable ones, those with checkpoint loaded first, then the least busy.
+// Servers in tried are skipped. Returns nil when none are left.
+func (p *Pool) acquire(checkpoint string, tried map[*poolMember]bool) *poolMember {
+   p.mu.Lock()
+   defer p.mu.Unlock()
+   want := checkpointKey(checkpoint)
+   score := func(m *poolMember) int {
+       s := m.active
+       if want != "" && checkpointKey(m.loadedModel()) != want {
+           // Switching checkpoints costs more than waiting behind a few jobs
+           s += 1000
+       }
+       if !m.healthy {
+           s += 1000000
+       }
+       return s
+   }
+   var best *poolMember
+   for _, m := range p.members {
+       if tried[m] {
+           continue
+       }
+       if best == nil || score(m) < score(best) {
+           best = m
+       }
+   }
+   if best != nil {
+       best.active++
+   }
+   return best
+}
+
+// release marks a request on m as finished, taking the server out of rotation until its
+// next health check if it could not be reached.
+func (p *Pool) release(m *poolMember, unreachable error) {
+   p.mu.Lock()
+   defer p.mu.Unlock()
+   m.active--
+   if unreachable != nil {
+       m.healthy, m.lastErr = false, unreachable
+       log.Printf("forgeclient: server %s unreachable, failing over: %v", m.url, unreachable)
+   }
+}
+
+// isUnreachable reports whether err means the server could not be reached (or its circuit
+// is open), as opposed to the server answering with an error, timing out or the caller
+// giving up.
+func isUnreachable(ctx context.Context, err error) bool {
+   var uerr *url.Error
+   return err != nil && ctx.Err() == nil && (errors.Is(err, ErrUnavailable) || errors.As(err, &uerr) && !errors.Is(err, ErrTimeout))
+}
+
+// do runs fn on the best server for checkpoint, moving on to the next server as long as
+// servers cannot be reached.
+func (p *Pool) do(ctx context.Context, checkpoint string, fn func(*poolMember) error) error {
+   tried := map[*poolMember]bool{}
+   var lastErr error
+   for {
+       m := p.acquire(checkpoint, tried)
+       if m == nil {
+           if lastErr != nil {
+               return fmt.Errorf("%w: %v", ErrNoServers, lastErr)
+           }
+           return ErrNoServers
+       }
+       err := fn(m)
+       if !isUnreachable(ctx, err) {
+           p.release(m, nil)
+           return err
+       }
+       p.release(m, err)
+       tried[m] = true
+       lastErr = err
+   }
+}
+
+// track records m as the server running the request identified by ctx until the returned
+// function is called.
+func (p *Pool) track(ctx context.Context, m *poolMember) func() {
+   id := RequestID(ctx)
+   if id == "" {
+       return func() {}
+   }
+   p.mu.Lock()
+   p.running[id] = m
+   p.mu.Unlock()
+   return func() {
+       p.mu.Lock()
+       if p.running[id] == m {
+           delete(p.running, id)
+       }
+       p.mu.Unlock()
+   }
+}
+
+// targets returns the servers a Progress, Interrupt or Skip call applies to: the server
+// running the request identified by ctx, none if that request is not running, or the busy
+// servers (all if none are busy) when ctx names no request.
+func (p *Pool) targets(ctx context.Context) []*poolMember {
+   if id := RequestID(ctx); id != "" {
+       p.mu.Lock()
+       defer p.mu.Unlock()
+       if m, ok := p.running[id]; ok {
+           return []*poolMember{m}
+       }
+       return nil
+   }
+   return p.busyOrAll()
+}
+
+// busyOrAll returns the servers currently running requests, or all servers if none are.
+func (p *Pool) busyOrAll() []*poolMember {
+   p.mu.Lock()
+   defer p.mu.Unlock()
+   var busy []*poolMember
+   for _, m := range p.members {
+       if m.active > 0 {
+           busy = append(busy, m)
+       }
+   }
+   if len(busy) == 0 {
+       return append([]*poolMember(nil), p.members...)
+   }
+   return busy
+}
+
+// broadcast runs fn on every server in members, succeeding if any server succeeds.
+func broadcast(members []*poolMember, fn func(Client) error) error {
+   var firstErr error
+   ok := false
+   for _, m := range members {
+       if err := fn(m.client); err != nil {
+           if firstErr == nil {
+               firstErr = err
+           }
+           continue
+       }
+       ok = true
+   }
+   if ok || firstErr == nil {
+       return nil
+   }
+   return firstErr
+}
+
+// requestedCheckpoint returns the checkpoint a generation overrides, if any.
+func requestedCheckpoint(overrides map[string]interface{}) string {
+   s, _ := overrides["sd_model_checkpoint"].(string)
+   return s
+}
+
+// Txt2Img runs on a server with the requested checkpoint loaded, or the least busy one.
+func (p *Pool) Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error) {
+   var out *ImageResponse
+   err := p.do(ctx, requestedCheckpoint(req.OverrideSettings), func(m *poolMember) (err error) {
+       defer p.track(ctx, m)()
+       out, err = m.client.Txt2Img(ctx, req)
+       return err
+   })
+   return out, err
+}
+
+// Img2Img runs on a server with the requested checkpoint loaded, or the least busy one.
+func (p *Pool) Img2Img(ctx context.Context, req *Img2ImgRequest) (*ImageResponse, error) {
+   var out *ImageResponse
+   err := p.do(ctx, requestedCheckpoint(req.OverrideSettings), func(m *poolMember) (err error) {
+       defer p.track(ctx, m)()
+       out, err = m.client.Img2Img(ctx, req)
+       return err
+   })
+   return out, err
+}
+
+// Progress reports the progress of the server running the caller's request, which is idle
+// once the request has finished. Without a request ID, the first busy server is reported.
+func (p *Pool) Progress(ctx context.Context, skipCurrent bool) (*ProgressResponse, error) {
+   members := p.targets(ctx)
+   if members == nil && RequestID(ctx) != "" {
+       return &ProgressResponse{}, nil
+   }
+   var firstErr error
+   for _, m := range members {
+       out, err := m.client.Progress(ctx, skipCurrent)
+       if err == nil {
+           return out, nil
+       }
+       if firstErr == nil {
+           firstErr = err
+       }
+   }
+   if firstErr == nil {
+       firstErr = ErrNoServers
+   }
+   return nil, firstErr
+}
+
+// Extras runs on the least busy server.
+func (p *Pool) Extras(ctx context.Context, req *ExtrasRequest) (*ExtrasResponse, error) {
+   var out *ExtrasResponse
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Extras(ctx, req)
+       return err
+   })
+   return out, err
+}
+
+// ExtrasBatch runs on the least busy server.
+func (p *Pool) ExtrasBatch(ctx context.Context, req *ExtrasBatchRequest) (*ExtrasBatchResponse, error) {
+   var out *ExtrasBatchResponse
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.ExtrasBatch(ctx, req)
+       return err
+   })
+   return out, err
+}
+
+// Models lists the checkpoints of a reachable server; all servers are expected to share them.
+func (p *Pool) Models(ctx context.Context) ([]ModelInfo, error) {
+   var out []ModelInfo
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Models(ctx)
+       return err
+   })
+   return out, err
+}
+
+// SwitchModel loads model on the least busy server unless a server already has it loaded,
+// so other servers keep their checkpoints for jobs pinned to them.
+func (p *Pool) SwitchModel(ctx context.Context, model string) error {
+   return p.do(ctx, model, func(m *poolMember) error {
+       p.mu.Lock()
+       loaded := checkpointKey(m.loadedModel()) == checkpointKey(model)
+       p.mu.Unlock()
+       if loaded {
+           return nil
+       }
+       if err := m.client.SwitchModel(ctx, model); err != nil {
+           return err
+       }
+       p.mu.Lock()
+       m.model = model
+       p.mu.Unlock()
+       return nil
+   })
+}
+
+// Loras lists the LoRAs of a reachable server.
+func (p *Pool) Loras(ctx context.Context) ([]LoraInfo, error) {
+   var out []LoraInfo
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Loras(ctx)
+       return err
+   })
+   return out, err
+}
+
+// RefreshLoras rescans LoRAs on every server.
+func (p *Pool) RefreshLoras(ctx context.Context) error {
+   return broadcast(p.snapshot(), func(c Client) error { return c.RefreshLoras(ctx) })
+}
+
+// Ping checks every server and succeeds if any is healthy.
+func (p *Pool) Ping(ctx context.Context) error {
+   p.CheckHealth(ctx)
+   for _, s := range p.Servers() {
+       if s.Healthy {
+           return nil
+       }
+   }
+   return ErrNoServers
+}
+
+// Interrupt stops the generation of the caller's request, or those running on the busy
+// servers without a request ID.
+func (p *Pool) Interrupt(ctx context.Context) error {
+   return broadcast(p.targets(ctx), func(c Client) error { return c.Interrupt(ctx) })
+}
+
+// Skip skips the current image of the caller's request, or on the busy servers without a
+// request ID.
+func (p *Pool) Skip(ctx context.Context) error {
+   return broadcast(p.targets(ctx), func(c Client) error { return c.Skip(ctx) })
+}
+
+// Samplers lists the samplers of a reachable server.
+func (p *Pool) Samplers(ctx context.Context) ([]SamplerInfo, error) {
+   var out []SamplerInfo
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Samplers(ctx)
+       return err
+   })
+   return out, err
+}
+
+// Schedulers lists the schedulers of a reachable server.
+func (p *Pool) Schedulers(ctx context.Context) ([]SchedulerInfo, error) {
+   var out []SchedulerInfo
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Schedulers(ctx)
+       return err
+   })
+   return out, err
+}
+
+// Upscalers lists the upscalers of a reachable server.
+func (p *Pool) Upscalers(ctx context.Context) ([]UpscalerInfo, error) {
+   var out []UpscalerInfo
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Upscalers(ctx)
+       return err
+   })
+   return out, err
+}
+
+// VAEs lists the VAEs of a reachable server.
+func (p *Pool) VAEs(ctx context.Context) ([]VAEInfo, error) {
+   var out []VAEInfo
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.VAEs(ctx)
+       return err
+   })
+   return out, err
+}
+
+// Embeddings lists the embeddings of a reachable server.
+func (p *Pool) Embeddings(ctx context.Context) (*EmbeddingsResponse, error) {
+   var out *EmbeddingsResponse
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Embeddings(ctx)
+       return err
+   })
+   return out, err
+}
+
+// PromptStyles lists the prompt styles of a reachable server.
+func (p *Pool) PromptStyles(ctx context.Context) ([]PromptStyle, error) {
+   var out []PromptStyle
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.PromptStyles(ctx)
+       return err
+   })
+   return out, err
+}
+
+// Options returns the options of a reachable server.
+func (p *Pool) Options(ctx context.Context) (map[string]interface{}, error) {
+   var out map[string]interface{}
+   err := p.do(ctx, "", func(m *poolMember) (err error) {
+       out, err = m.client.Options(ctx)
+       return err
+   })
+   return out, err
+}
@@ -0,0 +1,187 @@
+package forgeclient
+
+import (
+   "context"
+   "errors"
+   "fmt"
+   "net/http"
+   "net/http/httptest"
+   "sync/atomic"
+   "testing"
+   "time"
+)
+
+// fakeForge is a minimal SD-Forge server with a loaded checkpoint that counts generations
+// and can hold them until released.
+type fakeForge struct {
+   *httptest.Server
+   model      string
+   hits       int32
+   blocking   int32
+   interrupts int32
+   release    chan struct{}
+}
+
+func newFakeForge(t *testing.T, model string) *fakeForge {
+   f := &fakeForge{model: model, release: make(chan struct{})}
+   f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       switch r.URL.Path {
+       case "/internal/ping":
+           w.Write([]byte(`{}`))
+       case "/sdapi/v1/options":
+           if r.Method == http.MethodPost {
+               w.Write([]byte(`null`))
+               return
+           }
+           fmt.Fprintf(w, `{"sd_model_checkpoint": %q}`, f.model)
+       case "/sdapi/v1/txt2img":
+           atomic.AddInt32(&f.hits, 1)
+           if atomic.LoadInt32(&f.blocking) == 1 {
+               <-f.release
+           }
+           fmt.Fprintf(w, `{"images": [], "info": %q}`, f.URL)
+       case "/sdapi/v1/progress":
+           fmt.Fprintf(w, `{"progress": 0.5, "current_image": %q}`, f.URL)
+       case "/sdapi/v1/interrupt":
+           atomic.AddInt32(&f.interrupts, 1)
+           w.Write([]byte(`{}`))
+       default:
+           http.NotFound(w, r)
+       }
+   }))
+   t.Cleanup(f.Close)
+   return f
+}
+
+func TestPoolPinsCheckpointAndBalancesLoad(t *testing.T) {
+   a := newFakeForge(t, "sdxl_base.safetensors [31e35c80fc]")
+   b := newFakeForge(t, "flux1-dev.safetensors [abcdef1234]")
+   p := NewPool([]string{a.URL, b.URL}, time.Minute, ClientOptions{RequestTimeout: time.Second})
+   p.Start()
+   defer p.Close()
+
+   // Jobs go to the server that already has the checkpoint, by title or file name
+   for _, ckpt := range []string{"flux1-dev", "models/flux1-dev.safetensors", "flux1-dev.safetensors [abcdef1234]"} {
+       resp, err := p.Txt2Img(context.Background(), &Txt2ImgRequest{OverrideSettings: map[string]interface{}{"sd_model_checkpoint": ckpt}})
+       if err != nil || resp.Info != b.URL {
+           t.Fatalf("%s: expected server b, got %v (%v)", ckpt, resp, err)
+       }
+   }
+   if atomic.LoadInt32(&a.hits) != 0 {
+       t.Errorf("server a should not have been used")
+   }
+
+   // Without a checkpoint, a busy server is passed over for an idle one
+   atomic.StoreInt32(&a.blocking, 1)
+   done := make(chan *ImageResponse)
+   go func() {
+       resp, _ := p.Txt2Img(context.Background(), &Txt2ImgRequest{})
+       done <- resp
+   }()
+   for atomic.LoadInt32(&a.hits) == 0 {
+       time.Sleep(time.Millisecond)
+   }
+   resp, err := p.Txt2Img(context.Background(), &Txt2ImgRequest{})
+   if err != nil || resp.Info != b.URL {
+       t.Errorf("expected the idle server b, got %v (%v)", resp, err)
+   }
+   close(a.release)
+   if resp := <-done; resp.Info != a.URL {
+       t.Errorf("expected the first job on server a, got %v", resp)
+   }
+   for _, s := range p.Servers() {
+       if !s.Healthy || s.Active != 0 || s.Model == "" {
+           t.Errorf("unexpected server status %+v", s)
+       }
+   }
+}
+
+func TestPoolFailsOverWhenServerIsDown(t *testing.T) {
+   up := newFakeForge(t, "model")
+   down := newFakeForge(t, "wanted")
+   down.Close()
+   // The down server is preferred for its checkpoint until the pool notices it is gone
+   p := NewPool([]string{down.URL, up.URL}, time.Minute, ClientOptions{RequestTimeout: time.Second})
+   p.members[0].model = "wanted"
+   resp, err := p.Txt2Img(context.Background(), &Txt2ImgRequest{OverrideSettings: map[string]interface{}{"sd_model_checkpoint": "wanted"}})
+   if err != nil || resp.Info != up.URL {
+       t.Fatalf("expected failover to the live server, got %v (%v)", resp, err)
+   }
+   if s := p.Servers(); s[0].Healthy || s[0].Error == "" || !s[1].Healthy {
+       t.Errorf("expected the down server marked unhealthy: %+v", s)
+   }
+   if err := p.Ping(context.Background()); err != nil {
+       t.Errorf("ping should succeed with one healthy server: %v", err)
+   }
+
+   up.Close()
+   if _, err := p.Txt2Img(context.Background(), &Txt2ImgRequest{}); !errors.Is(err, ErrNoServers) {
+       t.Errorf("expected ErrNoServers with all servers down, got %v", err)
+   }
+   if err := p.Ping(context.Background()); !errors.Is(err, ErrNoServers) {
+       t.Errorf("expected ping to fail with all servers down, got %v", err)
+   }
+}
+
+func TestPoolRoutesControlsToTheRequestsServer(t *testing.T) {
+   a, b := newFakeForge(t, "a"), newFakeForge(t, "b")
+   atomic.StoreInt32(&a.blocking, 1)
+   atomic.StoreInt32(&b.blocking, 1)
+   p := NewPool([]string{a.URL, b.URL}, time.Minute, ClientOptions{RequestTimeout: time.Second})
+   p.CheckHealth(context.Background())
+   done := make(chan struct{}, 2)
+   for _, id := range []string{"job-a", "job-b"} {
+       ckpt := id[len(id)-1:]
+       go func(id string) {
+           p.Txt2Img(WithRequestID(context.Background(), id), &Txt2ImgRequest{OverrideSettings: map[string]interface{}{"sd_model_checkpoint": ckpt}})
+           done <- struct{}{}
+       }(id)
+   }
+   for atomic.LoadInt32(&a.hits) == 0 || atomic.LoadInt32(&b.hits) == 0 {
+       time.Sleep(time.Millisecond)
+   }
+
+   resp, err := p.Progress(WithRequestID(context.Background(), "job-b"), false)
+   if err != nil || resp.CurrentImage != b.URL {
+       t.Errorf("expected the progress of server b, got %v (%v)", resp, err)
+   }
+   if err := p.Interrupt(WithRequestID(context.Background(), "job-b")); err != nil {
+       t.Fatalf("interrupt: %v", err)
+   }
+   if atomic.LoadInt32(&a.interrupts) != 0 || atomic.LoadInt32(&b.interrupts) != 1 {
+       t.Errorf("expected only server b interrupted, got a=%d b=%d", a.interrupts, b.interrupts)
+   }
+   // Requests that are not running are neither reported nor interrupted
+   if resp, err := p.Progress(WithRequestID(context.Background(), "other"), false); err != nil || resp.Progress != 0 {
+       t.Errorf("expected idle progress for an unknown request, got %v (%v)", resp, err)
+   }
+   if err := p.Interrupt(WithRequestID(context.Background(), "other")); err != nil || atomic.LoadInt32(&a.interrupts)+atomic.LoadInt32(&b.interrupts) != 1 {
+       t.Errorf("an unknown request should interrupt nothing (%v)", err)
+   }
+   // Without a request ID every busy server is addressed
+   if err := p.Interrupt(context.Background()); err != nil || atomic.LoadInt32(&a.interrupts) != 1 || atomic.LoadInt32(&b.interrupts) != 2 {
+       t.Errorf("expected both busy servers interrupted (%v)", err)
+   }
+   close(a.release)
+   close(b.release)
+   <-done
+   <-done
+   if len(p.running) != 0 {
+       t.Errorf("finished requests should be forgotten: %v", p.running)
+   }
+}
+
+func TestPoolFollowsCoordinatorSwitches(t *testing.T) {
+   a, b := newFakeForge(t, "x"), newFakeForge(t, "y")
+   p := NewPool([]string{a.URL, b.URL}, time.Minute, ClientOptions{RequestTimeout: time.Second})
+   p.CheckHealth(context.Background())
+   // A switch made by server b's coordinator, e.g. for a generation, is not seen by a health check
+   b.model = "z"
+   if err := p.members[1].client.(*Coordinator).SwitchModel(context.Background(), "z"); err != nil {
+       t.Fatalf("switch: %v", err)
+   }
+   resp, err := p.Txt2Img(context.Background(), &Txt2ImgRequest{OverrideSettings: map[string]interface{}{"sd_model_checkpoint": "z"}})
+   if err != nil || resp.Info != b.URL {
+       t.Errorf("expected server b, which has z loaded now, got %v (%v)", resp, err)
+   }
+}
@@ -2,28 +2,105 @@ package forgeclient
 
 import (
    "context"
+   "encoding/json"
+   "fmt"
 )
 
 // Txt2ImgRequest defines parameters for a text-to-image generation request.
+// Zero values are omitted so that SD-Forge applies its own defaults; fields where
+// zero is meaningful are pointers.
 type Txt2ImgRequest struct {
-   Prompt         string  `json:"prompt"`
-   NegativePrompt string  `json:"negative_prompt,omitempty"`
-   Steps          int     `json:"steps,omitempty"`
-   CFGScale       float32 `json:"cfg_scale,omitempty"`
-   Width          int     `json:"width,omitempty"`
-   Height         int     `json:"height,omitempty"`
-   Seed           *int    `json:"seed,omitempty"`
+   Prompt            string   `json:"prompt"`
+   NegativePrompt    string   `json:"negative_prompt,omitempty"`
+   Styles            []string `json:"styles,omitempty"`
+   Steps             int      `json:"steps,omitempty"`
+   CFGScale          float32  `json:"cfg_scale,omitempty"`
+   DistilledCFGScale float32  `json:"distilled_cfg_scale,omitempty"`
+   Width             int      `json:"width,omitempty"`
+   Height            int      `json:"height,omitempty"`
+   Seed              *int     `json:"seed,omitempty"`
+   Subseed           *int     `json:"subseed,omitempty"`
+   SubseedStrength   float32  `json:"subseed_strength,omitempty"`
+   SeedResizeFromW   int      `json:"seed_resize_from_w,omitempty"`
+   SeedResizeFromH   int      `json:"seed_resize_from_h,omitempty"`
+   SamplerName       string   `json:"sampler_name,omitempty"`
+   Scheduler         string   `json:"scheduler,omitempty"`
+   BatchSize         int      `json:"batch_size,omitempty"`
+   NIter             int      `json:"n_iter,omitempty"`
+   RestoreFaces      *bool    `json:"restore_faces,omitempty"`
+   Tiling            *bool    `json:"tiling,omitempty"`
+
+   // Hires fix
+   EnableHR          bool    `json:"enable_hr,omitempty"`
+   DenoisingStrength float32 `json:"denoising_strength,omitempty"`
+   HRScale           float32 `json:"hr_scale,omitempty"`
+   HRUpscaler        string  `json:"hr_upscaler,omitempty"`
+   HRSecondPassSteps int     `json:"hr_second_pass_steps,omitempty"`
+   HRResizeX         int     `json:"hr_resize_x,omitempty"`
+   HRResizeY         int     `json:"hr_resize_y,omitempty"`
+   HRCheckpointName  string  `json:"hr_checkpoint_name,omitempty"`
+   HRSamplerName     string  `json:"hr_sampler_name,omitempty"`
+   HRScheduler       string  `json:"hr_scheduler,omitempty"`
+   HRPrompt          string  `json:"hr_prompt,omitempty"`
+   HRNegativePrompt  string  `json:"hr_negative_prompt,omitempty"`
+
+   OverrideSettings                  map[string]interface{} `json:"override_settings,omitempty"`
+   OverrideSettingsRestoreAfterwards *bool                  `json:"override_settings_restore_afterwards,omitempty"`
+   // AlwaysonScripts is passed to SD-Forge unchanged (ControlNet, ADetailer, ...).
+   AlwaysonScripts json.RawMessage `json:"alwayson_scripts,omitempty"`
+   // ForceTaskID names the request in SD-Forge's task queue; the client sets a random one
+   // when empty, so that it can tell whether the request is the one running.
+   ForceTaskID string `json:"force_task_id,omitempty"`
 }
 
 // Img2ImgRequest defines parameters for an image-to-image (inpainting) request.
+// Zero values are omitted so that SD-Forge applies its own defaults; fields where
+// zero is meaningful are pointers.
 type Img2ImgRequest struct {
    InitImages        []string `json:"init_images"`
    Mask              string   `json:"mask,omitempty"`
    Prompt            string   `json:"prompt"`
    NegativePrompt    string   `json:"negative_prompt,omitempty"`
+   Styles            []string `json:"styles,omitempty"`
    Steps             int      `json:"steps,omitempty"`
    CFGScale          float32  `json:"cfg_scale,omitempty"`
+   DistilledCFGScale float32  `json:"distilled_cfg_scale,omitempty"`
+   ImageCFGScale     float32  `json:"image_cfg_scale,omitempty"`
    DenoisingStrength float32  `json:"denoising_strength,omitempty"`
+   Width             int      `json:"width,omitempty"`
+   Height            int      `json:"height,omitempty"`
+   Seed              *int     `json:"seed,omitempty"`
+   Subseed           *int     `json:"subseed,omitempty"`
+   SubseedStrength   float32  `json:"subseed_strength,omitempty"`
+   SeedResizeFromW   int      `json:"seed_resize_from_w,omitempty"`
+   SeedResizeFromH   int      `json:"seed_resize_from_h,omitempty"`
+   SamplerName       string   `json:"sampler_name,omitempty"`
+   Scheduler         string   `json:"scheduler,omitempty"`
+   BatchSize         int      `json:"batch_size,omitempty"`
+   NIter             int      `json:"n_iter,omitempty"`
+   RestoreFaces      *bool    `json:"restore_faces,omitempty"`
+   Tiling            *bool    `json:"tiling,omitempty"`
+   // ResizeMode: 0 just resize, 1 crop and resize, 2 resize and fill, 3 latent upscale.
+   ResizeMode int `json:"resize_mode,omitempty"`
+
+   // Inpainting
+   MaskBlur               *int    `json:"mask_blur,omitempty"`
+   MaskBlurX              *int    `json:"mask_blur_x,omitempty"`
+   MaskBlurY              *int    `json:"mask_blur_y,omitempty"`
+   // InpaintingFill: 0 fill, 1 original, 2 latent noise, 3 latent nothing.
+   InpaintingFill         int     `json:"inpainting_fill,omitempty"`
+   InpaintFullRes         *bool   `json:"inpaint_full_res,omitempty"`
+   InpaintFullResPadding  int     `json:"inpaint_full_res_padding,omitempty"`
+   InpaintingMaskInvert   int     `json:"inpainting_mask_invert,omitempty"`
+   InitialNoiseMultiplier float32 `json:"initial_noise_multiplier,omitempty"`
+
+   OverrideSettings                  map[string]interface{} `json:"override_settings,omitempty"`
+   OverrideSettingsRestoreAfterwards *bool                  `json:"override_settings_restore_afterwards,omitempty"`
+   // AlwaysonScripts is passed to SD-Forge unchanged (ControlNet, ADetailer, ...).
+   AlwaysonScripts json.RawMessage `json:"alwayson_scripts,omitempty"`
+   // ForceTaskID names the request in SD-Forge's task queue; the client sets a random one
+   // when empty, so that it can tell whether the request is the one running.
+   ForceTaskID string `json:"force_task_id,omitempty"`
 }
 
 // ImageResponse represents a response from an image generation or editing call.
@@ -35,21 +112,76 @@ type ImageResponse struct {
 
 // ProgressResponse represents progress information from the server.
 type ProgressResponse struct {
-   CurrentImage string  `json:"current_image"`
-   Progress     float32 `json:"progress"`
-   ETA          float32 `json:"eta_relative"`
+   CurrentImage string         `json:"current_image"`
+   Progress     float32        `json:"progress"`
+   ETA          float32        `json:"eta_relative"`
+   State        *ProgressState `json:"state,omitempty"`
+}
+
+// TaskProgress is the state of one task in SD-Forge's queue (/internal/progress).
+type TaskProgress struct {
+   Active    bool `json:"active"`
+   Queued    bool `json:"queued"`
+   Completed bool `json:"completed"`
 }
 
-// Extras parameters for single-image operations.
-type ExtrasParams struct {
-   Operation string `json:"operation"`
-   Image     string `json:"image"`
+// ProgressState is the sampler state reported alongside progress.
+type ProgressState struct {
+   Skipped       bool   `json:"skipped"`
+   Interrupted   bool   `json:"interrupted"`
+   Job           string `json:"job"`
+   JobCount      int    `json:"job_count"`
+   JobNo         int    `json:"job_no"`
+   SamplingStep  int    `json:"sampling_step"`
+   SamplingSteps int    `json:"sampling_steps"`
 }
 
-// ExtrasBatchParams for batch operations.
-type ExtrasBatchParams struct {
-   Operations []string `json:"operations"`
-   Images     []string `json:"images"`
+// ExtrasOptions are the postprocessing parameters shared by single and batch extras.
+// Zero values are omitted so that SD-Forge applies its own defaults.
+type ExtrasOptions struct {
+   // ResizeMode: 0 scales by UpscalingResize, 1 scales to UpscalingResizeW x UpscalingResizeH.
+   ResizeMode           int     `json:"resize_mode,omitempty"`
+   UpscalingResize      float32 `json:"upscaling_resize,omitempty"`
+   UpscalingResizeW     int     `json:"upscaling_resize_w,omitempty"`
+   UpscalingResizeH     int     `json:"upscaling_resize_h,omitempty"`
+   UpscalingCrop        *bool   `json:"upscaling_crop,omitempty"`
+   Upscaler1            string  `json:"upscaler_1,omitempty"`
+   Upscaler2            string  `json:"upscaler_2,omitempty"`
+   Upscaler2Visibility  float32 `json:"extras_upscaler_2_visibility,omitempty"`
+   UpscaleFirst         bool    `json:"upscale_first,omitempty"`
+   GFPGANVisibility     float32 `json:"gfpgan_visibility,omitempty"`
+   CodeFormerVisibility float32 `json:"codeformer_visibility,omitempty"`
+   CodeFormerWeight     float32 `json:"codeformer_weight,omitempty"`
+}
+
+// ExtrasRequest is a single-image postprocessing request (/sdapi/v1/extra-single-image).
+type ExtrasRequest struct {
+   ExtrasOptions
+   Image string `json:"image"`
+}
+
+// ExtrasResponse is the result of a single-image postprocessing request.
+type ExtrasResponse struct {
+   HTMLInfo string `json:"html_info"`
+   Image    string `json:"image"`
+}
+
+// ExtrasBatchImage is one input of a batch postprocessing request.
+type ExtrasBatchImage struct {
+   Data string `json:"data"`
+   Name string `json:"name"`
+}
+
+// ExtrasBatchRequest is a batch postprocessing request (/sdapi/v1/extra-batch-images).
+type ExtrasBatchRequest struct {
+   ExtrasOptions
+   ImageList []ExtrasBatchImage `json:"imageList"`
+}
+
+// ExtrasBatchResponse is the result of a batch postprocessing request, one image per input.
+type ExtrasBatchResponse struct {
+   HTMLInfo string   `json:"html_info"`
+   Images   []string `json:"images"`
 }
 
 // ModelInfo describes an available SD model.
@@ -57,15 +189,69 @@ type ModelInfo struct {
    Name string `json:"model_name"`
 }
 
-// LoraInfo describes an available LoRA.
+// LoraInfo describes an available LoRA as reported by /sdapi/v1/loras.
 type LoraInfo struct {
-   Name string `json:"name"`
+   Name  string `json:"name"`
+   Alias string `json:"alias"`
+   Path  string `json:"path"`
+   // Metadata is the training metadata embedded in the file (ss_* keys for kohya-trained LoRAs).
+   Metadata map[string]interface{} `json:"metadata,omitempty"`
+   // TriggerWords are the most frequent training tags, derived from ss_tag_frequency.
+   TriggerWords []string `json:"trigger_words,omitempty"`
+}
+
+// SamplerInfo describes a sampler supported by the server.
+type SamplerInfo struct {
+   Name    string            `json:"name"`
+   Aliases []string          `json:"aliases"`
+   Options map[string]string `json:"options"`
+}
+
+// SchedulerInfo describes a noise schedule supported by the server.
+type SchedulerInfo struct {
+   Name           string   `json:"name"`
+   Label          string   `json:"label"`
+   Aliases        []string `json:"aliases"`
+   DefaultRho     float64  `json:"default_rho"`
+   NeedInnerModel bool     `json:"need_inner_model"`
+}
+
+// UpscalerInfo describes an upscaler usable for hires fix and extras.
+type UpscalerInfo struct {
+   Name      string   `json:"name"`
+   ModelName *string  `json:"model_name"`
+   ModelPath *string  `json:"model_path"`
+   ModelURL  *string  `json:"model_url"`
+   Scale     *float64 `json:"scale"`
+}
+
+// VAEInfo describes an available VAE.
+type VAEInfo struct {
+   ModelName string `json:"model_name"`
+   Filename  string `json:"filename"`
 }
 
-// HistoryEntry represents a past version entry.
-type HistoryEntry struct {
-   Timestamp string `json:"timestamp"`
-   Url       string `json:"url"`
+// EmbeddingInfo describes a textual inversion embedding.
+type EmbeddingInfo struct {
+   Step             *int    `json:"step"`
+   SDCheckpoint     *string `json:"sd_checkpoint"`
+   SDCheckpointName *string `json:"sd_checkpoint_name"`
+   Shape            int     `json:"shape"`
+   Vectors          int     `json:"vectors"`
+}
+
+// EmbeddingsResponse lists embeddings that loaded and those skipped as incompatible
+// with the current model.
+type EmbeddingsResponse struct {
+   Loaded  map[string]EmbeddingInfo `json:"loaded"`
+   Skipped map[string]EmbeddingInfo `json:"skipped"`
+}
+
+// PromptStyle is a saved prompt style.
+type PromptStyle struct {
+   Name           string `json:"name"`
+   Prompt         string `json:"prompt"`
+   NegativePrompt string `json:"negative_prompt"`
 }
 
 // Client defines the interface for communicating with an SD-Forge server.
@@ -73,11 +259,42 @@ type Client interface {
    Txt2Img(ctx context.Context, req *Txt2ImgRequest) (*ImageResponse, error)
    Img2Img(ctx context.Context, req *Img2ImgRequest) (*ImageResponse, error)
    Progress(ctx context.Context, skipCurrent bool) (*ProgressResponse, error)
-   Extras(ctx context.Context, operation string, image string) (*ImageResponse, error)
-   ExtrasBatch(ctx context.Context, operations []string, images []string) ([]ImageResponse, error)
+   Extras(ctx context.Context, req *ExtrasRequest) (*ExtrasResponse, error)
+   ExtrasBatch(ctx context.Context, req *ExtrasBatchRequest) (*ExtrasBatchResponse, error)
    Models(ctx context.Context) ([]ModelInfo, error)
    SwitchModel(ctx context.Context, model string) error
    Loras(ctx context.Context) ([]LoraInfo, error)
+   RefreshLoras(ctx context.Context) error
    Ping(ctx context.Context) error
-   History(ctx context.Context, imageID string) ([]HistoryEntry, error)
-}
\ No newline at end of file
+   Interrupt(ctx context.Context) error
+   Skip(ctx context.Context) error
+   Samplers(ctx context.Context) ([]SamplerInfo, error)
+   Schedulers(ctx context.Context) ([]SchedulerInfo, error)
+   Upscalers(ctx context.Context) ([]UpscalerInfo, error)
+   VAEs(ctx context.Context) ([]VAEInfo, error)
+   Embeddings(ctx context.Context) (*EmbeddingsResponse, error)
+   PromptStyles(ctx context.Context) ([]PromptStyle, error)
+   Options(ctx context.Context) (map[string]interface{}, error)
+}
+
+// GenerationInfo is the decoded form of ImageResponse.Info returned by txt2img and img2img.
+type GenerationInfo struct {
+   Seed              int64    `json:"seed"`
+   AllSeeds          []int64  `json:"all_seeds"`
+   ModelName         string   `json:"sd_model_name"`
+   ModelHash         string   `json:"sd_model_hash"`
+   Infotexts         []string `json:"infotexts"`
+   IndexOfFirstImage int      `json:"index_of_first_image"`
+}
+
+// ParseInfo decodes the JSON info string of an ImageResponse.
+func (r *ImageResponse) ParseInfo() (*GenerationInfo, error) {
+   var info GenerationInfo
+   if r.Info == "" {
+       return &info, nil
+   }
+   if err := json.Unmarshal([]byte(r.Info), &info); err != nil {
+       return nil, fmt.Errorf("forgeclient: decode info: %w", err)
+   }
+   return &info, nil
+}
@@ -0,0 +1,138 @@
+package imaging
+
+import (
+   "bytes"
+   "errors"
+   "image"
+   "image/draw"
+   "image/png"
+   "math"
+
+   // Register decoders for library images
+   _ "image/jpeg"
+)
+
+// ErrWebPUnsupported is returned by Decode for WebP data: the library lists WebP images
+// but only PNG and JPEG can be decoded for local edits.
+var ErrWebPUnsupported = errors.New("WebP images cannot be edited locally; convert them to PNG or JPEG first")
+
+// Decode decodes PNG or JPEG data into an NRGBA image with bounds starting at the origin.
+func Decode(data []byte) (*image.NRGBA, error) {
+   if len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
+       return nil, ErrWebPUnsupported
+   }
+   img, _, err := image.Decode(bytes.NewReader(data))
+   if err != nil {
+       return nil, err
+   }
+   return ToNRGBA(img), nil
+}
+
+// ToNRGBA converts img to NRGBA with bounds starting at the origin.
+func ToNRGBA(img image.Image) *image.NRGBA {
+   b := img.Bounds()
+   out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+   draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+   return out
+}
+
+// EncodePNG encodes img as PNG.
+func EncodePNG(img image.Image) ([]byte, error) {
+   var buf bytes.Buffer
+   if err := png.Encode(&buf, img); err != nil {
+       return nil, err
+   }
+   return buf.Bytes(), nil
+}
+
+// Composite blends overlay onto base where mask is set: each pixel becomes
+// base*(1-m) + overlay*m with m the mask value scaled to 0..1. All three must be the same size.
+func Composite(base, overlay *image.NRGBA, mask *image.Gray) *image.NRGBA {
+   out := image.NewNRGBA(base.Bounds())
+   copy(out.Pix, base.Pix)
+   b := base.Bounds()
+   for y := 0; y < b.Dy(); y++ {
+       for x := 0; x < b.Dx(); x++ {
+           m := int(mask.Pix[y*mask.Stride+x])
+           if m == 0 {
+               continue
+           }
+           i := y*out.Stride + x*4
+           j := y*overlay.Stride + x*4
+           for k := 0; k < 4; k++ {
+               out.Pix[i+k] = uint8((int(base.Pix[i+k])*(255-m) + int(overlay.Pix[j+k])*m + 127) / 255)
+           }
+       }
+   }
+   return out
+}
+
+// Resize scales img to w x h with bilinear interpolation.
+func Resize(img *image.NRGBA, w, h int) *image.NRGBA {
+   b := img.Bounds()
+   if b.Dx() == w && b.Dy() == h {
+       return img
+   }
+   out := image.NewNRGBA(image.Rect(0, 0, w, h))
+   sx := float64(b.Dx()) / float64(w)
+   sy := float64(b.Dy()) / float64(h)
+   for y := 0; y < h; y++ {
+       fy := math.Max((float64(y)+0.5)*sy-0.5, 0)
+       y0 := int(fy)
+       y1 := min(y0+1, b.Dy()-1)
+       ty := fy - float64(y0)
+       for x := 0; x < w; x++ {
+           fx := math.Max((float64(x)+0.5)*sx-0.5, 0)
+           x0 := int(fx)
+           x1 := min(x0+1, b.Dx()-1)
+           tx := fx - float64(x0)
+           o := y*out.Stride + x*4
+           for k := 0; k < 4; k++ {
+               p00 := float64(img.Pix[y0*img.Stride+x0*4+k])
+               p10 := float64(img.Pix[y0*img.Stride+x1*4+k])
+               p01 := float64(img.Pix[y1*img.Stride+x0*4+k])
+               p11 := float64(img.Pix[y1*img.Stride+x1*4+k])
+               top := p00 + (p10-p00)*tx
+               bottom := p01 + (p11-p01)*tx
+               out.Pix[o+k] = uint8(math.Round(top + (bottom-top)*ty))
+           }
+       }
+   }
+   return out
+}
+
+// GenerationSize rounds an image dimension to the multiple of 8 the diffusion model
+// works with, with a floor of 64.
+func GenerationSize(v int) int {
+   v = (v + 4) / 8 * 8
+   if v < 64 {
+       v = 64
+   }
+   return v
+}
+
+// Extend returns img on a larger canvas with left, top, right and bottom pixels added on
+// each side. The new area repeats the nearest edge pixel, a neutral start for outpainting.
+func Extend(img *image.NRGBA, left, top, right, bottom int) *image.NRGBA {
+   b := img.Bounds()
+   w, h := b.Dx(), b.Dy()
+   out := image.NewNRGBA(image.Rect(0, 0, w+left+right, h+top+bottom))
+   for y := 0; y < out.Rect.Dy(); y++ {
+       sy := min(max(y-top, 0), h-1)
+       for x := 0; x < out.Rect.Dx(); x++ {
+           sx := min(max(x-left, 0), w-1)
+           copy(out.Pix[y*out.Stride+x*4:y*out.Stride+x*4+4], img.Pix[sy*img.Stride+sx*4:])
+       }
+   }
+   return out
+}
+
+// Crop returns a copy of the part of img inside r, with bounds starting at the origin.
+func Crop(img *image.NRGBA, r image.Rectangle) *image.NRGBA {
+   return ToNRGBA(img.SubImage(r.Intersect(img.Bounds())))
+}
+
+// Paste copies src into dst with its top-left corner at p.
+func Paste(dst, src *image.NRGBA, p image.Point) {
+   draw.Draw(dst, src.Bounds().Add(p), src, src.Bounds().Min, draw.Src)
+}
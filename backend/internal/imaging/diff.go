@@ -0,0 +1,46 @@
+package imaging
+
+import "image"
+
+// DiffStats summarizes the difference between two images.
+type DiffStats struct {
+   ChangedPixels  int     `json:"changed_pixels"`
+   TotalPixels    int     `json:"total_pixels"`
+   ChangedPercent float64 `json:"changed_percent"`
+   MeanDifference float64 `json:"mean_difference"`
+}
+
+// Diff compares b against a and returns a grayscale map of the largest per-channel
+// difference at each pixel, along with summary statistics. b is resized to the size of a
+// first when they differ. MeanDifference is the average of the map scaled to 0..1.
+func Diff(a, b *image.NRGBA) (*image.Gray, DiffStats) {
+   ab := a.Bounds()
+   b = Resize(b, ab.Dx(), ab.Dy())
+   out := image.NewGray(image.Rect(0, 0, ab.Dx(), ab.Dy()))
+   stats := DiffStats{TotalPixels: ab.Dx() * ab.Dy()}
+   sum := 0
+   for y := 0; y < ab.Dy(); y++ {
+       for x := 0; x < ab.Dx(); x++ {
+           i := y*a.Stride + x*4
+           j := y*b.Stride + x*4
+           d := 0
+           for k := 0; k < 4; k++ {
+               v := int(a.Pix[i+k]) - int(b.Pix[j+k])
+               if v < 0 {
+                   v = -v
+               }
+               d = max(d, v)
+           }
+           out.Pix[y*out.Stride+x] = uint8(d)
+           if d > 0 {
+               stats.ChangedPixels++
+           }
+           sum += d
+       }
+   }
+   if stats.TotalPixels > 0 {
+       stats.ChangedPercent = 100 * float64(stats.ChangedPixels) / float64(stats.TotalPixels)
+       stats.MeanDifference = float64(sum) / float64(stats.TotalPixels) / 255
+   }
+   return out, stats
+}
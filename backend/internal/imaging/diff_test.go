@@ -0,0 +1,24 @@
+package imaging
+
+import (
+   "image"
+   "image/color"
+   "testing"
+)
+
+func TestDiffCountsChangedPixelsAndResizes(t *testing.T) {
+   a := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+   b := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+   b.SetNRGBA(1, 2, color.NRGBA{0, 0, 0, 51})
+   m, stats := Diff(a, b)
+   if stats.ChangedPixels != 1 || stats.TotalPixels != 16 || m.GrayAt(1, 2).Y != 51 || m.GrayAt(0, 0).Y != 0 {
+       t.Errorf("unexpected diff: %+v", stats)
+   }
+   if stats.ChangedPercent != 6.25 || stats.MeanDifference != 0.0125 {
+       t.Errorf("unexpected summary: %+v", stats)
+   }
+   big := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+   if m, stats := Diff(a, big); m.Bounds().Dx() != 4 || stats.ChangedPixels != 0 {
+       t.Errorf("expected the second image scaled to the first: %v %+v", m.Bounds(), stats)
+   }
+}
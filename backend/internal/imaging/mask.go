@@ -0,0 +1,184 @@
+// Package imaging builds inpainting masks and composites images in pure Go.
+package imaging
+
+import (
+   "errors"
+   "fmt"
+   "image"
+   "sort"
+)
+
+// Point is a position in normalized image coordinates (0..1 on both axes).
+type Point struct {
+   X float64 `json:"x"`
+   Y float64 `json:"y"`
+}
+
+// Shape is a mask region in normalized image coordinates: a rectangle given by its
+// top-left corner and size, or a polygon given by its vertices.
+type Shape struct {
+   Type   string  `json:"type"`
+   X      float64 `json:"x,omitempty"`
+   Y      float64 `json:"y,omitempty"`
+   Width  float64 `json:"width,omitempty"`
+   Height float64 `json:"height,omitempty"`
+   Points []Point `json:"points,omitempty"`
+}
+
+// Shape types.
+const (
+   ShapeRect    = "rect"
+   ShapePolygon = "polygon"
+)
+
+// inUnit reports whether v lies in [0, 1].
+func inUnit(v float64) bool {
+   return v >= 0 && v <= 1
+}
+
+// Validate checks that the shape is well formed and lies within the image.
+func (s Shape) Validate() error {
+   switch s.Type {
+   case ShapeRect:
+       if s.Width <= 0 || s.Height <= 0 {
+           return errors.New("rect must have a positive width and height")
+       }
+       if !inUnit(s.X) || !inUnit(s.Y) || !inUnit(s.X+s.Width) || !inUnit(s.Y+s.Height) {
+           return errors.New("rect must lie within normalized coordinates 0..1")
+       }
+   case ShapePolygon:
+       if len(s.Points) < 3 {
+           return errors.New("polygon needs at least 3 points")
+       }
+       for _, p := range s.Points {
+           if !inUnit(p.X) || !inUnit(p.Y) {
+               return errors.New("polygon points must lie within normalized coordinates 0..1")
+           }
+       }
+   default:
+       return fmt.Errorf("unknown shape type %q", s.Type)
+   }
+   return nil
+}
+
+// polygon returns the shape's outline in pixel coordinates for a w x h image.
+func (s Shape) polygon(w, h int) []Point {
+   fw, fh := float64(w), float64(h)
+   if s.Type == ShapeRect {
+       x0, y0 := s.X*fw, s.Y*fh
+       x1, y1 := (s.X+s.Width)*fw, (s.Y+s.Height)*fh
+       return []Point{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+   }
+   pts := make([]Point, len(s.Points))
+   for i, p := range s.Points {
+       pts[i] = Point{p.X * fw, p.Y * fh}
+   }
+   return pts
+}
+
+// Rasterize returns a w x h mask with the given shapes filled white (255) on black.
+// Pixels are included when their center lies inside a shape.
+func Rasterize(w, h int, shapes []Shape) *image.Gray {
+   m := image.NewGray(image.Rect(0, 0, w, h))
+   for _, s := range shapes {
+       fillPolygon(m, s.polygon(w, h))
+   }
+   return m
+}
+
+// fillPolygon fills poly into m with the even-odd rule using a scanline at each pixel center.
+func fillPolygon(m *image.Gray, poly []Point) {
+   b := m.Bounds()
+   xs := make([]float64, 0, 8)
+   for y := b.Min.Y; y < b.Max.Y; y++ {
+       yc := float64(y) + 0.5
+       xs = xs[:0]
+       for i := range poly {
+           a, c := poly[i], poly[(i+1)%len(poly)]
+           if (a.Y <= yc && c.Y > yc) || (c.Y <= yc && a.Y > yc) {
+               xs = append(xs, a.X+(yc-a.Y)/(c.Y-a.Y)*(c.X-a.X))
+           }
+       }
+       sort.Float64s(xs)
+       for i := 0; i+1 < len(xs); i += 2 {
+           for x := b.Min.X; x < b.Max.X; x++ {
+               if xc := float64(x) + 0.5; xc >= xs[i] && xc < xs[i+1] {
+                   m.Pix[m.PixOffset(x, y)] = 255
+               }
+           }
+       }
+   }
+}
+
+// Union returns the per-pixel maximum of masks of equal size.
+func Union(masks ...*image.Gray) *image.Gray {
+   if len(masks) == 0 {
+       return nil
+   }
+   out := image.NewGray(masks[0].Bounds())
+   for _, m := range masks {
+       for i, v := range m.Pix {
+           if v > out.Pix[i] {
+               out.Pix[i] = v
+           }
+       }
+   }
+   return out
+}
+
+// Feather softens mask edges with a blur of the given radius in pixels.
+// Two box blur passes approximate a gaussian.
+func Feather(m *image.Gray, radius int) *image.Gray {
+   out := cloneGray(m)
+   if radius <= 0 {
+       return out
+   }
+   for pass := 0; pass < 2; pass++ {
+       out = boxBlur(out, radius, true)
+       out = boxBlur(out, radius, false)
+   }
+   return out
+}
+
+// boxBlur averages each pixel with its neighbors within radius along one axis.
+func boxBlur(m *image.Gray, radius int, horizontal bool) *image.Gray {
+   b := m.Bounds()
+   w, h := b.Dx(), b.Dy()
+   out := image.NewGray(b)
+   lines, length := h, w
+   if !horizontal {
+       lines, length = w, h
+   }
+   at := func(line, i int) int {
+       if horizontal {
+           return line*m.Stride + i
+       }
+       return i*m.Stride + line
+   }
+   for line := 0; line < lines; line++ {
+       sum, n := 0, 0
+       for i := 0; i <= radius && i < length; i++ {
+           sum += int(m.Pix[at(line, i)])
+           n++
+       }
+       for i := 0; i < length; i++ {
+           out.Pix[at(line, i)] = uint8((sum + n/2) / n)
+           if j := i + radius + 1; j < length {
+               sum += int(m.Pix[at(line, j)])
+               n++
+           }
+           if j := i - radius; j >= 0 {
+               sum -= int(m.Pix[at(line, j)])
+               n--
+           }
+       }
+   }
+   return out
+}
+
+// cloneGray returns a copy of m.
+func cloneGray(m *image.Gray) *image.Gray {
+   out := image.NewGray(m.Bounds())
+   copy(out.Pix, m.Pix)
+   return out
+}
@@ -0,0 +1,132 @@
+package imaging
+
+import (
+   "image"
+   "image/color"
+   "math/rand"
+   "testing"
+)
+
+func TestRasterizeShapes(t *testing.T) {
+   m := Rasterize(10, 10, []Shape{
+       {Type: ShapeRect, X: 0, Y: 0, Width: 0.3, Height: 0.2},
+       {Type: ShapePolygon, Points: []Point{{0.5, 0.5}, {1, 0.5}, {1, 1}}},
+   })
+   count := func(x0, y0, x1, y1 int) int {
+       n := 0
+       for y := y0; y < y1; y++ {
+           for x := x0; x < x1; x++ {
+               if m.GrayAt(x, y).Y == 255 {
+                   n++
+               }
+           }
+       }
+       return n
+   }
+   if got := count(0, 0, 3, 2); got != 6 {
+       t.Errorf("rect: expected 6 pixels, got %d", got)
+   }
+   if m.GrayAt(3, 0).Y != 0 || m.GrayAt(0, 2).Y != 0 {
+       t.Errorf("rect spilled outside its bounds")
+   }
+   // Lower-right triangle with the diagonal from (5,5) to (10,10): 5+4+3+2+1 pixel centers
+   if got := count(5, 5, 10, 10); got != 15 {
+       t.Errorf("triangle: expected 15 pixels, got %d", got)
+   }
+}
+
+func TestFeatherAndComposite(t *testing.T) {
+   m := Rasterize(20, 1, []Shape{{Type: ShapeRect, X: 0.5, Y: 0, Width: 0.5, Height: 1}})
+   f := Feather(m, 2)
+   if f.GrayAt(0, 0).Y != 0 || f.GrayAt(19, 0).Y != 255 {
+       t.Errorf("feather changed pixels far from the edge: %d %d", f.GrayAt(0, 0).Y, f.GrayAt(19, 0).Y)
+   }
+   if v := f.GrayAt(10, 0).Y; v == 0 || v == 255 {
+       t.Errorf("expected a soft edge, got %d", v)
+   }
+
+   base := image.NewNRGBA(image.Rect(0, 0, 20, 1))
+   over := image.NewNRGBA(image.Rect(0, 0, 20, 1))
+   for x := 0; x < 20; x++ {
+       base.SetNRGBA(x, 0, color.NRGBA{200, 0, 0, 255})
+       over.SetNRGBA(x, 0, color.NRGBA{0, 0, 200, 255})
+   }
+   out := Composite(base, over, m)
+   if out.NRGBAAt(9, 0) != (color.NRGBA{200, 0, 0, 255}) || out.NRGBAAt(10, 0) != (color.NRGBA{0, 0, 200, 255}) {
+       t.Errorf("unexpected composite: %v %v", out.NRGBAAt(9, 0), out.NRGBAAt(10, 0))
+   }
+}
+
+func TestResizeAndGenerationSize(t *testing.T) {
+   img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+   for i := range img.Pix {
+       img.Pix[i] = 100
+   }
+   out := Resize(img, 7, 3)
+   if out.Bounds().Dx() != 7 || out.Bounds().Dy() != 3 || out.NRGBAAt(6, 2).R != 100 {
+       t.Errorf("unexpected resize result %v", out.Bounds())
+   }
+   for in, want := range map[int]int{1: 64, 512: 512, 515: 512, 517: 520} {
+       if got := GenerationSize(in); got != want {
+           t.Errorf("GenerationSize(%d) = %d, want %d", in, got, want)
+       }
+   }
+}
+
+func TestMaskSpecStrokesExpandInvert(t *testing.T) {
+   spec := MaskSpec{Strokes: []Stroke{{Points: []Point{{0.05, 0.5}, {0.95, 0.5}}, Radius: 0.05}}}
+   if err := spec.Validate(); err != nil {
+       t.Fatalf("validate: %v", err)
+   }
+   m := spec.Render(20, 20)
+   if m.GrayAt(10, 10).Y != 255 || m.GrayAt(10, 12).Y != 0 {
+       t.Errorf("unexpected stroke coverage: %d %d", m.GrayAt(10, 10).Y, m.GrayAt(10, 12).Y)
+   }
+   spec.Expand = 2
+   if m := spec.Render(20, 20); m.GrayAt(10, 12).Y != 255 {
+       t.Errorf("expand did not grow the stroke")
+   }
+   spec.Expand, spec.Invert = -3, true
+   if m := spec.Render(20, 20); m.GrayAt(10, 10).Y != 255 {
+       t.Errorf("expected shrink to remove the thin stroke before inverting")
+   }
+   if err := (MaskSpec{Feather: 1000, Shapes: spec.Shapes, Strokes: spec.Strokes}).Validate(); err == nil {
+       t.Errorf("expected feather limit error")
+   }
+}
+
+func TestExpandMatchesBruteForce(t *testing.T) {
+   rng := rand.New(rand.NewSource(1))
+   m := image.NewGray(image.Rect(0, 0, 23, 17))
+   for i := range m.Pix {
+       m.Pix[i] = uint8(rng.Intn(256))
+   }
+   // Square neighborhood extreme, clipped at the edges
+   want := func(px int) *image.Gray {
+       grow, r := px > 0, max(px, -px)
+       out := image.NewGray(m.Bounds())
+       for y := 0; y < 17; y++ {
+           for x := 0; x < 23; x++ {
+               v := m.GrayAt(x, y).Y
+               for yy := max(y-r, 0); yy <= min(y+r, 16); yy++ {
+                   for xx := max(x-r, 0); xx <= min(x+r, 22); xx++ {
+                       if u := m.GrayAt(xx, yy).Y; (grow && u > v) || (!grow && u < v) {
+                           v = u
+                       }
+                   }
+               }
+               out.SetGray(x, y, color.Gray{v})
+           }
+       }
+       return out
+   }
+   for _, px := range []int{1, 2, 3, 7, 30, -1, -4, -30} {
+       got, ref := Expand(m, px), want(px)
+       for i := range ref.Pix {
+           if got.Pix[i] != ref.Pix[i] {
+               t.Errorf("expand %d: pixel %d is %d, want %d", px, i, got.Pix[i], ref.Pix[i])
+               break
+           }
+       }
+   }
+}
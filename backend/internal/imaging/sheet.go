@@ -0,0 +1,124 @@
+package imaging
+
+import (
+   "image"
+   "image/color"
+)
+
+// Contact sheet layout, in pixels (text scale in font pixels).
+const (
+   sheetMargin = 8
+   sheetScale  = 2
+   maxRowLabel = 240
+)
+
+var (
+   sheetBackground = color.NRGBA{255, 255, 255, 255}
+   sheetText       = color.NRGBA{0, 0, 0, 255}
+   sheetMissing    = color.NRGBA{220, 220, 220, 255}
+)
+
+// SheetPanel is one grid of a contact sheet: Cells[row][col], nil where an image is
+// missing, labeled by a title and the labels of its columns and rows (all optional).
+type SheetPanel struct {
+   Title     string
+   ColLabels []string
+   RowLabels []string
+   Cells     [][]image.Image
+}
+
+// ContactSheet lays out panels one below the other on a white background. Every image is
+// scaled to fit a cell whose longer side is cellSize, with the aspect ratio of the first
+// image; labels are drawn in the built-in font and shortened to fit.
+func ContactSheet(panels []SheetPanel, cellSize int) *image.NRGBA {
+   cellW, cellH := cellSize, cellSize
+   cols, labelW := 0, 0
+   var first image.Image
+   for _, p := range panels {
+       cols = max(cols, len(p.ColLabels))
+       for i, row := range p.Cells {
+           cols = max(cols, len(row))
+           if i < len(p.RowLabels) {
+               labelW = max(labelW, TextWidth(p.RowLabels[i], sheetScale))
+           }
+           for _, img := range row {
+               if first == nil && img != nil {
+                   first = img
+               }
+           }
+       }
+   }
+   if first != nil {
+       if b := first.Bounds(); b.Dx() >= b.Dy() {
+           cellH = max(1, cellSize*b.Dy()/b.Dx())
+       } else {
+           cellW = max(1, cellSize*b.Dx()/b.Dy())
+       }
+   }
+   if labelW > 0 {
+       labelW = min(labelW, maxRowLabel) + 2*sheetMargin
+   }
+   lineH := GlyphHeight*sheetScale + 2*sheetMargin
+   height := sheetMargin
+   for _, p := range panels {
+       if p.Title != "" {
+           height += lineH
+       }
+       if len(p.ColLabels) > 0 {
+           height += lineH
+       }
+       height += len(p.Cells)*(cellH+sheetMargin) + sheetMargin
+   }
+   width := labelW + cols*(cellW+sheetMargin) + sheetMargin
+   sheet := image.NewNRGBA(image.Rect(0, 0, width, height))
+   fill(sheet, sheet.Bounds(), sheetBackground)
+
+   y := sheetMargin
+   for _, p := range panels {
+       if p.Title != "" {
+           DrawText(sheet, sheetMargin, y+sheetMargin, FitText(p.Title, width-2*sheetMargin, sheetScale), sheetScale, sheetText)
+           y += lineH
+       }
+       if len(p.ColLabels) > 0 {
+           for c, label := range p.ColLabels {
+               label = FitText(label, cellW, sheetScale)
+               x := labelW + sheetMargin + c*(cellW+sheetMargin) + (cellW-TextWidth(label, sheetScale))/2
+               DrawText(sheet, x, y+sheetMargin, label, sheetScale, sheetText)
+           }
+           y += lineH
+       }
+       for r, row := range p.Cells {
+           if r < len(p.RowLabels) {
+               label := FitText(p.RowLabels[r], labelW-2*sheetMargin, sheetScale)
+               DrawText(sheet, sheetMargin, y+(cellH-GlyphHeight*sheetScale)/2, label, sheetScale, sheetText)
+           }
+           for c, img := range row {
+               cell := image.Rect(0, 0, cellW, cellH).Add(image.Pt(labelW+sheetMargin+c*(cellW+sheetMargin), y))
+               if img == nil {
+                   fill(sheet, cell, sheetMissing)
+                   continue
+               }
+               thumb := Fit(ToNRGBA(img), cellW, cellH)
+               tb := thumb.Bounds()
+               Paste(sheet, thumb, cell.Min.Add(image.Pt((cellW-tb.Dx())/2, (cellH-tb.Dy())/2)))
+           }
+           y += cellH + sheetMargin
+       }
+       y += sheetMargin
+   }
+   return sheet
+}
+
+// Fit scales img down or up to the largest size fitting w x h, keeping its aspect ratio.
+func Fit(img *image.NRGBA, w, h int) *image.NRGBA {
+   b := img.Bounds()
+   if b.Dx()*h > b.Dy()*w {
+       h = max(1, b.Dy()*w/b.Dx())
+   } else {
+       w = max(1, b.Dx()*h/b.Dy())
+   }
+   if w == b.Dx() && h == b.Dy() {
+       return img
+   }
+   return Resize(img, w, h)
+}
@@ -0,0 +1,45 @@
+package imaging
+
+import (
+   "image"
+   "image/color"
+   "testing"
+)
+
+func TestFitTextAndDrawText(t *testing.T) {
+   if w := TextWidth("ab", 2); w != 22 {
+       t.Errorf("expected width 22, got %d", w)
+   }
+   if s := FitText("abcdefgh", TextWidth("abcd..", 1), 1); s != "abcd.." {
+       t.Errorf("unexpected fitted text %q", s)
+   }
+   img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+   DrawText(img, 0, 0, "I", 1, color.Black)
+   // 'I' is a vertical bar in the middle column with serifs top and bottom
+   if img.NRGBAAt(2, 3).A == 0 || img.NRGBAAt(0, 3).A != 0 || img.NRGBAAt(1, 0).A == 0 {
+       t.Errorf("unexpected glyph pixels")
+   }
+}
+
+func TestContactSheetLayout(t *testing.T) {
+   tall := image.NewNRGBA(image.Rect(0, 0, 50, 100))
+   fill(tall, tall.Bounds(), color.NRGBA{255, 0, 0, 255})
+   sheet := ContactSheet([]SheetPanel{
+       {Title: "z: 1", ColLabels: []string{"a", "b"}, RowLabels: []string{"row"}, Cells: [][]image.Image{{tall, nil}}},
+       {Cells: [][]image.Image{{tall, tall}}},
+   }, 40)
+   lineH := GlyphHeight*sheetScale + 2*sheetMargin
+   labelW := TextWidth("row", sheetScale) + 2*sheetMargin
+   wantW := labelW + 2*(20+sheetMargin) + sheetMargin
+   wantH := sheetMargin + 2*lineH + 2*(40+2*sheetMargin)
+   if b := sheet.Bounds(); b.Dx() != wantW || b.Dy() != wantH {
+       t.Fatalf("expected %dx%d sheet, got %v", wantW, wantH, b)
+   }
+   cellY := sheetMargin + 2*lineH + 20
+   if c := sheet.NRGBAAt(labelW+sheetMargin+10, cellY); c != (color.NRGBA{255, 0, 0, 255}) {
+       t.Errorf("expected the image in the first cell, got %v", c)
+   }
+   if c := sheet.NRGBAAt(labelW+2*sheetMargin+30, cellY); c != sheetMissing {
+       t.Errorf("expected the missing cell filled, got %v", c)
+   }
+}
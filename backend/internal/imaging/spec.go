@@ -0,0 +1,206 @@
+package imaging
+
+import (
+   "errors"
+   "fmt"
+   "image"
+   "math"
+)
+
+// Limits for mask post-processing, in pixels.
+const (
+   MaxExpand  = 256
+   MaxFeather = 128
+)
+
+// Stroke is a brush stroke through Points in normalized coordinates. Radius is the brush
+// radius as a fraction of the image's shorter side; Erase strokes clear instead of paint.
+type Stroke struct {
+   Points []Point `json:"points"`
+   Radius float64 `json:"radius"`
+   Erase  bool    `json:"erase,omitempty"`
+}
+
+// MaskSpec describes a mask independently of image resolution: shapes are filled first,
+// then strokes are applied in order. The result is grown (or shrunk, if negative) by Expand
+// pixels, softened by Feather pixels, and finally inverted if Invert is set.
+type MaskSpec struct {
+   Shapes  []Shape  `json:"shapes,omitempty"`
+   Strokes []Stroke `json:"strokes,omitempty"`
+   Expand  int      `json:"expand,omitempty"`
+   Feather int      `json:"feather,omitempty"`
+   Invert  bool     `json:"invert,omitempty"`
+}
+
+// Validate checks the shapes, strokes and options of the spec.
+func (s MaskSpec) Validate() error {
+   for i, sh := range s.Shapes {
+       if err := sh.Validate(); err != nil {
+           return fmt.Errorf("shape %d: %w", i, err)
+       }
+   }
+   for i, st := range s.Strokes {
+       if len(st.Points) == 0 {
+           return fmt.Errorf("stroke %d: no points", i)
+       }
+       if st.Radius <= 0 || st.Radius > 1 {
+           return fmt.Errorf("stroke %d: radius must be in (0, 1]", i)
+       }
+       for _, p := range st.Points {
+           if !inUnit(p.X) || !inUnit(p.Y) {
+               return fmt.Errorf("stroke %d: points must lie within normalized coordinates 0..1", i)
+           }
+       }
+   }
+   if s.Expand < -MaxExpand || s.Expand > MaxExpand {
+       return fmt.Errorf("expand must be between %d and %d", -MaxExpand, MaxExpand)
+   }
+   if s.Feather < 0 || s.Feather > MaxFeather {
+       return fmt.Errorf("feather must be between 0 and %d", MaxFeather)
+   }
+   if len(s.Shapes) == 0 && len(s.Strokes) == 0 {
+       return errors.New("mask has no shapes or strokes")
+   }
+   return nil
+}
+
+// Render rasterizes the spec into a w x h mask.
+func (s MaskSpec) Render(w, h int) *image.Gray {
+   m := Rasterize(w, h, s.Shapes)
+   for _, st := range s.Strokes {
+       drawStroke(m, st)
+   }
+   m = Expand(m, s.Expand)
+   m = Feather(m, s.Feather)
+   if s.Invert {
+       m = Invert(m)
+   }
+   return m
+}
+
+// drawStroke paints (or erases) every pixel whose center lies within the brush radius
+// of the stroke's polyline.
+func drawStroke(m *image.Gray, st Stroke) {
+   b := m.Bounds()
+   w, h := float64(b.Dx()), float64(b.Dy())
+   r := st.Radius * math.Min(w, h)
+   value := uint8(255)
+   if st.Erase {
+       value = 0
+   }
+   pts := make([]Point, len(st.Points))
+   for i, p := range st.Points {
+       pts[i] = Point{p.X * w, p.Y * h}
+   }
+   if len(pts) == 1 {
+       pts = append(pts, pts[0])
+   }
+   for i := 0; i+1 < len(pts); i++ {
+       a, c := pts[i], pts[i+1]
+       x0 := max(int(math.Floor(math.Min(a.X, c.X)-r)), 0)
+       x1 := min(int(math.Ceil(math.Max(a.X, c.X)+r)), b.Dx())
+       y0 := max(int(math.Floor(math.Min(a.Y, c.Y)-r)), 0)
+       y1 := min(int(math.Ceil(math.Max(a.Y, c.Y)+r)), b.Dy())
+       for y := y0; y < y1; y++ {
+           for x := x0; x < x1; x++ {
+               if segmentDist(Point{float64(x) + 0.5, float64(y) + 0.5}, a, c) <= r {
+                   m.Pix[y*m.Stride+x] = value
+               }
+           }
+       }
+   }
+}
+
+// segmentDist returns the distance from p to the segment a-c.
+func segmentDist(p, a, c Point) float64 {
+   dx, dy := c.X-a.X, c.Y-a.Y
+   t := 0.0
+   if l := dx*dx + dy*dy; l > 0 {
+       t = math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/l))
+   }
+   return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
+
+// Expand grows the mask by px pixels in every direction, or shrinks it if px is negative.
+func Expand(m *image.Gray, px int) *image.Gray {
+   if px == 0 {
+       return cloneGray(m)
+   }
+   grow := px > 0
+   if !grow {
+       px = -px
+   }
+   return morph(morph(m, px, grow, true), px, grow, false)
+}
+
+// morph applies a running max (grow) or min filter of the given radius along one axis.
+// It uses the van Herk/Gil-Werman algorithm: each line is split into blocks of the window
+// size, and every window is the extreme of one block suffix and the next block's prefix,
+// so the cost does not depend on the radius.
+func morph(m *image.Gray, radius int, grow, horizontal bool) *image.Gray {
+   b := m.Bounds()
+   w, h := b.Dx(), b.Dy()
+   out := image.NewGray(b)
+   lines, length := h, w
+   if !horizontal {
+       lines, length = w, h
+   }
+   at := func(line, i int) int {
+       if horizontal {
+           return line*m.Stride + i
+       }
+       return i*m.Stride + line
+   }
+   pick := func(a, c uint8) uint8 {
+       if (a > c) == grow {
+           return a
+       }
+       return c
+   }
+   // Pixels beyond the edge never win: 0 for a max filter, 255 for a min filter.
+   pad := uint8(0)
+   if !grow {
+       pad = 255
+   }
+   k := 2*radius + 1
+   n := length + 2*radius
+   buf := make([]uint8, n)
+   prefix := make([]uint8, n)
+   suffix := make([]uint8, n)
+   for line := 0; line < lines; line++ {
+       for i := range buf {
+           buf[i] = pad
+       }
+       for i := 0; i < length; i++ {
+           buf[radius+i] = m.Pix[at(line, i)]
+       }
+       for i := 0; i < n; i++ {
+           if i%k == 0 {
+               prefix[i] = buf[i]
+           } else {
+               prefix[i] = pick(prefix[i-1], buf[i])
+           }
+       }
+       for i := n - 1; i >= 0; i-- {
+           if i == n-1 || (i+1)%k == 0 {
+               suffix[i] = buf[i]
+           } else {
+               suffix[i] = pick(suffix[i+1], buf[i])
+           }
+       }
+       // The window of pixel i covers buf[i .. i+2*radius]
+       for i := 0; i < length; i++ {
+           out.Pix[at(line, i)] = pick(suffix[i], prefix[i+2*radius])
+       }
+   }
+   return out
+}
+
+// Invert swaps masked and unmasked areas.
+func Invert(m *image.Gray) *image.Gray {
+   out := image.NewGray(m.Bounds())
+   for i, v := range m.Pix {
+       out.Pix[i] = 255 - v
+   }
+   return out
+}
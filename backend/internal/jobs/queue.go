@@ -0,0 +1,437 @@
+// Package jobs implements a sequential, persistent job queue.
+package jobs
+
+import (
+   "context"
+   "crypto/rand"
+   "crypto/sha256"
+   "encoding/hex"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "io/ioutil"
+   "log"
+   "os"
+   "path/filepath"
+   "sort"
+   "sync"
+   "time"
+)
+
+// Status is the lifecycle state of a job.
+type Status string
+
+const (
+   StatusQueued    Status = "queued"
+   StatusRunning   Status = "running"
+   StatusSucceeded Status = "succeeded"
+   StatusFailed    Status = "failed"
+   StatusCanceled  Status = "canceled"
+)
+
+var (
+   // ErrNotFound is returned for unknown job IDs.
+   ErrNotFound = errors.New("job not found")
+   // ErrInvalidState is returned when an operation does not apply to the job's current status.
+   ErrInvalidState = errors.New("invalid job state for operation")
+)
+
+// Job is a unit of work and its persisted state.
+type Job struct {
+   ID         string          `json:"id"`
+   Type       string          `json:"type"`
+   Status     Status          `json:"status"`
+   Request    json.RawMessage `json:"request"`
+   Result     json.RawMessage `json:"result,omitempty"`
+   Error      string          `json:"error,omitempty"`
+   Attempts   int             `json:"attempts"`
+   CreatedAt  string          `json:"created_at"`
+   StartedAt  string          `json:"started_at,omitempty"`
+   FinishedAt string          `json:"finished_at,omitempty"`
+}
+
+// Runner executes a job and returns its result. The context is canceled when the job is canceled.
+type Runner func(ctx context.Context, job *Job) (json.RawMessage, error)
+
+// Queue runs jobs one at a time in submission order and persists their state under a directory.
+type Queue struct {
+   dir      string
+   run      Runner
+   mu       sync.Mutex
+   jobs     map[string]*Job
+   running  string
+   cancel   context.CancelFunc
+   wake     chan struct{}
+   stop     chan struct{}
+   done     chan struct{}
+   onUpdate func(Job)
+   started  bool
+}
+
+// NewQueue loads persisted jobs from dir. Jobs interrupted by a restart are queued again.
+func NewQueue(dir string, run Runner) (*Queue, error) {
+   if err := os.MkdirAll(dir, 0755); err != nil {
+       return nil, err
+   }
+   q := &Queue{
+       dir:  dir,
+       run:  run,
+       jobs: make(map[string]*Job),
+       wake: make(chan struct{}, 1),
+       stop: make(chan struct{}),
+       done: make(chan struct{}),
+   }
+   files, err := ioutil.ReadDir(dir)
+   if err != nil {
+       return nil, err
+   }
+   for _, fi := range files {
+       if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+           continue
+       }
+       data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+       if err != nil {
+           continue
+       }
+       var job Job
+       if err := json.Unmarshal(data, &job); err != nil || job.ID == "" {
+           log.Printf("jobs: skipping unreadable job file %s", fi.Name())
+           continue
+       }
+       if job.Status == StatusRunning {
+           job.Status = StatusQueued
+           job.StartedAt = ""
+           q.persist(&job)
+       }
+       q.jobs[job.ID] = &job
+   }
+   return q, nil
+}
+
+// OnUpdate registers a callback invoked (outside the queue lock) after every job state change.
+func (q *Queue) OnUpdate(fn func(Job)) {
+   q.mu.Lock()
+   q.onUpdate = fn
+   q.mu.Unlock()
+}
+
+// Start launches the worker goroutine.
+func (q *Queue) Start() {
+   q.mu.Lock()
+   q.started = true
+   q.mu.Unlock()
+   go q.worker()
+   q.signal()
+}
+
+// Stop cancels the running job, if any, and waits for the worker to exit.
+// The interrupted job is left queued so it resumes on the next start.
+func (q *Queue) Stop() {
+   close(q.stop)
+   q.mu.Lock()
+   if q.cancel != nil {
+       q.cancel()
+   }
+   started := q.started
+   q.mu.Unlock()
+   if started {
+       <-q.done
+   }
+}
+
+// Dir returns the directory the queue persists to.
+func (q *Queue) Dir() string {
+   return q.dir
+}
+
+// OutputDir returns the directory for files produced by the given job.
+func (q *Queue) OutputDir(id string) string {
+   return filepath.Join(q.dir, id)
+}
+
+// PutBlob stores data in the queue's content-addressed blob store and returns its SHA-256
+// hex digest, so requests can reference large inputs instead of embedding them.
+func (q *Queue) PutBlob(data []byte) (string, error) {
+   sum := sha256.Sum256(data)
+   hash := hex.EncodeToString(sum[:])
+   path := q.blobPath(hash)
+   if _, err := os.Stat(path); err == nil {
+       return hash, nil
+   }
+   if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+       return "", err
+   }
+   tmp := path + "." + newID() + ".tmp"
+   if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+       return "", err
+   }
+   if err := os.Rename(tmp, path); err != nil {
+       os.Remove(tmp)
+       return "", err
+   }
+   return hash, nil
+}
+
+// Blob returns the data stored under hash by PutBlob.
+func (q *Queue) Blob(hash string) ([]byte, error) {
+   if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha256.Size*2 {
+       return nil, fmt.Errorf("invalid blob hash %q", hash)
+   }
+   return ioutil.ReadFile(q.blobPath(hash))
+}
+
+// blobPath returns the file holding the blob with the given hash.
+func (q *Queue) blobPath(hash string) string {
+   return filepath.Join(q.dir, "blobs", hash)
+}
+
+// Submit enqueues a new job.
+func (q *Queue) Submit(typ string, request json.RawMessage) Job {
+   job := &Job{
+       ID:        newID(),
+       Type:      typ,
+       Status:    StatusQueued,
+       Request:   request,
+       CreatedAt: now(),
+   }
+   q.mu.Lock()
+   q.jobs[job.ID] = job
+   q.persist(job)
+   snapshot := *job
+   q.mu.Unlock()
+   q.notify(snapshot)
+   q.signal()
+   return snapshot
+}
+
+// Get returns a copy of the job with the given ID.
+func (q *Queue) Get(id string) (Job, error) {
+   q.mu.Lock()
+   defer q.mu.Unlock()
+   job, ok := q.jobs[id]
+   if !ok {
+       return Job{}, ErrNotFound
+   }
+   return *job, nil
+}
+
+// List returns copies of all jobs, oldest first.
+func (q *Queue) List() []Job {
+   q.mu.Lock()
+   defer q.mu.Unlock()
+   out := make([]Job, 0, len(q.jobs))
+   for _, job := range q.jobs {
+       out = append(out, *job)
+   }
+   sortJobs(out)
+   return out
+}
+
+// Cancel stops a queued or running job.
+func (q *Queue) Cancel(id string) (Job, error) {
+   q.mu.Lock()
+   job, ok := q.jobs[id]
+   if !ok {
+       q.mu.Unlock()
+       return Job{}, ErrNotFound
+   }
+   switch job.Status {
+   case StatusQueued:
+       job.Status = StatusCanceled
+       job.FinishedAt = now()
+       q.persist(job)
+   case StatusRunning:
+       // the worker records the canceled state when the runner returns
+       if q.cancel != nil {
+           q.cancel()
+       }
+   default:
+       q.mu.Unlock()
+       return Job{}, ErrInvalidState
+   }
+   snapshot := *job
+   q.mu.Unlock()
+   q.notify(snapshot)
+   return snapshot, nil
+}
+
+// Retry re-queues a failed or canceled job.
+func (q *Queue) Retry(id string) (Job, error) {
+   q.mu.Lock()
+   job, ok := q.jobs[id]
+   if !ok {
+       q.mu.Unlock()
+       return Job{}, ErrNotFound
+   }
+   if job.Status != StatusFailed && job.Status != StatusCanceled {
+       q.mu.Unlock()
+       return Job{}, ErrInvalidState
+   }
+   job.Status = StatusQueued
+   job.Error = ""
+   job.Result = nil
+   job.StartedAt = ""
+   job.FinishedAt = ""
+   q.persist(job)
+   snapshot := *job
+   q.mu.Unlock()
+   q.notify(snapshot)
+   q.signal()
+   return snapshot, nil
+}
+
+// Running returns the ID of the job currently being executed, or "".
+func (q *Queue) Running() string {
+   q.mu.Lock()
+   defer q.mu.Unlock()
+   return q.running
+}
+
+// worker executes queued jobs sequentially until Stop is called.
+func (q *Queue) worker() {
+   defer close(q.done)
+   for {
+       select {
+       case <-q.stop:
+           return
+       case <-q.wake:
+       }
+       for {
+           job, ctx := q.next()
+           if job == nil {
+               break
+           }
+           result, err := q.run(ctx, job)
+           if !q.finish(job.ID, ctx, result, err) {
+               return
+           }
+       }
+   }
+}
+
+// next marks the oldest queued job as running and returns a copy with its context.
+func (q *Queue) next() (*Job, context.Context) {
+   q.mu.Lock()
+   select {
+   case <-q.stop:
+       q.mu.Unlock()
+       return nil, nil
+   default:
+   }
+   var queued []Job
+   for _, job := range q.jobs {
+       if job.Status == StatusQueued {
+           queued = append(queued, *job)
+       }
+   }
+   if len(queued) == 0 {
+       q.mu.Unlock()
+       return nil, nil
+   }
+   sortJobs(queued)
+   job := q.jobs[queued[0].ID]
+   job.Status = StatusRunning
+   job.Attempts++
+   job.StartedAt = now()
+   q.persist(job)
+   ctx, cancel := context.WithCancel(context.Background())
+   q.running = job.ID
+   q.cancel = cancel
+   snapshot := *job
+   q.mu.Unlock()
+   q.notify(snapshot)
+   return &snapshot, ctx
+}
+
+// finish records the outcome of a run. Returns false if the queue is stopping.
+func (q *Queue) finish(id string, ctx context.Context, result json.RawMessage, err error) bool {
+   canceled := ctx.Err() != nil
+   q.mu.Lock()
+   job := q.jobs[id]
+   q.cancel()
+   q.running = ""
+   q.cancel = nil
+   select {
+   case <-q.stop:
+       // leave the job queued so it resumes after restart
+       job.Status = StatusQueued
+       job.StartedAt = ""
+       q.persist(job)
+       q.mu.Unlock()
+       return false
+   default:
+   }
+   job.FinishedAt = now()
+   switch {
+   case err == nil:
+       job.Status = StatusSucceeded
+       job.Result = result
+   case canceled:
+       job.Status = StatusCanceled
+   default:
+       job.Status = StatusFailed
+       job.Error = err.Error()
+   }
+   q.persist(job)
+   snapshot := *job
+   q.mu.Unlock()
+   q.notify(snapshot)
+   return true
+}
+
+// persist writes the job state to disk; callers hold q.mu.
+func (q *Queue) persist(job *Job) {
+   data, err := json.MarshalIndent(job, "", "  ")
+   if err != nil {
+       log.Printf("jobs: marshal %s: %v", job.ID, err)
+       return
+   }
+   tmp := filepath.Join(q.dir, job.ID+".json.tmp")
+   if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+       log.Printf("jobs: write %s: %v", job.ID, err)
+       return
+   }
+   if err := os.Rename(tmp, filepath.Join(q.dir, job.ID+".json")); err != nil {
+       log.Printf("jobs: rename %s: %v", job.ID, err)
+   }
+}
+
+// notify invokes the update callback, if any.
+func (q *Queue) notify(job Job) {
+   q.mu.Lock()
+   fn := q.onUpdate
+   q.mu.Unlock()
+   if fn != nil {
+       fn(job)
+   }
+}
+
+// signal wakes the worker without blocking.
+func (q *Queue) signal() {
+   select {
+   case q.wake <- struct{}{}:
+   default:
+   }
+}
+
+// sortJobs orders jobs by creation time, then ID.
+func sortJobs(jobs []Job) {
+   sort.Slice(jobs, func(i, j int) bool {
+       if jobs[i].CreatedAt != jobs[j].CreatedAt {
+           return jobs[i].CreatedAt < jobs[j].CreatedAt
+       }
+       return jobs[i].ID < jobs[j].ID
+   })
+}
+
+// newID returns a random job identifier.
+func newID() string {
+   b := make([]byte, 8)
+   _, _ = rand.Read(b)
+   return hex.EncodeToString(b)
+}
+
+// now returns the current UTC time in a fixed-width RFC 3339 form that sorts lexically.
+func now() string {
+   return time.Now().UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
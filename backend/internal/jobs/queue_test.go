@@ -0,0 +1,206 @@
+package jobs
+
+import (
+   "bytes"
+   "context"
+   "encoding/json"
+   "errors"
+   "os"
+   "path/filepath"
+   "sync"
+   "testing"
+   "time"
+)
+
+// waitFor polls q until the job reaches one of the given states.
+func waitFor(t *testing.T, q *Queue, id string, states ...Status) Job {
+   t.Helper()
+   deadline := time.Now().Add(5 * time.Second)
+   for time.Now().Before(deadline) {
+       job, err := q.Get(id)
+       if err != nil {
+           t.Fatalf("get %s: %v", id, err)
+       }
+       for _, s := range states {
+           if job.Status == s {
+               return job
+           }
+       }
+       time.Sleep(5 * time.Millisecond)
+   }
+   t.Fatalf("job %s did not reach %v", id, states)
+   return Job{}
+}
+
+// blockingRunner returns a runner that blocks each job until released or canceled.
+func blockingRunner(release <-chan struct{}) Runner {
+   return func(ctx context.Context, job *Job) (json.RawMessage, error) {
+       select {
+       case <-release:
+           return json.RawMessage(`{}`), nil
+       case <-ctx.Done():
+           return nil, ctx.Err()
+       }
+   }
+}
+
+func TestQueueRunsJobsOneAtATimeInSubmissionOrder(t *testing.T) {
+   var mu sync.Mutex
+   var order []string
+   active, peak := 0, 0
+   q, err := NewQueue(t.TempDir(), func(ctx context.Context, job *Job) (json.RawMessage, error) {
+       mu.Lock()
+       order = append(order, string(job.Request))
+       active++
+       peak = max(peak, active)
+       mu.Unlock()
+       time.Sleep(time.Millisecond)
+       mu.Lock()
+       active--
+       mu.Unlock()
+       return nil, nil
+   })
+   if err != nil {
+       t.Fatalf("new queue: %v", err)
+   }
+   var ids []string
+   for _, name := range []string{`"a"`, `"b"`, `"c"`} {
+       ids = append(ids, q.Submit("test", json.RawMessage(name)).ID)
+   }
+   q.Start()
+   defer q.Stop()
+   for _, id := range ids {
+       waitFor(t, q, id, StatusSucceeded)
+   }
+   mu.Lock()
+   defer mu.Unlock()
+   if len(order) != 3 || order[0] != `"a"` || order[1] != `"b"` || order[2] != `"c"` {
+       t.Errorf("unexpected run order %v", order)
+   }
+   if peak != 1 {
+       t.Errorf("expected one job at a time, saw %d", peak)
+   }
+   if list := q.List(); len(list) != 3 || list[0].ID != ids[0] || list[2].ID != ids[2] {
+       t.Errorf("expected jobs listed oldest first, got %+v", list)
+   }
+}
+
+func TestQueueCancelsQueuedAndRunningJobs(t *testing.T) {
+   release := make(chan struct{})
+   q, err := NewQueue(t.TempDir(), blockingRunner(release))
+   if err != nil {
+       t.Fatalf("new queue: %v", err)
+   }
+   q.Start()
+   defer q.Stop()
+   running := q.Submit("test", nil)
+   queued := q.Submit("test", nil)
+   waitFor(t, q, running.ID, StatusRunning)
+
+   if job, err := q.Cancel(queued.ID); err != nil || job.Status != StatusCanceled {
+       t.Fatalf("cancel queued: %+v %v", job, err)
+   }
+   if _, err := q.Cancel(running.ID); err != nil {
+       t.Fatalf("cancel running: %v", err)
+   }
+   if job := waitFor(t, q, running.ID, StatusCanceled, StatusFailed); job.Status != StatusCanceled || job.FinishedAt == "" {
+       t.Fatalf("expected the running job canceled, got %+v", job)
+   }
+   if _, err := q.Cancel(running.ID); !errors.Is(err, ErrInvalidState) {
+       t.Errorf("expected ErrInvalidState canceling twice, got %v", err)
+   }
+   if _, err := q.Cancel("missing"); !errors.Is(err, ErrNotFound) {
+       t.Errorf("expected ErrNotFound, got %v", err)
+   }
+
+   close(release)
+   if _, err := q.Retry(queued.ID); err != nil {
+       t.Fatalf("retry: %v", err)
+   }
+   if job := waitFor(t, q, queued.ID, StatusSucceeded); job.Attempts != 1 {
+       t.Errorf("expected one attempt for a job canceled while queued, got %d", job.Attempts)
+   }
+   if _, err := q.Retry(queued.ID); !errors.Is(err, ErrInvalidState) {
+       t.Errorf("expected ErrInvalidState retrying a succeeded job, got %v", err)
+   }
+}
+
+func TestQueuePersistsJobsAcrossRestarts(t *testing.T) {
+   dir := t.TempDir()
+   release := make(chan struct{})
+   q, err := NewQueue(dir, blockingRunner(release))
+   if err != nil {
+       t.Fatalf("new queue: %v", err)
+   }
+   q.Start()
+   canceled := q.Submit("test", nil)
+   q.Cancel(canceled.ID)
+   interrupted := q.Submit("test", json.RawMessage(`"x"`))
+   waitFor(t, q, interrupted.ID, StatusRunning)
+   q.Stop()
+
+   // A record left running by a crash is queued again as well
+   crashed := Job{ID: "crashed", Type: "test", Status: StatusRunning, Attempts: 1, CreatedAt: now(), StartedAt: now()}
+   data, _ := json.Marshal(crashed)
+   if err := os.WriteFile(filepath.Join(dir, "crashed.json"), data, 0644); err != nil {
+       t.Fatalf("write: %v", err)
+   }
+   if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644); err != nil {
+       t.Fatalf("write: %v", err)
+   }
+
+   var ran []string
+   var mu sync.Mutex
+   q, err = NewQueue(dir, func(ctx context.Context, job *Job) (json.RawMessage, error) {
+       mu.Lock()
+       ran = append(ran, job.ID)
+       mu.Unlock()
+       return json.RawMessage(`{"ok":true}`), nil
+   })
+   if err != nil {
+       t.Fatalf("reload queue: %v", err)
+   }
+   if list := q.List(); len(list) != 3 {
+       t.Fatalf("expected 3 jobs after reload, got %+v", list)
+   }
+   for _, id := range []string{interrupted.ID, "crashed"} {
+       if job, _ := q.Get(id); job.Status != StatusQueued || job.StartedAt != "" {
+           t.Errorf("%s: expected queued after restart, got %+v", id, job)
+       }
+   }
+   if job, _ := q.Get(canceled.ID); job.Status != StatusCanceled {
+       t.Errorf("expected finished jobs to keep their state, got %+v", job)
+   }
+   q.Start()
+   defer q.Stop()
+   job := waitFor(t, q, interrupted.ID, StatusSucceeded)
+   if job.Attempts != 2 || string(job.Request) != `"x"` || string(job.Result) != `{"ok":true}` {
+       t.Errorf("unexpected resumed job %+v", job)
+   }
+   waitFor(t, q, "crashed", StatusSucceeded)
+   mu.Lock()
+   defer mu.Unlock()
+   if len(ran) != 2 {
+       t.Errorf("expected only the interrupted jobs to run, got %v", ran)
+   }
+}
+
+func TestQueueBlobs(t *testing.T) {
+   q, err := NewQueue(t.TempDir(), nil)
+   if err != nil {
+       t.Fatalf("new queue: %v", err)
+   }
+   hash, err := q.PutBlob([]byte("init image"))
+   if err != nil {
+       t.Fatalf("put: %v", err)
+   }
+   if again, err := q.PutBlob([]byte("init image")); err != nil || again != hash {
+       t.Errorf("expected the same hash for the same content, got %s (%v)", again, err)
+   }
+   if data, err := q.Blob(hash); err != nil || !bytes.Equal(data, []byte("init image")) {
+       t.Errorf("unexpected blob %q (%v)", data, err)
+   }
+   if _, err := q.Blob("../" + hash[3:]); err == nil {
+       t.Errorf("expected an error for an invalid hash")
+   }
+}
@@ -0,0 +1,193 @@
+// Package prompts expands prompt templates before they are sent to the generator:
+// {name} variables, {a|b|c} dynamic choices and __name__ wildcards picking a random
+// entry from a word list. Braces, bars, underscores and backslashes are taken literally
+// when escaped with a backslash.
+package prompts
+
+import (
+   "errors"
+   "fmt"
+   "math/rand"
+   "regexp"
+   "strings"
+)
+
+// ErrSyntax is wrapped by errors for malformed templates.
+var ErrSyntax = errors.New("prompts: invalid template")
+
+// maxDepth bounds the nesting of variables and wildcards expanding to further templates,
+// which catches wildcards referring to themselves.
+const maxDepth = 10
+
+// validName matches variable and wildcard names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// ValidName reports whether name can be used for a variable or wildcard.
+func ValidName(name string) bool {
+   return validName.MatchString(name)
+}
+
+// Expander expands templates with a set of variables and wildcards.
+type Expander struct {
+   // Vars holds variable values; they may be templates themselves.
+   Vars map[string]string
+   // Wildcards returns the entries of the named wildcard list.
+   Wildcards func(name string) ([]string, error)
+   // Rand picks choices and wildcard entries; a fixed seed gives the same expansion.
+   Rand *rand.Rand
+}
+
+// NewExpander returns an Expander for vars and wildcards whose random picks are
+// reproducible from seed.
+func NewExpander(vars map[string]string, wildcards func(name string) ([]string, error), seed int64) *Expander {
+   return &Expander{Vars: vars, Wildcards: wildcards, Rand: rand.New(rand.NewSource(seed))}
+}
+
+// Expand returns template with every variable, choice and wildcard replaced. Unknown
+// variables and wildcards are errors, so typos do not end up in the prompt.
+func (e *Expander) Expand(template string) (string, error) {
+   return e.expand(template, 0)
+}
+
+// expand expands template at the given nesting depth.
+func (e *Expander) expand(template string, depth int) (string, error) {
+   if depth > maxDepth {
+       return "", fmt.Errorf("%w: nested more than %d levels deep (does a wildcard refer to itself?)", ErrSyntax, maxDepth)
+   }
+   p := &parser{e: e, s: template, depth: depth}
+   return p.text(false)
+}
+
+// parser holds the state of expanding one template.
+type parser struct {
+   e     *Expander
+   s     string
+   pos   int
+   depth int
+}
+
+// text expands until the end of the template or, inside braces, the next unescaped | or }.
+func (p *parser) text(nested bool) (string, error) {
+   var b strings.Builder
+   for p.pos < len(p.s) {
+       ch := p.s[p.pos]
+       switch {
+       case ch == '\\' && p.pos+1 < len(p.s) && strings.IndexByte(`{}|_\`, p.s[p.pos+1]) >= 0:
+           b.WriteByte(p.s[p.pos+1])
+           p.pos += 2
+       case ch == '{':
+           p.pos++
+           out, err := p.group()
+           if err != nil {
+               return "", err
+           }
+           b.WriteString(out)
+       case nested && (ch == '|' || ch == '}'):
+           return b.String(), nil
+       case ch == '}':
+           return "", fmt.Errorf("%w: unmatched } at offset %d", ErrSyntax, p.pos)
+       case strings.HasPrefix(p.s[p.pos:], "__"):
+           out, ok, err := p.wildcard()
+           if err != nil {
+               return "", err
+           }
+           if !ok {
+               b.WriteString("__")
+               p.pos += 2
+           }
+           b.WriteString(out)
+       default:
+           b.WriteByte(ch)
+           p.pos++
+       }
+   }
+   if nested {
+       return "", fmt.Errorf("%w: unclosed {", ErrSyntax)
+   }
+   return b.String(), nil
+}
+
+// group expands the braces starting at p.pos (after the {): a variable if they hold
+// just a name, otherwise a choice between the alternatives separated by |.
+func (p *parser) group() (string, error) {
+   if end := strings.IndexByte(p.s[p.pos:], '}'); end >= 0 && validName.MatchString(p.s[p.pos:p.pos+end]) {
+       name := p.s[p.pos : p.pos+end]
+       p.pos += end + 1
+       value, ok := p.e.Vars[name]
+       if !ok {
+           return "", fmt.Errorf("prompt variable %q has no value", name)
+       }
+       return p.e.expand(value, p.depth+1)
+   }
+   var options []string
+   for {
+       opt, err := p.text(true)
+       if err != nil {
+           return "", err
+       }
+       options = append(options, opt)
+       sep := p.s[p.pos]
+       p.pos++
+       if sep == '}' {
+           break
+       }
+   }
+   return options[p.e.Rand.Intn(len(options))], nil
+}
+
+// wildcard expands the __name__ at p.pos to a random entry of the wildcard list. It
+// reports false, consuming nothing, when no wildcard name follows.
+func (p *parser) wildcard() (string, bool, error) {
+   rest := p.s[p.pos+2:]
+   end := strings.Index(rest, "__")
+   if end <= 0 || !validName.MatchString(rest[:end]) {
+       return "", false, nil
+   }
+   name := rest[:end]
+   p.pos += end + 4
+   if p.e.Wildcards == nil {
+       return "", true, fmt.Errorf("unknown wildcard %q", name)
+   }
+   entries, err := p.e.Wildcards(name)
+   if err != nil {
+       return "", true, fmt.Errorf("wildcard %q: %w", name, err)
+   }
+   if len(entries) == 0 {
+       return "", true, fmt.Errorf("wildcard %q has no entries", name)
+   }
+   out, err := p.e.expand(entries[p.e.Rand.Intn(len(entries))], p.depth+1)
+   return out, true, err
+}
+
+// Variables returns the names of the variables template refers to, in order of first use.
+func Variables(template string) []string {
+   var names []string
+   seen := map[string]bool{}
+   for i := 0; i < len(template); i++ {
+       switch template[i] {
+       case '\\':
+           i++
+       case '{':
+           end := strings.IndexByte(template[i+1:], '}')
+           if end < 0 {
+               return names
+           }
+           if name := template[i+1 : i+1+end]; validName.MatchString(name) && !seen[name] {
+               seen[name] = true
+               names = append(names, name)
+           }
+       }
+   }
+   return names
+}
+
+// Check reports syntax errors in template without resolving its variables and wildcards.
+func Check(template string) error {
+   vars := map[string]string{}
+   for _, name := range Variables(template) {
+       vars[name] = ""
+   }
+   e := NewExpander(vars, func(string) ([]string, error) { return []string{""}, nil }, 0)
+   _, err := e.Expand(template)
+   return err
+}
@@ -0,0 +1,65 @@
+package prompts
+
+import (
+   "errors"
+   "strings"
+   "testing"
+)
+
+func TestExpand(t *testing.T) {
+   wildcards := map[string][]string{
+       "hair_color": {"red", "{dark|light} brown"},
+       "loop":       {"__loop__"},
+   }
+   lookup := func(name string) ([]string, error) {
+       if w, ok := wildcards[name]; ok {
+           return w, nil
+       }
+       return nil, errors.New("not found")
+   }
+   vars := map[string]string{"subject": "a knight", "style": "{oil|ink} painting"}
+
+   // The same seed gives the same picks; different seeds cover every alternative
+   seen := map[string]bool{}
+   for seed := int64(0); seed < 50; seed++ {
+       out, err := NewExpander(vars, lookup, seed).Expand(`{subject} with __hair_color__ hair, {style}, \{literal\} \__x\__`)
+       if err != nil {
+           t.Fatal(err)
+       }
+       again, _ := NewExpander(vars, lookup, seed).Expand(`{subject} with __hair_color__ hair, {style}, \{literal\} \__x\__`)
+       if out != again {
+           t.Fatalf("expansion is not reproducible: %q vs %q", out, again)
+       }
+       if !strings.HasPrefix(out, "a knight with ") || !strings.HasSuffix(out, " painting, {literal} __x__") {
+           t.Fatalf("unexpected expansion %q", out)
+       }
+       seen[out] = true
+   }
+   if len(seen) != 6 {
+       t.Errorf("expected all 6 combinations, got %v", seen)
+   }
+
+   for template, want := range map[string]string{
+       "snake_case and a__b stay": "snake_case and a__b stay",
+       "{|}{single}{x|x}":         "x",
+   } {
+       out, err := NewExpander(map[string]string{"single": ""}, lookup, 1).Expand(template)
+       if err != nil || out != want {
+           t.Errorf("%q: got %q, %v", template, out, err)
+       }
+   }
+   for _, template := range []string{"{missing}", "__nope__", "{a|b", "a}", "__loop__"} {
+       if _, err := NewExpander(vars, lookup, 1).Expand(template); err == nil {
+           t.Errorf("%q: expected an error", template)
+       }
+   }
+   if err := Check("{a|b} {subject} __anything__"); err != nil {
+       t.Errorf("expected a valid template: %v", err)
+   }
+   if err := Check("{a|b"); !errors.Is(err, ErrSyntax) {
+       t.Errorf("expected a syntax error, got %v", err)
+   }
+   if got := Variables(`{a} {b|c} \{d} {a} {e}`); strings.Join(got, ",") != "a,e" {
+       t.Errorf("unexpected variables %v", got)
+   }
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+   "encoding/json"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+)
+
+// Attributes holds user-assigned per-image properties such as tags and rating.
+type Attributes struct {
+   Tags   []string `json:"tags"`
+   Rating int      `json:"rating"`
+}
+
+// LeafDir returns the per-image metadata directory for the given content hash.
+func LeafDir(baseDir, hash string) string {
+   return filepath.Join(baseDir, "metadata", hash[:2], hash)
+}
+
+// LoadAttributes reads the attributes stored for the image with the given content hash.
+// Returns empty attributes if none have been saved.
+func LoadAttributes(baseDir, hash string) (Attributes, error) {
+   attrs := Attributes{Tags: []string{}}
+   data, err := ioutil.ReadFile(filepath.Join(LeafDir(baseDir, hash), "attributes.json"))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return attrs, nil
+       }
+       return attrs, err
+   }
+   if err := json.Unmarshal(data, &attrs); err != nil {
+       return attrs, err
+   }
+   if attrs.Tags == nil {
+       attrs.Tags = []string{}
+   }
+   return attrs, nil
+}
+
+// SaveAttributes writes the attributes for the image with the given content hash.
+func SaveAttributes(baseDir, hash string, attrs Attributes) error {
+   leaf := LeafDir(baseDir, hash)
+   if err := os.MkdirAll(leaf, 0755); err != nil {
+       return err
+   }
+   data, err := json.MarshalIndent(attrs, "", "  ")
+   if err != nil {
+       return err
+   }
+   return ioutil.WriteFile(filepath.Join(leaf, "attributes.json"), data, 0644)
+}
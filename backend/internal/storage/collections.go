@@ -0,0 +1,128 @@
+package storage
+
+import (
+   "crypto/rand"
+   "encoding/hex"
+   "encoding/json"
+   "errors"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "sort"
+   "strings"
+)
+
+// ErrCollectionNotFound is returned when a collection ID does not exist.
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// CollectionQuery describes the saved query that selects a collection's members.
+// Empty fields do not constrain the result. Model matches the generation model name
+// (case-insensitive substring) or hash embedded in the image.
+type CollectionQuery struct {
+   Tags       []string `json:"tags,omitempty"`
+   MinRating  *int     `json:"min_rating,omitempty"`
+   MaxRating  *int     `json:"max_rating,omitempty"`
+   From       string   `json:"from,omitempty"`
+   To         string   `json:"to,omitempty"`
+   DialogText string   `json:"dialog_text,omitempty"`
+   Model      string   `json:"model,omitempty"`
+   Paths      []string `json:"paths,omitempty"`
+}
+
+// Collection is a virtual album whose members are resolved from a saved query.
+type Collection struct {
+   ID          string          `json:"id"`
+   Name        string          `json:"name"`
+   Query       CollectionQuery `json:"query"`
+   ManualOrder bool            `json:"manual_order"`
+   Order       []string        `json:"order,omitempty"`
+}
+
+// collectionsDir returns the directory holding collection definitions under root.
+func collectionsDir(root string) string {
+   return filepath.Join(root, "collections")
+}
+
+// NewCollectionID returns a random identifier for a new collection.
+func NewCollectionID() string {
+   b := make([]byte, 8)
+   _, _ = rand.Read(b)
+   return hex.EncodeToString(b)
+}
+
+// validCollectionID reports whether id is safe to use as a file name.
+func validCollectionID(id string) bool {
+   return id != "" && !strings.ContainsAny(id, `/\.`)
+}
+
+// LoadCollection reads a single collection definition.
+func LoadCollection(root, id string) (*Collection, error) {
+   if !validCollectionID(id) {
+       return nil, ErrCollectionNotFound
+   }
+   data, err := ioutil.ReadFile(filepath.Join(collectionsDir(root), id+".json"))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return nil, ErrCollectionNotFound
+       }
+       return nil, err
+   }
+   var col Collection
+   if err := json.Unmarshal(data, &col); err != nil {
+       return nil, err
+   }
+   col.ID = id
+   return &col, nil
+}
+
+// SaveCollection writes a collection definition, creating the collections directory if needed.
+func SaveCollection(root string, col *Collection) error {
+   if !validCollectionID(col.ID) {
+       return ErrCollectionNotFound
+   }
+   dir := collectionsDir(root)
+   if err := os.MkdirAll(dir, 0755); err != nil {
+       return err
+   }
+   data, err := json.MarshalIndent(col, "", "  ")
+   if err != nil {
+       return err
+   }
+   return ioutil.WriteFile(filepath.Join(dir, col.ID+".json"), data, 0644)
+}
+
+// DeleteCollection removes a collection definition.
+func DeleteCollection(root, id string) error {
+   if !validCollectionID(id) {
+       return ErrCollectionNotFound
+   }
+   err := os.Remove(filepath.Join(collectionsDir(root), id+".json"))
+   if os.IsNotExist(err) {
+       return ErrCollectionNotFound
+   }
+   return err
+}
+
+// ListCollections returns all collection definitions sorted by name.
+func ListCollections(root string) ([]Collection, error) {
+   files, err := ioutil.ReadDir(collectionsDir(root))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return []Collection{}, nil
+       }
+       return nil, err
+   }
+   cols := []Collection{}
+   for _, fi := range files {
+       if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+           continue
+       }
+       col, err := LoadCollection(root, strings.TrimSuffix(fi.Name(), ".json"))
+       if err != nil {
+           continue
+       }
+       cols = append(cols, *col)
+   }
+   sort.Slice(cols, func(i, j int) bool { return cols[i].Name < cols[j].Name })
+   return cols, nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+   "bytes"
+   "encoding/binary"
+   "errors"
+   "io"
+)
+
+// maxChunkSize bounds the size of a metadata chunk or segment read into memory.
+const maxChunkSize = 32 << 20
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunk is a single chunk of a PNG file.
+type pngChunk struct {
+   Type string
+   Data []byte
+}
+
+// readPNGChunks reads the chunks of a PNG stream, loading data only for IHDR and the wanted types.
+// Other chunks (notably IDAT) are skipped without being buffered.
+func readPNGChunks(r io.ReadSeeker, want map[string]bool) ([]pngChunk, error) {
+   sig := make([]byte, len(pngSignature))
+   if _, err := io.ReadFull(r, sig); err != nil {
+       return nil, err
+   }
+   if !bytes.Equal(sig, pngSignature) {
+       return nil, errors.New("not a PNG file")
+   }
+   var chunks []pngChunk
+   var hdr [8]byte
+   for {
+       if _, err := io.ReadFull(r, hdr[:]); err != nil {
+           if err == io.EOF || err == io.ErrUnexpectedEOF {
+               return chunks, nil
+           }
+           return chunks, err
+       }
+       length := int64(binary.BigEndian.Uint32(hdr[:4]))
+       typ := string(hdr[4:8])
+       if typ == "IEND" {
+           return chunks, nil
+       }
+       if (typ == "IHDR" || want[typ]) && length <= maxChunkSize {
+           data := make([]byte, length)
+           if _, err := io.ReadFull(r, data); err != nil {
+               return chunks, err
+           }
+           chunks = append(chunks, pngChunk{Type: typ, Data: data})
+           length = 0
+       }
+       // skip remaining data plus CRC
+       if _, err := r.Seek(length+4, io.SeekCurrent); err != nil {
+           return chunks, err
+       }
+   }
+}
+
+// jpegSegment is a single marker segment from a JPEG header.
+type jpegSegment struct {
+   Marker byte
+   Data   []byte
+}
+
+// readJPEGSegments reads marker segments up to the start of scan.
+func readJPEGSegments(r io.Reader) ([]jpegSegment, error) {
+   var soi [2]byte
+   if _, err := io.ReadFull(r, soi[:]); err != nil {
+       return nil, err
+   }
+   if soi[0] != 0xFF || soi[1] != 0xD8 {
+       return nil, errors.New("not a JPEG file")
+   }
+   var segs []jpegSegment
+   var hdr [4]byte
+   for {
+       if _, err := io.ReadFull(r, hdr[:2]); err != nil {
+           return segs, err
+       }
+       // skip fill bytes
+       for hdr[0] == 0xFF && hdr[1] == 0xFF {
+           hdr[1] = 0
+           if _, err := io.ReadFull(r, hdr[1:2]); err != nil {
+               return segs, err
+           }
+       }
+       if hdr[0] != 0xFF {
+           return segs, errors.New("invalid JPEG marker")
+       }
+       marker := hdr[1]
+       // standalone markers carry no length
+       if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+           continue
+       }
+       if marker == 0xD9 || marker == 0xDA {
+           return segs, nil
+       }
+       if _, err := io.ReadFull(r, hdr[2:4]); err != nil {
+           return segs, err
+       }
+       length := int(binary.BigEndian.Uint16(hdr[2:4])) - 2
+       if length < 0 {
+           return segs, errors.New("invalid JPEG segment length")
+       }
+       data := make([]byte, length)
+       if _, err := io.ReadFull(r, data); err != nil {
+           return segs, err
+       }
+       segs = append(segs, jpegSegment{Marker: marker, Data: data})
+   }
+}
+
+// riffChunk is a single chunk of a RIFF (WebP) container.
+type riffChunk struct {
+   FourCC string
+   Data   []byte
+}
+
+// readWebPChunks reads the chunks of a WebP file. Image bitstream chunks
+// (VP8, VP8L, ALPH, ANMF) are truncated to their first 32 bytes, which is enough for frame headers.
+func readWebPChunks(r io.ReadSeeker) ([]riffChunk, error) {
+   var hdr [12]byte
+   if _, err := io.ReadFull(r, hdr[:]); err != nil {
+       return nil, err
+   }
+   if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WEBP" {
+       return nil, errors.New("not a WebP file")
+   }
+   var chunks []riffChunk
+   var ch [8]byte
+   for {
+       if _, err := io.ReadFull(r, ch[:]); err != nil {
+           if err == io.EOF || err == io.ErrUnexpectedEOF {
+               return chunks, nil
+           }
+           return chunks, err
+       }
+       fourcc := string(ch[0:4])
+       size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+       padded := size + size&1
+       keep := size
+       switch fourcc {
+       case "VP8 ", "VP8L", "ALPH", "ANMF":
+           if keep > 32 {
+               keep = 32
+           }
+       }
+       if keep > maxChunkSize {
+           keep = 0
+       }
+       data := make([]byte, keep)
+       if _, err := io.ReadFull(r, data); err != nil {
+           return chunks, err
+       }
+       chunks = append(chunks, riffChunk{FourCC: fourcc, Data: data})
+       if _, err := r.Seek(padded-keep, io.SeekCurrent); err != nil {
+           return chunks, err
+       }
+   }
+}
@@ -0,0 +1,328 @@
+package storage
+
+import (
+   "bytes"
+   "compress/zlib"
+   "encoding/binary"
+   "encoding/json"
+   "errors"
+   "io"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "regexp"
+   "strconv"
+   "strings"
+   "unicode/utf16"
+
+   "github.com/rwcarlsen/goexif/exif"
+)
+
+// generationVersion is bumped whenever infotext parsing changes, so cached entries are refreshed.
+const generationVersion = 1
+
+// ErrNoGenerationParams is returned when an image carries no embedded generation parameters.
+var ErrNoGenerationParams = errors.New("no generation parameters")
+
+// GenerationParams holds Stable Diffusion generation parameters parsed from an infotext.
+type GenerationParams struct {
+   Version           int               `json:"version"`
+   Prompt            string            `json:"prompt"`
+   NegativePrompt    string            `json:"negative_prompt,omitempty"`
+   Steps             int               `json:"steps,omitempty"`
+   Sampler           string            `json:"sampler,omitempty"`
+   Scheduler         string            `json:"scheduler,omitempty"`
+   CFGScale          float64           `json:"cfg_scale,omitempty"`
+   Seed              *int64            `json:"seed,omitempty"`
+   Width             int               `json:"width,omitempty"`
+   Height            int               `json:"height,omitempty"`
+   Model             string            `json:"model,omitempty"`
+   ModelHash         string            `json:"model_hash,omitempty"`
+   DenoisingStrength float64           `json:"denoising_strength,omitempty"`
+   Extra             map[string]string `json:"extra,omitempty"`
+   Raw               string            `json:"raw"`
+}
+
+// generationCache is the on-disk form of cached parse results; Params is nil when the image has none.
+type generationCache struct {
+   Version int               `json:"version"`
+   Params  *GenerationParams `json:"params"`
+}
+
+// infotextParamRe matches "Key: value" pairs on the last infotext line; values may be quoted.
+var infotextParamRe = regexp.MustCompile(`\s*(\w[\w \-/]+):\s*("(?:\\.|[^\\"])+"|[^,]*)(?:,|$)`)
+
+// ParseInfotext parses an A1111/Forge style infotext:
+//
+//   <prompt>
+//   Negative prompt: <negative prompt>
+//   Steps: 20, Sampler: Euler a, CFG scale: 7, Seed: 1, Size: 512x768, Model hash: abc, Model: name
+func ParseInfotext(text string) *GenerationParams {
+   p := &GenerationParams{Version: generationVersion, Raw: text, Extra: map[string]string{}}
+   lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n")), "\n")
+   var paramLine string
+   if n := len(lines); n > 0 && len(infotextParamRe.FindAllString(lines[n-1], -1)) >= 3 {
+       paramLine = lines[n-1]
+       lines = lines[:n-1]
+   }
+   var prompt, negative []string
+   inNegative := false
+   for _, line := range lines {
+       if strings.HasPrefix(line, "Negative prompt:") {
+           inNegative = true
+           line = strings.TrimSpace(strings.TrimPrefix(line, "Negative prompt:"))
+       }
+       if inNegative {
+           negative = append(negative, line)
+       } else {
+           prompt = append(prompt, line)
+       }
+   }
+   p.Prompt = strings.TrimSpace(strings.Join(prompt, "\n"))
+   p.NegativePrompt = strings.TrimSpace(strings.Join(negative, "\n"))
+   for _, m := range infotextParamRe.FindAllStringSubmatch(paramLine, -1) {
+       key := strings.TrimSpace(m[1])
+       val := strings.TrimSpace(m[2])
+       if len(val) >= 2 && strings.HasPrefix(val, `"`) && strings.HasSuffix(val, `"`) {
+           if unq, err := strconv.Unquote(val); err == nil {
+               val = unq
+           }
+       }
+       switch key {
+       case "Steps":
+           p.Steps, _ = strconv.Atoi(val)
+       case "Sampler":
+           p.Sampler = val
+       case "Schedule type":
+           p.Scheduler = val
+       case "CFG scale":
+           p.CFGScale, _ = strconv.ParseFloat(val, 64)
+       case "Seed":
+           if seed, err := strconv.ParseInt(val, 10, 64); err == nil {
+               p.Seed = &seed
+           }
+       case "Size":
+           if w, h, ok := strings.Cut(val, "x"); ok {
+               p.Width, _ = strconv.Atoi(w)
+               p.Height, _ = strconv.Atoi(h)
+           }
+       case "Model":
+           p.Model = val
+       case "Model hash":
+           p.ModelHash = val
+       case "Denoising strength":
+           p.DenoisingStrength, _ = strconv.ParseFloat(val, 64)
+       default:
+           p.Extra[key] = val
+       }
+   }
+   if len(p.Extra) == 0 {
+       p.Extra = nil
+   }
+   return p
+}
+
+// LoadGenerationParams returns the generation parameters embedded in the image file id in baseDir.
+// Results (including their absence) are cached per content hash; hash may be empty to compute it.
+// Returns ErrNoGenerationParams if the image carries none.
+func LoadGenerationParams(baseDir, id, hash string) (*GenerationParams, error) {
+   fullpath := filepath.Join(baseDir, id)
+   if hash == "" {
+       var err error
+       if hash, err = HashFile(fullpath); err != nil {
+           return nil, err
+       }
+   }
+   file := filepath.Join(LeafDir(baseDir, hash), "generation.json")
+   if data, err := ioutil.ReadFile(file); err == nil {
+       var cached generationCache
+       if err := json.Unmarshal(data, &cached); err == nil && cached.Version == generationVersion {
+           if cached.Params == nil {
+               return nil, ErrNoGenerationParams
+           }
+           return cached.Params, nil
+       }
+   }
+   var params *GenerationParams
+   text, err := ReadInfotext(fullpath)
+   if err == nil && strings.TrimSpace(text) != "" {
+       params = ParseInfotext(text)
+   }
+   if err := os.MkdirAll(filepath.Dir(file), 0755); err == nil {
+       if data, err := json.MarshalIndent(generationCache{Version: generationVersion, Params: params}, "", "  "); err == nil {
+           _ = ioutil.WriteFile(file, data, 0644)
+       }
+   }
+   if params == nil {
+       return nil, ErrNoGenerationParams
+   }
+   return params, nil
+}
+
+// ReadInfotext extracts the raw generation infotext from a PNG "parameters" text chunk
+// or from the EXIF UserComment of a JPEG or WebP file.
+func ReadInfotext(path string) (string, error) {
+   f, err := os.Open(path)
+   if err != nil {
+       return "", err
+   }
+   defer f.Close()
+   var magic [12]byte
+   n, _ := io.ReadFull(f, magic[:])
+   if _, err := f.Seek(0, io.SeekStart); err != nil {
+       return "", err
+   }
+   switch {
+   case n >= 8 && bytes.Equal(magic[:8], pngSignature):
+       chunks, err := readPNGChunks(f, map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true})
+       if err != nil && len(chunks) == 0 {
+           return "", err
+       }
+       for _, ch := range chunks {
+           if key, text, ok := decodePNGText(ch); ok && key == "parameters" {
+               return text, nil
+           }
+       }
+       for _, ch := range chunks {
+           if ch.Type == "eXIf" {
+               return exifUserComment(ch.Data)
+           }
+       }
+   case n >= 2 && magic[0] == 0xFF && magic[1] == 0xD8:
+       segs, err := readJPEGSegments(f)
+       if err != nil && len(segs) == 0 {
+           return "", err
+       }
+       for _, seg := range segs {
+           if seg.Marker == 0xE1 && bytes.HasPrefix(seg.Data, []byte("Exif\x00\x00")) {
+               return exifUserComment(seg.Data)
+           }
+       }
+   case n >= 12 && string(magic[0:4]) == "RIFF" && string(magic[8:12]) == "WEBP":
+       chunks, err := readWebPChunks(f)
+       if err != nil && len(chunks) == 0 {
+           return "", err
+       }
+       for _, ch := range chunks {
+           if ch.FourCC == "EXIF" {
+               return exifUserComment(ch.Data)
+           }
+       }
+   }
+   return "", ErrNoGenerationParams
+}
+
+// decodePNGText decodes tEXt, zTXt and iTXt chunks into a keyword and text.
+func decodePNGText(ch pngChunk) (string, string, bool) {
+   i := bytes.IndexByte(ch.Data, 0)
+   if i < 0 {
+       return "", "", false
+   }
+   key := string(ch.Data[:i])
+   rest := ch.Data[i+1:]
+   switch ch.Type {
+   case "tEXt":
+       return key, latin1ToString(rest), true
+   case "zTXt":
+       if len(rest) < 1 {
+           return "", "", false
+       }
+       text, err := inflate(rest[1:])
+       if err != nil {
+           return "", "", false
+       }
+       return key, latin1ToString(text), true
+   case "iTXt":
+       // compression flag, compression method, language tag\0, translated keyword\0, text
+       if len(rest) < 2 {
+           return "", "", false
+       }
+       compressed := rest[0] == 1
+       rest = rest[2:]
+       for skip := 0; skip < 2; skip++ {
+           j := bytes.IndexByte(rest, 0)
+           if j < 0 {
+               return "", "", false
+           }
+           rest = rest[j+1:]
+       }
+       if compressed {
+           text, err := inflate(rest)
+           if err != nil {
+               return "", "", false
+           }
+           rest = text
+       }
+       return key, string(rest), true
+   }
+   return "", "", false
+}
+
+// inflate decompresses zlib data, bounded by maxChunkSize.
+func inflate(data []byte) ([]byte, error) {
+   zr, err := zlib.NewReader(bytes.NewReader(data))
+   if err != nil {
+       return nil, err
+   }
+   defer zr.Close()
+   return ioutil.ReadAll(io.LimitReader(zr, maxChunkSize))
+}
+
+// latin1ToString converts ISO-8859-1 bytes to a UTF-8 string.
+func latin1ToString(b []byte) string {
+   r := make([]rune, len(b))
+   for i, c := range b {
+       r[i] = rune(c)
+   }
+   return string(r)
+}
+
+// exifUserComment decodes the EXIF UserComment tag from raw EXIF data.
+func exifUserComment(data []byte) (string, error) {
+   x, err := exif.Decode(bytes.NewReader(data))
+   if x == nil {
+       return "", err
+   }
+   tag, err := x.Get(exif.UserComment)
+   if err != nil {
+       return "", ErrNoGenerationParams
+   }
+   return decodeUserComment(tag.Val), nil
+}
+
+// decodeUserComment decodes a UserComment value: an 8-byte character code followed by the text.
+func decodeUserComment(val []byte) string {
+   if len(val) < 8 {
+       return strings.TrimRight(string(val), "\x00")
+   }
+   code, body := string(val[:8]), val[8:]
+   if code == "UNICODE\x00" {
+       return strings.TrimRight(decodeUTF16(body), "\x00")
+   }
+   return strings.TrimRight(string(body), "\x00 ")
+}
+
+// decodeUTF16 decodes UTF-16 text, guessing the byte order from where the zero bytes of ASCII characters fall.
+func decodeUTF16(b []byte) string {
+   if len(b) < 2 {
+       return ""
+   }
+   var order binary.ByteOrder = binary.BigEndian
+   evenZeros, oddZeros := 0, 0
+   for i := 0; i+1 < len(b); i += 2 {
+       if b[i] == 0 {
+           evenZeros++
+       }
+       if b[i+1] == 0 {
+           oddZeros++
+       }
+   }
+   if oddZeros > evenZeros {
+       order = binary.LittleEndian
+   }
+   u := make([]uint16, len(b)/2)
+   for i := range u {
+       u[i] = order.Uint16(b[2*i:])
+   }
+   return string(utf16.Decode(u))
+}
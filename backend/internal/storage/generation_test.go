@@ -0,0 +1,70 @@
+package storage
+
+import (
+   "bytes"
+   "encoding/binary"
+   "hash/crc32"
+   "image"
+   "image/png"
+   "os"
+   "path/filepath"
+   "testing"
+)
+
+const sampleInfotext = `masterpiece, a knight
+standing in the rain
+Negative prompt: blurry, lowres
+Steps: 28, Sampler: DPM++ 2M, Schedule type: Karras, CFG scale: 6.5, Seed: 1234567890, Size: 832x1216, Model hash: 7f96a1a9ca, Model: sdxl_base, Lora hashes: "knight: abc123, rain: def456", Version: f2.0.1`
+
+func TestParseInfotext(t *testing.T) {
+   p := ParseInfotext(sampleInfotext)
+   if p.Prompt != "masterpiece, a knight\nstanding in the rain" {
+       t.Errorf("unexpected prompt: %q", p.Prompt)
+   }
+   if p.NegativePrompt != "blurry, lowres" {
+       t.Errorf("unexpected negative prompt: %q", p.NegativePrompt)
+   }
+   if p.Steps != 28 || p.Sampler != "DPM++ 2M" || p.Scheduler != "Karras" || p.CFGScale != 6.5 {
+       t.Errorf("unexpected sampling params: %+v", p)
+   }
+   if p.Seed == nil || *p.Seed != 1234567890 || p.Width != 832 || p.Height != 1216 {
+       t.Errorf("unexpected seed/size: %+v", p)
+   }
+   if p.Model != "sdxl_base" || p.ModelHash != "7f96a1a9ca" {
+       t.Errorf("unexpected model: %q %q", p.Model, p.ModelHash)
+   }
+   if p.Extra["Lora hashes"] != "knight: abc123, rain: def456" || p.Extra["Version"] != "f2.0.1" {
+       t.Errorf("unexpected extra: %v", p.Extra)
+   }
+}
+
+func TestReadInfotextFromPNG(t *testing.T) {
+   var buf bytes.Buffer
+   if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+       t.Fatalf("encode: %v", err)
+   }
+   // Insert a tEXt chunk right after IHDR (8-byte signature + 25-byte IHDR chunk).
+   raw := buf.Bytes()
+   data := append([]byte("parameters\x00"), sampleInfotext...)
+   chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+   chunk = append(chunk, "tEXt"...)
+   chunk = append(chunk, data...)
+   chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+   out := append(append(append([]byte{}, raw[:33]...), chunk...), raw[33:]...)
+
+   dir := t.TempDir()
+   if err := os.WriteFile(filepath.Join(dir, "gen.png"), out, 0644); err != nil {
+       t.Fatalf("write: %v", err)
+   }
+   p, err := LoadGenerationParams(dir, "gen.png", "")
+   if err != nil {
+       t.Fatalf("load params: %v", err)
+   }
+   if p.Model != "sdxl_base" || p.Steps != 28 {
+       t.Errorf("unexpected params: %+v", p)
+   }
+   // Second call is served from the leaf cache
+   if p2, err := LoadGenerationParams(dir, "gen.png", ""); err != nil || p2.Raw != p.Raw {
+       t.Errorf("cached params mismatch: %v", err)
+   }
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+   "encoding/binary"
+   "strings"
+   "unicode/utf16"
+)
+
+// parseICCDescription returns the profile description ('desc' tag) of an ICC profile,
+// supporting both the v2 textDescriptionType and the v4 multiLocalizedUnicodeType.
+func parseICCDescription(profile []byte) string {
+   if len(profile) < 132 {
+       return ""
+   }
+   count := int(binary.BigEndian.Uint32(profile[128:132]))
+   for i := 0; i < count; i++ {
+       off := 132 + i*12
+       if off+12 > len(profile) {
+           return ""
+       }
+       if string(profile[off:off+4]) != "desc" {
+           continue
+       }
+       start := int(binary.BigEndian.Uint32(profile[off+4 : off+8]))
+       size := int(binary.BigEndian.Uint32(profile[off+8 : off+12]))
+       if start < 0 || size < 12 || start+size > len(profile) {
+           return ""
+       }
+       tag := profile[start : start+size]
+       switch string(tag[0:4]) {
+       case "desc":
+           n := int(binary.BigEndian.Uint32(tag[8:12]))
+           if 12+n > len(tag) {
+               n = len(tag) - 12
+           }
+           return strings.TrimRight(string(tag[12:12+n]), "\x00")
+       case "mluc":
+           if len(tag) < 28 {
+               return ""
+           }
+           // use the first localized record
+           strLen := int(binary.BigEndian.Uint32(tag[20:24]))
+           strOff := int(binary.BigEndian.Uint32(tag[24:28]))
+           if strOff+strLen > len(tag) {
+               return ""
+           }
+           raw := tag[strOff : strOff+strLen]
+           u := make([]uint16, len(raw)/2)
+           for j := range u {
+               u[j] = binary.BigEndian.Uint16(raw[2*j:])
+           }
+           return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+       }
+       return ""
+   }
+   return ""
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+   "bytes"
+   "compress/zlib"
+   "encoding/binary"
+   "encoding/json"
+   "errors"
+   "io"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "strings"
+
+   "github.com/rwcarlsen/goexif/exif"
+   "github.com/rwcarlsen/goexif/tiff"
+)
+
+// imageInfoVersion is bumped whenever extraction changes, so cached entries are refreshed.
+const imageInfoVersion = 1
+
+// maxExifValueLen skips EXIF values (maker notes, thumbnails) too large to be useful in listings.
+const maxExifValueLen = 512
+
+// ImageInfo holds technical metadata extracted from an image file.
+type ImageInfo struct {
+   Version    int               `json:"version"`
+   Width      int               `json:"width"`
+   Height     int               `json:"height"`
+   Format     string            `json:"format"`
+   BitDepth   int               `json:"bit_depth,omitempty"`
+   ColorModel string            `json:"color_model,omitempty"`
+   ICCProfile string            `json:"icc_profile,omitempty"`
+   FileSize   int64             `json:"file_size"`
+   EXIF       map[string]string `json:"exif,omitempty"`
+}
+
+// LoadImageInfo returns technical metadata for the image file id in baseDir.
+// Results are cached per content hash in the image's metadata leaf; hash may be empty to compute it.
+func LoadImageInfo(baseDir, id, hash string) (*ImageInfo, error) {
+   fullpath := filepath.Join(baseDir, id)
+   if hash == "" {
+       var err error
+       if hash, err = HashFile(fullpath); err != nil {
+           return nil, err
+       }
+   }
+   file := filepath.Join(LeafDir(baseDir, hash), "info.json")
+   if data, err := ioutil.ReadFile(file); err == nil {
+       var info ImageInfo
+       if err := json.Unmarshal(data, &info); err == nil && info.Version == imageInfoVersion {
+           return &info, nil
+       }
+   }
+   info, err := ReadImageInfo(fullpath)
+   if err != nil {
+       return nil, err
+   }
+   if err := os.MkdirAll(filepath.Dir(file), 0755); err == nil {
+       if data, err := json.MarshalIndent(info, "", "  "); err == nil {
+           _ = ioutil.WriteFile(file, data, 0644)
+       }
+   }
+   return info, nil
+}
+
+// ReadImageInfo extracts technical metadata directly from the file at path without caching.
+func ReadImageInfo(path string) (*ImageInfo, error) {
+   f, err := os.Open(path)
+   if err != nil {
+       return nil, err
+   }
+   defer f.Close()
+   st, err := f.Stat()
+   if err != nil {
+       return nil, err
+   }
+   var magic [12]byte
+   n, _ := io.ReadFull(f, magic[:])
+   if _, err := f.Seek(0, io.SeekStart); err != nil {
+       return nil, err
+   }
+   info := &ImageInfo{Version: imageInfoVersion, FileSize: st.Size()}
+   switch {
+   case n >= 8 && bytes.Equal(magic[:8], pngSignature):
+       info.Format = "png"
+       err = readPNGInfo(f, info)
+   case n >= 2 && magic[0] == 0xFF && magic[1] == 0xD8:
+       info.Format = "jpeg"
+       err = readJPEGInfo(f, info)
+   case n >= 12 && string(magic[0:4]) == "RIFF" && string(magic[8:12]) == "WEBP":
+       info.Format = "webp"
+       err = readWebPInfo(f, info)
+   default:
+       return nil, errors.New("unsupported image format")
+   }
+   if err != nil {
+       return nil, err
+   }
+   return info, nil
+}
+
+// pngColorModels maps PNG IHDR color types to color model names.
+var pngColorModels = map[byte]string{
+   0: "gray",
+   2: "rgb",
+   3: "palette",
+   4: "gray-alpha",
+   6: "rgba",
+}
+
+// readPNGInfo fills info from the IHDR, iCCP, sRGB and eXIf chunks.
+func readPNGInfo(r io.ReadSeeker, info *ImageInfo) error {
+   chunks, err := readPNGChunks(r, map[string]bool{"iCCP": true, "sRGB": true, "eXIf": true})
+   if err != nil && len(chunks) == 0 {
+       return err
+   }
+   for _, ch := range chunks {
+       switch ch.Type {
+       case "IHDR":
+           if len(ch.Data) < 13 {
+               return errors.New("invalid PNG header")
+           }
+           info.Width = int(binary.BigEndian.Uint32(ch.Data[0:4]))
+           info.Height = int(binary.BigEndian.Uint32(ch.Data[4:8]))
+           info.BitDepth = int(ch.Data[8])
+           info.ColorModel = pngColorModels[ch.Data[9]]
+       case "iCCP":
+           // profile name, null separator, compression method, zlib data
+           i := bytes.IndexByte(ch.Data, 0)
+           if i < 0 {
+               continue
+           }
+           info.ICCProfile = string(ch.Data[:i])
+           if i+2 <= len(ch.Data) {
+               if zr, err := zlib.NewReader(bytes.NewReader(ch.Data[i+2:])); err == nil {
+                   if profile, err := ioutil.ReadAll(io.LimitReader(zr, maxChunkSize)); err == nil {
+                       if desc := parseICCDescription(profile); desc != "" {
+                           info.ICCProfile = desc
+                       }
+                   }
+                   zr.Close()
+               }
+           }
+       case "sRGB":
+           if info.ICCProfile == "" {
+               info.ICCProfile = "sRGB IEC61966-2.1"
+           }
+       case "eXIf":
+           info.EXIF = decodeExifMap(ch.Data)
+       }
+   }
+   return nil
+}
+
+// jpegColorModels maps the SOF component count to color model names.
+var jpegColorModels = map[byte]string{
+   1: "gray",
+   3: "ycbcr",
+   4: "cmyk",
+}
+
+// readJPEGInfo fills info from the SOF, APP1 (EXIF) and APP2 (ICC) segments.
+func readJPEGInfo(r io.Reader, info *ImageInfo) error {
+   segs, err := readJPEGSegments(r)
+   if err != nil && len(segs) == 0 {
+       return err
+   }
+   var icc []byte
+   for _, seg := range segs {
+       switch {
+       case isSOFMarker(seg.Marker) && len(seg.Data) >= 6:
+           info.BitDepth = int(seg.Data[0])
+           info.Height = int(binary.BigEndian.Uint16(seg.Data[1:3]))
+           info.Width = int(binary.BigEndian.Uint16(seg.Data[3:5]))
+           info.ColorModel = jpegColorModels[seg.Data[5]]
+       case seg.Marker == 0xE1 && bytes.HasPrefix(seg.Data, []byte("Exif\x00\x00")):
+           info.EXIF = decodeExifMap(seg.Data)
+       case seg.Marker == 0xE2 && bytes.HasPrefix(seg.Data, []byte("ICC_PROFILE\x00")) && len(seg.Data) > 14:
+           // chunks appear in sequence order in practice
+           icc = append(icc, seg.Data[14:]...)
+       }
+   }
+   if len(icc) > 0 {
+       info.ICCProfile = parseICCDescription(icc)
+   }
+   if info.Width == 0 {
+       return errors.New("JPEG frame header not found")
+   }
+   return nil
+}
+
+// isSOFMarker reports whether m is a start-of-frame marker (excluding DHT, JPG and DAC).
+func isSOFMarker(m byte) bool {
+   return m >= 0xC0 && m <= 0xCF && m != 0xC4 && m != 0xC8 && m != 0xCC
+}
+
+// readWebPInfo fills info from the VP8X, VP8, VP8L, ICCP and EXIF chunks.
+func readWebPInfo(r io.ReadSeeker, info *ImageInfo) error {
+   chunks, err := readWebPChunks(r)
+   if err != nil && len(chunks) == 0 {
+       return err
+   }
+   info.BitDepth = 8
+   for _, ch := range chunks {
+       d := ch.Data
+       switch ch.FourCC {
+       case "VP8X":
+           if len(d) >= 10 {
+               info.Width = int(uint32(d[4])|uint32(d[5])<<8|uint32(d[6])<<16) + 1
+               info.Height = int(uint32(d[7])|uint32(d[8])<<8|uint32(d[9])<<16) + 1
+               if d[0]&0x10 != 0 {
+                   info.ColorModel = "rgba"
+               } else {
+                   info.ColorModel = "rgb"
+               }
+           }
+       case "VP8 ":
+           if info.Width == 0 && len(d) >= 10 && d[3] == 0x9d && d[4] == 0x01 && d[5] == 0x2a {
+               info.Width = int(binary.LittleEndian.Uint16(d[6:8]) & 0x3fff)
+               info.Height = int(binary.LittleEndian.Uint16(d[8:10]) & 0x3fff)
+               info.ColorModel = "rgb"
+           }
+       case "VP8L":
+           if info.Width == 0 && len(d) >= 5 && d[0] == 0x2f {
+               bits := binary.LittleEndian.Uint32(d[1:5])
+               info.Width = int(bits&0x3fff) + 1
+               info.Height = int((bits>>14)&0x3fff) + 1
+               if bits&(1<<28) != 0 {
+                   info.ColorModel = "rgba"
+               } else {
+                   info.ColorModel = "rgb"
+               }
+           }
+       case "ICCP":
+           info.ICCProfile = parseICCDescription(d)
+       case "EXIF":
+           info.EXIF = decodeExifMap(d)
+       }
+   }
+   if info.Width == 0 {
+       return errors.New("WebP frame header not found")
+   }
+   return nil
+}
+
+// exifMapWalker collects EXIF tags as strings.
+type exifMapWalker map[string]string
+
+// Walk implements exif.Walker.
+func (m exifMapWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+   if len(tag.Val) > maxExifValueLen {
+       return nil
+   }
+   m[string(name)] = strings.Trim(tag.String(), "\"")
+   return nil
+}
+
+// decodeExifMap decodes raw EXIF data (TIFF, optionally prefixed by "Exif\0\0") into a tag map.
+func decodeExifMap(data []byte) map[string]string {
+   x, err := exif.Decode(bytes.NewReader(data))
+   if err != nil && x == nil {
+       return nil
+   }
+   m := exifMapWalker{}
+   _ = x.Walk(m)
+   if len(m) == 0 {
+       return nil
+   }
+   return m
+}
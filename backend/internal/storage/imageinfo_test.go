@@ -0,0 +1,64 @@
+package storage
+
+import (
+   "encoding/binary"
+   "image"
+   "image/jpeg"
+   "image/png"
+   "os"
+   "path/filepath"
+   "testing"
+)
+
+func TestReadImageInfoFormats(t *testing.T) {
+   dir := t.TempDir()
+   img := image.NewRGBA(image.Rect(0, 0, 7, 5))
+
+   pngPath := filepath.Join(dir, "a.png")
+   f, _ := os.Create(pngPath)
+   if err := png.Encode(f, img); err != nil {
+       t.Fatalf("encode png: %v", err)
+   }
+   f.Close()
+   info, err := ReadImageInfo(pngPath)
+   if err != nil {
+       t.Fatalf("png info: %v", err)
+   }
+   if info.Format != "png" || info.Width != 7 || info.Height != 5 || info.BitDepth != 8 {
+       t.Errorf("unexpected png info: %+v", info)
+   }
+
+   jpgPath := filepath.Join(dir, "a.jpg")
+   f, _ = os.Create(jpgPath)
+   if err := jpeg.Encode(f, img, nil); err != nil {
+       t.Fatalf("encode jpeg: %v", err)
+   }
+   f.Close()
+   info, err = ReadImageInfo(jpgPath)
+   if err != nil {
+       t.Fatalf("jpeg info: %v", err)
+   }
+   if info.Format != "jpeg" || info.Width != 7 || info.Height != 5 || info.ColorModel != "ycbcr" {
+       t.Errorf("unexpected jpeg info: %+v", info)
+   }
+
+   // Minimal lossless WebP header: RIFF container with a VP8L chunk.
+   vp8l := make([]byte, 5)
+   vp8l[0] = 0x2f
+   binary.LittleEndian.PutUint32(vp8l[1:], uint32(7-1)|uint32(5-1)<<14)
+   webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8L")
+   webp = binary.LittleEndian.AppendUint32(webp, uint32(len(vp8l)))
+   webp = append(webp, vp8l...)
+   webp = append(webp, 0) // pad to even length
+   webpPath := filepath.Join(dir, "a.webp")
+   if err := os.WriteFile(webpPath, webp, 0644); err != nil {
+       t.Fatalf("write webp: %v", err)
+   }
+   info, err = ReadImageInfo(webpPath)
+   if err != nil {
+       t.Fatalf("webp info: %v", err)
+   }
+   if info.Format != "webp" || info.Width != 7 || info.Height != 5 {
+       t.Errorf("unexpected webp info: %+v", info)
+   }
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+   "encoding/json"
+   "errors"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "sort"
+   "strings"
+
+   "image-processor-backend/internal/imaging"
+)
+
+// ErrMaskNotFound is returned when a mask ID does not exist for an image.
+var ErrMaskNotFound = errors.New("mask not found")
+
+// Mask is an inpainting mask stored for an image as resolution-independent vector data.
+type Mask struct {
+   ID        string `json:"id"`
+   Name      string `json:"name"`
+   CreatedAt string `json:"created_at"`
+   UpdatedAt string `json:"updated_at"`
+   imaging.MaskSpec
+}
+
+// masksDir returns the directory holding the masks of the image with the given hash.
+// Masks live in the metadata leaf so they follow the image when its content changes.
+func masksDir(baseDir, hash string) string {
+   return filepath.Join(LeafDir(baseDir, hash), "masks")
+}
+
+// NewMaskID returns a random identifier for a new mask.
+func NewMaskID() string {
+   return NewCollectionID()
+}
+
+// LoadMask reads a single mask of the image with the given hash.
+func LoadMask(baseDir, hash, id string) (*Mask, error) {
+   if !validCollectionID(id) {
+       return nil, ErrMaskNotFound
+   }
+   data, err := ioutil.ReadFile(filepath.Join(masksDir(baseDir, hash), id+".json"))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return nil, ErrMaskNotFound
+       }
+       return nil, err
+   }
+   var m Mask
+   if err := json.Unmarshal(data, &m); err != nil {
+       return nil, err
+   }
+   m.ID = id
+   return &m, nil
+}
+
+// SaveMask writes a mask of the image with the given hash.
+func SaveMask(baseDir, hash string, m *Mask) error {
+   if !validCollectionID(m.ID) {
+       return ErrMaskNotFound
+   }
+   dir := masksDir(baseDir, hash)
+   if err := os.MkdirAll(dir, 0755); err != nil {
+       return err
+   }
+   data, err := json.MarshalIndent(m, "", "  ")
+   if err != nil {
+       return err
+   }
+   return ioutil.WriteFile(filepath.Join(dir, m.ID+".json"), data, 0644)
+}
+
+// DeleteMask removes a mask of the image with the given hash.
+func DeleteMask(baseDir, hash, id string) error {
+   if !validCollectionID(id) {
+       return ErrMaskNotFound
+   }
+   err := os.Remove(filepath.Join(masksDir(baseDir, hash), id+".json"))
+   if os.IsNotExist(err) {
+       return ErrMaskNotFound
+   }
+   return err
+}
+
+// ListMasks returns the masks of the image with the given hash, oldest first.
+func ListMasks(baseDir, hash string) ([]Mask, error) {
+   files, err := ioutil.ReadDir(masksDir(baseDir, hash))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return []Mask{}, nil
+       }
+       return nil, err
+   }
+   masks := []Mask{}
+   for _, fi := range files {
+       if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+           continue
+       }
+       m, err := LoadMask(baseDir, hash, strings.TrimSuffix(fi.Name(), ".json"))
+       if err != nil {
+           continue
+       }
+       masks = append(masks, *m)
+   }
+   sort.Slice(masks, func(i, j int) bool { return masks[i].CreatedAt < masks[j].CreatedAt })
+   return masks, nil
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+   "encoding/json"
+   "errors"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "sort"
+   "strings"
+)
+
+// ErrPromptNotFound is returned when a prompt preset, saved negative prompt or wildcard
+// does not exist.
+var ErrPromptNotFound = errors.New("prompt not found")
+
+// PromptPreset is a named prompt template. Prompt and NegativePrompt may contain
+// {variables}, {a|b} choices and __wildcards__; {prompt} stands for the prompt of the
+// request using the preset. Variables holds default values.
+type PromptPreset struct {
+   ID             string            `json:"id"`
+   Name           string            `json:"name"`
+   Description    string            `json:"description,omitempty"`
+   Prompt         string            `json:"prompt"`
+   NegativePrompt string            `json:"negative_prompt,omitempty"`
+   Variables      map[string]string `json:"variables,omitempty"`
+}
+
+// NegativePrompt is a saved negative prompt.
+type NegativePrompt struct {
+   ID   string `json:"id"`
+   Name string `json:"name"`
+   Text string `json:"text"`
+}
+
+// Wildcard is a named list of alternatives for __name__ in prompts.
+type Wildcard struct {
+   Name    string   `json:"name"`
+   Entries []string `json:"entries"`
+}
+
+// promptsDir returns the directory of the given kind of prompt data under root.
+func promptsDir(root, kind string) string {
+   return filepath.Join(root, "prompts", kind)
+}
+
+// NewPromptID returns a random identifier for a new preset or negative prompt.
+func NewPromptID() string {
+   return NewCollectionID()
+}
+
+// loadPromptJSON reads the JSON file id of the given kind into v.
+func loadPromptJSON(root, kind, id string, v interface{}) error {
+   if !validCollectionID(id) {
+       return ErrPromptNotFound
+   }
+   data, err := ioutil.ReadFile(filepath.Join(promptsDir(root, kind), id+".json"))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return ErrPromptNotFound
+       }
+       return err
+   }
+   return json.Unmarshal(data, v)
+}
+
+// savePromptJSON writes v as the JSON file id of the given kind.
+func savePromptJSON(root, kind, id string, v interface{}) error {
+   if !validCollectionID(id) {
+       return ErrPromptNotFound
+   }
+   dir := promptsDir(root, kind)
+   if err := os.MkdirAll(dir, 0755); err != nil {
+       return err
+   }
+   data, err := json.MarshalIndent(v, "", "  ")
+   if err != nil {
+       return err
+   }
+   return ioutil.WriteFile(filepath.Join(dir, id+".json"), data, 0644)
+}
+
+// deletePromptFile removes the file name of the given kind.
+func deletePromptFile(root, kind, name string) error {
+   if !validCollectionID(strings.TrimSuffix(name, filepath.Ext(name))) {
+       return ErrPromptNotFound
+   }
+   err := os.Remove(filepath.Join(promptsDir(root, kind), name))
+   if os.IsNotExist(err) {
+       return ErrPromptNotFound
+   }
+   return err
+}
+
+// listPromptFiles returns the base names of the files with extension ext of the given kind.
+func listPromptFiles(root, kind, ext string) ([]string, error) {
+   files, err := ioutil.ReadDir(promptsDir(root, kind))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return nil, nil
+       }
+       return nil, err
+   }
+   var names []string
+   for _, fi := range files {
+       if !fi.IsDir() && filepath.Ext(fi.Name()) == ext {
+           names = append(names, strings.TrimSuffix(fi.Name(), ext))
+       }
+   }
+   return names, nil
+}
+
+// LoadPromptPreset reads a single prompt preset.
+func LoadPromptPreset(root, id string) (*PromptPreset, error) {
+   var p PromptPreset
+   if err := loadPromptJSON(root, "presets", id, &p); err != nil {
+       return nil, err
+   }
+   p.ID = id
+   return &p, nil
+}
+
+// SavePromptPreset writes a prompt preset, creating its directory if needed.
+func SavePromptPreset(root string, p *PromptPreset) error {
+   return savePromptJSON(root, "presets", p.ID, p)
+}
+
+// DeletePromptPreset removes a prompt preset.
+func DeletePromptPreset(root, id string) error {
+   return deletePromptFile(root, "presets", id+".json")
+}
+
+// ListPromptPresets returns all prompt presets sorted by name.
+func ListPromptPresets(root string) ([]PromptPreset, error) {
+   ids, err := listPromptFiles(root, "presets", ".json")
+   if err != nil {
+       return nil, err
+   }
+   out := []PromptPreset{}
+   for _, id := range ids {
+       if p, err := LoadPromptPreset(root, id); err == nil {
+           out = append(out, *p)
+       }
+   }
+   sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+   return out, nil
+}
+
+// LoadNegativePrompt reads a single saved negative prompt.
+func LoadNegativePrompt(root, id string) (*NegativePrompt, error) {
+   var n NegativePrompt
+   if err := loadPromptJSON(root, "negatives", id, &n); err != nil {
+       return nil, err
+   }
+   n.ID = id
+   return &n, nil
+}
+
+// SaveNegativePrompt writes a saved negative prompt, creating its directory if needed.
+func SaveNegativePrompt(root string, n *NegativePrompt) error {
+   return savePromptJSON(root, "negatives", n.ID, n)
+}
+
+// DeleteNegativePrompt removes a saved negative prompt.
+func DeleteNegativePrompt(root, id string) error {
+   return deletePromptFile(root, "negatives", id+".json")
+}
+
+// ListNegativePrompts returns all saved negative prompts sorted by name.
+func ListNegativePrompts(root string) ([]NegativePrompt, error) {
+   ids, err := listPromptFiles(root, "negatives", ".json")
+   if err != nil {
+       return nil, err
+   }
+   out := []NegativePrompt{}
+   for _, id := range ids {
+       if n, err := LoadNegativePrompt(root, id); err == nil {
+           out = append(out, *n)
+       }
+   }
+   sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+   return out, nil
+}
+
+// LoadWildcard reads the entries of a wildcard file, one per line. Blank lines and lines
+// starting with # are skipped, so lists from other tools can be dropped in as they are.
+func LoadWildcard(root, name string) (*Wildcard, error) {
+   if !validCollectionID(name) {
+       return nil, ErrPromptNotFound
+   }
+   data, err := ioutil.ReadFile(filepath.Join(promptsDir(root, "wildcards"), name+".txt"))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return nil, ErrPromptNotFound
+       }
+       return nil, err
+   }
+   w := &Wildcard{Name: name, Entries: []string{}}
+   for _, line := range strings.Split(string(data), "\n") {
+       line = strings.TrimSpace(line)
+       if line != "" && !strings.HasPrefix(line, "#") {
+           w.Entries = append(w.Entries, line)
+       }
+   }
+   return w, nil
+}
+
+// SaveWildcard writes a wildcard file, creating its directory if needed.
+func SaveWildcard(root string, w *Wildcard) error {
+   if !validCollectionID(w.Name) {
+       return ErrPromptNotFound
+   }
+   dir := promptsDir(root, "wildcards")
+   if err := os.MkdirAll(dir, 0755); err != nil {
+       return err
+   }
+   data := strings.Join(w.Entries, "\n") + "\n"
+   return ioutil.WriteFile(filepath.Join(dir, w.Name+".txt"), []byte(data), 0644)
+}
+
+// DeleteWildcard removes a wildcard file.
+func DeleteWildcard(root, name string) error {
+   return deletePromptFile(root, "wildcards", name+".txt")
+}
+
+// ListWildcards returns the names of all wildcards, sorted.
+func ListWildcards(root string) ([]string, error) {
+   names, err := listPromptFiles(root, "wildcards", ".txt")
+   if err != nil {
+       return nil, err
+   }
+   sort.Strings(names)
+   return append([]string{}, names...), nil
+}
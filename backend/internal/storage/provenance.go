@@ -0,0 +1,70 @@
+package storage
+
+import (
+   "encoding/json"
+   "errors"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+)
+
+// ErrNoProvenance is returned when an image has no provenance record.
+var ErrNoProvenance = errors.New("no provenance record")
+
+// Provenance records how an image was produced by the backend.
+type Provenance struct {
+   Operation       string           `json:"operation"`
+   CreatedAt       string           `json:"created_at"`
+   Model           string           `json:"model,omitempty"`
+   ModelHash       string           `json:"model_hash,omitempty"`
+   Seed            *int64           `json:"seed,omitempty"`
+   SourceHash      string           `json:"source_hash,omitempty"`
+   Index           int              `json:"index"`
+   Request         json.RawMessage  `json:"request,omitempty"`
+   PromptExpansion *PromptExpansion `json:"prompt_expansion,omitempty"`
+   Infotext        string           `json:"infotext,omitempty"`
+   Info            string           `json:"info,omitempty"`
+}
+
+// PromptExpansion records the templates a generation's prompts were expanded from.
+// Seed drove the random choices and wildcard picks.
+type PromptExpansion struct {
+   Preset                 string            `json:"preset,omitempty"`
+   NegativePresets        []string          `json:"negative_presets,omitempty"`
+   PromptTemplate         string            `json:"prompt_template"`
+   NegativePromptTemplate string            `json:"negative_prompt_template,omitempty"`
+   Variables              map[string]string `json:"variables,omitempty"`
+   Seed                   int64             `json:"seed"`
+   Prompt                 string            `json:"prompt"`
+   NegativePrompt         string            `json:"negative_prompt,omitempty"`
+}
+
+// SaveProvenance writes the provenance record for the image with the given content hash.
+func SaveProvenance(baseDir, hash string, p *Provenance) error {
+   leaf := LeafDir(baseDir, hash)
+   if err := os.MkdirAll(leaf, 0755); err != nil {
+       return err
+   }
+   data, err := json.MarshalIndent(p, "", "  ")
+   if err != nil {
+       return err
+   }
+   return ioutil.WriteFile(filepath.Join(leaf, "provenance.json"), data, 0644)
+}
+
+// LoadProvenance reads the provenance record for the image with the given content hash.
+// Returns ErrNoProvenance if none exists.
+func LoadProvenance(baseDir, hash string) (*Provenance, error) {
+   data, err := ioutil.ReadFile(filepath.Join(LeafDir(baseDir, hash), "provenance.json"))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return nil, ErrNoProvenance
+       }
+       return nil, err
+   }
+   var p Provenance
+   if err := json.Unmarshal(data, &p); err != nil {
+       return nil, err
+   }
+   return &p, nil
+}
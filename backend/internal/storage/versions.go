@@ -0,0 +1,148 @@
+package storage
+
+import (
+   "crypto/sha256"
+   "encoding/hex"
+   "encoding/json"
+   "errors"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "time"
+)
+
+// Version is an earlier state of an image, archived when its file was replaced in place.
+// Versions live in the image's metadata leaf and move with it when the image is rekeyed.
+type Version struct {
+   Hash      string `json:"hash"`
+   File      string `json:"file"`
+   Size      int64  `json:"size"`
+   Operation string `json:"operation"`
+   CreatedAt string `json:"created_at"`
+}
+
+// versionsDir returns the directory holding archived versions of the image with the given hash.
+func versionsDir(baseDir, hash string) string {
+   return filepath.Join(LeafDir(baseDir, hash), "versions")
+}
+
+// ListVersions returns the archived versions of the image with the given content hash,
+// oldest first. Returns an empty list if there are none.
+func ListVersions(baseDir, hash string) ([]Version, error) {
+   versions := []Version{}
+   data, err := ioutil.ReadFile(filepath.Join(versionsDir(baseDir, hash), "index.json"))
+   if err != nil {
+       if os.IsNotExist(err) {
+           return versions, nil
+       }
+       return nil, err
+   }
+   if err := json.Unmarshal(data, &versions); err != nil {
+       return nil, err
+   }
+   return versions, nil
+}
+
+// ErrVersionNotFound is returned when an image has no archived version with the requested hash.
+var ErrVersionNotFound = errors.New("version not found")
+
+// FindVersion returns the archived version of the image with the given hash whose own
+// content hash is versionHash.
+func FindVersion(baseDir, hash, versionHash string) (*Version, error) {
+   versions, err := ListVersions(baseDir, hash)
+   if err != nil {
+       return nil, err
+   }
+   for i := range versions {
+       if versions[i].Hash == versionHash {
+           return &versions[i], nil
+       }
+   }
+   return nil, ErrVersionNotFound
+}
+
+// VersionPath returns the path of an archived version file.
+func VersionPath(baseDir, hash string, v Version) string {
+   return filepath.Join(versionsDir(baseDir, hash), v.File)
+}
+
+// ArchiveVersion stores data, the current content of the image with the given hash, as a
+// version before the image is replaced by operation. ext is the file extension of data.
+func ArchiveVersion(baseDir, hash string, data []byte, ext, operation string) (*Version, error) {
+   dir := versionsDir(baseDir, hash)
+   if err := os.MkdirAll(dir, 0755); err != nil {
+       return nil, err
+   }
+   sum := sha256.Sum256(data)
+   v := Version{
+       Hash:      hex.EncodeToString(sum[:]),
+       Size:      int64(len(data)),
+       Operation: operation,
+       CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+   }
+   v.File = v.Hash + ext
+   if err := ioutil.WriteFile(filepath.Join(dir, v.File), data, 0644); err != nil {
+       return nil, err
+   }
+   versions, err := ListVersions(baseDir, hash)
+   if err != nil {
+       return nil, err
+   }
+   versions = append(versions, v)
+   if err := saveVersionIndex(baseDir, hash, versions); err != nil {
+       return nil, err
+   }
+   return &v, nil
+}
+
+// saveVersionIndex writes the version list of the image with the given hash.
+func saveVersionIndex(baseDir, hash string, versions []Version) error {
+   data, err := json.MarshalIndent(versions, "", "  ")
+   if err != nil {
+       return err
+   }
+   return ioutil.WriteFile(filepath.Join(versionsDir(baseDir, hash), "index.json"), data, 0644)
+}
+
+// derivedLeafFiles are caches computed from image content; they are dropped on rekey.
+var derivedLeafFiles = []string{"info.json", "generation.json", "image"}
+
+// RekeyImage moves the metadata of an image whose content changed from oldHash to newHash:
+// its timestamp entry and its metadata leaf (dialog, attributes, provenance, versions).
+// Caches derived from the old content are removed.
+func RekeyImage(baseDir, oldHash, newHash string) error {
+   if oldHash == newHash {
+       return nil
+   }
+   oldMeta := filepath.Join(baseDir, "metadata", oldHash[:2], oldHash+".json")
+   newMeta := filepath.Join(baseDir, "metadata", newHash[:2], newHash+".json")
+   if err := os.MkdirAll(filepath.Dir(newMeta), 0755); err != nil {
+       return err
+   }
+   if err := os.Rename(oldMeta, newMeta); err != nil && !os.IsNotExist(err) {
+       return err
+   }
+   oldLeaf := LeafDir(baseDir, oldHash)
+   newLeaf := LeafDir(baseDir, newHash)
+   entries, err := ioutil.ReadDir(oldLeaf)
+   if err != nil {
+       if os.IsNotExist(err) {
+           return nil
+       }
+       return err
+   }
+   if err := os.MkdirAll(newLeaf, 0755); err != nil {
+       return err
+   }
+   for _, fi := range entries {
+       // The moving image's metadata wins over any left behind under the new hash
+       _ = os.RemoveAll(filepath.Join(newLeaf, fi.Name()))
+       if err := os.Rename(filepath.Join(oldLeaf, fi.Name()), filepath.Join(newLeaf, fi.Name())); err != nil {
+           return err
+       }
+   }
+   for _, name := range derivedLeafFiles {
+       _ = os.Remove(filepath.Join(newLeaf, name))
+   }
+   return os.Remove(oldLeaf)
+}
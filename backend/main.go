@@ -11,6 +11,7 @@ import (
    "net/url"
    "os"
    "path/filepath"
+   "strconv"
    "strings"
    "time"
 
@@ -42,12 +43,20 @@ type ReorderResponse = api.ReorderResponse
 
 // Config holds server configuration loaded from environment.
 type Config struct {
-   Mode           string // dev or prod
-   DevServerURL   string // when in dev mode
-   ImageDir       string
-   ServerHost     string
-   ServerPort     string
-   ForgeServerURL string // SD-Forge server URL
+   Mode             string                    // dev or prod
+   DevServerURL     string                    // when in dev mode
+   ImageDir         string
+   ServerHost       string
+   ServerPort       string
+   ForgeServerURL   string                    // SD-Forge server URL
+   ForgeServerURLs  []string                  // SD-Forge server pool; overrides ForgeServerURL when set
+   ForgeHealthCheck time.Duration             // health check interval of the server pool
+   ForgeClient      forgeclient.ClientOptions // SD-Forge timeouts, retries and circuit breaker
+   ProgressInterval time.Duration             // SD-Forge progress polling interval for streams
+   Backend          string                    // generation backend: forge or comfyui
+   ComfyURL         string                    // ComfyUI server URL
+   ComfyWorkflowDir string                    // directory of ComfyUI workflow templates overriding the built-in ones
+   ComfyCheckpoint  string                    // initial ComfyUI checkpoint
 }
 
 // loadConfig reads configuration from environment variables with sensible defaults.
@@ -89,9 +98,102 @@ func loadConfig() Config {
    } else {
        cfg.ForgeServerURL = "http://localhost:7860"
    }
+   // SD-Forge server pool
+   for _, u := range strings.Split(os.Getenv("FORGE_SERVER_URLS"), ",") {
+       if u = strings.TrimSpace(u); u != "" {
+           cfg.ForgeServerURLs = append(cfg.ForgeServerURLs, u)
+       }
+   }
+   cfg.ForgeHealthCheck = forgeclient.DefaultHealthInterval
+   if h := os.Getenv("FORGE_HEALTH_INTERVAL"); h != "" {
+       if d, err := time.ParseDuration(h); err == nil && d > 0 {
+           cfg.ForgeHealthCheck = d
+       } else {
+           log.Printf("Ignoring invalid FORGE_HEALTH_INTERVAL %q", h)
+       }
+   }
+   // SD-Forge timeouts, retries and circuit breaker
+   cfg.ForgeClient = forgeclient.DefaultClientOptions()
+   durationEnv("FORGE_GENERATION_TIMEOUT", &cfg.ForgeClient.GenerationTimeout)
+   durationEnv("FORGE_SWITCH_TIMEOUT", &cfg.ForgeClient.SwitchTimeout)
+   durationEnv("FORGE_REQUEST_TIMEOUT", &cfg.ForgeClient.RequestTimeout)
+   intEnv("FORGE_RETRIES", &cfg.ForgeClient.Retries)
+   durationEnv("FORGE_RETRY_BACKOFF", &cfg.ForgeClient.RetryBackoff)
+   intEnv("FORGE_BREAKER_THRESHOLD", &cfg.ForgeClient.BreakerThreshold)
+   durationEnv("FORGE_BREAKER_COOLDOWN", &cfg.ForgeClient.BreakerCooldown)
+   // SD-Forge authentication and TLS
+   if auth := os.Getenv("FORGE_API_AUTH"); auth != "" {
+       user, pass, ok := strings.Cut(auth, ":")
+       if !ok {
+           log.Fatalf("FORGE_API_AUTH must be user:password")
+       }
+       cfg.ForgeClient.Credentials.Username, cfg.ForgeClient.Credentials.Password = user, pass
+   }
+   cfg.ForgeClient.Credentials.Token = os.Getenv("FORGE_API_TOKEN")
+   if h := os.Getenv("FORGE_HEADERS"); h != "" {
+       headers, err := forgeclient.ParseHeaders(h)
+       if err != nil {
+           log.Fatalf("Invalid FORGE_HEADERS: %v", err)
+       }
+       cfg.ForgeClient.Credentials.Headers = headers
+   }
+   tlsCfg, err := forgeclient.LoadTLSConfig(os.Getenv("FORGE_CA_FILE"), os.Getenv("FORGE_CLIENT_CERT"), os.Getenv("FORGE_CLIENT_KEY"))
+   if err != nil {
+       log.Fatalf("Invalid SD-Forge TLS settings: %v", err)
+   }
+   cfg.ForgeClient.TLS = tlsCfg
+   // Generation backend
+   cfg.Backend = strings.ToLower(os.Getenv("GENERATOR_BACKEND"))
+   if cfg.Backend == "" {
+       cfg.Backend = "forge"
+   }
+   // ComfyUI server URL
+   if u := os.Getenv("COMFYUI_URL"); u != "" {
+       cfg.ComfyURL = u
+   } else {
+       cfg.ComfyURL = "http://localhost:8188"
+   }
+   cfg.ComfyWorkflowDir = os.Getenv("COMFYUI_WORKFLOW_DIR")
+   cfg.ComfyCheckpoint = os.Getenv("COMFYUI_CHECKPOINT")
+   // Progress polling interval
+   cfg.ProgressInterval = time.Second
+   if p := os.Getenv("PROGRESS_INTERVAL"); p != "" {
+       if d, err := time.ParseDuration(p); err == nil && d > 0 {
+           cfg.ProgressInterval = d
+       } else {
+           log.Printf("Ignoring invalid PROGRESS_INTERVAL %q", p)
+       }
+   }
    return cfg
 }
 
+// durationEnv overrides dst with the duration in the named variable, if set; zero is
+// allowed and disables the setting.
+func durationEnv(name string, dst *time.Duration) {
+   v := os.Getenv(name)
+   if v == "" {
+       return
+   }
+   if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+       *dst = d
+   } else {
+       log.Printf("Ignoring invalid %s %q", name, v)
+   }
+}
+
+// intEnv overrides dst with the non-negative integer in the named variable, if set.
+func intEnv(name string, dst *int) {
+   v := os.Getenv(name)
+   if v == "" {
+       return
+   }
+   if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+       *dst = n
+   } else {
+       log.Printf("Ignoring invalid %s %q", name, v)
+   }
+}
+
 func main() {
 	// configure logging and randomness
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
@@ -104,8 +206,29 @@ func main() {
 		log.Fatalf("Could not create image dir: %v", err)
 	}
 	api.SetImageDir(imageDir)
-	// Initialize forgeclient for SD-Forge integration
-	api.SetForgeClient(forgeclient.NewClient(cfg.ForgeServerURL))
+	// Initialize forgeclient for SD-Forge (or ComfyUI) integration
+	switch {
+	case cfg.Backend == "comfyui":
+		api.SetForgeClient(forgeclient.NewComfyClient(cfg.ComfyURL, forgeclient.ComfyOptions{
+			WorkflowDir: cfg.ComfyWorkflowDir,
+			Checkpoint:  cfg.ComfyCheckpoint,
+			Client:      cfg.ForgeClient,
+		}))
+	case cfg.Backend != "forge":
+		log.Fatalf("Unknown GENERATOR_BACKEND %q (expected forge or comfyui)", cfg.Backend)
+	case len(cfg.ForgeServerURLs) > 0:
+		pool := forgeclient.NewPool(cfg.ForgeServerURLs, cfg.ForgeHealthCheck, cfg.ForgeClient)
+		pool.Start()
+		defer pool.Close()
+		api.SetForgeClient(pool)
+	default:
+		api.SetForgeClient(forgeclient.NewCoordinator(forgeclient.NewClientWithOptions(cfg.ForgeServerURL, cfg.ForgeClient)))
+	}
+	api.ProgressInterval = cfg.ProgressInterval
+	// Start the asynchronous generation job queue (state persisted under the image dir)
+	if err := api.StartJobQueue(imageDir); err != nil {
+		log.Fatalf("Could not start job queue: %v", err)
+	}
 	if err := api.StartWatcher(imageDir); err != nil {
 		log.Println("Warning: file watcher not started:", err)
 	}